@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/asccclass/pcai/internal/database"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditDays   int
+	auditSince  string
+	auditUntil  string
+	auditFormat string
+	auditOutput string
+	auditFilter database.AuditFilter
+)
+
+func init() {
+	auditCmd.Flags().IntVarP(&auditDays, "days", "d", 1, "查詢最近幾天 (未指定 --since 時使用)")
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "起始時間 (today, yesterday, 2006-01-02, 2006-01-02 15:04)")
+	auditCmd.Flags().StringVar(&auditUntil, "until", "", "結束時間 (不含)，格式同 --since")
+	auditCmd.Flags().StringVarP(&auditFilter.Channel, "channel", "c", "", "只顯示此頻道 (cli, api, telegram, whatsapp, heartbeat...)")
+	auditCmd.Flags().StringVarP(&auditFilter.Tool, "tool", "t", "", "只顯示此工具")
+	auditCmd.Flags().StringVarP(&auditFilter.SessionID, "session", "s", "", "只顯示此 Session")
+	auditCmd.Flags().StringVar(&auditFilter.Sender, "sender", "", "只顯示此發送者")
+	auditCmd.Flags().BoolVarP(&auditFilter.ErrorsOnly, "errors", "e", false, "只顯示失敗的呼叫")
+	auditCmd.Flags().IntVarP(&auditFilter.Limit, "limit", "n", 50, "最多顯示幾筆")
+	auditCmd.Flags().StringVarP(&auditFormat, "format", "f", "table", "輸出格式 (table, json, csv)")
+	auditCmd.Flags().StringVarP(&auditOutput, "output", "o", "", "匯出到檔案 (預設輸出到終端機)")
+	rootCmd.AddCommand(auditCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "查詢工具呼叫稽核紀錄 (時間、頻道、參數、耗時與錯誤)",
+	Example: `  pcai audit --since yesterday --until today --channel telegram
+  pcai audit --tool shell_exec --errors
+  pcai audit --days 7 --format csv -o audit.csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter := auditFilter
+		var err error
+		if auditSince != "" {
+			filter.Since, err = database.ParseLocalTime(auditSince)
+		} else if auditDays > 0 {
+			filter.Since = time.Now().AddDate(0, 0, -auditDays)
+		}
+		if auditUntil != "" && err == nil {
+			filter.Until, err = database.ParseLocalTime(auditUntil)
+		}
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		// 資料庫初始化訊息改印到 stderr，避免混入輸出到終端機的 JSON/CSV
+		home, _ := os.Getwd()
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		db, err := database.NewSQLite(filepath.Join(home, "botmemory", "pcai.db"))
+		os.Stdout = stdout
+		if err != nil {
+			fmt.Printf("❌ 無法開啟資料庫: %v\n", err)
+			return
+		}
+		defer db.Close()
+
+		rows, err := db.QueryToolAudit(context.Background(), filter)
+		if err != nil {
+			fmt.Printf("❌ 查詢失敗: %v\n", err)
+			return
+		}
+
+		var out io.Writer = os.Stdout
+		if auditOutput != "" {
+			f, err := os.Create(auditOutput)
+			if err != nil {
+				fmt.Printf("❌ 無法建立檔案: %v\n", err)
+				return
+			}
+			defer f.Close()
+			out = f
+		}
+
+		switch auditFormat {
+		case "json":
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(rows)
+		case "csv":
+			err = database.WriteToolAuditCSV(out, rows)
+		default:
+			printAuditTable(out, rows)
+		}
+		if err != nil {
+			fmt.Printf("❌ 匯出失敗: %v\n", err)
+			return
+		}
+		if auditOutput != "" {
+			fmt.Println(successStyle.Render(fmt.Sprintf("✅ 已匯出 %d 筆紀錄到 %s", len(rows), auditOutput)))
+		}
+	},
+}
+
+// printAuditTable 以表格顯示稽核紀錄
+func printAuditTable(w io.Writer, rows []database.ToolAuditEntry) {
+	fmt.Fprintln(w, headerStyle.Render("\n🔎 工具呼叫稽核紀錄"))
+	fmt.Fprintln(w)
+	if len(rows) == 0 {
+		fmt.Fprintln(w, dimStyle.Render("沒有符合條件的紀錄"))
+		return
+	}
+
+	timeStyle := lipgloss.NewStyle().Width(21)
+	chanStyle := lipgloss.NewStyle().Width(12)
+	toolStyle := lipgloss.NewStyle().Width(24)
+	numStyle := lipgloss.NewStyle().Width(10).Align(lipgloss.Right)
+	fmt.Fprintln(w, labelStyle.UnsetWidth().Render(timeStyle.Render("時間"))+chanStyle.Render("頻道")+toolStyle.Render("工具")+
+		numStyle.Render("耗時")+numStyle.Render("結果")+"  參數")
+
+	var failures int
+	for _, r := range rows {
+		detail := dimStyle.Render(truncateAudit(r.Args, 60))
+		if r.Error != "" {
+			failures++
+			detail = failStyle.Render("✗ " + truncateAudit(r.Error, 60))
+		}
+		fmt.Fprintln(w, timeStyle.Render(r.CreatedAt)+chanStyle.Render(truncateAudit(r.Channel, 11))+
+			toolStyle.Render(truncateAudit(r.Tool, 23))+numStyle.Render(fmt.Sprintf("%dms", r.DurationMs))+
+			numStyle.Render(fmt.Sprintf("%dB", r.ResultSize))+"  "+detail)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, successStyle.Render(fmt.Sprintf("合計: %d 筆 (失敗 %d)", len(rows), failures)))
+}
+
+// truncateAudit 截斷過長的欄位以維持表格對齊
+func truncateAudit(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -2,13 +2,21 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/asccclass/pcai/internal/agent"
+	"github.com/asccclass/pcai/internal/approval"
 	"github.com/asccclass/pcai/internal/config"
 	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms"
 	"github.com/asccclass/pcai/llms/ollama"
 	"github.com/asccclass/pcai/tools"
 
@@ -27,7 +35,6 @@ var (
 	// toolStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("13")).Italic(true)
 	notifyStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Bold(true) // 亮黃色
 	promptStr   = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render(">>> ")
-	currentOpts = ollama.Options{Temperature: 0.7, TopP: 0.9}
 )
 
 func init() {
@@ -40,8 +47,59 @@ func init() {
 // 輔助函式：用來處理 Glamour 需要的 uint 指標
 func uintPtr(i uint) *uint { return &i }
 
+// turnCanceler 保存目前這一輪對話的取消函式
+// Ctrl+C 時若 AI 正在生成則只中斷這一輪，閒置時才結束程式
+type turnCanceler struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// begin 建立新一輪對話的 Context
+func (t *turnCanceler) begin() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.mu.Lock()
+	t.cancel = cancel
+	t.mu.Unlock()
+	return ctx
+}
+
+// end 結束這一輪對話並釋放 Context
+func (t *turnCanceler) end() {
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	t.mu.Unlock()
+}
+
+// interrupt 中斷進行中的對話，若沒有進行中的對話則回傳 false
+func (t *turnCanceler) interrupt() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel == nil {
+		return false
+	}
+	t.cancel()
+	t.cancel = nil
+	return true
+}
+
+// readLines 在背景讀取標準輸入，讓等待輸入的地方可以同時等待取消 (stdin 關閉時關閉 channel)
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
 func runChat(cmd *cobra.Command, args []string) {
-	scanner := bufio.NewScanner(os.Stdin)
+	lines := readLines(os.Stdin)
 	// --- 緊湊型 Glamour 樣式設定 ---
 	renderer, _ := glamour.NewTermRenderer(
 		glamour.WithStandardStyle("dark"),
@@ -71,7 +129,7 @@ func runChat(cmd *cobra.Command, args []string) {
 	sess := history.LoadLatestSession()
 
 	// [FIX] 啟動時檢查是否需要歸納 (處理「上次關閉後過很久才重開」的情況)
-	history.CheckAndSummarize(sess, modelName, systemPrompt)
+	history.CheckAndSummarize(context.Background(), sess, modelName, systemPrompt)
 
 	// 若歸納後被清空 (Start New Session)，這裡 sess 內容已變，需重新對齊
 	// 但因為 CurrentSession 是指標，上面的 CheckAndSummarize 內修改的就是同一個物件
@@ -87,6 +145,8 @@ func runChat(cmd *cobra.Command, args []string) {
 	// 5. 初始化 Agent
 	// -------------------------------------------------------------
 	myAgent := agent.NewAgent(modelName, systemPrompt, sess, registry, logger)
+	myAgent.UseProfile("cli")
+	myAgent.Channel = "cli"
 
 	// [BOOT] 系統啟動時，優先詢問 LLM 的姓名並寫入全域變數
 	fmt.Print(lipgloss.NewStyle().Foreground(lipgloss.Color("242")).Render("AI 正在設定專屬稱呼..."))
@@ -95,7 +155,10 @@ func runChat(cmd *cobra.Command, args []string) {
 		{Role: "user", Content: "請為你自己取一個簡短的名字（只要回覆名字即可，絕對不要回答其他對話或標點符號）。"},
 	}
 	// 繞過 agent.Chat 直接呼叫 Provider 確保這段對話不會被記錄進歷史
-	if nameMsg, err := myAgent.Provider(modelName, bootMessages, nil, currentOpts, nil); err == nil && nameMsg.Content != "" {
+	bootCtx, bootCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	nameMsg, err := myAgent.Provider(bootCtx, modelName, bootMessages, nil, myAgent.Options, nil)
+	bootCancel()
+	if err == nil && nameMsg.Content != "" {
 		config.GlobalName = strings.TrimSpace(nameMsg.Content)
 		// 動態更新終端 Prompt 顯示名字
 		promptStr = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Render(fmt.Sprintf(">>> [%s]: ", config.GlobalName))
@@ -118,12 +181,12 @@ func runChat(cmd *cobra.Command, args []string) {
 	myAgent.OnIsTaskLocked = tools.IsTaskLocked
 
 	// 設定 UI 回調 (Bridging Agent Events -> CLI Glamour UI)
-	myAgent.OnGenerateStart = func() {
+	renderGenerating := func() {
 		// 恢復 "AI 正在思考中..." 的暫時性提示
 		fmt.Print(lipgloss.NewStyle().Foreground(lipgloss.Color("242")).Render("AI 正在思考中..."))
 	}
 
-	myAgent.OnModelMessageComplete = func(content string) {
+	renderMessage := func(content string) {
 		// 清除行 (如果是思考中...)
 		fmt.Print("\r\033[K")
 
@@ -138,7 +201,7 @@ func runChat(cmd *cobra.Command, args []string) {
 			// 目前架構 Agent.Chat 會回傳 finalResponse。
 
 			// 簡單實作：直接印出回答作為結果，或者視為思考的一部分 (如果後面還有 Tool Call)
-			// 但 OnModelMessageComplete 是在 Tool Loop 裡面的每一輪都會觸發嗎？
+			// 但 message 事件是在 Tool Loop 裡面的每一輪都會觸發嗎？
 			// 看 agent.go:99 -> 是的，每次 Provider 回傳都會觸發。
 
 			// 判斷是否為「引導 Tool Call 的思考」還是「最終回答」比較困難，
@@ -164,7 +227,15 @@ func runChat(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	myAgent.OnToolCall = func(name, args string) {
+	// [THINKING] 推理過程預設折疊，只顯示前幾行，輸入 /think 展開上一段完整內容
+	var lastThinking string
+	renderThinkingEvent := func(thinking string) {
+		fmt.Print("\r\033[K")
+		lastThinking = thinking
+		fmt.Println(renderThinking(thinking, false))
+	}
+
+	renderToolCall := func(name, args string) {
 		// 工具決策輸出
 		header := lipgloss.NewStyle().Foreground(lipgloss.Color("13")).Bold(true).Render(">> 工具決策: ")
 		fmt.Printf("%s呼叫 %s\n", header, name)
@@ -174,7 +245,25 @@ func runChat(cmd *cobra.Command, args []string) {
 		fmt.Printf("       %s\n", paramStyle.Render(fmt.Sprintf("參數: %s", args)))
 	}
 
-	myAgent.OnToolResult = func(result string) {
+	// [APPROVAL] 有副作用的工具執行前在終端機詢問使用者
+	myAgent.Approver = func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+		warn := lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Bold(true)
+		fmt.Println(warn.Render(req.Prompt()))
+		fmt.Print("允許執行？ [y]是 / [a]本次對話一律允許 / [N]否: ")
+		// 等待回覆時也要回應逾時與 Ctrl+C
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return approval.Deny, io.EOF
+			}
+			return approval.ParseDecision(line), nil
+		case <-ctx.Done():
+			fmt.Println()
+			return approval.Deny, ctx.Err()
+		}
+	}
+
+	renderToolResult := func(result string) {
 		// 結果輸出
 		header := lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Bold(true).Render(">> 結果: ")
 
@@ -193,6 +282,38 @@ func runChat(cmd *cobra.Command, args []string) {
 		fmt.Printf("%s %s %s\n", header, icon, strings.TrimSpace(cleanResult))
 	}
 
+	myAgent.Events.Subscribe(func(e agent.Event) {
+		switch e.Kind {
+		case agent.KindGenerating:
+			renderGenerating()
+		case agent.KindMessage:
+			renderMessage(e.Text)
+		case agent.KindThinking:
+			renderThinkingEvent(e.Text)
+		case agent.KindToolCall:
+			renderToolCall(e.Tool, e.Args)
+		case agent.KindToolResult:
+			renderToolResult(e.Text)
+		}
+	})
+
+	// [CANCEL] Ctrl+C：生成中則中斷本輪對話，閒置時結束程式
+	turn := &turnCanceler{}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		for range sigCh {
+			if turn.interrupt() {
+				fmt.Print("\r\033[K")
+				fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("⏹️ 已中斷本次回覆"))
+				continue
+			}
+			fmt.Println()
+			os.Exit(0)
+		}
+	}()
+
 	for {
 		// --- 背景任務完成通知推播 ---
 		select {
@@ -203,10 +324,11 @@ func runChat(cmd *cobra.Command, args []string) {
 		}
 
 		fmt.Print(promptStr)
-		if !scanner.Scan() {
+		line, ok := <-lines
+		if !ok {
 			break
 		}
-		input := strings.TrimSpace(scanner.Text())
+		input := strings.TrimSpace(line)
 
 		// 顯示使用者輸入 (模擬 Log 格式，雖然使用者已經打在螢幕上了，但為了符合需求格式，我們再印一次？)
 		// 使用者需求: ">>> 使用者輸入: 「...」"
@@ -235,16 +357,26 @@ func runChat(cmd *cobra.Command, args []string) {
 		}
 
 		// 這裡可以加入處理 /file, /set 等自定義指令的邏輯
+		if input == "/think" {
+			if lastThinking == "" {
+				fmt.Println(dimStyle.Render("（目前沒有推理內容）"))
+			} else {
+				fmt.Println(renderThinking(lastThinking, true))
+			}
+			continue
+		}
 
 		// 交給 Agent 處理
-		_, err := myAgent.Chat(input, nil) // CLI 暫不使用 Realtime stream raw text，而是依賴 Callbacks 渲染 Markdown
-		if err != nil {
+		turnCtx := llms.WithUsageTags(turn.begin(), llms.UsageTags{Channel: "cli", Workflow: "chat"})
+		_, err := myAgent.Chat(turnCtx, input, nil) // CLI 暫不使用 Realtime stream raw text，而是依賴 Callbacks 渲染 Markdown
+		turn.end()
+		if err != nil && !errors.Is(err, context.Canceled) {
 			fmt.Printf("❌ 錯誤: %v\n", err)
 		}
 
 		// 自動儲存與 RAG 歸納檢查 (Session 由 Agent 內部維護，直接儲存即可)
 		history.SaveSession(sess)
-		history.CheckAndSummarize(sess, modelName, systemPrompt)
+		history.CheckAndSummarize(context.Background(), sess, modelName, systemPrompt)
 	}
 }
 
@@ -253,3 +385,24 @@ var chatCmd = &cobra.Command{
 	Short: "開啟具備 AI Agent 能力的對話",
 	Run:   runChat,
 }
+
+// thinkingPreviewLines 折疊時顯示的推理行數
+const thinkingPreviewLines = 3
+
+// renderThinking 以淡色呈現推理過程；expanded 為 false 時只顯示前幾行
+func renderThinking(thinking string, expanded bool) string {
+	lines := strings.Split(strings.TrimSpace(thinking), "\n")
+	header := fmt.Sprintf("💭 推理過程 (%d 行)", len(lines))
+	if !expanded && len(lines) > thinkingPreviewLines {
+		header += "，輸入 /think 展開"
+		lines = append(lines[:thinkingPreviewLines], "…")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(dimStyle.Render(header))
+	for _, l := range lines {
+		sb.WriteString("\n")
+		sb.WriteString(dimStyle.Render("  │ " + l))
+	}
+	return sb.String()
+}
@@ -128,6 +128,12 @@ func runServe(cmd *cobra.Command, args []string) {
 	memHandler := webapi.NewMemoryHandler(memToolKit, sqliteDB)
 	memHandler.AddRoutes(router)
 
+	usageHandler := webapi.NewUsageHandler(sqliteDB)
+	usageHandler.AddRoutes(router)
+
+	auditHandler := webapi.NewAuditHandler(sqliteDB)
+	auditHandler.AddRoutes(router)
+
 	sysLogger, _ := agent.NewSystemLogger("botmemory")
 	chatModel := cfg.Model
 	if chatModel == "" {
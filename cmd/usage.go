@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/asccclass/pcai/internal/database"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageDays    int
+	usageGroupBy string
+)
+
+func init() {
+	usageCmd.Flags().IntVarP(&usageDays, "days", "d", 7, "統計最近幾天")
+	usageCmd.Flags().StringVarP(&usageGroupBy, "by", "b", "workflow", "彙總維度 (workflow, channel, provider, model, session, day)")
+	rootCmd.AddCommand(usageCmd)
+}
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "顯示 LLM Token 用量、延遲與輸出速度統計",
+	Run: func(cmd *cobra.Command, args []string) {
+		home, _ := os.Getwd()
+		db, err := database.NewSQLite(filepath.Join(home, "botmemory", "pcai.db"))
+		if err != nil {
+			fmt.Printf("❌ 無法開啟資料庫: %v\n", err)
+			return
+		}
+		defer db.Close()
+
+		rows, err := db.GetLLMUsageSummary(context.Background(), usageGroupBy, usageDays)
+		if err != nil {
+			fmt.Printf("❌ 查詢失敗: %v\n", err)
+			return
+		}
+
+		fmt.Println(headerStyle.Render(fmt.Sprintf("\n📊 LLM 用量統計 (最近 %d 天，依 %s)", usageDays, usageGroupBy)))
+		fmt.Println()
+		if len(rows) == 0 {
+			fmt.Println(dimStyle.Render("尚無用量紀錄"))
+			return
+		}
+
+		keyStyle := lipgloss.NewStyle().Width(28)
+		numStyle := lipgloss.NewStyle().Width(12).Align(lipgloss.Right)
+		fmt.Println(labelStyle.Render(keyStyle.Render(usageGroupBy)) +
+			numStyle.Render("呼叫") + numStyle.Render("失敗") + numStyle.Render("輸入") +
+			numStyle.Render("輸出") + numStyle.Render("平均延遲") + numStyle.Render("tok/s"))
+
+		var calls, failures, prompt, completion int
+		for _, r := range rows {
+			failText := numStyle.Render(fmt.Sprint(r.Failures))
+			if r.Failures > 0 {
+				failText = failStyle.Render(failText)
+			}
+			fmt.Println(keyStyle.Render(r.Key) +
+				numStyle.Render(fmt.Sprint(r.Calls)) + failText +
+				numStyle.Render(fmt.Sprint(r.PromptTokens)) + numStyle.Render(fmt.Sprint(r.CompletionTokens)) +
+				numStyle.Render(fmt.Sprintf("%.0fms", r.AvgLatencyMs)) + numStyle.Render(fmt.Sprintf("%.1f", r.AvgTokensPerSec)))
+			calls += r.Calls
+			failures += r.Failures
+			prompt += r.PromptTokens
+			completion += r.CompletionTokens
+		}
+
+		fmt.Println()
+		fmt.Println(successStyle.Render(fmt.Sprintf("合計: %d 次呼叫 (失敗 %d)，輸入 %d tokens，輸出 %d tokens", calls, failures, prompt, completion)))
+	},
+}
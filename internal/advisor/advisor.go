@@ -3,23 +3,22 @@ package advisor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"strings"
 
-	"github.com/ollama/ollama/api"
+	"github.com/asccclass/pcai/llms"
+	"github.com/asccclass/pcai/llms/ollama"
 )
 
 // Classification 定義 AI 回傳的架構建議格式
 type Classification struct {
-	Category    string   `json:"category"`    // "Skill" 或 "Tool"
-	Reasoning   string   `json:"reasoning"`   // 判定理由
-	Persistence bool     `json:"persistence"` // 是否需要資料庫
-	Components  []string `json:"components"`  // 建議開發的組件
+	Category    string   `json:"category" jsonschema:"enum=Skill|Tool"` // "Skill" 或 "Tool"
+	Reasoning   string   `json:"reasoning"`                             // 判定理由
+	Persistence bool     `json:"persistence"`                           // 是否需要資料庫
+	Components  []string `json:"components"`                            // 建議開發的組件
 }
 
-// Analyze 透過 LLM 進行架構分析
-func Analyze(ctx context.Context, client *api.Client, modelName, desc string) (*Classification, error) {
+// Analyze 透過 LLM 進行架構分析 (結構化輸出，回覆不合格式時會自動重問)
+func Analyze(ctx context.Context, chatFn llms.ChatStreamFunc, modelName string, opts ollama.Options, desc string) (*Classification, error) {
 	prompt := fmt.Sprintf(`你是 PCAI 架構專家。請分析需求並判斷應實作為 Skill 還是 Tool。
 標準：
 * Tool (工具)：底層執行單元
@@ -41,30 +40,11 @@ func Analyze(ctx context.Context, client *api.Client, modelName, desc string) (*
     需求描述: %s
     請回傳 JSON 格式。`, desc)
 
-	req := &api.GenerateRequest{
-		Model:  modelName,
-		Prompt: prompt,
-		Stream: new(bool), // false
-		Format: json.RawMessage(`"json"`),
-	}
-
-	var responseText string
-	err := client.Generate(ctx, req, func(resp api.GenerateResponse) error {
-		responseText = resp.Response
-		return nil
-	})
+	res, err := llms.ChatJSON[Classification](ctx, chatFn, modelName, []ollama.Message{
+		{Role: "user", Content: prompt},
+	}, opts)
 	if err != nil {
-		return nil, err
-	}
-
-	var res Classification
-	// 嘗試解析 JSON (Ollama 有時會回傳 markdown code block)
-	cleaned := strings.TrimSpace(responseText)
-	cleaned = strings.TrimPrefix(cleaned, "```json")
-	cleaned = strings.TrimSuffix(cleaned, "```")
-
-	if err := json.Unmarshal([]byte(cleaned), &res); err != nil {
-		return nil, fmt.Errorf("解析失敗: %v, 原始回應: %s", err, responseText)
+		return nil, fmt.Errorf("架構分析失敗: %w", err)
 	}
 	return &res, nil
 }
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -8,11 +9,13 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/asccclass/pcai/internal/approval"
 	"github.com/asccclass/pcai/internal/core"
 	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/internal/toolparse"
+	"github.com/asccclass/pcai/internal/toolresult"
 	"github.com/asccclass/pcai/llms"
 	"github.com/asccclass/pcai/llms/ollama"
-	"github.com/ollama/ollama/api"
 )
 
 // Agent 封裝了對話邏輯、工具呼叫與 Session 管理
@@ -26,54 +29,80 @@ type Agent struct {
 	Logger       *SystemLogger // [NEW] 系統日誌
 	ActiveBuffer *history.ActiveBuffer
 	DailyLogger  *history.DailyLogger
+	Limits       LoopLimits    // [NEW] 單輪工具迴圈上限
+	Context      ContextBudget // [NEW] 上下文 Token 預算
 
-	// Callbacks for UI interaction
-	OnGenerateStart        func()
-	OnModelMessageComplete func(content string)
-	OnToolCall             func(name, args string)
-	OnToolResult           func(result string)
-	OnShortTermMemory      func(source, content string) // 短期記憶自動存入回調
-	OnMemorySearch         func(query string) string    // 記憶預搜尋回調
-	OnCheckPendingPlan     func() string                // 未完成任務檢查回調
-	OnAcquireTaskLock      func() bool                  // 獲取任務鎖
-	OnReleaseTaskLock      func()                       // 釋放任務鎖
-	OnIsTaskLocked         func() bool                  // 檢查任務鎖
+	MaxParallelTools int            // [NEW] 同一輪可同時執行的工具數 (1 代表依序執行)
+	Tools            *ToolSelector  // [NEW] 依相關度挑選每輪送出的工具 (見 tool_selector.go)
+	activeTools      *toolSet       // 本輪提供給模型的工具
+	turnRegistry     *core.Registry // 本輪使用的工具快照 (重新載入技能不影響進行中的對話)
+
+	Results *toolresult.Store // [NEW] 大型工具結果的存放區 (見 tool_results.go)
+
+	Approval *approval.Gate    // [NEW] 有副作用工具的核准狀態 (記住本 Session 一律允許的工具)
+	Approver approval.Approver // [NEW] 由頻道提供的核准詢問方式，nil 代表無法詢問使用者
+
+	Channel string        // [NEW] 對話來源頻道 (cli, api, telegram...)，與 Sender 一起傳給工具的 core.RunEnv
+	Sender  string        // [NEW] 發送者 ID
+	Images  []string      // [NEW] 下一則使用者訊息附帶的圖片 (Base64)，送出後清空
+	lastLLM *ollama.Usage // 最近一次回應的 Provider 與模型 (寫入工具稽核紀錄)
+
+	// [NEW] 對話事件 (工具呼叫、串流片段、記憶命中等)，UI 與觀察者透過 Events.Subscribe 訂閱
+	Events *EventBus
+
+	// 由 Agent 呼叫並取得結果的掛鉤 (不是單純的通知，因此不走事件)
+	OnMemorySearch     func(query string) string // 記憶預搜尋回調
+	OnCheckPendingPlan func() string             // 未完成任務檢查回調
+	OnAcquireTaskLock  func() bool               // 獲取任務鎖
+	OnReleaseTaskLock  func()                    // 釋放任務鎖
+	OnIsTaskLocked     func() bool               // 檢查任務鎖
 }
 
 // NewAgent 建立一個新的 Agent 實例
 func NewAgent(modelName, systemPrompt string, session *history.Session, registry *core.Registry, logger *SystemLogger) *Agent {
-	// 預設 Provider：從環境變數 PCAI_PROVIDER 讀取（可選 "ollama", "copilot"），預設為 "ollama"
-	providerName := os.Getenv("PCAI_PROVIDER")
-	if providerName == "" {
-		providerName = "ollama"
-	}
-	defaultProvider, _ := llms.GetProviderFunc(providerName)
+	a := newEphemeralAgent(modelName, systemPrompt, session, registry, logger)
 
 	// 初始化每日日誌與 Active Buffer
 	home, _ := os.Getwd()
 	kbDir := filepath.Join(home, "botmemory")
-	dailyLogger := history.NewDailyLogger(kbDir)
-	activeBuffer := history.NewActiveBuffer(4000, dailyLogger)
+	a.DailyLogger = history.NewDailyLogger(kbDir)
+	a.ActiveBuffer = history.NewActiveBuffer(4000, a.DailyLogger)
 
 	// 自動恢復今日會話
-	entries, _ := dailyLogger.LoadToday()
+	entries, _ := a.DailyLogger.LoadToday()
 	for _, e := range entries {
-		activeBuffer.Add(ollama.Message{Role: e.Role, Content: e.Content})
+		a.ActiveBuffer.Add(ollama.Message{Role: e.Role, Content: e.Content})
 	}
+	return a
+}
 
+// newEphemeralAgent 建立不寫入每日日誌、也不載入今日對話的 Agent (例如委派的子 Agent)
+func newEphemeralAgent(modelName, systemPrompt string, session *history.Session, registry *core.Registry, logger *SystemLogger) *Agent {
 	return &Agent{
 		Session:      session,
 		ModelName:    modelName,
 		SystemPrompt: systemPrompt,
 		Registry:     registry,
-		Options:      ollama.Options{Temperature: 0.7, TopP: 0.9},
-		Provider:     defaultProvider,
+		Options:      llms.ProfileOptions(llms.ProfileChat),
+		Provider:     llms.GetDefaultChatStream(), // PCAI_PROVIDER_CHAIN (Failover 鏈) 或 PCAI_PROVIDER，預設為 "ollama"
 		Logger:       logger,
-		ActiveBuffer: activeBuffer,
-		DailyLogger:  dailyLogger,
+		Limits:       DefaultLoopLimits(),
+		Context:      DefaultContextBudget(),
+
+		MaxParallelTools: DefaultMaxParallelTools(),
+		Tools:            DefaultToolSelector(),
+		Results:          toolresult.Default(),
+		Approval:         approval.NewGate(),
+		Events:           NewEventBus(),
 	}
 }
 
+// UseProfile 依用途 (例如 cli、telegram、patrol) 套用生成參數 Profile
+// profiles.yaml 沒有為此用途綁定時使用 chat Profile
+func (a *Agent) UseProfile(purpose string) {
+	a.Options = llms.ProfileFor(purpose, llms.ProfileChat)
+}
+
 // SetModelConfig update the model and provider dynamically
 func (a *Agent) SetModelConfig(modelName string, provider llms.ChatStreamFunc) {
 	if modelName != "" {
@@ -84,14 +113,39 @@ func (a *Agent) SetModelConfig(modelName string, provider llms.ChatStreamFunc) {
 	}
 }
 
+// registry 回傳本輪的工具快照，不在對話中時回傳共用的註冊表
+func (a *Agent) registry() *core.Registry {
+	if a.turnRegistry != nil {
+		return a.turnRegistry
+	}
+	return a.Registry
+}
+
 // Chat 處理使用者輸入，執行思考與工具呼叫迴圈
 // onStream 是即時輸出 AI 回應的回調函式
-func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
+// ctx 被取消時會中止目前的 LLM 串流並停止工具迴圈，回傳的 error 可用 errors.Is(err, context.Canceled) 判斷
+func (a *Agent) Chat(ctx context.Context, input string, onStream func(string)) (string, error) {
+	a.emit(Event{Kind: KindTurnStarted, Text: input})
+	reply, err := a.chat(ctx, input, onStream)
+	finished := Event{Kind: KindTurnFinished, Text: reply}
+	if err != nil {
+		a.emit(Event{Kind: KindError, Error: err.Error()})
+		finished.Error = err.Error()
+	}
+	a.emit(finished)
+	return reply, err
+}
+
+func (a *Agent) chat(ctx context.Context, input string, onStream func(string)) (string, error) {
 	// [LOG] 記錄使用者輸入
 	if a.Logger != nil {
 		a.Logger.LogUserInput(input)
 	}
 
+	// [REGISTRY] 本輪固定使用同一份工具快照
+	a.turnRegistry = a.Registry.Snapshot()
+	defer func() { a.turnRegistry = nil }()
+
 	// [TOOL HINT] 根據關鍵字注入工具提示，引導 LLM 選擇正確工具
 	userContent := input
 	var lastPendingID string
@@ -111,7 +165,7 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 
 	// [MULTI-STEP] 偵測多步驟意圖，若偵測到則注入計畫編排 Prompt
 	multiStepDetected := false
-	if multiStepHint := detectMultiStepIntent(input); multiStepHint != "" {
+	if multiStepHint := detectMultiStepIntent(a.registry(), input); multiStepHint != "" {
 		// 檢查任務鎖：若已有任務在執行，不允許建立新計畫
 		if a.OnIsTaskLocked != nil && a.OnIsTaskLocked() {
 			fmt.Println("⚠️ [Agent] 已有任務在執行中，無法建立新計畫")
@@ -123,6 +177,7 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 				a.OnAcquireTaskLock()
 			}
 			fmt.Println("🧩 [Agent] 偵測到多步驟意圖，啟用計畫編排模式")
+			a.emit(Event{Kind: KindPlanStep, Text: "偵測到多步驟意圖，啟用計畫編排模式"})
 		}
 	}
 
@@ -139,35 +194,37 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 					a.OnAcquireTaskLock()
 				}
 				fmt.Println("🔄 [Agent] 偵測到未完成任務，注入恢復指令")
+				a.emit(Event{Kind: KindPlanStep, Text: "恢復未完成的計畫"})
 			}
 		}
 	}
 
-	if hint := getToolHint(input, lastPendingID); hint != "" {
-		userContent = userContent + "\n\n" + hint
+	// [INTENT] 命中意圖規則時附加工具提示 (規則見 intents_default.yaml 與 intents.yaml)
+	intent := a.matchIntent(ctx, input)
+	if intent != nil {
+		if hint := intent.render(input, lastPendingID); hint != "" {
+			userContent = userContent + "\n\n" + hint
+		}
 	}
 
-	// [MEMORY-FIRST] 搜尋記憶，注入相關上下文
+	// [MEMORY-FIRST] 搜尋記憶，與今日對話一起在組裝上下文時注入 (不寫入 Session，見 context_builder.go)
+	var turn turnContext
 	if a.OnMemorySearch != nil {
 		if memCtx := a.OnMemorySearch(input); memCtx != "" {
-			// 把記憶放在問題之前，讓 LLM 的注意力聚焦在最後的問題上
-			userContent = memCtx + "\n\n【使用者問題】\n" + userContent
+			turn.memory = memCtx
 			fmt.Println("💾 [Memory] 記憶命中，已注入上下文")
+			a.emit(Event{Kind: KindMemoryHit, Text: memCtx})
 		}
 	}
 
-	// [ACTIVE-BUFFER] 注入當前日誌上下文
-	if a.ActiveBuffer != nil && len(a.ActiveBuffer.GetMessages()) > 0 {
-		var activeCtx strings.Builder
-		activeCtx.WriteString("【今日對話上下文】\n")
-		for _, m := range a.ActiveBuffer.GetMessages() {
-			activeCtx.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
-		}
-		userContent = activeCtx.String() + "\n\n" + userContent
+	// [ACTIVE-BUFFER] 當前日誌上下文 (不含本輪輸入)
+	if a.ActiveBuffer != nil {
+		turn.buffer = append([]ollama.Message(nil), a.ActiveBuffer.GetMessages()...)
 	}
 
 	// 將使用者輸入加入對話歷史
-	msg := ollama.Message{Role: "user", Content: userContent}
+	msg := ollama.Message{Role: "user", Content: userContent, Images: a.Images}
+	a.Images = nil
 	a.Session.Messages = append(a.Session.Messages, msg)
 
 	// 記錄到 Active Buffer 和每日日誌
@@ -180,28 +237,63 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 
 	var finalResponse string
 
+	// [USAGE] 以 Session 標記本輪對話的 LLM 用量 (Channel/Workflow 由呼叫端決定)
+	if a.Session != nil {
+		ctx = llms.WithUsageTags(ctx, llms.UsageTags{SessionID: a.Session.ID})
+	}
+	iteration := 0
+	budget := newLoopBudget(a.Limits)
+
+	// [TOOLS] 依輸入挑選本輪的工具 (未啟用時提供全部工具)
+	a.activeTools = a.selectTools(ctx, input)
+	defer func() { a.activeTools = nil }()
+	if intent != nil && intent.Force {
+		a.activeTools.force(intent.Tool)
+	}
+	var lastReport ContextReport
+
 	// Tool-Calling 狀態機循環
 	for {
+		// [CANCEL] 每一輪開始前檢查是否已被取消 (例如 Ctrl+C、HTTP 客戶端斷線、Heartbeat 逾時)
+		if err := ctx.Err(); err != nil {
+			return finalResponse, a.interrupted(err)
+		}
+
+		// [LIMIT] 回合數、執行時間或 Token 用量超過上限時，改為收尾回答
+		if reason := budget.exceeded(); reason != "" {
+			return a.wrapUpAfterLimit(ctx, turn, reason, onStream)
+		}
+
 		var currentResponse strings.Builder
-		toolDefs := a.Registry.GetDefinitions()
+		toolDefs := a.activeTools.definitions()
 
-		// 觸發生成開始回調 (供 UI 顯示 "Thinking..." 提示)
-		if a.OnGenerateStart != nil {
-			a.OnGenerateStart()
+		// [CONTEXT] 依模型 num_ctx 組裝上下文，內容有變動時回報捨棄或壓縮了什麼
+		messages, report := a.buildContext(ctx, turn, toolDefs)
+		if report.Changed() && report != lastReport {
+			fmt.Printf("📐 [Context] %s\n", report)
+			if a.Logger != nil {
+				a.Logger.LogContextTrim(report.String())
+			}
 		}
+		lastReport = report
 
 		// 呼叫 Provider 進行對話串流 (不再寫死 ollama.ChatStream)
 		if a.Provider == nil {
 			return "", fmt.Errorf("Agent Provider 未設定")
 		}
 
+		// 生成開始事件 (供 UI 顯示 "Thinking..." 提示)
+		iteration++
+		a.emit(Event{Kind: KindGenerating, Iteration: iteration})
 		aiMsg, err := a.Provider(
+			llms.WithUsageTags(ctx, llms.UsageTags{Iteration: iteration}),
 			a.ModelName,
-			a.Session.Messages,
+			messages,
 			toolDefs,
 			a.Options,
 			func(content string) {
 				currentResponse.WriteString(content)
+				a.emit(Event{Kind: KindTokenDelta, Text: content})
 				if onStream != nil {
 					onStream(content)
 				}
@@ -209,6 +301,21 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 		)
 
 		if err != nil {
+			if ctx.Err() != nil {
+				// 保留已串流出的部分回覆，讓 Session 與使用者看到的內容一致
+				partial := aiMsg.Content
+				if partial == "" {
+					partial = currentResponse.String()
+				}
+				if strings.TrimSpace(partial) != "" {
+					a.Session.Messages = append(a.Session.Messages, ollama.Message{
+						Role:    "assistant",
+						Content: partial + "\n\n（回覆已中斷）",
+					})
+					finalResponse = partial
+				}
+				return finalResponse, a.interrupted(ctx.Err())
+			}
 			// [LOG] 記錄錯誤
 			if a.Logger != nil {
 				a.Logger.LogError("AI 思考錯誤", err)
@@ -216,372 +323,25 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 			return "", fmt.Errorf("AI 思考錯誤: %v", err)
 		}
 
-		// [FIX] 補救措施：如果 ToolCalls 為空，但 Content 看起來像是 JSON 工具呼叫
-		// 有些情況下，LLM 甚至會一次輸出多個獨立的 JSON block
-		if len(aiMsg.ToolCalls) == 0 {
-			content := strings.TrimSpace(aiMsg.Content)
-
-			// 尋找所有的 JSON blocks (替換為大括號計數邏輯，支援巢狀 JSON)
-			matches := extractJSONBlocks(content)
-
-			parsedCount := 0
-			for _, jsonStr := range matches {
-				// 嘗試解析這種非標準的 JSON 輸出
-				// 例如: {"type": "function", "name": "fs_append_to_file", "parameters": {...}}
-				var rawCall struct {
-					Name       string                         `json:"name"`
-					Action     string                         `json:"action"`     // Support "action" instead of "name"
-					Parameters *api.ToolCallFunctionArguments `json:"parameters"` // 改變為指標以允許 nil 檢查
-					Arguments  *api.ToolCallFunctionArguments `json:"arguments"`
-				}
-
-				if err := json.Unmarshal([]byte(jsonStr), &rawCall); err == nil {
-					funcName := rawCall.Name
-					if funcName == "" {
-						funcName = rawCall.Action
-					}
-
-					// [FIX] 嘗試從參數特徵推斷 (如果 AI 漏寫 action/name)
-					if funcName == "" {
-						var inferMap map[string]interface{}
-						if json.Unmarshal([]byte(jsonStr), &inferMap) == nil {
-							// 若包含 content 和 category，高機率是 memory_save 的參數體
-							if _, hasContent := inferMap["content"]; hasContent {
-								if _, hasCategory := inferMap["category"]; hasCategory {
-									funcName = "memory_save"
-								}
-							}
-						}
-					}
-
-					if funcName != "" {
-						fmt.Printf("🔍 [Agent] 偵測到原始 JSON 工具呼叫: %s\n", funcName)
-
-						// 參數相容性處理: 有些模型會用 parameters 代替 arguments
-						var finalArgs api.ToolCallFunctionArguments
-
-						if rawCall.Arguments != nil {
-							finalArgs = *rawCall.Arguments
-						} else if rawCall.Parameters != nil {
-							finalArgs = *rawCall.Parameters
-						} else {
-							// 嘗試將整個 JSON 視為 Arguments
-							var fullArgs map[string]interface{}
-							if err := json.Unmarshal([]byte(jsonStr), &fullArgs); err == nil {
-								delete(fullArgs, "name")
-								delete(fullArgs, "action")
-
-								// Convert map to api.ToolCallFunctionArguments
-								argsBytes, _ := json.Marshal(fullArgs)
-								var convertedArgs api.ToolCallFunctionArguments
-								_ = json.Unmarshal(argsBytes, &convertedArgs)
-								finalArgs = convertedArgs
-							}
-						}
-
-						// 建構標準 ToolCall
-						aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-							Function: api.ToolCallFunction{
-								Name:      funcName,
-								Arguments: finalArgs,
-							},
-						})
-						parsedCount++
-					}
-				}
-			}
-
-			if parsedCount > 0 {
-				aiMsg.Content = ""
-				finalResponse = ""
-			}
-		}
-
-		// [FIX] 補救措施 2：處理 Python 風格的工具呼叫
-		// Llama 有時會輸出 <|python_tag|>get_weather(city="苗栗") 格式
-		// 或在文字中嵌入 function_name(key="value") 格式
-		if len(aiMsg.ToolCalls) == 0 {
-			content := strings.TrimSpace(aiMsg.Content)
-			// 移除 <|python_tag|> 前綴
-			cleaned := content
-			if idx := strings.Index(cleaned, "<|python_tag|>"); idx != -1 {
-				cleaned = strings.TrimSpace(cleaned[idx+len("<|python_tag|>"):])
-			}
-
-			// 匹配 function_name(key=value, key2=value2) 格式 (不限定行首行尾)
-			// 例如: get_weather(city="苗栗") 或嵌入在自然語言文字中
-			pyCallRe := regexp.MustCompile(`(\w+)\((\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+)(?:\s*,\s*\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+))*)\)`)
-			if m := pyCallRe.FindStringSubmatch(cleaned); m != nil {
-				funcName := m[1]
-				argsStr := m[2]
-
-				fmt.Printf("🔍 [Agent] 偵測到 Python 風格工具呼叫: %s(%s)\n", funcName, argsStr)
-
-				// 解析 key=value 或 key="value" 參數
-				argsMap := make(map[string]interface{})
-				// 匹配 key="value" 或 key='value' 或 key=value
-				argRe := regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)'|(\S+))`)
-				for _, am := range argRe.FindAllStringSubmatch(argsStr, -1) {
-					key := am[1]
-					val := am[2] // double-quoted
-					if val == "" {
-						val = am[3] // single-quoted
-					}
-					if val == "" {
-						val = am[4] // unquoted
-					}
-					argsMap[key] = val
-				}
-
-				// 轉換為 api.ToolCallFunctionArguments
-				argsBytes, _ := json.Marshal(argsMap)
-				var finalArgs api.ToolCallFunctionArguments
-				_ = json.Unmarshal(argsBytes, &finalArgs)
-
-				aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-					Function: api.ToolCallFunction{
-						Name:      funcName,
-						Arguments: finalArgs,
-					},
-				})
+		budget.addUsage(aiMsg)
+		a.lastLLM = aiMsg.Usage
 
-				aiMsg.Content = ""
-				finalResponse = ""
-			}
-
-		}
-
-		// [FIX] 補救措施 2.5：處理方括號風格的工具呼叫
-		// Llama 有時會輸出 [get_taiwan_weather location="苗栗縣"] 格式
-		if len(aiMsg.ToolCalls) == 0 {
-			content := strings.TrimSpace(aiMsg.Content)
-			// 匹配 [tool_name key="value" key2="value2"] 格式
-			bracketRe := regexp.MustCompile(`\[(\w+)\s+((?:\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+)\s*)+)\]`)
-			if m := bracketRe.FindStringSubmatch(content); m != nil {
-				funcName := m[1]
-				argsStr := m[2]
-
-				fmt.Printf("🔍 [Agent] 偵測到方括號風格工具呼叫: [%s %s]\n", funcName, argsStr)
-
-				// 解析 key=value 或 key="value" 參數
-				argsMap := make(map[string]interface{})
-				argRe := regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)'|(\S+))`)
-				for _, am := range argRe.FindAllStringSubmatch(argsStr, -1) {
-					key := am[1]
-					val := am[2] // double-quoted
-					if val == "" {
-						val = am[3] // single-quoted
-					}
-					if val == "" {
-						val = am[4] // unquoted
-					}
-					argsMap[key] = val
-				}
-
-				// 轉換為 api.ToolCallFunctionArguments
-				argsBytes, _ := json.Marshal(argsMap)
-				var finalArgs api.ToolCallFunctionArguments
-				_ = json.Unmarshal(argsBytes, &finalArgs)
-
-				aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-					Function: api.ToolCallFunction{
-						Name:      funcName,
-						Arguments: finalArgs,
-					},
-				})
-
-				aiMsg.Content = ""
-				finalResponse = ""
-			}
+		// [THINKING] 推理內容只交給 UI 顯示，不寫入 Session、日誌或記憶
+		if aiMsg.Thinking != "" {
+			a.emit(Event{Kind: KindThinking, Text: aiMsg.Thinking})
 		}
 
-		// [FIX] 補救措施 2.6：處理裸工具名稱 + key="value" 的常見格式
-		// Llama 有時會輸出 browser_open url="https://..." 格式
+		// [FIX] 補救措施：ToolCalls 為空，但內容中夾帶了工具呼叫 (JSON、Python 風格、方括號、敘述式等)
+		// 依模型家族的解析器順序還原，第一個成功的解析器生效 (見 internal/toolparse)
 		if len(aiMsg.ToolCalls) == 0 {
-			content := strings.TrimSpace(aiMsg.Content)
-			// 匹配 tool_name key="value" key2="value2" 格式 (確保不在引號或括號內)
-			nakedRe := regexp.MustCompile(`^([\w_]+)\s+((?:\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+)\s*)+)$`)
-
-			// 為了處理可能前面有一些空行或提示詞，先切出最後一行來檢查
-			lines := strings.Split(content, "\n")
-			for i := len(lines) - 1; i >= 0; i-- {
-				line := strings.TrimSpace(lines[i])
-				if m := nakedRe.FindStringSubmatch(line); len(m) == 3 {
-					funcName := m[1]
-					argsStr := m[2]
-
-					// 確保是真的工具名稱
-					isValidTool := false
-					for _, d := range toolDefs {
-						if d.Function.Name == funcName {
-							isValidTool = true
-							break
-						}
-					}
-
-					if isValidTool {
-						fmt.Printf("🔍 [Agent] 偵測到裸參數風格工具呼叫: %s %s\n", funcName, argsStr)
-
-						argsMap := make(map[string]interface{})
-						argRe := regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)'|(\S+))`)
-						for _, am := range argRe.FindAllStringSubmatch(argsStr, -1) {
-							key := am[1]
-							val := am[2] // double-quoted
-							if val == "" {
-								val = am[3] // single-quoted
-							}
-							if val == "" {
-								val = am[4] // unquoted
-							}
-							argsMap[key] = val
-						}
-
-						argsBytes, _ := json.Marshal(argsMap)
-						var finalArgs api.ToolCallFunctionArguments
-						_ = json.Unmarshal(argsBytes, &finalArgs)
-
-						aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-							Function: api.ToolCallFunction{
-								Name:      funcName,
-								Arguments: finalArgs,
-							},
-						})
-
-						// 移除工具呼叫那一行，保留前面的說話內容
-						lines[i] = ""
-						aiMsg.Content = strings.Join(lines, "\n")
-						finalResponse = aiMsg.Content
-						break
-					}
-				}
-			}
-		}
-
-		// [FIX] 補救措施 3：處理自然語言描述 + 非標準參數的模式
-		// 支援的格式:
-		//   (a) 裸 JSON 參數: "我會呼叫 get_taiwan_weather... { "location": "苗栗縣" }"
-		//   (b) URL query string: "get_taiwan_weather?location=苗栗縣"
-		if len(aiMsg.ToolCalls) == 0 {
-			content := strings.TrimSpace(aiMsg.Content)
-
-			// 在文字中搜尋已知工具名稱
-			detectedTool := ""
-			for _, tDef := range toolDefs {
-				if strings.Contains(content, tDef.Function.Name) {
-					detectedTool = tDef.Function.Name
-					break
-				}
-			}
-
-			if detectedTool != "" {
-				var parsed bool
-
-				// (a) 嘗試裸 JSON 參數
-				start := strings.Index(content, "{")
-				end := strings.LastIndex(content, "}")
-				if start != -1 && end > start {
-					jsonStr := content[start : end+1]
-					var argsMap map[string]interface{}
-					if err := json.Unmarshal([]byte(jsonStr), &argsMap); err == nil {
-						if _, hasName := argsMap["name"]; !hasName {
-							fmt.Printf("🔍 [Agent] 偵測到自然語言工具呼叫: %s + %s\n", detectedTool, jsonStr)
-							argsBytes, _ := json.Marshal(argsMap)
-							var finalArgs api.ToolCallFunctionArguments
-							_ = json.Unmarshal(argsBytes, &finalArgs)
-							aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-								Function: api.ToolCallFunction{
-									Name:      detectedTool,
-									Arguments: finalArgs,
-								},
-							})
-							aiMsg.Content = ""
-							finalResponse = ""
-							parsed = true
-						}
-					}
-				}
-
-				// (b) 嘗試 URL query string: tool_name?key=value&key2=value2
-				if !parsed {
-					qsRe := regexp.MustCompile(regexp.QuoteMeta(detectedTool) + `\?([^\s]+)`)
-					if m := qsRe.FindStringSubmatch(content); m != nil {
-						queryStr := m[1]
-						argsMap := make(map[string]interface{})
-						for _, pair := range strings.Split(queryStr, "&") {
-							kv := strings.SplitN(pair, "=", 2)
-							if len(kv) == 2 {
-								argsMap[kv[0]] = kv[1]
-							}
-						}
-						if len(argsMap) > 0 {
-							fmt.Printf("🔍 [Agent] 偵測到 URL query string 工具呼叫: %s?%s\n", detectedTool, queryStr)
-							argsBytes, _ := json.Marshal(argsMap)
-							var finalArgs api.ToolCallFunctionArguments
-							_ = json.Unmarshal(argsBytes, &finalArgs)
-							aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-								Function: api.ToolCallFunction{
-									Name:      detectedTool,
-									Arguments: finalArgs,
-								},
-							})
-							aiMsg.Content = ""
-							finalResponse = ""
-						}
-					}
-				}
-			}
-		}
-
-		// [FIX] 補救措施 3.5：處理敘述式工具呼叫 + key: value 參數模式
-		// LLM 有時會輸出: "我將呼叫 read_calendars 工具...\n from: 2026-02-26\n to: 2026-02-27"
-		if len(aiMsg.ToolCalls) == 0 {
-			content := strings.TrimSpace(aiMsg.Content)
-
-			// 在文字中搜尋已知工具名稱，同時收集該工具的參數名清單
-			detectedTool := ""
-			var toolParamNames []string
-			for _, tDef := range toolDefs {
-				if strings.Contains(content, tDef.Function.Name) {
-					detectedTool = tDef.Function.Name
-					toolParamNames = tDef.Function.Parameters.Required
-					break
-				}
-			}
-
-			if detectedTool != "" && len(toolParamNames) > 0 {
-				// 提取 key: value 或 key：value 的行（支援全形冒號）
-				kvRe := regexp.MustCompile(`(?m)^\s*(\w+)\s*[:：]\s*(.+?)\s*$`)
-				kvMatches := kvRe.FindAllStringSubmatch(content, -1)
-				argsMap := make(map[string]interface{})
-
-				for _, m := range kvMatches {
-					key := strings.TrimSpace(m[1])
-					val := strings.TrimSpace(m[2])
-					// 只接受與工具定義中參數名匹配的 key
-					for _, p := range toolParamNames {
-						if strings.EqualFold(key, p) {
-							argsMap[p] = val
-							break
-						}
-					}
-				}
-
-				if len(argsMap) > 0 {
-					fmt.Printf("🔍 [Agent] 偵測到敘述式工具呼叫: %s (key: value pattern)\n", detectedTool)
-
-					argsBytes, _ := json.Marshal(argsMap)
-					var finalArgs api.ToolCallFunctionArguments
-					_ = json.Unmarshal(argsBytes, &finalArgs)
-
-					aiMsg.ToolCalls = append(aiMsg.ToolCalls, api.ToolCall{
-						Function: api.ToolCallFunction{
-							Name:      detectedTool,
-							Arguments: finalArgs,
-						},
-					})
-					aiMsg.Content = ""
-					finalResponse = ""
+			if res, ok := toolparse.ForModel(a.ModelName).Parse(aiMsg.Content, toolDefs); ok {
+				fmt.Printf("🔍 [Agent] 解析器 %s 從內容還原 %d 個工具呼叫 (模型 %s)\n", res.Parser, len(res.Calls), a.ModelName)
+				if a.Logger != nil {
+					a.Logger.LogToolCallRecovery(res.Parser, a.ModelName, len(res.Calls))
 				}
+				aiMsg.ToolCalls = res.Calls
+				aiMsg.Content = res.Content
+				finalResponse = res.Content
 			}
 		}
 
@@ -589,10 +349,8 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 		if aiMsg.Content != "" {
 			// 如果 fallback 成功，這裡 Content 會變空，就不會觸發回調
 			finalResponse = aiMsg.Content
-			// 觸發訊息完成回調 (供 UI 渲染 Markdown)
-			if a.OnModelMessageComplete != nil {
-				a.OnModelMessageComplete(finalResponse)
-			}
+			// 訊息完成事件 (供 UI 渲染 Markdown)
+			a.emit(Event{Kind: KindMessage, Text: finalResponse})
 			// [LOG] 記錄 AI 回應
 			if finalResponse != "" {
 				if a.Logger != nil {
@@ -607,10 +365,11 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 						summarizeFunc := func(model string, prompt string) (string, error) {
 							var res strings.Builder
 							chatFn := llms.GetDefaultChatStream()
-							_, err := chatFn(model, []ollama.Message{
+							ctxSum := llms.WithUsageTags(ctx, llms.UsageTags{Workflow: "summarize"})
+							_, err := chatFn(ctxSum, model, []ollama.Message{
 								{Role: "system", Content: "你是一個對話摘要專家。請幫我精煉對話。"},
 								{Role: "user", Content: prompt},
-							}, nil, a.Options, func(c string) { res.WriteString(c) })
+							}, nil, llms.ProfileOptions(llms.ProfileSummarize), func(c string) { res.WriteString(c) })
 							return res.String(), err
 						}
 						_ = a.ActiveBuffer.TriggerSummarization(a.ModelName, summarizeFunc)
@@ -633,8 +392,23 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 		// 執行工具
 		forceBreakState := false
 		var forcedAssistReply string
+		var limitReason string
 
+		// [CANCEL] 取消後不再執行工具，但仍補上 tool 訊息，避免 Session 留下沒有結果的 tool_calls
+		if err := ctx.Err(); err != nil {
+			for _, skipped := range aiMsg.ToolCalls {
+				a.Session.Messages = append(a.Session.Messages, ollama.Message{
+					Role:    "tool",
+					Content: fmt.Sprintf("【SYSTEM】: 工具 %s 未執行（對話已中斷）", skipped.Function.Name),
+				})
+			}
+			return finalResponse, a.interrupted(err)
+		}
+
+		// 第一階段：整理參數並檢查重複呼叫 (依原始順序)
+		jobs := make([]*toolJob, 0, len(aiMsg.ToolCalls))
 		for _, tc := range aiMsg.ToolCalls {
+
 			argsJSON, _ := json.Marshal(tc.Function.Arguments)
 			argsStr := string(argsJSON)
 
@@ -684,30 +458,75 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 				}
 			}
 
+			job := &toolJob{call: tc, args: argsStr}
+			jobs = append(jobs, job)
+			a.activeTools.add(tc.Function.Name) // 清單外但存在的工具，之後的回合也提供給模型
+
+			// [LIMIT] 相同工具與參數重複呼叫過多次時不再執行
+			if reason := budget.trackCall(tc.Function.Name, argsStr); reason != "" {
+				limitReason = reason
+				job.skip = reason
+				continue
+			}
+
+			// [APPROVAL] 風險等級達到門檻的工具需經使用者核准，拒絕的原因會當作工具結果回傳給模型
+			if a.Approval != nil {
+				req := approval.Request{
+					Tool: tc.Function.Name,
+					Args: argsStr,
+					Risk: approval.Assess(a.registry(), tc.Function.Name, argsStr),
+				}
+				if name, ok := a.registry().ToolName(tc.Function.Name); ok {
+					req.Tool = name // 「一律允許」與 unattended 以實際工具名稱比對
+				}
+				if a.Session != nil {
+					req.SessionID = a.Session.ID
+				}
+				if ok, reason := a.Approval.Check(ctx, a.Approver, req); !ok {
+					job.skip = reason
+					if a.Logger != nil {
+						a.Logger.LogToolCall(tc.Function.Name, argsStr)
+						a.Logger.LogToolResult(tc.Function.Name, "", fmt.Errorf("未核准: %s", reason))
+					}
+					continue
+				}
+			}
+
 			// [LOG] 記錄工具呼叫
 			if a.Logger != nil {
 				a.Logger.LogToolCall(tc.Function.Name, argsStr)
 			}
 
-			// 觸發工具呼叫回調 (供 UI 顯示 "Executing..." 提示)
-			if a.OnToolCall != nil {
-				a.OnToolCall(tc.Function.Name, argsStr)
-			}
+			// 工具呼叫事件 (供 UI 顯示 "Executing..." 提示)
+			a.emit(Event{Kind: KindToolCall, Tool: tc.Function.Name, Args: argsStr})
+		}
+
+		// 第二階段：執行工具 (可平行的工具會同時執行)
+		cancelled := a.executeToolJobs(ctx, jobs)
 
-			result, toolErr := a.Registry.CallTool(tc.Function.Name, argsStr)
+		// 第三階段：依原始呼叫順序處理結果並寫入 Session
+		for _, job := range jobs {
+			tc := job.call
+			if job.skip != "" {
+				feedback := fmt.Sprintf("【SYSTEM】: 工具 %s 未執行（%s）", tc.Function.Name, job.skip)
+				// 未核准或被略過的工具同樣發出結果事件，訂閱者不會看到沒有結果的工具呼叫
+				a.emit(Event{Kind: KindToolResult, Tool: tc.Function.Name, Args: job.args, Text: feedback, Error: job.skip})
+				a.Session.Messages = append(a.Session.Messages, ollama.Message{
+					Role:    "tool",
+					Content: feedback,
+				})
+				continue
+			}
+			result, toolErr := job.result, job.err
 
 			// [LOG] 記錄工具結果
 			if a.Logger != nil {
 				a.Logger.LogToolResult(tc.Function.Name, result, toolErr)
 			}
 
-			// [SHORT-TERM MEMORY] 將工具回應自動存入短期記憶
-			if toolErr == nil && result != "" && a.OnShortTermMemory != nil {
-				// 根據工具名稱決定來源分類
-				source := toolNameToMemorySource(tc.Function.Name)
-				if source != "" {
-					a.OnShortTermMemory(source, result)
-				}
+			// [SPILL] 過大的結果存入結果存放區，對話中只放摘要與預覽
+			if toolErr == nil {
+				result = a.spillLargeResult(ctx, tc.Function.Name, result)
 			}
 
 			// --- 強化背景執行的反饋 ---
@@ -770,14 +589,15 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 				}
 			}
 
-			// 觸發結果回調
-			if a.OnToolResult != nil {
-				msgToPrint := result
-				if toolFeedback != "" {
-					msgToPrint = toolFeedback
-				}
-				a.OnToolResult(msgToPrint)
+			// 工具結果事件
+			resultEvent := Event{Kind: KindToolResult, Tool: tc.Function.Name, Args: job.args, Text: result}
+			if toolFeedback != "" {
+				resultEvent.Text = toolFeedback
 			}
+			if toolErr != nil {
+				resultEvent.Error = toolErr.Error()
+			}
+			a.emit(resultEvent)
 
 			// 將工具執行結果加入歷史
 			a.Session.Messages = append(a.Session.Messages, ollama.Message{
@@ -795,11 +615,16 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 							Content: "[SYSTEM] ⚠️ 計畫中仍有未完成的步驟。你必須立即繼續執行下一個步驟，不要回覆使用者。",
 						})
 						fmt.Println("🔄 [Agent] 計畫仍有未完成步驟，強制繼續執行")
+						a.emit(Event{Kind: KindPlanStep, Text: "計畫仍有未完成步驟，繼續執行"})
 					}
 				}
 			}
 		}
 
+		if cancelled != nil {
+			return finalResponse, a.interrupted(cancelled)
+		}
+
 		if forceBreakState {
 			if forcedAssistReply != "" {
 				// 如果有強制回覆，代表我們人工終止了生成迴圈並代答
@@ -808,58 +633,28 @@ func (a *Agent) Chat(input string, onStream func(string)) (string, error) {
 					Content: forcedAssistReply,
 				})
 				finalResponse = forcedAssistReply
-				if a.OnModelMessageComplete != nil {
-					a.OnModelMessageComplete(finalResponse)
-				}
+				a.emit(Event{Kind: KindMessage, Text: finalResponse})
 				if a.Logger != nil {
 					a.Logger.LogAIResponse(finalResponse)
 				}
 			}
 			break // 打破外層的狀態機迴圈
 		}
+
+		budget.rounds++
+		if limitReason != "" {
+			return a.wrapUpAfterLimit(ctx, turn, limitReason, onStream)
+		}
 	}
 
 	return finalResponse, nil
 }
 
-// toolNameToMemorySource 將工具名稱對應到短期記憶的來源分類
-// 返回空字串表示不需要儲存
-func toolNameToMemorySource(toolName string) string {
-	sourceMap := map[string]string{
-		"get_taiwan_weather": "weather",
-		"manage_calendar":    "calendar",
-		"manage_email":       "email",
-		"web_search":         "search",
-		"knowledge_search":   "knowledge_query",
-	}
-	if source, ok := sourceMap[toolName]; ok {
-		return source
-	}
-	return ""
-}
-
-// extractJSONBlocks 透過計算大括號的數量，精確提取巢狀的 JSON 區塊
-func extractJSONBlocks(text string) []string {
-	var blocks []string
-	startIdx := -1
-	braceCount := 0
-
-	for i, r := range text {
-		switch r {
-case '{':
-			if braceCount == 0 {
-				startIdx = i
-			}
-			braceCount++
-		case '}':
-			braceCount--
-			if braceCount == 0 && startIdx != -1 {
-				blocks = append(blocks, text[startIdx:i+1])
-				startIdx = -1
-			} else if braceCount < 0 {
-				braceCount = 0 // Ignore unmatched closing braces
-			}
-		}
+// interrupted 記錄中斷事件並包裝 ctx 錯誤
+func (a *Agent) interrupted(cause error) error {
+	fmt.Println("⏹️ [Agent] 對話已中斷")
+	if a.Logger != nil {
+		a.Logger.LogError("對話已中斷", cause)
 	}
-	return blocks
+	return fmt.Errorf("對話已中斷: %w", cause)
 }
@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"testing"
 
@@ -21,7 +23,7 @@ func TestSelfEvolutionProtocolInjection(t *testing.T) {
 	// 2. Mock Provider
 	// Simulate LLM calling a non-existent tool
 	callCount := 0
-	mockProvider := func(model string, messages []ollama.Message, tools []api.Tool, options ollama.Options, onStream func(string)) (ollama.Message, error) {
+	mockProvider := func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, options ollama.Options, onStream func(string)) (ollama.Message, error) {
 		callCount++
 		if callCount == 1 {
 			return ollama.Message{
@@ -44,7 +46,7 @@ func TestSelfEvolutionProtocolInjection(t *testing.T) {
 	agent.Provider = mockProvider
 
 	// 3. Execute
-	_, err := agent.Chat("Please use magic tool", nil)
+	_, err := agent.Chat(context.Background(), "Please use magic tool", nil)
 	if err != nil {
 		t.Fatalf("Chat failed: %v", err)
 	}
@@ -70,3 +72,64 @@ func TestSelfEvolutionProtocolInjection(t *testing.T) {
 		t.Errorf("Expected tool feedback to suggestion 'skill_scaffold', got:\n%s", toolMsg.Content)
 	}
 }
+
+func TestChatCancellationKeepsSessionConsistent(t *testing.T) {
+	registry := core.NewRegistry()
+	session := &history.Session{}
+	agent := NewAgent("mock-model", "system prompt", session, registry, nil)
+	agent.ActiveBuffer = nil
+	agent.DailyLogger = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 第一輪回傳兩個工具呼叫，並在回傳前取消 Context，模擬使用者在工具執行前按下 Ctrl+C
+	agent.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, options ollama.Options, onStream func(string)) (ollama.Message, error) {
+		cancel()
+		return ollama.Message{
+			Role: "assistant",
+			ToolCalls: []api.ToolCall{
+				{Function: api.ToolCallFunction{Name: "tool_a", Arguments: api.NewToolCallFunctionArguments()}},
+				{Function: api.ToolCallFunction{Name: "tool_b", Arguments: api.NewToolCallFunctionArguments()}},
+			},
+		}, nil
+	}
+
+	_, err := agent.Chat(ctx, "run both tools", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// 每個 tool_call 都必須有對應的 tool 訊息
+	pending := 0
+	for _, m := range session.Messages {
+		if len(m.ToolCalls) > 0 {
+			pending += len(m.ToolCalls)
+		}
+		if m.Role == "tool" {
+			pending--
+		}
+	}
+	if pending != 0 {
+		t.Errorf("expected every tool call to be answered, %d left dangling: %+v", pending, session.Messages)
+	}
+
+	// 串流中途取消時，部分內容應被保留
+	session.Messages = nil
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	agent.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, options ollama.Options, onStream func(string)) (ollama.Message, error) {
+		onStream("部分回覆")
+		cancel2()
+		return ollama.Message{Role: "assistant", Content: "部分回覆"}, ctx.Err()
+	}
+	reply, err := agent.Chat(ctx2, "hello", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if reply != "部分回覆" {
+		t.Errorf("expected partial reply to be returned, got %q", reply)
+	}
+	last := session.Messages[len(session.Messages)-1]
+	if last.Role != "assistant" || !strings.Contains(last.Content, "部分回覆") {
+		t.Errorf("expected partial assistant message at end of session, got %+v", last)
+	}
+}
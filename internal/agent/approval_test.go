@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/approval"
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+type riskyTool struct{ runs int }
+
+func (r *riskyTool) Name() string  { return "shell_exec" }
+func (r *riskyTool) IsSkill() bool { return false }
+func (r *riskyTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: "shell_exec"}}
+}
+func (r *riskyTool) Run(argsJSON string) (string, error) {
+	r.runs++
+	return "done", nil
+}
+func (r *riskyTool) Risk(argsJSON string) core.RiskLevel { return core.RiskHigh }
+
+func TestRiskyToolRequiresApproval(t *testing.T) {
+	for _, tc := range []struct {
+		decision approval.Decision
+		runs     int
+		result   string
+	}{
+		{approval.Deny, 0, "使用者拒絕執行"},
+		{approval.Allow, 1, "done"},
+	} {
+		tool := &riskyTool{}
+		reg := core.NewRegistry()
+		reg.Register(tool)
+		a := NewAgent("mock-model", "system prompt", &history.Session{}, reg, nil)
+		a.ActiveBuffer = nil
+		a.DailyLogger = nil
+
+		var asked []approval.Request
+		a.Approver = func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+			asked = append(asked, req)
+			return tc.decision, nil
+		}
+		var resultEvent Event
+		a.Events.Subscribe(func(e Event) {
+			if e.Kind == KindToolResult {
+				resultEvent = e
+			}
+		})
+		var toolResult string
+		a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+			if last := messages[len(messages)-1]; last.Role == "tool" {
+				toolResult = last.Content
+				return ollama.Message{Role: "assistant", Content: "好的"}, nil
+			}
+			var args api.ToolCallFunctionArguments
+			args.Set("command", "rm -rf build")
+			return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+				{Function: api.ToolCallFunction{Name: "shell_exec", Arguments: args}},
+			}}, nil
+		}
+
+		if _, err := a.Chat(context.Background(), "清掉 build", nil); err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+		if len(asked) != 1 || asked[0].Risk != core.RiskHigh || !strings.Contains(asked[0].Args, "rm -rf build") {
+			t.Fatalf("%s: unexpected approval requests: %+v", tc.decision, asked)
+		}
+		if tool.runs != tc.runs || !strings.Contains(toolResult, tc.result) {
+			t.Errorf("%s: runs=%d result=%q, want %d runs and %q", tc.decision, tool.runs, toolResult, tc.runs, tc.result)
+		}
+		if resultEvent.Tool != "shell_exec" || !strings.Contains(resultEvent.Text, tc.result) || (tc.runs == 0) != (resultEvent.Error != "") {
+			t.Errorf("%s: unexpected tool result event: %+v", tc.decision, resultEvent)
+		}
+	}
+}
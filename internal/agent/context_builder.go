@@ -0,0 +1,361 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/asccclass/pcai/internal/memory"
+	"github.com/asccclass/pcai/llms"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 上下文組裝 (Token-Budgeted Context)
+// ─────────────────────────────────────────────────────────────
+//
+// 每次呼叫模型前，依模型的 num_ctx 組裝要送出的訊息：
+// System Prompt 與最近幾輪對話保留原文，較舊的對話壓縮成滾動摘要 (寫回 Session)，
+// 舊回合的大型工具結果只送出開頭，已出現在上下文中的記憶片段與今日對話不重複注入。
+// 記憶與今日對話只在組裝時加到本輪的使用者訊息前，不寫入 Session。
+// 每次組裝時捨棄或壓縮了什麼，記錄在 ContextReport。
+
+// ContextBudget 定義上下文組裝的預算
+type ContextBudget struct {
+	MaxTokens     int // 模型未設定 num_ctx 時的上下文大小 (PCAI_CONTEXT_TOKENS)
+	ReserveTokens int // 保留給模型回覆的 Token (PCAI_CONTEXT_RESERVE)
+	KeepTurns     int // 保留原文的最近對話輪數，包含本輪 (PCAI_CONTEXT_KEEP_TURNS)
+	MaxToolResult int // 舊回合單筆工具結果的 Token 上限，0 代表不截斷 (PCAI_CONTEXT_TOOL_RESULT)
+	SpillResult   int // 超過此 Token 數的工具結果改存入結果存放區，只送出預覽，0 代表停用 (PCAI_TOOL_RESULT_SPILL)
+}
+
+// DefaultContextBudget 回傳預設預算，可由環境變數覆寫
+func DefaultContextBudget() ContextBudget {
+	return ContextBudget{
+		MaxTokens:     envInt("PCAI_CONTEXT_TOKENS", 8192),
+		ReserveTokens: envInt("PCAI_CONTEXT_RESERVE", 1024),
+		KeepTurns:     envInt("PCAI_CONTEXT_KEEP_TURNS", 4),
+		MaxToolResult: envInt("PCAI_CONTEXT_TOOL_RESULT", 800),
+		SpillResult:   envInt("PCAI_TOOL_RESULT_SPILL", 1500),
+	}
+}
+
+// ContextReport 記錄一次上下文組裝捨棄或壓縮的內容
+type ContextReport struct {
+	Budget          int  // 可用於訊息的 Token (num_ctx 扣除回覆保留與工具定義)
+	Tokens          int  // 組裝後的估計 Token
+	SummarizedTurns int  // 壓縮進滾動摘要的對話輪數
+	TrimmedResults  int  // 被截斷的工具結果
+	DedupedMemory   int  // 已在上下文中而略過的記憶片段
+	DroppedMemory   bool // 預算不足而捨棄記憶
+	DedupedBuffer   int  // 已在 Session 中而略過的今日對話訊息
+	DroppedBuffer   bool // 預算不足而捨棄今日對話
+}
+
+// Changed 回傳這次組裝是否捨棄或壓縮了任何內容
+func (r ContextReport) Changed() bool {
+	return r.SummarizedTurns > 0 || r.TrimmedResults > 0 || r.DedupedMemory > 0 ||
+		r.DroppedMemory || r.DedupedBuffer > 0 || r.DroppedBuffer || r.Tokens > r.Budget
+}
+
+func (r ContextReport) String() string {
+	var parts []string
+	if r.SummarizedTurns > 0 {
+		parts = append(parts, fmt.Sprintf("摘要 %d 輪舊對話", r.SummarizedTurns))
+	}
+	if r.TrimmedResults > 0 {
+		parts = append(parts, fmt.Sprintf("截斷 %d 筆工具結果", r.TrimmedResults))
+	}
+	if r.DedupedMemory > 0 {
+		parts = append(parts, fmt.Sprintf("略過 %d 段重複記憶", r.DedupedMemory))
+	}
+	if r.DroppedMemory {
+		parts = append(parts, "捨棄記憶上下文")
+	}
+	if r.DedupedBuffer > 0 {
+		parts = append(parts, fmt.Sprintf("略過 %d 則重複的今日對話", r.DedupedBuffer))
+	}
+	if r.DroppedBuffer {
+		parts = append(parts, "捨棄今日對話上下文")
+	}
+	if r.Tokens > r.Budget {
+		parts = append(parts, "仍超出預算")
+	}
+	return fmt.Sprintf("%d/%d tokens：%s", r.Tokens, r.Budget, strings.Join(parts, "、"))
+}
+
+// turnContext 是本輪額外注入的上下文，只送給模型，不寫入 Session
+type turnContext struct {
+	memory string           // 記憶預搜尋結果
+	buffer []ollama.Message // 今日對話 (Active Buffer)
+}
+
+// rollingSummaryPrefix 標記 Session 中的滾動摘要訊息
+const rollingSummaryPrefix = "【先前對話摘要】\n"
+
+// buildContext 依預算組裝送給模型的訊息；必要時會把舊對話壓縮寫回 Session
+func (a *Agent) buildContext(ctx context.Context, turn turnContext, tools []api.Tool) ([]ollama.Message, ContextReport) {
+	budget := a.Context
+	limit := a.Options.NumCtx
+	if limit <= 0 {
+		limit = budget.MaxTokens
+	}
+	if limit <= 0 {
+		limit = 8192
+	}
+	keep := budget.KeepTurns
+	if keep < 1 {
+		keep = 1
+	}
+
+	report := ContextReport{Budget: limit - budget.ReserveTokens - toolTokens(tools)}
+
+	// 1. 去除已在 Session 中的今日對話與記憶片段
+	buffer := make([]ollama.Message, 0, len(turn.buffer))
+	for _, m := range turn.buffer {
+		if inMessages(a.Session.Messages, m.Content) {
+			report.DedupedBuffer++
+			continue
+		}
+		buffer = append(buffer, m)
+	}
+	mem, deduped := dedupeMemory(turn.memory, func(s string) bool {
+		return inMessages(a.Session.Messages, s) || inMessages(buffer, s)
+	})
+	report.DedupedMemory = deduped
+
+	// 2. 依序放寬，直到符合預算
+	trimCurrent := false
+	steps := []func(){
+		func() { report.DroppedBuffer, buffer = len(buffer) > 0, nil },
+		func() { report.SummarizedTurns += a.compactSession(ctx, keep) },
+		func() { trimCurrent = true },
+		func() { report.DroppedMemory, mem = mem != "", "" },
+		func() { report.SummarizedTurns += a.compactSession(ctx, 1) },
+	}
+	messages, trimmed := a.assembleContext(buffer, mem, budget.MaxToolResult, trimCurrent)
+	for _, step := range steps {
+		if countMessageTokens(messages) <= report.Budget {
+			break
+		}
+		step()
+		messages, trimmed = a.assembleContext(buffer, mem, budget.MaxToolResult, trimCurrent)
+	}
+	report.TrimmedResults = trimmed
+	report.Tokens = countMessageTokens(messages)
+	return messages, report
+}
+
+// assembleContext 複製 Session 訊息，截斷大型工具結果並把今日對話與記憶注入本輪的使用者訊息
+func (a *Agent) assembleContext(buffer []ollama.Message, mem string, maxToolResult int, trimCurrent bool) ([]ollama.Message, int) {
+	messages := append([]ollama.Message(nil), a.Session.Messages...)
+
+	// 本輪從最後一則使用者訊息開始
+	current := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			current = i
+			break
+		}
+	}
+
+	trimmed := 0
+	for i := range messages {
+		// 圖片只隨本輪送出，舊回合的圖片不再重複送給模型
+		if i < current {
+			messages[i].Images = nil
+		}
+		if messages[i].Role != "tool" || maxToolResult <= 0 || (i > current && !trimCurrent) {
+			continue
+		}
+		if memory.CountTokens(messages[i].Content) > maxToolResult {
+			messages[i].Content = memory.TruncateByTokens(messages[i].Content, maxToolResult)
+			trimmed++
+		}
+	}
+
+	if current >= 0 {
+		content := messages[current].Content
+		if mem != "" {
+			// 把記憶放在問題之前，讓 LLM 的注意力聚焦在最後的問題上
+			content = mem + "\n\n【使用者問題】\n" + content
+		}
+		if len(buffer) > 0 {
+			var activeCtx strings.Builder
+			activeCtx.WriteString("【今日對話上下文】\n")
+			for _, m := range buffer {
+				activeCtx.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
+			}
+			content = activeCtx.String() + "\n\n" + content
+		}
+		messages[current].Content = content
+	}
+	return messages, trimmed
+}
+
+// compactSession 把最近 keep 輪以前的對話壓縮進滾動摘要，回傳被壓縮的輪數
+func (a *Agent) compactSession(ctx context.Context, keep int) int {
+	msgs := a.Session.Messages
+
+	// 開頭的 system 訊息是 System Prompt 與既有的滾動摘要
+	head := 0
+	for head < len(msgs) && msgs[head].Role == "system" {
+		head++
+	}
+	var prefix []ollama.Message
+	previous := ""
+	for _, m := range msgs[:head] {
+		if strings.HasPrefix(m.Content, rollingSummaryPrefix) {
+			previous = strings.TrimPrefix(m.Content, rollingSummaryPrefix)
+			continue
+		}
+		prefix = append(prefix, m)
+	}
+
+	// 每一輪從使用者訊息開始
+	var starts []int
+	for i := head; i < len(msgs); i++ {
+		if msgs[i].Role == "user" {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) <= keep {
+		return 0
+	}
+	cut := starts[len(starts)-keep]
+	turns := len(starts) - keep
+
+	summary := a.summarizeTurns(ctx, previous, msgs[head:cut])
+	compacted := append(prefix, ollama.Message{Role: "system", Content: rollingSummaryPrefix + summary})
+	a.Session.Messages = append(compacted, msgs[cut:]...)
+
+	fmt.Printf("🗜️ [Context] 已將 %d 輪舊對話壓縮為摘要\n", turns)
+	return turns
+}
+
+// summarizeTurns 把舊對話併入既有摘要；模型失敗時退回保留每則訊息開頭的擷取式摘要
+func (a *Agent) summarizeTurns(ctx context.Context, previous string, older []ollama.Message) string {
+	var transcript strings.Builder
+	var extract strings.Builder
+	for _, m := range older {
+		content := strings.TrimSpace(ollama.StripThinking(m.Content))
+		if m.Role == "tool" {
+			content = memory.TruncateByTokens(content, 200)
+		}
+		for _, tc := range m.ToolCalls {
+			content += fmt.Sprintf(" [呼叫工具 %s]", tc.Function.Name)
+		}
+		if content == "" {
+			continue
+		}
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", m.Role, content))
+		if m.Role == "user" || m.Role == "assistant" {
+			extract.WriteString(fmt.Sprintf("- %s: %s\n", m.Role, memory.TruncateByTokens(content, 60)))
+		}
+	}
+
+	if a.Provider != nil {
+		prompt := fmt.Sprintf("請把以下新的對話內容併入既有摘要，保留使用者的需求、已完成的操作、工具查到的關鍵數據與尚未完成的事項，只輸出更新後的摘要。\n\n【既有摘要】\n%s\n\n【新的對話】\n%s", previous, transcript.String())
+		var streamed strings.Builder
+		msg, err := a.Provider(llms.WithUsageTags(ctx, llms.UsageTags{Workflow: "summarize"}), a.ModelName, []ollama.Message{
+			{Role: "system", Content: "你是一個對話摘要專家。請幫我精煉對話。"},
+			{Role: "user", Content: prompt},
+		}, nil, llms.ProfileOptions(llms.ProfileSummarize), func(c string) { streamed.WriteString(c) })
+
+		summary := strings.TrimSpace(ollama.StripThinking(msg.Content))
+		if summary == "" {
+			summary = strings.TrimSpace(ollama.StripThinking(streamed.String()))
+		}
+		if err == nil && summary != "" {
+			return summary
+		}
+		if err != nil && a.Logger != nil {
+			a.Logger.LogError("對話摘要失敗", err)
+		}
+	}
+
+	summary := strings.TrimSpace(previous + "\n" + extract.String())
+	if a.Context.MaxToolResult > 0 {
+		summary = memory.TruncateByTokens(summary, a.Context.MaxToolResult)
+	}
+	return summary
+}
+
+// memorySnippetRe 對應 BuildMemorySearchFunc 輸出中每段記憶的標題，例如 "--- 背景知識 1 ---"
+var memorySnippetRe = regexp.MustCompile(`(?m)^--- .+ ---$`)
+
+// memoryTrailerRe 對應記憶片段後的區段標題或提示 (【長期深度記憶】、⚠️【注意】)
+var memoryTrailerRe = regexp.MustCompile(`\n+(?:【|⚠️)`)
+
+// dedupeMemory 移除內容已在上下文中的記憶片段；全部重複時回傳空字串
+func dedupeMemory(mem string, inContext func(string) bool) (string, int) {
+	locs := memorySnippetRe.FindAllStringIndex(mem, -1)
+	if len(locs) == 0 {
+		return mem, 0
+	}
+
+	var sb strings.Builder
+	sb.WriteString(mem[:locs[0][0]])
+	dropped := 0
+	for i, loc := range locs {
+		end := len(mem)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		body, trailer := mem[loc[1]:end], ""
+		if t := memoryTrailerRe.FindStringIndex(body); t != nil {
+			body, trailer = body[:t[0]], body[t[0]:]
+		}
+
+		if inContext(strings.TrimSuffix(strings.TrimSpace(body), "...«已截斷»")) {
+			dropped++
+		} else {
+			sb.WriteString(mem[loc[0]:loc[1]])
+			sb.WriteString(body)
+		}
+		sb.WriteString(trailer)
+	}
+	if dropped == len(locs) {
+		return "", dropped
+	}
+	return sb.String(), dropped
+}
+
+// inMessages 判斷內容是否已出現在任一則訊息中
+func inMessages(messages []ollama.Message, content string) bool {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return false
+	}
+	for _, m := range messages {
+		if strings.Contains(m.Content, content) {
+			return true
+		}
+	}
+	return false
+}
+
+// countMessageTokens 估算訊息的 Token 數 (每則訊息另計角色與格式的固定開銷)
+func countMessageTokens(messages []ollama.Message) int {
+	total := 0
+	for _, m := range messages {
+		total += memory.CountTokens(m.Content) + 4
+		if len(m.ToolCalls) > 0 {
+			data, _ := json.Marshal(m.ToolCalls)
+			total += memory.CountTokens(string(data))
+		}
+	}
+	return total
+}
+
+// toolTokens 估算工具定義佔用的 Token 數
+func toolTokens(tools []api.Tool) int {
+	if len(tools) == 0 {
+		return 0
+	}
+	data, _ := json.Marshal(tools)
+	return memory.CountTokens(string(data))
+}
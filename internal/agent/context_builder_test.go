@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func newContextAgent(budget ContextBudget, messages ...ollama.Message) *Agent {
+	a := NewAgent("mock-model", "system prompt", &history.Session{Messages: messages}, core.NewRegistry(), nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+	a.Context = budget
+	return a
+}
+
+func TestBuildContextDedupesMemoryAndBuffer(t *testing.T) {
+	a := newContextAgent(ContextBudget{MaxTokens: 100000, KeepTurns: 4},
+		ollama.Message{Role: "system", Content: "system prompt"},
+		ollama.Message{Role: "user", Content: "苗栗天氣如何"},
+		ollama.Message{Role: "tool", Content: "【SYSTEM】: 苗栗縣 晴 25度"},
+		ollama.Message{Role: "assistant", Content: "苗栗縣今天晴天"},
+		ollama.Message{Role: "user", Content: "那明天呢"},
+	)
+	turn := turnContext{
+		memory: "[MEMORY CONTEXT] 以下是系統短期記憶中的相關資訊：\n" +
+			"\n--- 近期紀錄 1 [2026-10-16] ---\n苗栗縣 晴 25度\n" +
+			"\n--- 近期紀錄 2 [2026-10-15] ---\n苗栗縣 雨 20度\n" +
+			"\n⚠️【注意】：請優先引用。",
+		buffer: []ollama.Message{
+			{Role: "user", Content: "苗栗天氣如何"},
+			{Role: "user", Content: "早上提醒我開會"},
+		},
+	}
+
+	messages, report := a.buildContext(context.Background(), turn, nil)
+	if report.DedupedMemory != 1 || report.DedupedBuffer != 1 {
+		t.Fatalf("report = %+v, want 1 memory and 1 buffer deduped", report)
+	}
+	if !report.Changed() || report.DroppedMemory || report.DroppedBuffer || report.SummarizedTurns != 0 {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	last := messages[len(messages)-1].Content
+	for _, want := range []string{"早上提醒我開會", "苗栗縣 雨 20度", "⚠️【注意】", "【使用者問題】\n那明天呢"} {
+		if !strings.Contains(last, want) {
+			t.Errorf("current turn missing %q:\n%s", want, last)
+		}
+	}
+	if strings.Contains(last, "近期紀錄 1") || strings.Contains(last, "user: 苗栗天氣如何") {
+		t.Errorf("duplicate context injected:\n%s", last)
+	}
+	if a.Session.Messages[len(a.Session.Messages)-1].Content != "那明天呢" {
+		t.Error("injected context must not be written to the session")
+	}
+}
+
+func TestBuildContextTrimsOldToolResults(t *testing.T) {
+	big := strings.Repeat("天氣資料", 200)
+	a := newContextAgent(ContextBudget{MaxTokens: 100000, KeepTurns: 4, MaxToolResult: 20},
+		ollama.Message{Role: "system", Content: "system prompt"},
+		ollama.Message{Role: "user", Content: "查天氣"},
+		ollama.Message{Role: "tool", Content: big},
+		ollama.Message{Role: "assistant", Content: "好的"},
+		ollama.Message{Role: "user", Content: "再查一次"},
+		ollama.Message{Role: "tool", Content: big},
+	)
+
+	messages, report := a.buildContext(context.Background(), turnContext{}, nil)
+	if report.TrimmedResults != 1 {
+		t.Fatalf("trimmed = %d, want 1 (only the old turn)", report.TrimmedResults)
+	}
+	if !strings.HasSuffix(messages[2].Content, "«已截斷»") {
+		t.Errorf("old tool result not trimmed: %d bytes", len(messages[2].Content))
+	}
+	if messages[5].Content != big || a.Session.Messages[2].Content != big {
+		t.Error("current tool result and session must stay verbatim")
+	}
+}
+
+func TestBuildContextSendsImagesOnlyForCurrentTurn(t *testing.T) {
+	a := newContextAgent(ContextBudget{MaxTokens: 100000, KeepTurns: 4},
+		ollama.Message{Role: "system", Content: "system prompt"},
+		ollama.Message{Role: "user", Content: "這是什麼", Images: []string{"b2xk"}},
+		ollama.Message{Role: "assistant", Content: "一隻貓"},
+	)
+	a.Session.Messages = append(a.Session.Messages, ollama.Message{Role: "user", Content: "這張呢", Images: []string{"bmV3"}})
+
+	messages, _ := a.buildContext(context.Background(), turnContext{}, nil)
+	if len(messages[1].Images) != 0 || len(messages[3].Images) != 1 {
+		t.Errorf("images = old %v, current %v", messages[1].Images, messages[3].Images)
+	}
+	if len(a.Session.Messages[1].Images) != 1 {
+		t.Error("session must keep the original images")
+	}
+}
+
+func TestBuildContextRollingSummary(t *testing.T) {
+	var prompts []string
+	provider := func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		prompts = append(prompts, messages[len(messages)-1].Content)
+		return ollama.Message{Role: "assistant", Content: "摘要第" + string(rune('0'+len(prompts))) + "版"}, nil
+	}
+
+	long := strings.Repeat("很長的對話內容", 20)
+	addTurns := func(msgs []ollama.Message, n int) []ollama.Message {
+		for i := 0; i < n; i++ {
+			msgs = append(msgs,
+				ollama.Message{Role: "user", Content: "問題" + long},
+				ollama.Message{Role: "assistant", Content: "回答" + long},
+			)
+		}
+		return msgs
+	}
+	a := newContextAgent(ContextBudget{MaxTokens: 1000, KeepTurns: 2},
+		addTurns([]ollama.Message{{Role: "system", Content: "system prompt"}}, 10)...)
+	a.Provider = provider
+
+	_, report := a.buildContext(context.Background(), turnContext{}, nil)
+	if report.SummarizedTurns != 8 || report.Tokens > report.Budget {
+		t.Fatalf("report = %+v, want 8 turns summarized within budget", report)
+	}
+	got := a.Session.Messages
+	if len(got) != 6 || got[0].Content != "system prompt" || got[1].Content != rollingSummaryPrefix+"摘要第1版" {
+		t.Fatalf("session not compacted to prompt + summary + 2 turns: %d messages", len(got))
+	}
+
+	// 再加幾輪後，新的摘要要包含既有摘要
+	a.Session.Messages = append(addTurns(a.Session.Messages, 8), ollama.Message{Role: "user", Content: "最新問題"})
+	_, report = a.buildContext(context.Background(), turnContext{}, nil)
+	if report.SummarizedTurns == 0 || !strings.Contains(prompts[len(prompts)-1], "摘要第1版") {
+		t.Fatalf("rolling summary did not include previous summary: %+v", report)
+	}
+	if got := a.Session.Messages; got[1].Content != rollingSummaryPrefix+"摘要第2版" || got[len(got)-1].Content != "最新問題" {
+		t.Errorf("unexpected compacted session: %+v", got[1])
+	}
+}
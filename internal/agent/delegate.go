@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/internal/memory"
+	"github.com/asccclass/pcai/llms"
+	"github.com/asccclass/pcai/llms/ollama"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 子 Agent 委派 (Sub-Agent Delegation)
+// ─────────────────────────────────────────────────────────────
+//
+// 複雜任務 (例如上網研究) 會產生大量工具結果，全部留在主對話會擠掉上下文。
+// 主 Agent 可透過 delegate_task 建立子 Agent：子 Agent 有自己的暫時 Session、
+// 指定的模型、白名單內的工具與獨立的迴圈上限，完成後只把精簡結果交回主對話。
+
+// DelegateToolName 是委派工具的名稱，子 Agent 不可再委派以避免無限遞迴
+const DelegateToolName = "delegate_task"
+
+// subAgentPrompt 是子 Agent 的系統提示
+const subAgentPrompt = `你是主助理委派的子助理，只負責完成下面交辦的單一任務。
+- 只能使用提供給你的工具，不要向使用者提問，資訊不足時盡力完成並註明缺少什麼。
+- 完成後用繁體中文精簡回報結果 (重點條列，附上資料來源網址或檔名)，不要重述過程。
+- 回報內容會直接交給主助理，不需要問候或客套話。`
+
+// SubTask 描述委派給子 Agent 的工作
+type SubTask struct {
+	Task      string              // 任務說明
+	Tools     []string            // 子 Agent 可使用的工具 (白名單)
+	Model     string              // 使用的模型，空字串沿用主對話這一輪的模型 (Caller.ChatModel)
+	Provider  llms.ChatStreamFunc // 空值沿用主對話這一輪的 Provider (Caller.ChatStream)，都沒有時使用預設 Provider
+	Limits    LoopLimits          // 子 Agent 的迴圈上限，零值使用 DefaultSubAgentLimits
+	MaxResult int                 // 交回主對話的結果 Token 上限 (PCAI_DELEGATE_RESULT_TOKENS，預設 600)
+	Caller    *core.RunEnv        // 主對話的呼叫端資訊 (頻道與發送者沿用給子 Agent 的工具)
+}
+
+// DefaultSubAgentLimits 回傳子 Agent 的預設上限 (比主對話嚴格)
+func DefaultSubAgentLimits() LoopLimits {
+	return LoopLimits{
+		MaxRounds:   envInt("PCAI_DELEGATE_MAX_ROUNDS", 6),
+		MaxWallTime: time.Duration(envInt("PCAI_DELEGATE_MAX_SECONDS", 180)) * time.Second,
+		MaxRepeats:  2,
+		MaxToolTime: time.Duration(envInt("PCAI_TOOL_TIMEOUT_SECONDS", 120)) * time.Second,
+	}
+}
+
+// RunSubAgent 以受限工具集建立子 Agent 執行任務，回傳精簡結果
+// 子 Agent 沒有可詢問使用者的頻道，需要核准的工具一律不會執行
+func RunSubAgent(ctx context.Context, registry *core.Registry, model string, task SubTask) (string, error) {
+	if strings.TrimSpace(task.Task) == "" {
+		return "", fmt.Errorf("未提供委派任務內容")
+	}
+	var allowed []string
+	for _, name := range task.Tools {
+		if name != DelegateToolName {
+			allowed = append(allowed, name)
+		}
+	}
+	if len(allowed) == 0 {
+		return "", fmt.Errorf("必須指定子 Agent 可使用的工具")
+	}
+	subset, missing := registry.Subset(allowed...)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("找不到工具: %s", strings.Join(missing, ", "))
+	}
+	if task.Caller != nil {
+		if task.Caller.ChatModel != "" {
+			model = task.Caller.ChatModel
+		}
+		if task.Provider == nil {
+			task.Provider = task.Caller.ChatStream
+		}
+	}
+	if task.Model != "" {
+		model = task.Model
+	}
+	if task.Limits == (LoopLimits{}) {
+		task.Limits = DefaultSubAgentLimits()
+	}
+	if task.MaxResult <= 0 {
+		task.MaxResult = envInt("PCAI_DELEGATE_RESULT_TOKENS", 600)
+	}
+
+	// 暫時 Session：不存檔、不寫入每日日誌，也不載入今日對話
+	sess := history.NewSession()
+	sess.ID = fmt.Sprintf("session_subagent_%d", time.Now().UnixNano())
+	sess.Messages = append(sess.Messages, ollama.Message{Role: "system", Content: subAgentPrompt})
+
+	child := newEphemeralAgent(model, subAgentPrompt, sess, subset, nil)
+	child.Limits = task.Limits
+	child.UseProfile("delegate")
+	if task.Caller != nil {
+		child.Channel, child.Sender = task.Caller.Channel, task.Caller.Sender
+	}
+	if task.Provider != nil {
+		child.Provider = task.Provider
+	}
+
+	fmt.Printf("🧬 [Delegate] 子 Agent (%s) 開始執行，可用工具: %s\n", model, strings.Join(allowed, ", "))
+	ctx = llms.WithUsageTags(ctx, llms.UsageTags{SessionID: sess.ID, Workflow: "delegate"})
+	reply, err := child.Chat(ctx, task.Task, nil)
+	if err != nil {
+		return "", fmt.Errorf("子 Agent 執行失敗: %w", err)
+	}
+
+	reply = strings.TrimSpace(reply)
+	if memory.CountTokens(reply) > task.MaxResult {
+		reply = memory.TruncateByTokens(reply, task.MaxResult)
+	}
+	fmt.Printf("🧬 [Delegate] 子 Agent 完成 (%d 則訊息)\n", len(sess.Messages))
+	return reply, nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+type namedTool struct {
+	name string
+	runs int
+}
+
+func (n *namedTool) Name() string  { return n.name }
+func (n *namedTool) IsSkill() bool { return false }
+func (n *namedTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: n.name}}
+}
+func (n *namedTool) Run(argsJSON string) (string, error) {
+	n.runs++
+	return n.name + " 的結果", nil
+}
+
+func TestRunSubAgentUsesAllowlistedTools(t *testing.T) {
+	reg := core.NewRegistry()
+	search := &namedTool{name: "web_search"}
+	shell := &namedTool{name: "shell_exec"}
+	reg.Register(search)
+	reg.Register(shell)
+	reg.Register(&namedTool{name: DelegateToolName})
+
+	var seenTools []string
+	var seenModel string
+	provider := func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		seenModel = model
+		seenTools = seenTools[:0]
+		for _, td := range tools {
+			seenTools = append(seenTools, td.Function.Name)
+		}
+		if messages[len(messages)-1].Role == "tool" {
+			return ollama.Message{Role: "assistant", Content: "- 找到三篇文章"}, nil
+		}
+		return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "web_search"}},
+		}}, nil
+	}
+
+	result, err := RunSubAgent(context.Background(), reg, "main-model", SubTask{
+		Task:     "研究苗栗的觀光景點",
+		Tools:    []string{"web_search", DelegateToolName},
+		Model:    "research-model",
+		Provider: provider,
+	})
+	if err != nil {
+		t.Fatalf("RunSubAgent: %v", err)
+	}
+	if result != "- 找到三篇文章" || search.runs != 1 || shell.runs != 0 {
+		t.Errorf("result=%q search=%d shell=%d", result, search.runs, shell.runs)
+	}
+	if strings.Join(seenTools, ",") != "web_search" || seenModel != "research-model" {
+		t.Errorf("child saw tools %v with model %s, want only web_search on research-model", seenTools, seenModel)
+	}
+
+	// 未指定模型時沿用主對話這一輪路由選出的 Provider 與模型
+	caller := &core.RunEnv{Channel: "telegram", ChatModel: "routed-model", ChatStream: provider}
+	if _, err := RunSubAgent(context.Background(), reg, "main-model", SubTask{Task: "y", Tools: []string{"web_search"}, Caller: caller}); err != nil || seenModel != "routed-model" {
+		t.Errorf("child should inherit the caller's backend, model=%s err=%v", seenModel, err)
+	}
+
+	if _, err := RunSubAgent(context.Background(), reg, "main-model", SubTask{Task: "x", Tools: []string{"no_such_tool"}, Provider: provider}); err == nil {
+		t.Error("unknown tool accepted")
+	}
+	if _, err := RunSubAgent(context.Background(), reg, "main-model", SubTask{Task: "x", Tools: []string{DelegateToolName}, Provider: provider}); err == nil {
+		t.Error("delegate-only toolset accepted")
+	}
+}
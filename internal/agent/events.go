@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventKind 是 Agent 事件的種類
+type EventKind string
+
+const (
+	KindTurnStarted  EventKind = "turn_started"  // 收到使用者輸入 (Text: 原始輸入)
+	KindGenerating   EventKind = "generating"    // 開始呼叫模型 (Iteration: 第幾次)
+	KindTokenDelta   EventKind = "token_delta"   // 串流輸出片段 (Text)
+	KindThinking     EventKind = "thinking"      // 推理模型的思考過程 (Text)
+	KindMessage      EventKind = "message"       // 模型完成一段回覆 (Text)
+	KindToolCall     EventKind = "tool_call"     // 即將執行工具 (Tool, Args)
+	KindToolResult   EventKind = "tool_result"   // 工具執行完畢 (Tool, Args, Text: 交給模型的結果, Error)
+	KindMemoryHit    EventKind = "memory_hit"    // 記憶預搜尋命中 (Text: 注入的記憶)
+	KindPlanStep     EventKind = "plan_step"     // 計畫編排 / 恢復 / 繼續執行 (Text: 說明)
+	KindLimit        EventKind = "limit"         // 達到工具迴圈上限 (Text: 原因)
+	KindError        EventKind = "error"         // 本輪發生錯誤或中斷 (Error)
+	KindTurnFinished EventKind = "turn_finished" // 本輪結束 (Text: 最終回覆, Error)
+)
+
+// Event 是 Agent 在一輪對話中發出的事件，欄位依 Kind 使用 (見上方說明)
+type Event struct {
+	Kind      EventKind `json:"kind"`
+	SessionID string    `json:"session_id,omitempty"`
+	Time      time.Time `json:"time"`
+	Iteration int       `json:"iteration,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Tool      string    `json:"tool,omitempty"`
+	Args      string    `json:"args,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// EventBus 將 Agent 事件依序同步送給所有訂閱者 (CLI 渲染、SSE、日誌、統計等)
+// 訂閱者在 Agent 的 goroutine 中執行，耗時的處理應自行轉交其他 goroutine
+type EventBus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   []subscriber
+}
+
+type subscriber struct {
+	id int
+	fn func(Event)
+}
+
+// NewEventBus 建立事件匯流排
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe 註冊訂閱者，回傳取消訂閱的函式
+func (b *EventBus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	b.subs = append(b.subs, subscriber{id: id, fn: fn})
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			for i, s := range b.subs {
+				if s.id == id {
+					b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+					return
+				}
+			}
+		})
+	}
+}
+
+// Publish 依訂閱順序送出事件；單一訂閱者 panic 不影響其他訂閱者與 Agent
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("⚠️ [Events] 訂閱者處理 %s 事件時發生錯誤: %v\n", e.Kind, r)
+				}
+			}()
+			s.fn(e)
+		}()
+	}
+}
+
+// emit 補上 Session 與時間後發佈事件
+func (a *Agent) emit(e Event) {
+	if a.Events == nil {
+		return
+	}
+	if a.Session != nil {
+		e.SessionID = a.Session.ID
+	}
+	e.Time = time.Now()
+	a.Events.Publish(e)
+}
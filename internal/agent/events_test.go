@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func TestEventBusSubscribers(t *testing.T) {
+	bus := NewEventBus()
+	var first, second []EventKind
+	unsubscribe := bus.Subscribe(func(e Event) { first = append(first, e.Kind) })
+	bus.Subscribe(func(e Event) { panic("壞掉的訂閱者") })
+	bus.Subscribe(func(e Event) { second = append(second, e.Kind) })
+
+	bus.Publish(Event{Kind: KindTurnStarted})
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(Event{Kind: KindTurnFinished})
+
+	if len(first) != 1 || len(second) != 2 {
+		t.Errorf("first=%v second=%v, want 1 and 2 events", first, second)
+	}
+}
+
+func TestChatEmitsTurnEvents(t *testing.T) {
+	tool := &countingTool{}
+	reg := core.NewRegistry()
+	reg.Register(tool)
+	a := NewAgent("mock-model", "system prompt", &history.Session{ID: "s1"}, reg, nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+	a.OnMemorySearch = func(query string) string { return "[MEMORY CONTEXT] 使用者喜歡咖啡" }
+
+	a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		if messages[len(messages)-1].Role == "tool" {
+			cb("完成")
+			return ollama.Message{Role: "assistant", Content: "完成"}, nil
+		}
+		return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "browser_snapshot"}},
+		}}, nil
+	}
+
+	var kinds []string
+	var result Event
+	a.Events.Subscribe(func(e Event) {
+		if e.SessionID != "s1" || e.Time.IsZero() {
+			t.Errorf("event %s missing session or time", e.Kind)
+		}
+		kinds = append(kinds, string(e.Kind))
+		if e.Kind == KindToolResult {
+			result = e
+		}
+	})
+
+	if _, err := a.Chat(context.Background(), "截圖", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	want := "turn_started,memory_hit,generating,tool_call,tool_result,generating,token_delta,message,turn_finished"
+	if got := strings.Join(kinds, ","); got != want {
+		t.Errorf("events:\n got %s\nwant %s", got, want)
+	}
+	if result.Tool != "browser_snapshot" || !strings.Contains(result.Text, "snapshot") {
+		t.Errorf("unexpected tool result event: %+v", result)
+	}
+}
@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 工具迴圈上限 (Tool-Loop Budget)
+// ─────────────────────────────────────────────────────────────
+//
+// 小模型可能反覆呼叫 browser_snapshot 或重複讀取同一段行事曆而停不下來。
+// 每一輪對話 (一次 Chat) 都有工具回合數、總耗時、Token 用量與重複呼叫次數的上限，
+// 達到上限時不再執行工具，改請模型根據已取得的資訊做最後整理，並把原因告知使用者。
+
+// LoopLimits 定義單輪對話的執行上限，0 代表不限制
+type LoopLimits struct {
+	MaxRounds   int           // 最多幾個工具回合 (PCAI_MAX_TOOL_ROUNDS)
+	MaxWallTime time.Duration // 最長執行時間 (PCAI_MAX_TURN_SECONDS)
+	MaxTokens   int           // Prompt + Completion Token 總量 (PCAI_MAX_TURN_TOKENS)
+	MaxRepeats  int           // 相同工具與參數最多執行幾次 (PCAI_MAX_REPEAT_CALLS)
+	MaxToolTime time.Duration // 單一工具的執行時限，0 代表不限 (PCAI_TOOL_TIMEOUT_SECONDS)
+}
+
+// DefaultLoopLimits 回傳預設上限，可由環境變數覆寫
+func DefaultLoopLimits() LoopLimits {
+	return LoopLimits{
+		MaxRounds:   envInt("PCAI_MAX_TOOL_ROUNDS", 10),
+		MaxWallTime: time.Duration(envInt("PCAI_MAX_TURN_SECONDS", 300)) * time.Second,
+		MaxTokens:   envInt("PCAI_MAX_TURN_TOKENS", 0),
+		MaxRepeats:  envInt("PCAI_MAX_REPEAT_CALLS", 2),
+		MaxToolTime: time.Duration(envInt("PCAI_TOOL_TIMEOUT_SECONDS", 120)) * time.Second,
+	}
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// loopBudget 追蹤單輪對話已使用的額度
+type loopBudget struct {
+	limits LoopLimits
+	start  time.Time
+	rounds int
+	tokens int
+	calls  map[string]int // 工具名稱 + 參數 -> 執行次數
+}
+
+func newLoopBudget(limits LoopLimits) *loopBudget {
+	return &loopBudget{
+		limits: limits,
+		start:  time.Now(),
+		calls:  make(map[string]int),
+	}
+}
+
+// addUsage 累計 Provider 回報的 Token 用量
+func (b *loopBudget) addUsage(msg ollama.Message) {
+	if msg.Usage != nil {
+		b.tokens += msg.Usage.PromptTokens + msg.Usage.CompletionTokens
+	}
+}
+
+// exceeded 在呼叫模型前檢查回合數、時間與 Token 上限，回傳原因或空字串
+func (b *loopBudget) exceeded() string {
+	if b.limits.MaxRounds > 0 && b.rounds >= b.limits.MaxRounds {
+		return fmt.Sprintf("工具呼叫已達 %d 回合上限", b.limits.MaxRounds)
+	}
+	if b.limits.MaxWallTime > 0 {
+		if elapsed := time.Since(b.start); elapsed >= b.limits.MaxWallTime {
+			return fmt.Sprintf("本輪執行時間已達 %s 上限", b.limits.MaxWallTime)
+		}
+	}
+	if b.limits.MaxTokens > 0 && b.tokens >= b.limits.MaxTokens {
+		return fmt.Sprintf("本輪 Token 用量 %d 已達 %d 上限", b.tokens, b.limits.MaxTokens)
+	}
+	return ""
+}
+
+// trackCall 記錄一次工具呼叫，超過重複上限時回傳原因
+func (b *loopBudget) trackCall(name, argsJSON string) string {
+	key := name + "\x00" + argsJSON
+	b.calls[key]++
+	if b.limits.MaxRepeats > 0 && b.calls[key] > b.limits.MaxRepeats {
+		return fmt.Sprintf("工具 %s 以相同參數重複呼叫超過 %d 次", name, b.limits.MaxRepeats)
+	}
+	return ""
+}
+
+// limitWrapUpPrompt 是達到上限後最後一次呼叫模型時附加的指示
+const limitWrapUpPrompt = "[SYSTEM] ⚠️ 本輪已達執行上限（%s）。請不要再呼叫任何工具，直接根據目前對話中已取得的資訊整理出最完整的回答；若資訊不足，請說明已完成與尚未完成的部分。"
+
+// limitNotice 回傳附加在回覆後的提示，讓頻道上的使用者知道回答被截斷的原因
+func limitNotice(reason string) string {
+	return fmt.Sprintf("\n\n（⚠️ %s，已停止呼叫工具並整理目前結果）", reason)
+}
+
+// wrapUpAfterLimit 在達到上限後最後呼叫一次模型 (不提供工具)，請它整理目前已取得的結果
+func (a *Agent) wrapUpAfterLimit(ctx context.Context, turn turnContext, reason string, onStream func(string)) (string, error) {
+	fmt.Printf("⛔ [Agent] %s，停止工具迴圈並整理回答\n", reason)
+	if a.Logger != nil {
+		a.Logger.LogLoopLimit(reason)
+	}
+	a.emit(Event{Kind: KindLimit, Text: reason})
+	a.emit(Event{Kind: KindGenerating})
+
+	// 收尾指示只送給模型，不寫入 Session
+	messages, _ := a.buildContext(ctx, turn, nil)
+	messages = append(messages, ollama.Message{
+		Role:    "system",
+		Content: fmt.Sprintf(limitWrapUpPrompt, reason),
+	})
+
+	var streamed strings.Builder
+	aiMsg, err := a.Provider(ctx, a.ModelName, messages, nil, a.Options, func(content string) {
+		streamed.WriteString(content)
+		a.emit(Event{Kind: KindTokenDelta, Text: content})
+		if onStream != nil {
+			onStream(content)
+		}
+	})
+	if err != nil && ctx.Err() != nil {
+		return "", a.interrupted(ctx.Err())
+	}
+
+	content := strings.TrimSpace(aiMsg.Content)
+	if content == "" {
+		content = strings.TrimSpace(streamed.String())
+	}
+	if err != nil || content == "" {
+		if err != nil && a.Logger != nil {
+			a.Logger.LogError("收尾回答失敗", err)
+		}
+		content = "抱歉，這個問題需要的步驟超過本輪的執行上限，目前無法完成。"
+	}
+
+	a.Session.Messages = append(a.Session.Messages, ollama.Message{Role: "assistant", Content: content})
+	if a.ActiveBuffer != nil {
+		a.ActiveBuffer.Add(ollama.Message{Role: "assistant", Content: content})
+	}
+	if a.DailyLogger != nil {
+		_ = a.DailyLogger.Record(ollama.Message{Role: "assistant", Content: content})
+	}
+
+	final := content + limitNotice(reason)
+	if onStream != nil {
+		onStream(limitNotice(reason))
+	}
+	if a.Logger != nil {
+		a.Logger.LogAIResponse(final)
+	}
+	a.emit(Event{Kind: KindMessage, Text: final})
+	return final, nil
+}
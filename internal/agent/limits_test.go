@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+type countingTool struct{ runs int }
+
+func (c *countingTool) Name() string  { return "browser_snapshot" }
+func (c *countingTool) IsSkill() bool { return false }
+func (c *countingTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: "browser_snapshot"}}
+}
+func (c *countingTool) Run(argsJSON string) (string, error) {
+	c.runs++
+	return "snapshot", nil
+}
+
+// loopingProvider 永遠呼叫同一個工具，直到收到不含工具的收尾請求
+func loopingProvider(calls *int, wrapUp *[]ollama.Message, argsFor func(n int) string) func(context.Context, string, []ollama.Message, []api.Tool, ollama.Options, func(string)) (ollama.Message, error) {
+	return func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		*calls++
+		if tools == nil {
+			*wrapUp = messages
+			return ollama.Message{Role: "assistant", Content: "目前取得的結果如下"}, nil
+		}
+		var args api.ToolCallFunctionArguments
+		args.Set("ref", argsFor(*calls))
+		return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "browser_snapshot", Arguments: args}},
+		}}, nil
+	}
+}
+
+func newLimitAgent(t *testing.T, limits LoopLimits) (*Agent, *countingTool) {
+	t.Helper()
+	tool := &countingTool{}
+	reg := core.NewRegistry()
+	reg.Register(tool)
+	a := NewAgent("mock-model", "system prompt", &history.Session{}, reg, nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+	a.Limits = limits
+	return a, tool
+}
+
+func TestLoopLimitMaxRounds(t *testing.T) {
+	a, tool := newLimitAgent(t, LoopLimits{MaxRounds: 3})
+	var calls int
+	var wrapUp []ollama.Message
+	a.Provider = loopingProvider(&calls, &wrapUp, func(n int) string { return string(rune('a' + n)) })
+
+	var limited string
+	a.Events.Subscribe(func(e Event) {
+		if e.Kind == KindLimit {
+			limited = e.Text
+		}
+	})
+
+	reply, err := a.Chat(context.Background(), "一直截圖", nil)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if tool.runs != 3 || calls != 4 {
+		t.Errorf("tool runs=%d provider calls=%d, want 3 and 4", tool.runs, calls)
+	}
+	if !strings.Contains(limited, "3 回合") || !strings.Contains(reply, limited) {
+		t.Errorf("limit reason not surfaced: reason=%q reply=%q", limited, reply)
+	}
+	if !strings.HasPrefix(reply, "目前取得的結果如下") {
+		t.Errorf("reply should start with wrap-up answer: %q", reply)
+	}
+	if last := wrapUp[len(wrapUp)-1]; last.Role != "system" || !strings.Contains(last.Content, "不要再呼叫任何工具") {
+		t.Errorf("wrap-up prompt missing: %+v", last)
+	}
+	for _, m := range a.Session.Messages {
+		if strings.Contains(m.Content, "不要再呼叫任何工具") {
+			t.Error("wrap-up prompt should not be stored in session")
+		}
+	}
+}
+
+func TestLoopLimitRepeatedCalls(t *testing.T) {
+	a, tool := newLimitAgent(t, LoopLimits{MaxRounds: 10, MaxRepeats: 2})
+	var calls int
+	var wrapUp []ollama.Message
+	a.Provider = loopingProvider(&calls, &wrapUp, func(int) string { return "same" })
+
+	reply, err := a.Chat(context.Background(), "一直截圖", nil)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if tool.runs != 2 {
+		t.Errorf("identical call should run only twice, ran %d times", tool.runs)
+	}
+	if !strings.Contains(reply, "重複呼叫") {
+		t.Errorf("repeat reason not surfaced: %q", reply)
+	}
+
+	// 被略過的呼叫仍需補上 tool 訊息
+	skipped := false
+	for _, m := range a.Session.Messages {
+		if m.Role == "tool" && strings.Contains(m.Content, "未執行") {
+			skipped = true
+		}
+	}
+	if !skipped {
+		t.Error("skipped call should leave a tool message")
+	}
+}
+
+func TestLoopLimitTokens(t *testing.T) {
+	b := newLoopBudget(LoopLimits{MaxTokens: 100})
+	b.addUsage(ollama.Message{Usage: &ollama.Usage{PromptTokens: 60, CompletionTokens: 30}})
+	if r := b.exceeded(); r != "" {
+		t.Fatalf("exceeded too early: %s", r)
+	}
+	b.addUsage(ollama.Message{Usage: &ollama.Usage{PromptTokens: 10}})
+	if r := b.exceeded(); !strings.Contains(r, "Token") {
+		t.Errorf("token limit not detected: %q", r)
+	}
+}
@@ -18,6 +18,9 @@ const (
 	EventToolResult LogEvent = "tool_result"
 	EventAIResponse LogEvent = "ai_response"
 	EventError      LogEvent = "error"
+	EventLoopLimit  LogEvent = "loop_limit"
+	EventToolParse  LogEvent = "tool_call_recovered"
+	EventContext    LogEvent = "context_trimmed"
 )
 
 // LogEntry 定義單條日誌結構 (JSONL)
@@ -30,6 +33,9 @@ type LogEntry struct {
 	ToolArgs string `json:"tool_args,omitempty"`
 	Success  *bool  `json:"success,omitempty"` // 指標以便區分 nil
 	Error    string `json:"error,omitempty"`
+	// 工具呼叫還原欄位
+	Parser string `json:"parser,omitempty"`
+	Model  string `json:"model,omitempty"`
 }
 
 // SystemLogger 負責寫入系統日誌
@@ -144,6 +150,35 @@ func (l *SystemLogger) LogError(prefix string, err error) {
 	})
 }
 
+// LogLoopLimit 記錄工具迴圈達到上限
+func (l *SystemLogger) LogLoopLimit(reason string) {
+	l.writeEntry(LogEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Event:     EventLoopLimit,
+		Content:   reason,
+	})
+}
+
+// LogContextTrim 記錄上下文組裝時被摘要、截斷或捨棄的內容
+func (l *SystemLogger) LogContextTrim(report string) {
+	l.writeEntry(LogEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Event:     EventContext,
+		Content:   report,
+	})
+}
+
+// LogToolCallRecovery 記錄從內容還原工具呼叫的解析器，用來觀察各模型需要哪些補救
+func (l *SystemLogger) LogToolCallRecovery(parser, model string, calls int) {
+	l.writeEntry(LogEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Event:     EventToolParse,
+		Content:   fmt.Sprintf("還原 %d 個工具呼叫", calls),
+		Parser:    parser,
+		Model:     model,
+	})
+}
+
 // LogHallucination 記錄幻覺 (嘗試呼叫不存在的工具)
 func (l *SystemLogger) LogHallucination(instruction, toolName string) {
 	// 這裡我們直接寫入 notools.log，保持與 tools.ReportMissingTool 一致的行為
@@ -11,7 +11,7 @@ import (
 )
 
 // memorySourceMap 定義使用者輸入關鍵字 → 短期記憶來源的映射
-// source 名稱必須與 toolNameToMemorySource() 中的值一致
+// source 名稱必須與 toolmw 內建設定 (或 toolmiddleware.yaml) 中工具的 memory 值一致
 var memorySourceMap = []struct {
 	InputKeywords []string // 使用者輸入中可能包含的關鍵字
 	Source        string   // 對應的 short_term_memory.source 值
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/asccclass/pcai/internal/approval"
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/ollama/ollama/api"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 平行執行工具 (Parallel Tool Execution)
+// ─────────────────────────────────────────────────────────────
+//
+// 模型在同一則訊息中要求多個工具時 (例如簡報同時查郵件、行事曆與天氣)，
+// 連續且宣告為 ParallelSafe 的工具會同時執行，其餘工具維持依序執行，
+// 結果一律依原始呼叫順序寫回 Session；單一工具失敗或 panic 不影響其他工具。
+
+// toolJob 是一個待執行的工具呼叫
+type toolJob struct {
+	call   api.ToolCall
+	args   string // 經過校正的參數 JSON
+	skip   string // 非空時不執行，內容為原因
+	result string
+	err    error
+}
+
+// DefaultMaxParallelTools 回傳同時執行的工具數上限 (PCAI_MAX_PARALLEL_TOOLS，預設 4；1 代表全部依序執行)
+func DefaultMaxParallelTools() int {
+	if n := envInt("PCAI_MAX_PARALLEL_TOOLS", 4); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// executeToolJobs 執行所有未被略過的工具，對話被取消時回傳 ctx 錯誤並標記剩餘工具為未執行
+func (a *Agent) executeToolJobs(ctx context.Context, jobs []*toolJob) error {
+	for i := 0; i < len(jobs); {
+		if err := ctx.Err(); err != nil {
+			for _, job := range jobs[i:] {
+				if job.skip == "" {
+					job.skip = "對話已中斷"
+				}
+			}
+			return err
+		}
+
+		// 收集從 i 開始連續可平行的工具
+		end := i
+		for end < len(jobs) && (jobs[end].skip != "" || a.registry().IsParallelSafe(jobs[end].call.Function.Name)) {
+			end++
+		}
+
+		if end-i > 1 && a.MaxParallelTools > 1 {
+			a.runParallel(ctx, jobs[i:end])
+			i = end
+			continue
+		}
+		if end == i {
+			end = i + 1 // 有副作用的工具單獨依序執行
+		}
+		for _, job := range jobs[i:end] {
+			if job.skip == "" {
+				job.result, job.err = a.runTool(ctx, job.call.Function.Name, job.args)
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+// runParallel 以 MaxParallelTools 為上限同時執行一批工具
+func (a *Agent) runParallel(ctx context.Context, batch []*toolJob) {
+	sem := make(chan struct{}, a.MaxParallelTools)
+	var wg sync.WaitGroup
+	count := 0
+	for _, job := range batch {
+		if job.skip != "" {
+			continue
+		}
+		count++
+		wg.Add(1)
+		go func(job *toolJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			job.result, job.err = a.runTool(ctx, job.call.Function.Name, job.args)
+		}(job)
+	}
+	if count > 1 {
+		fmt.Printf("⚡ [Agent] 平行執行 %d 個工具\n", count)
+	}
+	wg.Wait()
+}
+
+// runTool 在單一工具的時限內執行工具，並把 panic 轉為該工具的錯誤
+func (a *Agent) runTool(ctx context.Context, name, argsJSON string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("工具 %s 執行時發生錯誤: %v", name, r)
+		}
+	}()
+	if name == RequestToolsName && a.activeTools != nil {
+		return a.activeTools.request(argsJSON)
+	}
+	if a.Limits.MaxToolTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Limits.MaxToolTime)
+		defer cancel()
+	}
+	return a.registry().CallToolContext(ctx, a.runEnv(name), name, argsJSON)
+}
+
+// runEnv 建立傳給工具的呼叫端資訊；工具要求的額外核准沿用本 Session 的核准流程
+func (a *Agent) runEnv(tool string) *core.RunEnv {
+	env := &core.RunEnv{Channel: a.Channel, Sender: a.Sender, Model: a.ModelName, ChatModel: a.ModelName, ChatStream: a.Provider}
+	if a.lastLLM != nil {
+		env.Provider = a.lastLLM.Provider
+		if a.lastLLM.Model != "" {
+			env.Model = a.lastLLM.Model
+		}
+	}
+	if a.Session != nil && a.Session.ID != "" {
+		env.SessionID = a.Session.ID
+		home, _ := os.Getwd()
+		env.ScratchDir = filepath.Join(home, "botmemory", "scratch", filepath.Base(a.Session.ID))
+	}
+	if a.Approval != nil {
+		env.Approve = func(ctx context.Context, action string) (bool, string) {
+			return a.Approval.Check(ctx, a.Approver, approval.Request{
+				SessionID: env.SessionID,
+				Tool:      tool,
+				Args:      action,
+				Risk:      core.RiskHigh,
+			})
+		}
+	}
+	return env
+}
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// slowTool 模擬較慢的外部工具
+type slowTool struct {
+	name     string
+	delay    time.Duration
+	safe     bool
+	fail     bool
+	panics   bool
+	running  *int32
+	maxSeen  *int32
+	executed *int32
+}
+
+func (s *slowTool) Name() string       { return s.name }
+func (s *slowTool) IsSkill() bool      { return false }
+func (s *slowTool) ParallelSafe() bool { return s.safe }
+func (s *slowTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: s.name}}
+}
+func (s *slowTool) Run(argsJSON string) (string, error) {
+	n := atomic.AddInt32(s.running, 1)
+	defer atomic.AddInt32(s.running, -1)
+	for {
+		m := atomic.LoadInt32(s.maxSeen)
+		if n <= m || atomic.CompareAndSwapInt32(s.maxSeen, m, n) {
+			break
+		}
+	}
+	atomic.AddInt32(s.executed, 1)
+	time.Sleep(s.delay)
+	if s.panics {
+		panic("boom")
+	}
+	if s.fail {
+		return "", errors.New("服務無回應")
+	}
+	return s.name + " ok", nil
+}
+
+func newParallelAgent(t *testing.T, tools ...*slowTool) *Agent {
+	t.Helper()
+	reg := core.NewRegistry()
+	for _, tool := range tools {
+		reg.Register(tool)
+	}
+	a := NewAgent("mock-model", "system prompt", &history.Session{}, reg, nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+	a.MaxParallelTools = 4
+
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.name
+	}
+	calls := 0
+	a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		calls++
+		if calls > 1 {
+			return ollama.Message{Role: "assistant", Content: "簡報完成"}, nil
+		}
+		msg := ollama.Message{Role: "assistant"}
+		for _, n := range names {
+			msg.ToolCalls = append(msg.ToolCalls, api.ToolCall{Function: api.ToolCallFunction{Name: n}})
+		}
+		return msg, nil
+	}
+	return a
+}
+
+func toolMessages(s *history.Session) []string {
+	var out []string
+	for _, m := range s.Messages {
+		if m.Role == "tool" {
+			out = append(out, m.Content)
+		}
+	}
+	return out
+}
+
+func TestParallelToolsKeepOrderAndIsolateErrors(t *testing.T) {
+	var running, maxSeen, executed int32
+	mk := func(name string, delay time.Duration) *slowTool {
+		return &slowTool{name: name, delay: delay, safe: true, running: &running, maxSeen: &maxSeen, executed: &executed}
+	}
+	email := mk("manage_email", 150*time.Millisecond)
+	calendar := mk("read_calendar", 50*time.Millisecond)
+	calendar.fail = true
+	weather := mk("get_taiwan_weather", 100*time.Millisecond)
+	weather.panics = true
+
+	a := newParallelAgent(t, email, calendar, weather)
+	start := time.Now()
+	if _, err := a.Chat(context.Background(), "給我今天的簡報", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if maxSeen < 2 {
+		t.Errorf("tools did not run concurrently (max concurrency %d)", maxSeen)
+	}
+	if elapsed >= 290*time.Millisecond {
+		t.Errorf("elapsed %v, expected close to the slowest tool", elapsed)
+	}
+
+	msgs := toolMessages(a.Session)
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 tool messages, got %d: %v", len(msgs), msgs)
+	}
+	if !strings.Contains(msgs[0], "manage_email ok") {
+		t.Errorf("msg[0] = %q, want email result first", msgs[0])
+	}
+	if !strings.Contains(msgs[1], "執行失敗") || !strings.Contains(msgs[1], "服務無回應") {
+		t.Errorf("msg[1] = %q, want calendar error", msgs[1])
+	}
+	if !strings.Contains(msgs[2], "執行失敗") || !strings.Contains(msgs[2], "boom") {
+		t.Errorf("msg[2] = %q, want recovered panic", msgs[2])
+	}
+}
+
+func TestUnsafeToolsRunSequentially(t *testing.T) {
+	var running, maxSeen, executed int32
+	mk := func(name string, safe bool) *slowTool {
+		return &slowTool{name: name, delay: 30 * time.Millisecond, safe: safe, running: &running, maxSeen: &maxSeen, executed: &executed}
+	}
+	a := newParallelAgent(t, mk("fs_write_file", false), mk("fs_append_file", false), mk("fs_read_file", true))
+	if _, err := a.Chat(context.Background(), "寫檔", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if maxSeen != 1 {
+		t.Errorf("side-effect tools ran concurrently (max concurrency %d)", maxSeen)
+	}
+	if executed != 3 {
+		t.Errorf("executed %d tools, want 3", executed)
+	}
+}
+
+// envTool 實作 ContextTool，記錄收到的呼叫端資訊
+type envTool struct{ got *core.RunEnv }
+
+func (e *envTool) Name() string  { return "whoami" }
+func (e *envTool) IsSkill() bool { return false }
+func (e *envTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: "whoami"}}
+}
+func (e *envTool) Run(argsJSON string) (string, error) { return "", errors.New("應呼叫 RunContext") }
+func (e *envTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return "", errors.New("缺少工具時限")
+	}
+	e.got = env
+	return env.Channel + "/" + env.Sender, nil
+}
+
+func TestToolRunEnvAndDeadline(t *testing.T) {
+	var running, maxSeen, executed int32
+	slow := &slowTool{name: "web_fetch", delay: 500 * time.Millisecond, running: &running, maxSeen: &maxSeen, executed: &executed}
+	a := newParallelAgent(t, slow)
+	who := &envTool{}
+	a.Registry.Register(who)
+	a.Session.ID = "session_test_env"
+	a.Channel, a.Sender = "telegram", "u42"
+	a.Limits.MaxToolTime = 50 * time.Millisecond
+	a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		if len(toolMessages(a.Session)) > 0 {
+			return ollama.Message{Role: "assistant", Content: "完成"}, nil
+		}
+		return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "whoami"}},
+			{Function: api.ToolCallFunction{Name: "web_fetch"}},
+		}}, nil
+	}
+
+	start := time.Now()
+	if _, err := a.Chat(context.Background(), "我是誰", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 400*time.Millisecond {
+		t.Errorf("legacy tool was not cut off by MaxToolTime (elapsed %v)", elapsed)
+	}
+
+	msgs := toolMessages(a.Session)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 tool messages, got %d: %v", len(msgs), msgs)
+	}
+	if !strings.Contains(msgs[0], "telegram/u42") {
+		t.Errorf("msg[0] = %q, want caller identity", msgs[0])
+	}
+	if !strings.Contains(msgs[1], "未在時限內完成") {
+		t.Errorf("msg[1] = %q, want timeout error", msgs[1])
+	}
+	if who.got == nil || who.got.SessionID != "session_test_env" || !strings.HasSuffix(who.got.ScratchDir, "session_test_env") {
+		t.Errorf("run env = %+v", who.got)
+	}
+}
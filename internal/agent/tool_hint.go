@@ -1,158 +1,461 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	_ "embed"
 	"fmt"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/memory"
+	"gopkg.in/yaml.v3"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 意圖規則 (Intent Rules)
+// ─────────────────────────────────────────────────────────────
+//
+// 使用者輸入命中規則時，在本輪訊息後附加工具提示，引導模型選擇正確工具。規則來源依序為：
+//   - intents.yaml (路徑可用 PCAI_INTENTS 指定)：新增規則，或以同名規則覆寫/停用內建規則
+//   - 技能的觸發詞：SKILL.md frontmatter 的 triggers (core.IntentTrigger)，安裝技能即生效
+//   - 內建規則：intents_default.yaml
+// 每條規則可用 keywords (不分大小寫)、regex 或 examples (語意相似度，需設定 Embedding) 比對。
+// intents.yaml 修改後會在下一則訊息自動重新載入，載入失敗時沿用上一次的規則。
+
+//go:embed intents_default.yaml
+var defaultIntentYAML []byte
+
+const (
+	defaultIntentThreshold = 0.8 // examples 預設的相似度門檻
+	skillHintTemplate      = `[SYSTEM INSTRUCTION] 使用者的需求符合技能 {{.Tool}}（{{.Description}}）。請呼叫 {{.Tool}} 工具完成，不要改用其他工具或直接回答無法處理。`
 )
 
-// toolHintRule 定義關鍵字 → 工具提示的映射規則
-type toolHintRule struct {
-	Keywords []string                             // 使用者輸入中包含這些關鍵字時觸發
-	ToolName string                               // 應該使用的工具名稱
-	HintFunc func(input, pendingID string) string // 生成提示訊息的函式
-}
-
-// toolHintRules 定義所有工具路由提示規則
-var toolHintRules = []toolHintRule{
-	{
-		Keywords: []string{"新增行程", "加入行事曆", "紀錄到行事曆", "建立行程", "安排行程", "加行程", "排行程", "add event", "create event", "新增到行事曆", "記到行事曆", "寫入行事曆"},
-		ToolName: "manage_calendar",
-		HintFunc: func(input, pendingID string) string {
-			today := time.Now().Format("2006-01-02")
-			tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
-			return fmt.Sprintf(
-				`[SYSTEM INSTRUCTION] 使用者要求新增行事曆行程。你必須呼叫 manage_calendar 工具，並提供以下參數：
-- mode: 設為 "create"
-- summary: 行程摘要（從使用者描述中提取，例如「繳費」）
-- from: 行程開始日期時間 (YYYY-MM-DD)，今天是 %s，明天是 %s
-- to: 行程結束日期時間 (YYYY-MM-DD)
-- cal: 行事曆名稱（根據內容判斷，如「個人」「工作」「家庭」「帳務」）
-- rrule: 重複規則（若非重複事件則留空 ""）
-
-嚴禁使用 google_search、google_services 或 manage_cron_job。嚴禁回答「無法新增行程」，你有能力新增行程。`,
-				today, tomorrow,
-			)
-		},
-	},
-	{
-		Keywords: []string{"行事曆", "行程", "日程", "calendar", "schedule", "行程表"},
-		ToolName: "manage_calendar",
-		HintFunc: func(input, pendingID string) string {
-			today := time.Now().Format("2006-01-02")
-			return fmt.Sprintf(
-				"[SYSTEM INSTRUCTION] 使用者要求查看行事曆。你必須呼叫 manage_calendar 工具，並提供 mode、from 和 to 參數。mode 設為 \"read\"（讀取行程），from 和 to 格式為 YYYY-MM-DD。今天的日期是 %s。如果使用者說「今天」，from 和 to 都設為 %s。嚴禁使用 google_search、google_services 或 manage_cron_job。",
-				today, today,
-			)
-		},
-	},
-	{
-		Keywords: []string{"郵件", "信件", "信箱", "email", "mail", "gmail"},
-		ToolName: "manage_email",
-		HintFunc: func(input, pendingID string) string {
-			return `[SYSTEM INSTRUCTION] 使用者要求讀取或搜尋郵件。你必須呼叫 manage_email 工具。
-嚴禁使用 google_search、google_services、manage_cron_job 或編造 gog 指令。
-呼叫工具的嚴格格式要求：
-- 你必須使用標準 JSON 格式呼叫，範例：{"name": "manage_email", "parameters": {"query": "is:inbox", "limit": "5"}}
-- 工具必須包含 query (可用 is:inbox 或搜尋關鍵字) 與 limit (例如 "5" 或 "10")。`
-		},
-	},
-	{
-		Keywords: []string{"天氣", "氣象", "weather", "預報", "會冷", "會熱", "下雨", "溫度", "氣溫"},
-		ToolName: "get_taiwan_weather",
-		HintFunc: func(input, pendingID string) string {
-			today := time.Now().Format("2006-01-02")
-			return fmt.Sprintf(
-				`[SYSTEM INSTRUCTION] 使用者詢問天氣。今天的日期是 %s。
-
-判斷邏輯：
-1. 若本訊息包含 [MEMORY CONTEXT] 且記憶中已有「溫度」、「降雨機率」等實際天氣預報數據，請直接引用該資料回答，不需呼叫工具。
-2. 若記憶中沒有天氣預報數據，你必須呼叫 get_taiwan_weather 工具。
-
-呼叫工具的嚴格格式要求：
-- 你必須使用標準 JSON 格式呼叫，範例：{"name": "get_taiwan_weather", "parameters": {"location": "苗栗縣"}}
-- 工具只接受一個參數 location，絕對不要傳 date 或其他參數（API 自動回傳未來預報）。
-- location 的值必須從以下列表中精確複製一個：基隆市、臺北市、新北市、桃園市、新竹市、新竹縣、苗栗縣、臺中市、彰化縣、南投縣、雲林縣、嘉義市、嘉義縣、臺南市、高雄市、屏東縣、宜蘭縣、花蓮縣、臺東縣、澎湖縣、金門縣、連江縣。
-- 嚴禁使用 [tool_name param=value] 或其他非 JSON 格式。
-- 嚴禁使用 web_search、google_search。`,
-				today,
-			)
-		},
-	},
-	{
-		Keywords: []string{"立即執行", "執行簡報", "晨間簡報", "run briefing"},
-		ToolName: "manage_cron_job",
-		HintFunc: func(input, pendingID string) string {
-			return `[SYSTEM INSTRUCTION] 使用者要求立即執行排程任務。你必須呼叫 manage_cron_job 工具，參數為：{"action":"run_once","task_name":"daily_morning_briefing","task_type":"morning_briefing"}。嚴禁使用 task_planner 或其他不存在的工具。`
-		},
-	},
-	{
-		Keywords: []string{"瀏覽器", "網頁", "網址", "打開網址", "讀取網頁", "browser", "url", "頁面", "這頁", "這個頁面", "http", "https"},
-		ToolName: "browser_open",
-		HintFunc: func(input, pendingID string) string {
-			return `[SYSTEM INSTRUCTION] 使用者要求讀取網頁內容。
-你必須執行以下步驟來獲取答案：
-1. 呼叫 {"name": "browser_open", "arguments": {"url": "https://..."}}。
-2. 待開啟成功後，**立即**呼叫 {"name": "browser_get_text", "arguments": {}} 取得內容。
-⚠️【禁止停頓】：不要只停在開啟頁面，也不要問使用者後續操作。你必須取得文字內容後直接回答使用者的問題。`
-		},
-	},
-	{
-		Keywords: []string{"讀取內容", "抓取文字", "get text", "read content"},
-		ToolName: "browser_get_text",
-		HintFunc: func(input, pendingID string) string {
-			return `[SYSTEM INSTRUCTION] 網頁已開啟。請立即呼叫 browser_get_text 獲取內容，並針對使用者問題從中萃取答案回覆。`
-		},
-	},
-	{
-		Keywords: []string{"列出檔案", "目錄", "list files", "ls", "dir", "列出", "列下", "有什麼檔案"},
-		ToolName: "fs_list_dir",
-		HintFunc: func(input, pendingID string) string {
-			return `[SYSTEM INSTRUCTION] 使用者要求列出檔案或目錄。你必須呼叫 fs_list_dir 工具（跨平台），參數為：{"path": "."}。嚴禁使用 shell_exec 搭配 ls 或 dir 指令。`
-		},
-	},
-	{
-		Keywords: []string{"記住", "記下來", "存入記憶", "幫我記", "remember", "memorize", "記錄", "存起來"},
-		ToolName: "memory_save",
-		HintFunc: func(input, pendingID string) string {
-			return `[SYSTEM INSTRUCTION] 使用者要求記住某些資訊。你必須呼叫 memory_save 工具，將資訊整理為簡潔的事實陳述句存入長期記憶。參數需包含 content（內容）、mode（設定為 "long_term"）和 category（分類：個人資訊、工作紀錄、偏好設定、生活雜記、技術開發）。若資訊包含多個主題，請拆成多次呼叫分別儲存。\n\n⚠️【重要格式規範】：你必須使用標準 JSON 格式包裹參數，嚴禁單獨輸出內部參數！格式必須如下：\n{"name": "memory_save", "arguments": {"content": "...", "mode": "long_term", "category": "..."}}`
-		},
-	},
-	{
-		Keywords: []string{"同意存入", "確認存入", "確認", "同意", "確認儲存", "同意記錄", "yes", "好", "可以", "沒問題", "ok", "OK"},
-		ToolName: "memory_confirm",
-		HintFunc: func(input, pendingID string) string {
-			idStr := "pending_xxxx"
-			if pendingID != "" {
-				idStr = pendingID
+// IntentRule 是一條意圖規則，keywords、regex、examples 任一命中即成立
+type IntentRule struct {
+	Name      string   `yaml:"name"`
+	Tool      string   `yaml:"tool"`      // 對應的工具名稱
+	Keywords  []string `yaml:"keywords"`  // 輸入包含任一關鍵字時命中 (不分大小寫)
+	Regex     string   `yaml:"regex"`     // 輸入符合正規表示式時命中
+	Examples  []string `yaml:"examples"`  // 範例句，與輸入的語意相似度達 threshold 時命中
+	Threshold float64  `yaml:"threshold"` // 語意相似度門檻 (預設 0.8)
+	Hint      string   `yaml:"hint"`      // 附加到使用者訊息後的提示 (text/template)
+	Force     bool     `yaml:"force"`     // 第一輪只提供此工具給模型，強制使用
+	Disabled  bool     `yaml:"disabled"`  // 停用同名的內建規則
+
+	description string // 技能說明 (技能規則的提示使用)
+	re          *regexp.Regexp
+	tmpl        *template.Template
+}
+
+// MultiStepRules 是多步驟意圖的偵測設定
+type MultiStepRules struct {
+	MinTools    int      `yaml:"min_tools"`    // 命中幾個不同工具的規則視為多步驟
+	MinChaining int      `yaml:"min_chaining"` // 包含幾個連動詞視為多步驟
+	Chaining    []string `yaml:"chaining"`     // 連動詞 (然後、接著、and then...)
+	Prompt      string   `yaml:"prompt"`       // 計畫編排提示 (text/template)
+
+	tmpl *template.Template
+}
+
+// IntentConfig 是意圖規則檔的內容
+type IntentConfig struct {
+	Rules     []*IntentRule  `yaml:"rules"`
+	MultiStep MultiStepRules `yaml:"multi_step"`
+
+	custom  []*IntentRule // 設定檔新增的規則 (排在技能與內建規則之前)
+	builtin []*IntentRule // 內建規則 (已套用設定檔的覆寫與停用)
+}
+
+// hintData 是提示樣板可用的欄位
+type hintData struct {
+	Input, PendingID, Tool, Description string
+	Today, Tomorrow, Now                string
+}
+
+// LoadIntentConfig 讀取意圖規則檔並與內建規則合併；path 為空或檔案不存在時只有內建規則
+func LoadIntentConfig(path string) (*IntentConfig, error) {
+	cfg := &IntentConfig{}
+	if err := yaml.Unmarshal(defaultIntentYAML, cfg); err != nil {
+		return nil, fmt.Errorf("解析內建意圖規則失敗: %w", err)
+	}
+	cfg.builtin = cfg.Rules
+	if path == "" {
+		return cfg, cfg.compile()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("讀取意圖規則失敗: %w", err)
+	}
+	if err == nil {
+		var user IntentConfig
+		if err := yaml.Unmarshal(data, &user); err != nil {
+			return nil, fmt.Errorf("解析意圖規則失敗 (%s): %w", path, err)
+		}
+		cfg.merge(&user)
+	}
+	if err := cfg.compile(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// merge 套用設定檔：同名規則取代內建規則，其餘規則排在前面
+func (c *IntentConfig) merge(user *IntentConfig) {
+	for _, rule := range user.Rules {
+		replaced := false
+		for i, b := range c.builtin {
+			if rule.Name != "" && b.Name == rule.Name {
+				c.builtin[i] = rule
+				replaced = true
+				break
 			}
-			return fmt.Sprintf(`[SYSTEM INSTRUCTION] 系統偵測到使用者同意某項操作或同意存入記憶。你必須立刻呼叫 memory_confirm 工具，並使用系統捕獲的暫存 ID: %s 。\n\n嚴禁填寫 "該ID" 或 "暫存ID" 等代替字，請精確按照以下格式輸出：\n{"name": "memory_confirm", "arguments": {"action": "confirm", "pending_id": "%s"}}。若找不到待確認的 ID，請以一般助理風格回覆。`, idStr, idStr)
-		},
-	},
-	{
-		Keywords: []string{"拒絕存入", "拒絕", "不要", "取消", "不用存", "刪掉"},
-		ToolName: "memory_confirm",
-		HintFunc: func(input, pendingID string) string {
-			idStr := "pending_xxxx"
-			if pendingID != "" {
-				idStr = pendingID
+		}
+		if !replaced && !rule.Disabled {
+			c.custom = append(c.custom, rule)
+		}
+	}
+
+	ms := user.MultiStep
+	if ms.MinTools > 0 {
+		c.MultiStep.MinTools = ms.MinTools
+	}
+	if ms.MinChaining > 0 {
+		c.MultiStep.MinChaining = ms.MinChaining
+	}
+	if len(ms.Chaining) > 0 {
+		c.MultiStep.Chaining = ms.Chaining
+	}
+	if ms.Prompt != "" {
+		c.MultiStep.Prompt = ms.Prompt
+	}
+}
+
+// compile 檢查規則並編譯正規表示式與提示樣板
+func (c *IntentConfig) compile() error {
+	var builtin []*IntentRule
+	for _, rule := range c.builtin {
+		if !rule.Disabled {
+			builtin = append(builtin, rule)
+		}
+	}
+	c.builtin = builtin
+	c.Rules = append(append([]*IntentRule(nil), c.custom...), c.builtin...)
+
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return err
+		}
+	}
+	tmpl, err := template.New("multi_step").Parse(c.MultiStep.Prompt)
+	if err != nil {
+		return fmt.Errorf("多步驟提示樣板無效: %w", err)
+	}
+	c.MultiStep.tmpl = tmpl
+	return nil
+}
+
+func (r *IntentRule) compile() error {
+	if r.Tool == "" {
+		return fmt.Errorf("意圖規則 %s 未指定 tool", r.Name)
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("意圖規則 %s 的 regex 無效: %w", r.Name, err)
+		}
+		r.re = re
+	}
+	if r.Threshold <= 0 {
+		r.Threshold = defaultIntentThreshold
+	}
+	tmpl, err := template.New(r.Name).Parse(r.Hint)
+	if err != nil {
+		return fmt.Errorf("意圖規則 %s 的 hint 樣板無效: %w", r.Name, err)
+	}
+	r.tmpl = tmpl
+	return nil
+}
+
+// matchText 以關鍵字與正規表示式比對 (lower 為小寫的輸入)
+func (r *IntentRule) matchText(input, lower string) bool {
+	for _, kw := range r.Keywords {
+		if kw != "" && strings.Contains(lower, strings.ToLower(kw)) {
+			return true
+		}
+	}
+	return r.re != nil && r.re.MatchString(input)
+}
+
+// render 產生提示訊息；樣板錯誤時回傳空字串
+func (r *IntentRule) render(input, pendingID string) string {
+	if r.tmpl == nil {
+		return ""
+	}
+	if pendingID == "" {
+		pendingID = "pending_xxxx"
+	}
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, newHintData(input, pendingID, r.Tool, r.description)); err != nil {
+		fmt.Printf("⚠️ [Intent] 規則 %s 的提示產生失敗: %v\n", r.Name, err)
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+func newHintData(input, pendingID, tool, desc string) hintData {
+	now := time.Now()
+	return hintData{
+		Input:       input,
+		PendingID:   pendingID,
+		Tool:        tool,
+		Description: desc,
+		Today:       now.Format("2006-01-02"),
+		Tomorrow:    now.AddDate(0, 0, 1).Format("2006-01-02"),
+		Now:         now.Format("2006-01-02 15:04"),
+	}
+}
+
+// ─────────────────────────────────────────────────────────────
+// 規則比對 (支援熱重載)
+// ─────────────────────────────────────────────────────────────
+
+// intentEngine 保存目前的規則，並在規則檔變動時重新載入
+type intentEngine struct {
+	path string
+
+	mu       sync.Mutex
+	cfg      *IntentConfig
+	modTime  time.Time
+	size     int64
+	registry *core.Registry       // 提供技能觸發詞 (Gateway 路由使用)
+	examples map[string][]float32 // 範例句 -> Embedding
+}
+
+var (
+	intentOnce sync.Once
+	intents    *intentEngine
+)
+
+// defaultIntents 回傳共用的意圖規則，第一次使用時載入 PCAI_INTENTS (預設 intents.yaml)
+func defaultIntents() *intentEngine {
+	intentOnce.Do(func() {
+		path := os.Getenv("PCAI_INTENTS")
+		if path == "" {
+			path = "intents.yaml"
+		}
+		intents = &intentEngine{path: path, examples: make(map[string][]float32)}
+	})
+	return intents
+}
+
+// SetIntentRegistry 設定提供技能觸發詞的工具註冊表 (供沒有 Agent 的呼叫端，例如 Gateway 路由)
+func SetIntentRegistry(reg *core.Registry) {
+	e := defaultIntents()
+	e.mu.Lock()
+	e.registry = reg
+	e.mu.Unlock()
+}
+
+// config 回傳目前的規則，規則檔有變動時重新載入
+func (e *intentEngine) config() *IntentConfig {
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(e.path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cfg != nil && modTime.Equal(e.modTime) && size == e.size {
+		return e.cfg
+	}
+	cfg, err := LoadIntentConfig(e.path)
+	if err != nil {
+		fmt.Printf("⚠️ [Intent] %v，沿用目前的意圖規則\n", err)
+		if e.cfg == nil {
+			// 第一次載入就失敗時只使用內建規則
+			if e.cfg, err = LoadIntentConfig(""); err != nil {
+				e.cfg = &IntentConfig{}
 			}
-			return fmt.Sprintf(`[SYSTEM INSTRUCTION] 系統偵測到使用者拒絕某項操作或拒絕存入記憶。你必須立刻呼叫 memory_confirm 工具，取消暫存 ID: %s 。\n\n請按照以下格式輸出：\n{"name": "memory_confirm", "arguments": {"action": "reject", "pending_id": "%s"}}。`, idStr, idStr)
-		},
-	},
+		}
+	} else {
+		if e.cfg != nil {
+			fmt.Printf("🔁 [Intent] 已重新載入意圖規則 (%d 條)\n", len(cfg.Rules))
+		}
+		e.cfg = cfg
+	}
+	e.modTime, e.size = modTime, size
+	return e.cfg
 }
 
-// getToolHint 檢查使用者輸入是否匹配任何工具提示規則
-// 如果匹配，回傳提示訊息；否則回傳空字串
-func getToolHint(input, pendingID string) string {
+// rules 回傳依優先順序排列的規則：設定檔新增的規則、技能觸發詞、內建規則
+func (e *intentEngine) rules(reg *core.Registry) ([]*IntentRule, MultiStepRules) {
+	cfg := e.config()
+	if reg == nil {
+		e.mu.Lock()
+		reg = e.registry
+		e.mu.Unlock()
+	}
+	rules := append([]*IntentRule(nil), cfg.custom...)
+	rules = append(rules, skillRules(reg)...)
+	return append(rules, cfg.builtin...), cfg.MultiStep
+}
+
+// skillRules 將技能宣告的觸發詞轉為規則 (觸發詞同時作為語意比對的範例句)
+func skillRules(reg *core.Registry) []*IntentRule {
+	if reg == nil {
+		return nil
+	}
+	var rules []*IntentRule
+	for _, t := range reg.GetTriggers() {
+		rule := &IntentRule{
+			Name:        "skill:" + t.Name,
+			Tool:        t.Name,
+			Keywords:    t.Triggers,
+			Examples:    t.Triggers,
+			Hint:        skillHintTemplate,
+			description: t.Description,
+		}
+		if err := rule.compile(); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// matchText 回傳以關鍵字或正規表示式命中的第一條規則
+func matchText(rules []*IntentRule, input string) *IntentRule {
 	lower := strings.ToLower(input)
-	for _, rule := range toolHintRules {
-		for _, kw := range rule.Keywords {
-			if strings.Contains(lower, strings.ToLower(kw)) {
-				return rule.HintFunc(input, pendingID)
+	for _, rule := range rules {
+		if rule.matchText(input, lower) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchExamples 以語意相似度比對有範例句的規則，回傳分數最高且達門檻的規則
+func (e *intentEngine) matchExamples(ctx context.Context, embedder memory.EmbeddingProvider, rules []*IntentRule, input string) *IntentRule {
+	e.mu.Lock()
+	var missing []string
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		for _, ex := range rule.Examples {
+			if _, ok := e.examples[ex]; !ok && !seen[ex] {
+				seen[ex] = true
+				missing = append(missing, ex)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	vectors, err := embedder.Embed(ctx, append([]string{input}, missing...))
+	if err != nil || len(vectors) != len(missing)+1 {
+		if err != nil {
+			fmt.Printf("⚠️ [Intent] 語意比對失敗: %v\n", err)
+		}
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, ex := range missing {
+		e.examples[ex] = vectors[i+1]
+	}
+	var best *IntentRule
+	bestScore := 0.0
+	for _, rule := range rules {
+		for _, ex := range rule.Examples {
+			score := memory.CosineSimilarity(vectors[0], e.examples[ex])
+			if score >= rule.Threshold && score > bestScore {
+				best, bestScore = rule, score
 			}
 		}
 	}
+	if best != nil {
+		fmt.Printf("🎯 [Intent] 語意命中規則 %s (%.2f)\n", best.Name, bestScore)
+	}
+	return best
+}
+
+// hasExamples 判斷是否有規則需要語意比對 (沒有時不呼叫 Embedding)
+func hasExamples(rules []*IntentRule) bool {
+	for _, rule := range rules {
+		if len(rule.Examples) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIntent 回傳本輪輸入命中的規則：先比對關鍵字與正規表示式，都沒有命中時再以語意比對
+func (a *Agent) matchIntent(ctx context.Context, input string) *IntentRule {
+	e := defaultIntents()
+	rules, _ := e.rules(a.registry())
+	if rule := matchText(rules, input); rule != nil {
+		return rule
+	}
+	if a.Tools == nil || !hasExamples(rules) {
+		return nil
+	}
+	a.Tools.mu.Lock()
+	embedder := a.Tools.Embedder
+	a.Tools.mu.Unlock()
+	if embedder == nil {
+		return nil
+	}
+	return e.matchExamples(ctx, embedder, rules, input)
+}
+
+// getToolHint 檢查使用者輸入是否以關鍵字或正規表示式命中任何規則
+// 如果命中，回傳提示訊息；否則回傳空字串
+func getToolHint(input, pendingID string) string {
+	rules, _ := defaultIntents().rules(nil)
+	if rule := matchText(rules, input); rule != nil {
+		return rule.render(input, pendingID)
+	}
+	return ""
+}
+
+// matchedTools 回傳輸入以關鍵字或正規表示式命中的所有規則對應的工具 (不重複)
+func matchedTools(rules []*IntentRule, input string) []string {
+	lower := strings.ToLower(input)
+	seen := make(map[string]bool)
+	var names []string
+	for _, rule := range rules {
+		if !seen[rule.Tool] && rule.matchText(input, lower) {
+			seen[rule.Tool] = true
+			names = append(names, rule.Tool)
+		}
+	}
+	return names
+}
+
+// hintedTools 回傳使用者輸入命中的所有規則所對應的工具名稱 (供工具挑選加權)
+func hintedTools(reg *core.Registry, input string) []string {
+	rules, _ := defaultIntents().rules(reg)
+	return matchedTools(rules, input)
+}
+
+// DetectToolIntent 回傳使用者輸入命中的第一條規則所對應的工具名稱
+// 供 Gateway 路由規則依意圖選擇模型；沒有命中時回傳空字串
+func DetectToolIntent(input string) string {
+	rules, _ := defaultIntents().rules(nil)
+	if rule := matchText(rules, input); rule != nil {
+		return rule.Tool
+	}
 	return ""
 }
 
@@ -160,40 +463,25 @@ func getToolHint(input, pendingID string) string {
 // 多步驟意圖偵測 (Multi-Step Intent Detection)
 // ─────────────────────────────────────────────────────────────
 
-// chainingKeywords 連動關鍵字：使用者明確表達多步驟意圖
-var chainingKeywords = []string{
-	"然後", "之後", "接著", "以及", "同時",
-	"整理成", "彙整", "統整", "幫我", "再",
-	"步驟", "依序", "順序", "先", "最後",
-	"and then", "after that", "also", "next", "finally",
-	"step by step", "followed by",
-}
-
 // detectMultiStepIntent 偵測使用者輸入是否包含需要多步驟執行的意圖
 // 回傳 Planning Prompt 或空字串
-func detectMultiStepIntent(input string) string {
-	lower := strings.ToLower(input)
-
-	// 策略 1: 檢查是否命中 ≥2 個不同的工具類別關鍵字
-	matchedCategories := make(map[string]bool)
-	for _, rule := range toolHintRules {
-		for _, kw := range rule.Keywords {
-			if strings.Contains(lower, strings.ToLower(kw)) {
-				matchedCategories[rule.ToolName] = true
-				break // 一個類別只計算一次
-			}
-		}
+func detectMultiStepIntent(reg *core.Registry, input string) string {
+	rules, ms := defaultIntents().rules(reg)
+	if ms.tmpl == nil {
+		return ""
 	}
 
-	isMultiStep := len(matchedCategories) >= 2
+	// 策略 1: 檢查是否命中多個不同工具的規則
+	isMultiStep := ms.MinTools > 0 && len(matchedTools(rules, input)) >= ms.MinTools
 
 	// 策略 2: 檢查是否包含連動關鍵字
-	if !isMultiStep {
+	if !isMultiStep && ms.MinChaining > 0 {
+		lower := strings.ToLower(input)
 		chainingCount := 0
-		for _, kw := range chainingKeywords {
+		for _, kw := range ms.Chaining {
 			if strings.Contains(lower, strings.ToLower(kw)) {
 				chainingCount++
-				if chainingCount >= 2 {
+				if chainingCount >= ms.MinChaining {
 					isMultiStep = true
 					break
 				}
@@ -205,23 +493,10 @@ func detectMultiStepIntent(input string) string {
 		return ""
 	}
 
-	// 構建 Planning Prompt
-	today := time.Now().Format("2006-01-02 15:04")
-	return fmt.Sprintf(`[SYSTEM INSTRUCTION — 複雜任務編排模式]
-⚠️ 系統偵測到此請求涉及多個步驟或上下文連動的操作。
-
-你必須遵循以下「先計畫再執行」流程：
-
-1. **分析使用者意圖**：仔細閱讀使用者的完整請求，拆解為具體的執行步驟。
-2. **建立計畫**：立即呼叫 task_planner 工具建立計畫：
-   {"name": "task_planner", "arguments": {"action": "create", "goal": "使用者的總目標", "steps": "步驟1;步驟2;步驟3"}}
-3. **依序執行**：按照計畫中的步驟，逐一執行對應的工具呼叫。每完成一步後，使用 task_planner(action="update") 更新步驟狀態。
-4. **彙整結果**：所有步驟完成後，彙整各步驟結果，給出最終回答。最後呼叫 task_planner(action="finish") 結束計畫。
-
-📅 當前時間: %s
-
-注意事項：
-- 每個步驟應該具體到對應的工具呼叫
-- 若某步驟失敗，記錄失敗原因後繼續下一步
-- 不要跳過計畫建立步驟，直接執行工具`, today)
+	var buf bytes.Buffer
+	if err := ms.tmpl.Execute(&buf, newHintData(input, "", "", "")); err != nil {
+		fmt.Printf("⚠️ [Intent] 多步驟提示產生失敗: %v\n", err)
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
 }
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// triggeredTool 模擬在 SKILL.md 宣告 triggers 的技能
+type triggeredTool struct{ describedTool }
+
+func (t *triggeredTool) Triggers() []string { return []string{"匯率"} }
+
+// useIntentFile 讓共用的意圖規則改讀測試檔案，測試結束後還原
+func useIntentFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "intents.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := defaultIntents()
+	e.mu.Lock()
+	oldPath := e.path
+	e.path, e.cfg = path, nil
+	e.mu.Unlock()
+	t.Cleanup(func() {
+		e.mu.Lock()
+		e.path, e.cfg = oldPath, nil
+		e.mu.Unlock()
+	})
+	return path
+}
+
+func TestIntentRulesFromYAML(t *testing.T) {
+	path := useIntentFile(t, `
+rules:
+  - name: stock
+    tool: web_search
+    regex: '\d{4}\.TW'
+    hint: "查詢 {{.Input}} 的股價"
+  - name: weather
+    tool: get_taiwan_weather
+    keywords: [天氣]
+    hint: "自訂天氣提示"
+  - name: memory_confirm
+    disabled: true
+`)
+	if got := getToolHint("2330.TW 多少錢", ""); got != "查詢 2330.TW 多少錢 的股價" {
+		t.Errorf("regex rule hint = %q", got)
+	}
+	if got := getToolHint("台北天氣", ""); got != "自訂天氣提示" {
+		t.Errorf("override hint = %q", got)
+	}
+	if got := DetectToolIntent("好的"); got != "" {
+		t.Errorf("disabled rule still matches: %s", got)
+	}
+	if got := DetectToolIntent("查看行事曆"); got != "manage_calendar" {
+		t.Errorf("builtin rule lost: %q", got)
+	}
+
+	// 熱重載：修改檔案後下一次比對即生效
+	later := time.Now().Add(time.Second)
+	if err := os.WriteFile(path, []byte("rules:\n  - name: stock\n    tool: web_search\n    keywords: [股價]\n    hint: 新版\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, later, later)
+	if got := getToolHint("台積電股價", ""); got != "新版" {
+		t.Errorf("reloaded hint = %q", got)
+	}
+	if got := DetectToolIntent("好的"); got != "memory_confirm" {
+		t.Errorf("builtin rule should be restored after reload, got %q", got)
+	}
+
+	// 格式錯誤時沿用上一次的規則
+	_ = os.WriteFile(path, []byte("rules: [\n"), 0644)
+	_ = os.Chtimes(path, later.Add(time.Second), later.Add(time.Second))
+	if got := getToolHint("台積電股價", ""); got != "新版" {
+		t.Errorf("broken file should keep previous rules, got %q", got)
+	}
+}
+
+func TestSkillTriggersAndForcedTool(t *testing.T) {
+	useIntentFile(t, `
+rules:
+  - name: email
+    tool: manage_email
+    keywords: [郵件]
+    force: true
+    hint: 請讀取郵件
+`)
+	reg := core.NewRegistry()
+	reg.Register(&triggeredTool{describedTool{"currency_rate", "查詢外幣匯率"}})
+	reg.Register(&describedTool{"manage_email", "讀取郵件"})
+	reg.Register(&describedTool{"web_search", "搜尋網頁"})
+
+	a := NewAgent("mock-model", "system prompt", &history.Session{}, reg, nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+
+	var seen []string
+	var lastUser string
+	a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		seen = append(seen, toolNames(tools))
+		for _, m := range messages {
+			if m.Role == "user" {
+				lastUser = m.Content
+			}
+		}
+		if len(seen) == 1 {
+			return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+				{Function: api.ToolCallFunction{Name: "manage_email"}},
+			}}, nil
+		}
+		return ollama.Message{Role: "assistant", Content: "完成"}, nil
+	}
+
+	if _, err := a.Chat(context.Background(), "幫我看郵件", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if len(seen) != 2 || seen[0] != "manage_email" || seen[1] != "currency_rate,manage_email,web_search" {
+		t.Errorf("forced tool should only apply to the first round: %v", seen)
+	}
+	if !strings.HasSuffix(lastUser, "請讀取郵件") {
+		t.Errorf("hint not injected: %q", lastUser)
+	}
+
+	rule := a.matchIntent(context.Background(), "今天美金匯率多少")
+	if rule == nil || rule.Tool != "currency_rate" || !strings.Contains(rule.render("", ""), "查詢外幣匯率") {
+		t.Fatalf("skill trigger not matched: %+v", rule)
+	}
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/asccclass/pcai/internal/memory"
+	"github.com/asccclass/pcai/internal/toolresult"
+	"github.com/asccclass/pcai/llms"
+	"github.com/asccclass/pcai/llms/ollama"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 大型工具結果 (Spill-to-File)
+// ─────────────────────────────────────────────────────────────
+//
+// web_fetch 網頁、fs_read_file、郵件清單與瀏覽器快照常常一次就有數千 Token。
+// 超過 ContextBudget.SpillResult 的結果會存入 toolresult.Store，對話中只放
+// 代號、自動摘要與開頭/結尾預覽，模型需要細節時再呼叫 tool_result_read 分頁讀取或搜尋。
+
+const (
+	spillHeadTokens    = 400  // 預覽開頭
+	spillTailTokens    = 200  // 預覽結尾
+	spillSummaryTokens = 3000 // 送去摘要的內容上限 (避免超過小模型的上下文)
+)
+
+// spillLargeResult 將過大的工具結果存檔，回傳給模型看的預覽；不需處理時原樣回傳
+func (a *Agent) spillLargeResult(ctx context.Context, tool, result string) string {
+	if a.Results == nil || a.Context.SpillResult <= 0 || tool == toolresult.ReadToolName {
+		return result
+	}
+	tokens := memory.CountTokens(result)
+	if tokens <= a.Context.SpillResult {
+		return result
+	}
+	handle, err := a.Results.Save(result)
+	if err != nil {
+		fmt.Printf("⚠️ [ToolResult] %v，改為直接回傳完整結果\n", err)
+		return result
+	}
+	fmt.Printf("📦 [ToolResult] %s 的結果約 %d Token，已存為 %s\n", tool, tokens, handle)
+	if a.activeTools != nil {
+		a.activeTools.add(toolresult.ReadToolName)
+	}
+
+	// 代號放在最前面，舊回合被截斷時仍能保留
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("【大型工具結果】%s 的輸出共 %d 字元 (約 %d Token)，完整內容已存為 %s。\n",
+		tool, len([]rune(result)), tokens, handle))
+	sb.WriteString(fmt.Sprintf("需要更多內容時請呼叫 %s，參數 handle=\"%s\"，可用 offset 分頁或 query 搜尋關鍵字。\n",
+		toolresult.ReadToolName, handle))
+	if summary := a.summarizeResult(ctx, tool, result); summary != "" {
+		sb.WriteString("\n--- 摘要 ---\n" + summary + "\n")
+	}
+	sb.WriteString("\n--- 開頭 ---\n" + memory.TruncateByTokens(result, spillHeadTokens) + "\n")
+	sb.WriteString("\n--- 結尾 ---\n" + tailByTokens(result, spillTailTokens))
+	return sb.String()
+}
+
+// summarizeResult 請模型為工具結果寫摘要；PCAI_TOOL_RESULT_SUMMARY=false 或模型失敗時回傳空字串
+func (a *Agent) summarizeResult(ctx context.Context, tool, result string) string {
+	if a.Provider == nil || strings.EqualFold(os.Getenv("PCAI_TOOL_RESULT_SUMMARY"), "false") {
+		return ""
+	}
+	prompt := fmt.Sprintf("以下是工具 %s 的輸出，請用繁體中文在 5 行以內摘要重點 (保留關鍵數據、名稱與日期)，只輸出摘要。\n\n%s",
+		tool, memory.TruncateByTokens(result, spillSummaryTokens))
+	var streamed strings.Builder
+	msg, err := a.Provider(llms.WithUsageTags(ctx, llms.UsageTags{Workflow: "summarize"}), a.ModelName, []ollama.Message{
+		{Role: "system", Content: "你是一個資料摘要專家。"},
+		{Role: "user", Content: prompt},
+	}, nil, llms.ProfileOptions(llms.ProfileSummarize), func(c string) { streamed.WriteString(c) })
+	if err != nil {
+		if a.Logger != nil {
+			a.Logger.LogError("工具結果摘要失敗", err)
+		}
+		return ""
+	}
+	summary := strings.TrimSpace(ollama.StripThinking(msg.Content))
+	if summary == "" {
+		summary = strings.TrimSpace(ollama.StripThinking(streamed.String()))
+	}
+	return summary
+}
+
+// tailByTokens 回傳內容結尾約 maxTokens 的部分
+func tailByTokens(content string, maxTokens int) string {
+	runes := []rune(content)
+	total := memory.CountTokens(content)
+	if total <= maxTokens {
+		return content
+	}
+	n := len(runes) * maxTokens / total
+	return "«前略»..." + string(runes[len(runes)-n:])
+}
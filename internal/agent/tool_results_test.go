@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/internal/toolresult"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// bigTool 回傳超過 spill 門檻的結果
+type bigTool struct{ content string }
+
+func (b *bigTool) Name() string  { return "web_fetch" }
+func (b *bigTool) IsSkill() bool { return false }
+func (b *bigTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: "web_fetch"}}
+}
+func (b *bigTool) Run(argsJSON string) (string, error) { return b.content, nil }
+
+func TestLargeToolResultIsSpilled(t *testing.T) {
+	t.Setenv("PCAI_TOOL_RESULT_SUMMARY", "false")
+	var sb strings.Builder
+	for i := 0; i < 2000; i++ {
+		sb.WriteString("網頁內容 line filler text\n")
+	}
+	sb.WriteString("最後一行 重要結論")
+	content := sb.String()
+
+	reg := core.NewRegistry()
+	reg.Register(&bigTool{content: content})
+	a := NewAgent("mock-model", "system prompt", &history.Session{}, reg, nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+	a.Context.SpillResult = 200
+	a.Results = toolresult.NewStore(t.TempDir(), time.Hour)
+
+	var toolMsg string
+	a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		if last := messages[len(messages)-1]; last.Role == "tool" {
+			toolMsg = last.Content
+			return ollama.Message{Role: "assistant", Content: "完成"}, nil
+		}
+		return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "web_fetch"}},
+		}}, nil
+	}
+
+	if _, err := a.Chat(context.Background(), "幫我讀這個網頁", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	handle := regexp.MustCompile(`tr_\d{8}_\d{6}_\d+`).FindString(toolMsg)
+	if handle == "" {
+		t.Fatalf("preview has no handle: %q", toolMsg)
+	}
+	if len(toolMsg) >= len(content) || !strings.Contains(toolMsg, "重要結論") {
+		t.Errorf("preview should be short and keep the tail: %d bytes", len(toolMsg))
+	}
+	if saved, err := a.Results.Load(handle); err != nil || saved != content {
+		t.Errorf("stored result mismatch: %v", err)
+	}
+}
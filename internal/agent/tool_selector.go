@@ -0,0 +1,346 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/internal/memory"
+	"github.com/ollama/ollama/api"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 工具挑選 (Relevance-Based Tool Selection)
+// ─────────────────────────────────────────────────────────────
+//
+// 每次請求都送出 30+ 個工具定義會塞滿小模型的上下文，也讓它更容易選錯工具。
+// 設定 PCAI_TOOL_TOP_K 後，每輪只送出：
+//   - 固定工具 (PCAI_TOOL_PINNED)
+//   - 與使用者輸入語意最相近的 K 個工具 (以 memory.EmbeddingProvider 計算，
+//     命中意圖規則 (tool_hint.go) 與最近使用過的工具會加分)
+//   - request_tools：模型需要清單外的工具時，可依描述或名稱取得更多工具
+// Embedding 無法使用時退回送出全部工具。
+
+// RequestToolsName 是讓模型要求更多工具的內建工具名稱
+const RequestToolsName = "request_tools"
+
+const (
+	hintBoost    = 0.3 // 關鍵字提示命中的加分
+	recentBoost  = 0.2 // 最近使用過的加分
+	recentScan   = 12  // 往回檢查幾則 Session 訊息的工具呼叫
+	requestLimit = 3   // request_tools 每次最多加入的工具數
+	requestRatio = 0.8 // request_tools 只加入相似度達最高分此比例的工具
+)
+
+// ToolSelector 依使用者輸入挑選最相關的工具定義，工具的 Embedding 會快取重複使用
+type ToolSelector struct {
+	Embedder memory.EmbeddingProvider // nil 代表停用挑選
+	TopK     int                      // 每輪依相關度送出的工具數 (PCAI_TOOL_TOP_K，0 代表送出全部)
+	Pinned   []string                 // 一律送出的工具 (PCAI_TOOL_PINNED)
+
+	mu    sync.Mutex
+	cache map[string][]float32 // 工具說明文字 -> Embedding
+}
+
+var (
+	defaultSelectorOnce sync.Once
+	defaultSelector     *ToolSelector
+)
+
+// NewToolSelector 以環境變數設定建立工具挑選器
+func NewToolSelector(embedder memory.EmbeddingProvider) *ToolSelector {
+	pinned := []string{"task_planner", "memory_search", DelegateToolName}
+	if v, ok := os.LookupEnv("PCAI_TOOL_PINNED"); ok {
+		pinned = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				pinned = append(pinned, name)
+			}
+		}
+	}
+	return &ToolSelector{
+		Embedder: embedder,
+		TopK:     envInt("PCAI_TOOL_TOP_K", 0),
+		Pinned:   pinned,
+		cache:    make(map[string][]float32),
+	}
+}
+
+// DefaultToolSelector 回傳所有 Agent 共用的工具挑選器
+func DefaultToolSelector() *ToolSelector {
+	defaultSelectorOnce.Do(func() {
+		defaultSelector = NewToolSelector(nil)
+	})
+	return defaultSelector
+}
+
+// SetToolEmbedder 設定共用工具挑選器的 Embedding Provider (通常沿用記憶系統的 Provider)
+func SetToolEmbedder(e memory.EmbeddingProvider) {
+	s := DefaultToolSelector()
+	s.mu.Lock()
+	s.Embedder = e
+	s.mu.Unlock()
+}
+
+// Watch 訂閱註冊表變動：工具被移除或取代時丟棄過期的 Embedding 快取
+func (s *ToolSelector) Watch(reg *core.Registry) (unsubscribe func()) {
+	return reg.Subscribe(func(e core.RegistryEvent) {
+		if e.Change == core.ToolAdded {
+			return
+		}
+		current := make(map[string]bool)
+		for _, d := range reg.GetDefinitions() {
+			current[toolText(d)] = true
+		}
+		s.mu.Lock()
+		for text := range s.cache {
+			if !current[text] {
+				delete(s.cache, text)
+			}
+		}
+		s.mu.Unlock()
+	})
+}
+
+// enabled 判斷是否需要挑選 (工具數量不多時直接全部送出)
+func (s *ToolSelector) enabled(total int) bool {
+	if s == nil || s.TopK <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Embedder != nil && total > s.TopK+len(s.Pinned)
+}
+
+// rank 依語意相似度加上 boost 將 defs 由高到低排序，回傳工具名稱與分數
+func (s *ToolSelector) rank(ctx context.Context, query string, defs []api.Tool, boost map[string]float64) ([]string, map[string]float64, error) {
+	s.mu.Lock()
+	embedder := s.Embedder
+	var texts []string
+	for _, d := range defs {
+		if _, ok := s.cache[toolText(d)]; !ok {
+			texts = append(texts, toolText(d))
+		}
+	}
+	s.mu.Unlock()
+	if embedder == nil {
+		return nil, nil, fmt.Errorf("未設定 Embedding Provider")
+	}
+
+	vectors, err := embedder.Embed(ctx, append([]string{query}, texts...))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(vectors) != len(texts)+1 {
+		return nil, nil, fmt.Errorf("Embedding 數量不符: %d/%d", len(vectors), len(texts)+1)
+	}
+
+	s.mu.Lock()
+	for i, text := range texts {
+		s.cache[text] = vectors[i+1]
+	}
+	scores := make(map[string]float64, len(defs))
+	names := make([]string, 0, len(defs))
+	for _, d := range defs {
+		name := d.Function.Name
+		scores[name] = memory.CosineSimilarity(vectors[0], s.cache[toolText(d)]) + boost[name]
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(names, func(i, j int) bool { return scores[names[i]] > scores[names[j]] })
+	return names, scores, nil
+}
+
+// toolText 是用來計算 Embedding 的工具說明
+func toolText(d api.Tool) string {
+	return d.Function.Name + ": " + d.Function.Description
+}
+
+// requestToolsDefinition 回傳 request_tools 的定義
+func requestToolsDefinition() api.Tool {
+	var props api.ToolPropertiesMap
+	_ = json.Unmarshal([]byte(`{
+		"query": {"type": "string", "description": "需要的功能描述，例如「寄送 WhatsApp 訊息」"},
+		"names": {"type": "array", "items": {"type": "string"}, "description": "已知的工具名稱 (選填)"}
+	}`), &props)
+	return api.Tool{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name:        RequestToolsName,
+			Description: "目前只提供了部分工具。若需要的工具不在清單中，用此工具描述需要的功能或工具名稱，系統會在下一步提供對應的工具。",
+			Parameters: api.ToolFunctionParameters{
+				Type:       "object",
+				Properties: &props,
+			},
+		},
+	}
+}
+
+// toolSet 是單輪對話中提供給模型的工具 (nil names 代表全部)
+type toolSet struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	selector *ToolSelector
+	registry *core.Registry
+	names    map[string]bool
+	forced   string // 下一輪只提供此工具 (意圖規則的 force)
+}
+
+// selectTools 在每輪開始時依輸入、關鍵字提示與最近使用的工具挑選工具
+func (a *Agent) selectTools(ctx context.Context, input string) *toolSet {
+	reg := a.registry()
+	set := &toolSet{ctx: ctx, selector: a.Tools, registry: reg}
+	all := reg.GetDefinitions()
+	if !a.Tools.enabled(len(all)) {
+		return set
+	}
+
+	boost := make(map[string]float64)
+	for _, name := range hintedTools(reg, input) {
+		boost[name] += hintBoost
+	}
+	for _, name := range recentTools(a.Session) {
+		boost[name] += recentBoost
+	}
+
+	rankCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ranked, _, err := a.Tools.rank(rankCtx, input, all, boost)
+	if err != nil {
+		fmt.Printf("⚠️ [Tools] 工具挑選失敗，改為提供全部工具: %v\n", err)
+		return set
+	}
+
+	set.names = make(map[string]bool)
+	for _, name := range a.Tools.Pinned {
+		set.names[name] = true
+	}
+	for _, name := range ranked[:min(a.Tools.TopK, len(ranked))] {
+		set.names[name] = true
+	}
+	fmt.Printf("🧰 [Tools] 本輪提供 %d/%d 個工具\n", len(set.definitions())-1, len(all))
+	return set
+}
+
+// recentTools 回傳最近幾則訊息中呼叫過的工具
+func recentTools(sess *history.Session) []string {
+	if sess == nil {
+		return nil
+	}
+	msgs := sess.Messages[max(0, len(sess.Messages)-recentScan):]
+	var names []string
+	for _, m := range msgs {
+		for _, tc := range m.ToolCalls {
+			names = append(names, tc.Function.Name)
+		}
+	}
+	return names
+}
+
+// definitions 回傳本輪要送給模型的工具定義
+func (s *toolSet) definitions() []api.Tool {
+	all := s.registry.GetDefinitions()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.forced != "" {
+		name := s.forced
+		s.forced = ""
+		for _, d := range all {
+			if d.Function.Name == name {
+				return []api.Tool{d}
+			}
+		}
+	}
+	if s.names == nil {
+		return all
+	}
+	defs := make([]api.Tool, 0, len(s.names)+1)
+	for _, d := range all {
+		if s.names[d.Function.Name] {
+			defs = append(defs, d)
+		}
+	}
+	return append(defs, requestToolsDefinition())
+}
+
+// force 讓下一輪只提供指定的工具 (找不到該工具時照常提供)
+func (s *toolSet) force(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forced = name
+	if s.names != nil {
+		s.names[name] = true
+	}
+}
+
+// add 將工具加入本輪的清單 (例如模型直接呼叫了清單外但存在的工具)
+func (s *toolSet) add(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names == nil {
+		return
+	}
+	for _, name := range names {
+		s.names[name] = true
+	}
+}
+
+// request 處理 request_tools：依名稱或功能描述加入更多工具
+func (s *toolSet) request(argsJSON string) (string, error) {
+	var args struct {
+		Query string   `json:"query"`
+		Names []string `json:"names"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return "", fmt.Errorf("參數解析失敗: %v", err)
+	}
+	if s.names == nil {
+		return "目前已提供全部工具，請直接從工具清單中選擇。", nil
+	}
+
+	var candidates []api.Tool
+	known := make(map[string]bool)
+	s.mu.Lock()
+	for _, d := range s.registry.GetDefinitions() {
+		known[d.Function.Name] = true
+		if !s.names[d.Function.Name] {
+			candidates = append(candidates, d)
+		}
+	}
+	s.mu.Unlock()
+
+	var added []string
+	for _, name := range args.Names {
+		if known[name] {
+			added = append(added, name)
+		}
+	}
+	if strings.TrimSpace(args.Query) != "" && len(candidates) > 0 {
+		ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+		defer cancel()
+		ranked, scores, err := s.selector.rank(ctx, args.Query, candidates, nil)
+		if err != nil {
+			return "", fmt.Errorf("搜尋工具失敗: %w", err)
+		}
+		for _, name := range ranked[:min(requestLimit, len(ranked))] {
+			if scores[name] > 0 && scores[name] >= scores[ranked[0]]*requestRatio {
+				added = append(added, name)
+			}
+		}
+	}
+	if len(added) == 0 {
+		return "找不到符合的工具，請改用其他描述或直接回覆使用者目前無法完成。", nil
+	}
+
+	s.add(added...)
+	fmt.Printf("🧰 [Tools] 模型要求更多工具: %s\n", strings.Join(added, ", "))
+	return fmt.Sprintf("已加入工具: %s。下一步即可直接呼叫。", strings.Join(added, ", ")), nil
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// keywordEmbedder 以關鍵字出現與否當作向量維度，讓相似度可預期
+type keywordEmbedder struct{ calls int }
+
+var embedDims = [][]string{{"天氣", "weather"}, {"郵件", "email"}, {"檔案", "file"}, {"網頁", "web"}}
+
+func (k *keywordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	k.calls++
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, len(embedDims)+1)
+		vec[len(embedDims)] = 0.01
+		for d, words := range embedDims {
+			for _, w := range words {
+				if strings.Contains(strings.ToLower(text), w) {
+					vec[d] = 1
+				}
+			}
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+func (k *keywordEmbedder) Dimensions() int   { return len(embedDims) + 1 }
+func (k *keywordEmbedder) Name() string      { return "keyword" }
+func (k *keywordEmbedder) ModelName() string { return "keyword" }
+
+type describedTool struct{ name, desc string }
+
+func (d *describedTool) Name() string  { return d.name }
+func (d *describedTool) IsSkill() bool { return false }
+func (d *describedTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: d.name, Description: d.desc}}
+}
+func (d *describedTool) Run(argsJSON string) (string, error) { return d.name + " ok", nil }
+
+func toolNames(defs []api.Tool) string {
+	var names []string
+	for _, d := range defs {
+		names = append(names, d.Function.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestToolSelectionAndRequestTools(t *testing.T) {
+	reg := core.NewRegistry()
+	for _, tool := range []*describedTool{
+		{"get_taiwan_weather", "查詢台灣天氣預報"},
+		{"manage_email", "讀取與寄送 email 郵件"},
+		{"fs_list_dir", "列出目錄中的檔案"},
+		{"web_search", "搜尋網頁"},
+		{"task_planner", "建立多步驟計畫"},
+	} {
+		reg.Register(tool)
+	}
+
+	embedder := &keywordEmbedder{}
+	a := NewAgent("mock-model", "system prompt", &history.Session{}, reg, nil)
+	a.ActiveBuffer = nil
+	a.DailyLogger = nil
+	a.Tools = &ToolSelector{Embedder: embedder, TopK: 1, Pinned: []string{"task_planner"}, cache: map[string][]float32{}}
+
+	var seen []string
+	a.Provider = func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		seen = append(seen, toolNames(tools))
+		if len(seen) == 1 {
+			var args api.ToolCallFunctionArguments
+			args.Set("query", "寄 email")
+			return ollama.Message{Role: "assistant", ToolCalls: []api.ToolCall{
+				{Function: api.ToolCallFunction{Name: RequestToolsName, Arguments: args}},
+			}}, nil
+		}
+		return ollama.Message{Role: "assistant", Content: "好的"}, nil
+	}
+
+	if _, err := a.Chat(context.Background(), "明天天氣如何", nil); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("provider calls = %d, want 2", len(seen))
+	}
+	if seen[0] != "get_taiwan_weather,request_tools,task_planner" {
+		t.Errorf("first round tools = %s", seen[0])
+	}
+	if seen[1] != "get_taiwan_weather,manage_email,request_tools,task_planner" {
+		t.Errorf("requested tool not added: %s", seen[1])
+	}
+	if result := a.Session.Messages[len(a.Session.Messages)-2].Content; !strings.Contains(result, "manage_email") {
+		t.Errorf("request_tools result = %q", result)
+	}
+
+	// 關鍵字提示加分：「郵件」同時命中 embedding 與 tool_hint 規則
+	calls := embedder.calls
+	set := a.selectTools(context.Background(), "幫我看看網頁和郵件")
+	if got := toolNames(set.definitions()); got != "manage_email,request_tools,task_planner" {
+		t.Errorf("boosted selection = %s", got)
+	}
+	if embedder.calls != calls+1 {
+		t.Errorf("tool embeddings should be cached: %d embed calls", embedder.calls-calls)
+	}
+
+	// 沒有 Embedding Provider 時提供全部工具
+	a.Tools = &ToolSelector{TopK: 1}
+	if got := a.selectTools(context.Background(), "天氣").definitions(); len(got) != 5 {
+		t.Errorf("fallback should send all tools, got %s", toolNames(got))
+	}
+}
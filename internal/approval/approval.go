@@ -0,0 +1,258 @@
+// Package approval 在執行有副作用的工具前向使用者取得核准
+//
+// 工具透過 core.RiskAssessor (或 SKILL.md 的 risk / risk_when) 宣告風險等級，
+// approvals.yaml (路徑可用 PCAI_APPROVALS 指定) 可覆寫個別工具的等級與核准門檻：
+//
+//	require: medium          # 需要核准的最低等級 (low, medium, high；off 代表全部不需核准)
+//	unattended: [fs_append_file]  # 無法詢問使用者時 (心跳、排程等背景工作) 仍可直接執行的工具
+//	tools:
+//	  - name: fs_append_file
+//	    risk: medium
+//	  - name: manage_calendar
+//	    risk: high
+//	    when:
+//	      mode: [delete]
+//
+// 等級達到門檻的呼叫會透過發出訊息的頻道詢問使用者 (CLI 的 y/n、Telegram 按鈕、API 回調)，
+// 使用者選擇「本次對話一律允許」後，同一個 Session 不再詢問該工具。
+// 規則一律比對實際工具名稱，模型使用別名或 "namespace:name" 呼叫時同樣套用。
+package approval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Decision 是使用者對核准請求的回覆
+type Decision int
+
+const (
+	Deny        Decision = iota // 拒絕執行
+	Allow                       // 允許這一次
+	AllowAlways                 // 本次對話 (Session) 中一律允許此工具
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "allow"
+	case AllowAlways:
+		return "always"
+	}
+	return "deny"
+}
+
+// ParseDecision 解析使用者的回覆，無法辨識的回覆一律視為拒絕
+func ParseDecision(s string) Decision {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "y", "yes", "ok", "allow", "允許", "是", "好":
+		return Allow
+	case "a", "always", "一律允許", "總是允許":
+		return AllowAlways
+	}
+	return Deny
+}
+
+// Request 是一次工具核准請求
+type Request struct {
+	ID        string         `json:"id"`
+	SessionID string         `json:"session_id"`
+	Tool      string         `json:"tool"`
+	Args      string         `json:"args"`
+	Risk      core.RiskLevel `json:"risk"`
+}
+
+// Prompt 回傳顯示給使用者的核准說明
+func (r Request) Prompt() string {
+	return fmt.Sprintf("🛡️ 工具 %s (風險: %s) 需要您的核准才會執行\n參數: %s", r.Tool, r.Risk, r.Args)
+}
+
+// Approver 透過頻道詢問使用者並等待回覆；ctx 逾時或取消時應盡快回傳
+type Approver func(ctx context.Context, req Request) (Decision, error)
+
+// Rule 覆寫單一工具的風險等級
+type Rule struct {
+	Name string              `yaml:"name"`
+	Risk string              `yaml:"risk"`
+	When map[string][]string `yaml:"when"` // 只有參數符合時才套用，留空代表一律套用
+}
+
+// Policy 是 approvals.yaml 的內容
+type Policy struct {
+	Require    string   `yaml:"require"`    // 需要核准的最低等級，預設 medium
+	Unattended []string `yaml:"unattended"` // 沒有核准管道的呼叫端 (背景工作) 仍可執行的工具，其餘一律拒絕
+	Tools      []Rule   `yaml:"tools"`
+}
+
+var (
+	mu     sync.RWMutex
+	policy *Policy
+)
+
+// LoadPolicy 讀取核准設定檔；檔案不存在時使用預設值
+func LoadPolicy(path string) error {
+	p := &Policy{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("讀取核准設定失敗: %w", err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, p); err != nil {
+			return fmt.Errorf("解析核准設定失敗 (%s): %w", path, err)
+		}
+	}
+	if _, _, err := p.threshold(); err != nil {
+		return err
+	}
+	for _, r := range p.Tools {
+		if _, err := core.ParseRiskLevel(r.Risk); err != nil {
+			return fmt.Errorf("核准設定 %s: %w", r.Name, err)
+		}
+	}
+
+	mu.Lock()
+	policy = p
+	mu.Unlock()
+	return nil
+}
+
+// currentPolicy 回傳目前的設定，第一次使用時自動載入 PCAI_APPROVALS (預設 approvals.yaml)
+func currentPolicy() *Policy {
+	mu.RLock()
+	p := policy
+	mu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	path := os.Getenv("PCAI_APPROVALS")
+	if path == "" {
+		path = "approvals.yaml"
+	}
+	if err := LoadPolicy(path); err != nil {
+		fmt.Printf("⚠️ [Approval] %v，改用預設核准設定\n", err)
+		mu.Lock()
+		policy = &Policy{}
+		mu.Unlock()
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return policy
+}
+
+// threshold 回傳需要核准的最低等級；off 時 enabled 為 false
+func (p *Policy) threshold() (level core.RiskLevel, enabled bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(p.Require)) {
+	case "":
+		return core.RiskMedium, true, nil
+	case "off", "none":
+		return core.RiskNone, false, nil
+	}
+	level, err = core.ParseRiskLevel(p.Require)
+	if err != nil {
+		return core.RiskNone, false, fmt.Errorf("核准設定 require: %w", err)
+	}
+	return level, true, nil
+}
+
+// Assess 回傳工具呼叫的風險等級：設定檔中第一條符合的規則優先，否則使用工具本身的宣告
+// 比對規則前先透過註冊表把別名與 "namespace:name" 換成實際工具名稱
+func Assess(reg *core.Registry, tool, argsJSON string) core.RiskLevel {
+	name := tool
+	if reg != nil {
+		if real, ok := reg.ToolName(tool); ok {
+			name = real
+		}
+	}
+	for _, r := range currentPolicy().Tools {
+		if r.Name == name && core.ArgsMatch(argsJSON, r.When) {
+			level, _ := core.ParseRiskLevel(r.Risk)
+			return level
+		}
+	}
+	if reg == nil {
+		return core.RiskNone
+	}
+	return reg.ToolRisk(tool, argsJSON)
+}
+
+// Gate 記錄單一 Session 的核准狀態 (例如哪些工具已被「一律允許」)
+type Gate struct {
+	Timeout time.Duration // 等待使用者回覆的上限 (PCAI_APPROVAL_TIMEOUT 秒，預設 300)
+
+	mu     sync.Mutex
+	always map[string]bool
+	seq    int
+}
+
+// NewGate 建立新的核准閘道
+func NewGate() *Gate {
+	timeout := 300 * time.Second
+	if n, err := strconv.Atoi(os.Getenv("PCAI_APPROVAL_TIMEOUT")); err == nil && n > 0 {
+		timeout = time.Duration(n) * time.Second
+	}
+	return &Gate{Timeout: timeout, always: make(map[string]bool)}
+}
+
+// Check 判斷工具呼叫是否可以執行；需要核准時透過 approver 詢問使用者
+// 不允許執行時回傳的 reason 會當作工具結果交給模型
+func (g *Gate) Check(ctx context.Context, approver Approver, req Request) (ok bool, reason string) {
+	p := currentPolicy()
+	level, enabled, _ := p.threshold()
+	if !enabled || req.Risk < level {
+		return true, ""
+	}
+
+	g.mu.Lock()
+	if g.always[req.Tool] {
+		g.mu.Unlock()
+		return true, ""
+	}
+	g.seq++
+	req.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), g.seq)
+	g.mu.Unlock()
+
+	if approver == nil {
+		if slices.Contains(p.Unattended, req.Tool) {
+			fmt.Printf("🛡️ [Approval] %s 列在 unattended，無法詢問使用者時直接執行\n", req.Tool)
+			return true, ""
+		}
+		fmt.Printf("🛡️ [Approval] %s 需要核准，但目前的頻道無法詢問使用者，已拒絕 (可在 approvals.yaml 的 unattended 列出)\n", req.Tool)
+		return false, "此工具需要使用者核准，但目前的頻道無法詢問使用者，請改為告知使用者需要手動執行"
+	}
+
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	fmt.Printf("🛡️ [Approval] 等待使用者核准 %s (風險: %s)\n", req.Tool, req.Risk)
+	decision, err := approver(ctx, req)
+	switch {
+	case err != nil && ctx.Err() == context.DeadlineExceeded:
+		return false, "等待使用者核准逾時，工具未執行"
+	case err != nil:
+		return false, fmt.Sprintf("無法取得使用者核准: %v", err)
+	case decision == AllowAlways:
+		g.mu.Lock()
+		g.always[req.Tool] = true
+		g.mu.Unlock()
+		fmt.Printf("🛡️ [Approval] 使用者允許本次對話一律執行 %s\n", req.Tool)
+		return true, ""
+	case decision == Allow:
+		fmt.Printf("🛡️ [Approval] 使用者允許執行 %s\n", req.Tool)
+		return true, ""
+	}
+	fmt.Printf("🛡️ [Approval] 使用者拒絕執行 %s\n", req.Tool)
+	return false, "使用者拒絕執行此工具，請不要再嘗試，改為詢問使用者接下來要怎麼做"
+}
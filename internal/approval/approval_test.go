@@ -0,0 +1,130 @@
+package approval
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/ollama/ollama/api"
+)
+
+func usePolicy(t *testing.T, yaml string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "approvals.yaml")
+	if err := os.WriteFile(p, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPolicy(p); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	t.Cleanup(func() { policy = nil })
+}
+
+func answer(d Decision, asked *int) Approver {
+	return func(ctx context.Context, req Request) (Decision, error) {
+		*asked++
+		return d, nil
+	}
+}
+
+func TestGateCheck(t *testing.T) {
+	usePolicy(t, "")
+	ctx := context.Background()
+	var asked int
+
+	g := NewGate()
+	if ok, _ := g.Check(ctx, answer(Deny, &asked), Request{Tool: "fs_read_file", Risk: core.RiskLow}); !ok || asked != 0 {
+		t.Errorf("low risk should pass without asking: ok=%v asked=%d", ok, asked)
+	}
+	if ok, reason := g.Check(ctx, answer(Deny, &asked), Request{Tool: "shell_exec", Risk: core.RiskHigh}); ok || reason == "" {
+		t.Errorf("denied call ran: ok=%v reason=%q", ok, reason)
+	}
+	if ok, reason := g.Check(ctx, nil, Request{Tool: "shell_exec", Risk: core.RiskHigh}); ok || reason == "" {
+		t.Errorf("call without approver ran: ok=%v reason=%q", ok, reason)
+	}
+
+	asked = 0
+	for i := 0; i < 3; i++ {
+		if ok, _ := g.Check(ctx, answer(AllowAlways, &asked), Request{Tool: "fs_write_file", Risk: core.RiskMedium}); !ok {
+			t.Fatal("always-allowed call denied")
+		}
+	}
+	if asked != 1 {
+		t.Errorf("asked %d times, want 1 after always-allow", asked)
+	}
+	if ok, _ := NewGate().Check(ctx, nil, Request{Tool: "fs_write_file", Risk: core.RiskMedium}); ok {
+		t.Error("always-allow leaked into another gate")
+	}
+}
+
+func TestPolicyOverrides(t *testing.T) {
+	usePolicy(t, `
+require: high
+tools:
+  - name: manage_calendar
+    risk: high
+    when:
+      mode: [delete]
+`)
+	if got := Assess(nil, "manage_calendar", `{"mode": "DELETE"}`); got != core.RiskHigh {
+		t.Errorf("delete risk = %s, want high", got)
+	}
+	if got := Assess(nil, "manage_calendar", `{"mode": "list"}`); got != core.RiskNone {
+		t.Errorf("list risk = %s, want none", got)
+	}
+	var asked int
+	if ok, _ := NewGate().Check(context.Background(), answer(Deny, &asked), Request{Tool: "fs_write_file", Risk: core.RiskMedium}); !ok {
+		t.Error("medium risk should pass with require: high")
+	}
+
+	usePolicy(t, "require: off")
+	if ok, _ := NewGate().Check(context.Background(), nil, Request{Tool: "shell_exec", Risk: core.RiskHigh}); !ok {
+		t.Error("require: off should disable approval")
+	}
+
+	p := filepath.Join(t.TempDir(), "bad.yaml")
+	os.WriteFile(p, []byte("tools:\n  - name: x\n    risk: extreme\n"), 0644)
+	if err := LoadPolicy(p); err == nil {
+		t.Error("invalid risk level accepted")
+	}
+}
+
+type weatherTool struct{}
+
+func (weatherTool) Name() string  { return "get_taiwan_weather" }
+func (weatherTool) IsSkill() bool { return false }
+func (weatherTool) Definition() api.Tool {
+	return api.Tool{Type: "function", Function: api.ToolFunction{Name: "get_taiwan_weather"}}
+}
+func (weatherTool) Run(argsJSON string) (string, error) { return "晴", nil }
+
+func TestAssessResolvesAliases(t *testing.T) {
+	usePolicy(t, `
+tools:
+  - name: get_taiwan_weather
+    risk: high
+`)
+	reg := core.NewRegistry()
+	reg.RegisterIn("builtin", weatherTool{}, 0)
+	for _, name := range []string{"get_taiwan_weather", "weather", "builtin:get_taiwan_weather", "builtin:weather"} {
+		if got := Assess(reg, name, `{}`); got != core.RiskHigh {
+			t.Errorf("Assess(%q) = %s, want high", name, got)
+		}
+	}
+	if got := Assess(reg, "other:weather", `{}`); got != core.RiskNone {
+		t.Errorf("other namespace risk = %s, want none", got)
+	}
+}
+
+func TestGateUnattended(t *testing.T) {
+	usePolicy(t, "unattended: [fs_append_file]\n")
+	g := NewGate()
+	if ok, _ := g.Check(context.Background(), nil, Request{Tool: "fs_append_file", Risk: core.RiskMedium}); !ok {
+		t.Error("unattended tool denied without approver")
+	}
+	if ok, _ := g.Check(context.Background(), nil, Request{Tool: "shell_exec", Risk: core.RiskHigh}); ok {
+		t.Error("tool outside unattended ran without approver")
+	}
+}
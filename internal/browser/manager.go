@@ -1,6 +1,7 @@
 package browser
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -36,6 +37,28 @@ func GetManager() *BrowserManager {
 	return instance
 }
 
+// timeout 回傳 Playwright 操作的逾時 (毫秒)，不超過 ctx 剩餘的時間
+// Playwright 的呼叫無法取消，限制逾時讓操作在工具時限內結束，不會在背景繼續執行
+func timeout(ctx context.Context, ms float64) *float64 {
+	if deadline, ok := ctx.Deadline(); ok {
+		left := float64(time.Until(deadline).Milliseconds())
+		if left < ms {
+			ms = max(left, 1)
+		}
+	}
+	return playwright.Float(ms)
+}
+
+// sleep 等待 d 或直到 ctx 取消
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // EnsureContext makes sure a Playwright browser & page are running
 func (m *BrowserManager) EnsureContext() error {
 	m.mu.Lock()
@@ -88,7 +111,10 @@ func (m *BrowserManager) EnsureContext() error {
 }
 
 // Navigate opens a URL
-func (m *BrowserManager) Navigate(url string) error {
+func (m *BrowserManager) Navigate(ctx context.Context, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := m.EnsureContext(); err != nil {
 		return err
 	}
@@ -101,13 +127,16 @@ func (m *BrowserManager) Navigate(url string) error {
 	// Wait until network is mostly idle to ensure dynamic content loads
 	_, err := m.page.Goto(url, playwright.PageGotoOptions{
 		WaitUntil: playwright.WaitUntilStateNetworkidle,
-		Timeout:   playwright.Float(30000), // 30s timeout
+		Timeout:   timeout(ctx, 30000), // 30s timeout
 	})
 	return err
 }
 
 // Snapshot parses the DOM and returns a list of interactive elements with refs
-func (m *BrowserManager) Snapshot(interactiveOnly bool) (string, error) {
+func (m *BrowserManager) Snapshot(ctx context.Context, interactiveOnly bool) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if err := m.EnsureContext(); err != nil {
 		return "", err
 	}
@@ -166,6 +195,10 @@ func (m *BrowserManager) Snapshot(interactiveOnly bool) (string, error) {
 		}
 
 		for i := 0; i < numElements; i++ {
+			// 元素很多時逐一讀取會花不少時間，每個元素前確認對話尚未取消
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
 			nthLoc := loc.Nth(i)
 
 			// Try to ensure it's visible before mapping it
@@ -235,7 +268,7 @@ func (m *BrowserManager) Snapshot(interactiveOnly bool) (string, error) {
 }
 
 // Click Ref
-func (m *BrowserManager) Click(ref string) error {
+func (m *BrowserManager) Click(ctx context.Context, ref string) error {
 	m.mu.Lock()
 	loc, ok := m.refs[ref]
 	m.mu.Unlock()
@@ -249,7 +282,7 @@ func (m *BrowserManager) Click(ref string) error {
 	newPageCh := make(chan playwright.Page, 1)
 	go func() {
 		ev, err := m.context.ExpectEvent("page", func() error { return nil }, playwright.BrowserContextExpectEventOptions{
-			Timeout: playwright.Float(8000),
+			Timeout: timeout(ctx, 8000),
 		})
 		if err == nil {
 			newPageCh <- ev.(playwright.Page)
@@ -258,7 +291,7 @@ func (m *BrowserManager) Click(ref string) error {
 
 	// 執行點擊（加入 Force: true 突破 Cookie Banner 遮擋）
 	if err := loc.Click(playwright.LocatorClickOptions{
-		Timeout: playwright.Float(10000),
+		Timeout: timeout(ctx, 10000),
 		Force:   playwright.Bool(true),
 	}); err != nil {
 		return err
@@ -276,21 +309,24 @@ func (m *BrowserManager) Click(ref string) error {
 		// 等待新頁面載入完成
 		_ = newPage.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 			State:   playwright.LoadStateNetworkidle,
-			Timeout: playwright.Float(15000),
+			Timeout: timeout(ctx, 15000),
 		})
 		return nil
 	case <-time.After(8 * time.Second):
 		// 沒有新視窗，等待原頁面 networkidle
 		_ = m.page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 			State:   playwright.LoadStateNetworkidle,
-			Timeout: playwright.Float(10000),
+			Timeout: timeout(ctx, 10000),
 		})
 		return nil
+	case <-ctx.Done():
+		// 已點擊，只是不再等待頁面載入
+		return ctx.Err()
 	}
 }
 
 // Type into Ref
-func (m *BrowserManager) Type(ref, text string) error {
+func (m *BrowserManager) Type(ctx context.Context, ref, text string) error {
 	m.mu.Lock()
 	loc, ok := m.refs[ref]
 	m.mu.Unlock()
@@ -301,12 +337,15 @@ func (m *BrowserManager) Type(ref, text string) error {
 	// Playwright Fill clears it first, then types.
 	// If you want to simulate character-by-character typing without clearing, use Type()
 	return loc.Fill(text, playwright.LocatorFillOptions{
-		Timeout: playwright.Float(5000),
+		Timeout: timeout(ctx, 5000),
 	})
 }
 
 // Scroll
-func (m *BrowserManager) Scroll(direction string) error {
+func (m *BrowserManager) Scroll(ctx context.Context, direction string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := m.EnsureContext(); err != nil {
 		return err
 	}
@@ -320,16 +359,16 @@ func (m *BrowserManager) Scroll(direction string) error {
 		script = "window.scrollTo(0, document.body.scrollHeight)"
 	}
 
-	_, err := m.page.Evaluate(script)
+	if _, err := m.page.Evaluate(script); err != nil {
+		return err
+	}
 
 	// Wait a moment for dynamic lazy-loaded content
-	time.Sleep(500 * time.Millisecond)
-
-	return err
+	return sleep(ctx, 500*time.Millisecond)
 }
 
 // GetText
-func (m *BrowserManager) GetText(ref string) (string, error) {
+func (m *BrowserManager) GetText(ctx context.Context, ref string) (string, error) {
 	m.mu.Lock()
 	loc, ok := m.refs[ref]
 	m.mu.Unlock()
@@ -338,11 +377,14 @@ func (m *BrowserManager) GetText(ref string) (string, error) {
 	}
 
 	// Return InnerText to strip HTML tags
-	return loc.InnerText()
+	return loc.InnerText(playwright.LocatorInnerTextOptions{Timeout: timeout(ctx, 30000)})
 }
 
 // GetFullText extracts the entire readable text from the body
-func (m *BrowserManager) GetFullText() (string, error) {
+func (m *BrowserManager) GetFullText(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if err := m.EnsureContext(); err != nil {
 		return "", err
 	}
@@ -366,10 +408,12 @@ func (m *BrowserManager) GetFullText() (string, error) {
 	// 等待 networkidle：確保 JS 動態渲染（如匯率表格、SPA 路由）完成
 	_ = m.page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 		State:   playwright.LoadStateNetworkidle,
-		Timeout: playwright.Float(8000), // 最多等 8s
+		Timeout: timeout(ctx, 8000), // 最多等 8s
 	})
 
-	time.Sleep(500 * time.Millisecond)
+	if err := sleep(ctx, 500*time.Millisecond); err != nil {
+		return "", err
+	}
 
 	val, err := m.page.Evaluate("document.body.innerText")
 	if err != nil {
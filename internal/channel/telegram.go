@@ -2,13 +2,15 @@ package channel
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
 	"strings"
-
+	"sync"
 	"time"
 
+	"github.com/asccclass/pcai/internal/approval"
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
 	"github.com/valyala/fasthttp"
@@ -19,16 +21,27 @@ type Envelope struct {
 	SenderID string
 	Content  string
 	Platform string
+	// Images 訊息附帶的圖片 (Base64)，供路由規則選擇視覺模型
+	Images []string
 	// Reply 讓 Dispatcher 不需要知道如何調用 Telegram API 就能回覆
 	Reply func(text string) error
 	// MarkProcessing 顯示「正在輸入中...」或類似狀態
 	MarkProcessing func() error
+	// Approve 詢問使用者是否核准有副作用的工具呼叫；nil 時由 Dispatcher 改用文字回覆詢問
+	Approve approval.Approver
 }
 
 // TelegramChannel 實作了適配器結構
 type TelegramChannel struct {
 	bot         *telego.Bot
 	stopPolling context.CancelFunc
+	approvals   sync.Map // 核准請求 ID -> *pendingApproval
+}
+
+// pendingApproval 是等待使用者按下按鈕的核准請求
+type pendingApproval struct {
+	chatID   int64
+	decision chan approval.Decision
 }
 
 // customLogger 攔截特定錯誤 (如 409 Conflict)
@@ -108,16 +121,34 @@ func (t *TelegramChannel) Listen(handler func(Envelope)) {
 	fmt.Println("✅ [Telegram] 頻道已啟動，監聽中...")
 
 	for update := range updates {
-		// 我們只處理文字訊息
-		if update.Message != nil && update.Message.Text != "" {
+		// 核准按鈕的回覆
+		if update.CallbackQuery != nil {
+			t.handleApprovalCallback(update.CallbackQuery)
+			continue
+		}
+
+		// 處理文字訊息與圖片 (圖片的說明文字當作訊息內容)
+		if update.Message != nil && (update.Message.Text != "" || len(update.Message.Photo) > 0) {
 			msg := update.Message
 			chatID := msg.Chat.ID
 
+			content := msg.Text
+			var images []string
+			if len(msg.Photo) > 0 {
+				content = msg.Caption
+				if img, err := t.downloadPhoto(ctx, msg.Photo); err != nil {
+					log.Printf("⚠️ [Telegram] 下載圖片失敗: %v", err)
+				} else {
+					images = append(images, img)
+				}
+			}
+
 			// 建立封裝對象
 			env := Envelope{
 				SenderID: fmt.Sprintf("%d", chatID),
-				Content:  msg.Text,
+				Content:  content,
 				Platform: "telegram",
+				Images:   images,
 				Reply: func(text string) error {
 					// 封裝發送邏輯
 					_, err := t.bot.SendMessage(context.Background(), tu.Message(
@@ -133,6 +164,7 @@ func (t *TelegramChannel) Listen(handler func(Envelope)) {
 						telego.ChatActionTyping,
 					))
 				},
+				Approve: t.approver(chatID),
 			}
 
 			// 將封裝好的訊息丟給 Dispatcher 層處理
@@ -142,6 +174,81 @@ func (t *TelegramChannel) Listen(handler func(Envelope)) {
 	fmt.Println("🛑 [Telegram] 長輪詢已結束")
 }
 
+// downloadPhoto 下載圖片中解析度最高的版本，回傳 Base64
+func (t *TelegramChannel) downloadPhoto(ctx context.Context, sizes []telego.PhotoSize) (string, error) {
+	largest := sizes[len(sizes)-1] // Telegram 依尺寸由小到大排列
+	file, err := t.bot.GetFile(ctx, &telego.GetFileParams{FileID: largest.FileID})
+	if err != nil {
+		return "", err
+	}
+	data, err := tu.DownloadFile(t.bot.FileDownloadURL(file.FilePath))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// approver 以內嵌按鈕詢問使用者是否核准工具呼叫
+func (t *TelegramChannel) approver(chatID int64) approval.Approver {
+	return func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+		pending := &pendingApproval{chatID: chatID, decision: make(chan approval.Decision, 1)}
+		t.approvals.Store(req.ID, pending)
+		defer t.approvals.Delete(req.ID)
+
+		button := func(text string, d approval.Decision) telego.InlineKeyboardButton {
+			return tu.InlineKeyboardButton(text).WithCallbackData("approve:" + req.ID + ":" + d.String())
+		}
+		msg, err := t.bot.SendMessage(ctx, tu.Message(tu.ID(chatID), req.Prompt()).WithReplyMarkup(tu.InlineKeyboard(
+			tu.InlineKeyboardRow(button("✅ 允許", approval.Allow), button("❌ 拒絕", approval.Deny)),
+			tu.InlineKeyboardRow(button("🔓 本次對話一律允許", approval.AllowAlways)),
+		)))
+		if err != nil {
+			return approval.Deny, err
+		}
+
+		// 回覆後移除按鈕，避免重複點擊
+		finish := func(result string) {
+			_, _ = t.bot.EditMessageText(context.Background(), tu.EditMessageText(tu.ID(chatID), msg.MessageID, req.Prompt()+"\n\n"+result))
+		}
+		select {
+		case d := <-pending.decision:
+			switch d {
+			case approval.Allow:
+				finish("✅ 已允許")
+			case approval.AllowAlways:
+				finish("🔓 已允許 (本次對話不再詢問)")
+			default:
+				finish("❌ 已拒絕")
+			}
+			return d, nil
+		case <-ctx.Done():
+			finish("⌛ 已逾時，工具未執行")
+			return approval.Deny, ctx.Err()
+		}
+	}
+}
+
+// handleApprovalCallback 處理核准按鈕，只接受來自原聊天室的回覆
+func (t *TelegramChannel) handleApprovalCallback(q *telego.CallbackQuery) {
+	parts := strings.SplitN(q.Data, ":", 3)
+	if len(parts) != 3 || parts[0] != "approve" {
+		return
+	}
+
+	text := "⚠️ 此核准請求已失效"
+	if v, ok := t.approvals.Load(parts[1]); ok && q.Message != nil {
+		pending := v.(*pendingApproval)
+		if q.Message.GetChat().ID == pending.chatID {
+			select {
+			case pending.decision <- approval.ParseDecision(parts[2]):
+				text = "已收到"
+			default:
+			}
+		}
+	}
+	_ = t.bot.AnswerCallbackQuery(context.Background(), tu.CallbackQuery(q.ID).WithText(text))
+}
+
 // Stop 停止長輪詢
 func (t *TelegramChannel) Stop() {
 	if t.stopPolling != nil {
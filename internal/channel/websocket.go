@@ -13,12 +13,13 @@ import (
 
 // WSMessage 定義與中繼伺服器通訊的 JSON 格式
 type WSMessage struct {
-	Channel     string `json:"channel"`
-	UserID      string `json:"user_id"`      // 傳源系統的固定識別碼 (envfile WEBSOCKET_USER_ID)
-	DisplayName string `json:"display_name"` // [NEW] 可變的顯示名稱 (發送方或 AI 名稱)
-	ReplyTo     string `json:"reply_to"`
-	Message     string `json:"message"`
-	Type        string `json:"type"`
+	Channel     string   `json:"channel"`
+	UserID      string   `json:"user_id"`      // 傳源系統的固定識別碼 (envfile WEBSOCKET_USER_ID)
+	DisplayName string   `json:"display_name"` // [NEW] 可變的顯示名稱 (發送方或 AI 名稱)
+	ReplyTo     string   `json:"reply_to"`
+	Message     string   `json:"message"`
+	Images      []string `json:"images,omitempty"` // [NEW] 附帶的圖片 (Base64)
+	Type        string   `json:"type"`
 }
 
 // WebSocketChannel 實作 WebSocket 客戶端適配器
@@ -118,7 +119,7 @@ func (w *WebSocketChannel) Listen(handler func(Envelope)) {
 				continue
 			}
 
-			if wsMsg.Message == "" || wsMsg.UserID == "" {
+			if (wsMsg.Message == "" && len(wsMsg.Images) == 0) || wsMsg.UserID == "" {
 				continue // 忽略空訊息
 			}
 
@@ -127,6 +128,7 @@ func (w *WebSocketChannel) Listen(handler func(Envelope)) {
 				SenderID: wsMsg.UserID,
 				Content:  wsMsg.Message,
 				Platform: "websocket",
+				Images:   wsMsg.Images,
 				Reply: func(text string) error {
 					replyMsg := WSMessage{
 						Channel:     "pcai",
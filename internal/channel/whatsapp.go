@@ -2,6 +2,7 @@ package channel
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"strings"
@@ -147,14 +148,23 @@ func (wc *WhatsAppChannel) handleEvent(evt interface{}, handler func(Envelope))
 			return
 		}
 
-		// 取得訊息內容 (目前只支援文字)
+		// 取得訊息內容 (文字，或圖片與其說明文字)
 		var text string
+		var images []string
 		if v.Message.Conversation != nil {
 			text = *v.Message.Conversation
 		} else if v.Message.ExtendedTextMessage != nil && v.Message.ExtendedTextMessage.Text != nil {
 			text = *v.Message.ExtendedTextMessage.Text
+		} else if img := v.Message.GetImageMessage(); img != nil {
+			text = img.GetCaption()
+			data, err := wc.client.Download(context.Background(), img)
+			if err != nil {
+				fmt.Printf("⚠️ [WhatsApp] 下載圖片失敗: %v\n", err)
+				return
+			}
+			images = append(images, base64.StdEncoding.EncodeToString(data))
 		} else {
-			// 暫不處理非文字訊息
+			// 暫不處理其他類型的訊息
 			return
 		}
 
@@ -165,6 +175,7 @@ func (wc *WhatsAppChannel) handleEvent(evt interface{}, handler func(Envelope))
 			Platform: "whatsapp",
 			SenderID: chatID, // WhatsApp use JID as ID
 			Content:  text,
+			Images:   images,
 			Reply: func(replyText string) error {
 				return wc.SendMessage(chatID, replyText)
 			},
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 工具呼叫稽核 (Tool Call Audit)
+// ─────────────────────────────────────────────────────────────
+//
+// 每次 CallTool / CallToolContext 結束後產生一筆 ToolCallRecord 交給稽核紀錄器
+// (tools/init.go 寫入 SQLite 的 tool_audit 表，供 `pcai audit` 與 /api/audit 查詢)。
+// 參數在記錄前先遮蔽密碼、Token 等欄位與值中的密鑰 (見 redact.go) 並截斷過長的內容；結果只記錄大小與雜湊。
+
+// ToolCallRecord 是一次工具呼叫的稽核紀錄
+type ToolCallRecord struct {
+	Timestamp  time.Time
+	SessionID  string
+	Channel    string
+	Sender     string
+	Tool       string
+	Args       string // 已遮蔽的參數 (RedactArgs)
+	ResultSize int    // 結果位元組數
+	ResultHash string // 結果的 SHA-256 (前 16 碼)
+	Duration   time.Duration
+	Error      string
+	Provider   string // 要求此呼叫的 LLM Provider
+	Model      string // 要求此呼叫的模型
+}
+
+var (
+	auditMu       sync.RWMutex
+	auditRecorder func(ToolCallRecord)
+)
+
+// SetAuditRecorder 設定全域稽核紀錄器 (例如寫入 SQLite)，傳入 nil 可停用
+func SetAuditRecorder(fn func(ToolCallRecord)) {
+	auditMu.Lock()
+	auditRecorder = fn
+	auditMu.Unlock()
+}
+
+// recordCall 將一次呼叫交給稽核紀錄器 (未設定時不做任何事)
+func recordCall(env *RunEnv, name, argsJSON string, start time.Time, result string, err error) {
+	auditMu.RLock()
+	recorder := auditRecorder
+	auditMu.RUnlock()
+	if recorder == nil {
+		return
+	}
+
+	rec := ToolCallRecord{
+		Timestamp:  start,
+		Tool:       name,
+		Args:       RedactArgs(argsJSON),
+		ResultSize: len(result),
+		Duration:   time.Since(start),
+	}
+	if result != "" {
+		sum := sha256.Sum256([]byte(result))
+		rec.ResultHash = hex.EncodeToString(sum[:8])
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if env != nil {
+		rec.SessionID, rec.Channel, rec.Sender = env.SessionID, env.Channel, env.Sender
+		rec.Provider, rec.Model = env.Provider, env.Model
+	}
+	recorder(rec)
+}
+
+const redactMaxValue = 200 // 單一字串參數保留的字元數
+
+// secretKeyRe 比對需要遮蔽的參數名稱 (整個名稱或以 _ / - 分隔的最後一段，例如 access_token；max_tokens 不遮蔽)
+var secretKeyRe = regexp.MustCompile(`^([a-z0-9]+[_-])*(password|passwd|pass|secret|token|api[_-]?key|auth|authorization|cookie|credentials?|private[_-]?key)$`)
+
+// camelRe 找出 camelCase 的分界，讓 accessToken 與 access_token 一樣比對
+var camelRe = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// isSecretKey 判斷參數名稱是否存放密鑰
+func isSecretKey(key string) bool {
+	return secretKeyRe.MatchString(strings.ToLower(camelRe.ReplaceAllString(key, "${1}_${2}")))
+}
+
+// RedactArgs 遮蔽敏感參數與字串值中的密鑰 (例如指令中的 Token) 並截斷過長的字串，回傳可安全保存的 JSON
+func RedactArgs(argsJSON string) string {
+	var raw any
+	if err := json.Unmarshal([]byte(argsJSON), &raw); err != nil {
+		return truncateRunes(RedactSecrets(argsJSON), redactMaxValue)
+	}
+	out, err := json.Marshal(redactValue("", raw))
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func redactValue(key string, v any) any {
+	if key != "" && isSecretKey(key) {
+		return "***"
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			val[k] = redactValue(k, item)
+		}
+		return val
+	case []any:
+		for i, item := range val {
+			val[i] = redactValue("", item)
+		}
+		return val
+	case string:
+		return truncateRunes(RedactSecrets(val), redactMaxValue)
+	}
+	return v
+}
+
+// truncateRunes 截斷字串並標註原始長度
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return fmt.Sprintf("%s…(%d 字)", string(r[:n]), len(r))
+}
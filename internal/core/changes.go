@@ -0,0 +1,215 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 註冊表變動、移除與快照
+// ─────────────────────────────────────────────────────────────
+
+// RegistryChange 是工具變動的種類
+type RegistryChange string
+
+const (
+	ToolAdded    RegistryChange = "added"
+	ToolReplaced RegistryChange = "replaced"
+	ToolRemoved  RegistryChange = "removed"
+)
+
+// RegistryEvent 描述一次工具變動，供工具挑選、UI 等訂閱者更新狀態
+type RegistryEvent struct {
+	Change    RegistryChange
+	Name      string
+	Namespace string
+	Version   uint64 // 變動後的註冊表版本
+}
+
+// Subscribe 註冊變動通知，回傳取消訂閱的函式
+// 通知在變動完成後同步呼叫，訂閱者不應在回呼中長時間阻塞
+func (r *Registry) Subscribe(fn func(RegistryEvent)) (unsubscribe func()) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	if r.subs == nil {
+		r.subs = make(map[int]func(RegistryEvent))
+	}
+	id := r.nextSub
+	r.nextSub++
+	r.subs[id] = fn
+	return func() {
+		r.subMu.Lock()
+		delete(r.subs, id)
+		r.subMu.Unlock()
+	}
+}
+
+// publish 通知所有訂閱者；訂閱者 panic 不影響註冊表
+func (r *Registry) publish(events ...RegistryEvent) {
+	r.subMu.RLock()
+	subs := make([]func(RegistryEvent), 0, len(r.subs))
+	for _, fn := range r.subs {
+		subs = append(subs, fn)
+	}
+	r.subMu.RUnlock()
+
+	for _, ev := range events {
+		for _, fn := range subs {
+			func() {
+				defer func() {
+					if rec := recover(); rec != nil {
+						fmt.Printf("⚠️ [Registry] 訂閱者處理 %s %s 時發生錯誤: %v\n", ev.Change, ev.Name, rec)
+					}
+				}()
+				fn(ev)
+			}()
+		}
+	}
+}
+
+// put 放入工具 (呼叫端需持有寫入鎖)，回傳是否取代了既有的同名工具
+// 原本屬於其他命名空間的同名工具會被保留在 shadowed，例如技能暫時遮蔽同名的內建工具
+func (r *Registry) put(e *toolEntry) bool {
+	name := e.tool.Name()
+	old, exists := r.tools[name]
+	if exists && old.namespace != e.namespace {
+		if r.shadowed == nil {
+			r.shadowed = make(map[string][]*toolEntry)
+		}
+		r.shadowed[name] = append(dropNamespace(r.shadowed[name], e.namespace), old)
+	}
+	r.tools[name] = e
+	return exists
+}
+
+// remove 移除工具 (呼叫端需持有寫入鎖)，有被遮蔽的同名工具時恢復最近被遮蔽的那一個並回傳
+func (r *Registry) remove(name string) *toolEntry {
+	delete(r.tools, name)
+	stack := r.shadowed[name]
+	if len(stack) == 0 {
+		return nil
+	}
+	restored := stack[len(stack)-1]
+	if len(stack) == 1 {
+		delete(r.shadowed, name)
+	} else {
+		r.shadowed[name] = stack[:len(stack)-1]
+	}
+	r.tools[name] = restored
+	return restored
+}
+
+// dropNamespace 回傳去除指定命名空間後的工具清單
+func dropNamespace(entries []*toolEntry, namespace string) []*toolEntry {
+	out := entries[:0:0]
+	for _, e := range entries {
+		if e.namespace != namespace {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Unregister 移除工具，可用 "namespace:name" 限定只移除該命名空間的工具；回傳是否有移除
+// 被移除的工具若遮蔽了其他命名空間的同名工具，該工具會恢復
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	if r.frozen {
+		r.mu.Unlock()
+		return false
+	}
+	entry, ok := r.find(name) // 只比對實際名稱，別名不會移除工具
+	if !ok {
+		r.mu.Unlock()
+		return false
+	}
+	r.version++
+	ev := RegistryEvent{Change: ToolRemoved, Name: entry.tool.Name(), Namespace: entry.namespace, Version: r.version}
+	if restored := r.remove(entry.tool.Name()); restored != nil {
+		ev.Change, ev.Namespace = ToolReplaced, restored.namespace
+	}
+	r.mu.Unlock()
+
+	r.publish(ev)
+	return true
+}
+
+// ReplaceNamespace 以一次變動替換整個命名空間的工具 (例如重新載入全部技能)
+// 不在 tools 中的舊工具會被移除 (被遮蔽的其他命名空間同名工具隨之恢復)；其他 goroutine 不會看到只載入一半的狀態
+func (r *Registry) ReplaceNamespace(namespace string, priority int, tools ...AgentTool) {
+	r.mu.Lock()
+	if r.frozen {
+		r.mu.Unlock()
+		return
+	}
+	keep := make(map[string]bool, len(tools))
+	var events []RegistryEvent
+	for _, t := range tools {
+		keep[t.Name()] = true
+		change := ToolAdded
+		if r.put(&toolEntry{tool: t, priority: priority, namespace: namespace}) {
+			change = ToolReplaced
+		}
+		events = append(events, RegistryEvent{Change: change, Name: t.Name(), Namespace: namespace})
+	}
+	for name, e := range r.tools {
+		if e.namespace == namespace && !keep[name] {
+			ev := RegistryEvent{Change: ToolRemoved, Name: name, Namespace: namespace}
+			if restored := r.remove(name); restored != nil {
+				ev.Change, ev.Namespace = ToolReplaced, restored.namespace
+			}
+			events = append(events, ev)
+		}
+	}
+	// 被其他命名空間遮蔽、已不在新清單中的工具也一併移除
+	for name, stack := range r.shadowed {
+		if keep[name] {
+			continue
+		}
+		if rest := dropNamespace(stack, namespace); len(rest) == 0 {
+			delete(r.shadowed, name)
+		} else {
+			r.shadowed[name] = rest
+		}
+	}
+	r.version++
+	for i := range events {
+		events[i].Version = r.version
+	}
+	r.mu.Unlock()
+
+	r.publish(events...)
+}
+
+// Names 回傳工具名稱 (排序)；namespace 不為空時只列出該命名空間的工具
+func (r *Registry) Names(namespace string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for name, e := range r.tools {
+		if namespace == "" || e.namespace == namespace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Version 回傳註冊表目前的版本，每次變動加一
+func (r *Registry) Version() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.version
+}
+
+// Snapshot 回傳目前工具的唯讀副本，供單輪對話使用：
+// 對話進行中即使重新載入技能，也不會看到工具清單中途改變
+func (r *Registry) Snapshot() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snap := &Registry{tools: make(map[string]*toolEntry, len(r.tools)), version: r.version, frozen: true, middleware: r.middleware}
+	for name, e := range r.tools {
+		snap.tools[name] = e
+	}
+	return snap
+}
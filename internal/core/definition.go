@@ -1,10 +1,12 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ollama/ollama/api"
 )
@@ -24,15 +26,33 @@ type AgentTool interface {
 	IsSkill() bool
 }
 
+// ParallelSafe 是選用介面：沒有副作用、可與其他工具同時執行的工具 (例如查詢天氣、讀取郵件)
+// 實作此介面並回傳 true 後，Agent 會在同一輪中與其他可平行的工具一起執行
+type ParallelSafe interface {
+	ParallelSafe() bool
+}
+
 // toolEntry 包裝工具和其優先級
 type toolEntry struct {
-	tool     AgentTool
-	priority int // 數字越大越優先
+	tool      AgentTool
+	priority  int    // 數字越大越優先
+	namespace string // 來源 (例如 skill)，空字串為內建工具
 }
 
-// Registry 管理所有可用的工具
+// Registry 管理所有可用的工具，可同時被多個 goroutine 讀寫
+// (技能重新載入時，Telegram、WebSocket 與 Heartbeat 可能正在讀取定義或執行工具)
 type Registry struct {
-	tools map[string]*toolEntry
+	mu       sync.RWMutex
+	tools    map[string]*toolEntry
+	shadowed map[string][]*toolEntry // 被其他命名空間同名工具遮蔽的工具，遮蔽者移除後恢復 (見 changes.go)
+	version  uint64                  // 每次變動加一，供快取判斷是否過期
+	frozen   bool                    // Snapshot 建立的唯讀註冊表
+
+	middleware []Middleware // 工具執行的中介層 (見 middleware.go)
+
+	subMu   sync.RWMutex
+	subs    map[int]func(RegistryEvent)
+	nextSub int
 }
 
 // NewRegistry 建立新的註冊表
@@ -42,20 +62,79 @@ func NewRegistry() *Registry {
 
 // Register 以預設優先級 (0) 註冊一個工具
 func (r *Registry) Register(t AgentTool) {
-	r.tools[t.Name()] = &toolEntry{tool: t, priority: 0}
+	r.RegisterIn("", t, 0)
 }
 
 // RegisterWithPriority 以指定優先級註冊一個工具（數字越大越優先）
 func (r *Registry) RegisterWithPriority(t AgentTool, priority int) {
-	r.tools[t.Name()] = &toolEntry{tool: t, priority: priority}
+	r.RegisterIn("", t, priority)
+}
+
+// RegisterIn 在指定命名空間註冊工具，之後可用 "namespace:name" 查詢或移除
+// 同一命名空間的同名工具會被取代；其他命名空間的同名工具只是被遮蔽，這個工具移除後即恢復
+func (r *Registry) RegisterIn(namespace string, t AgentTool, priority int) {
+	r.mu.Lock()
+	if r.frozen {
+		r.mu.Unlock()
+		fmt.Printf("⚠️ [Registry] 快照為唯讀，忽略註冊 %s\n", t.Name())
+		return
+	}
+	change := ToolAdded
+	if r.put(&toolEntry{tool: t, priority: priority, namespace: namespace}) {
+		change = ToolReplaced
+	}
+	r.version++
+	ev := RegistryEvent{Change: change, Name: t.Name(), Namespace: namespace, Version: r.version}
+	r.mu.Unlock()
+
+	r.publish(ev)
+}
+
+// lookup 依名稱、別名或 "namespace:name" 找到工具 (呼叫端需持有讀取鎖)
+func (r *Registry) lookup(name string) (*toolEntry, bool) {
+	return r.find(resolveToolName(name))
+}
+
+// find 依實際名稱或 "namespace:name" 找到工具 (呼叫端需持有讀取鎖)
+func (r *Registry) find(name string) (*toolEntry, bool) {
+	if ns, base, ok := strings.Cut(name, ":"); ok {
+		entry, found := r.tools[base]
+		if !found || entry.namespace != ns {
+			return nil, false
+		}
+		return entry, true
+	}
+	entry, ok := r.tools[name]
+	return entry, ok
+}
+
+// Subset 建立只包含指定工具的新註冊表 (保留優先級，支援別名)，回傳找不到的工具名稱
+// 供子 Agent 使用受限的工具集，例如研究任務只開放 web_search 與 web_fetch
+func (r *Registry) Subset(names ...string) (*Registry, []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sub := NewRegistry()
+	sub.middleware = r.middleware
+	var missing []string
+	for _, name := range names {
+		entry, ok := r.lookup(name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		sub.tools[entry.tool.Name()] = entry
+	}
+	return sub, missing
 }
 
 // sortedEntries 依優先級降序排列所有工具
 func (r *Registry) sortedEntries() []*toolEntry {
+	r.mu.RLock()
 	entries := make([]*toolEntry, 0, len(r.tools))
 	for _, e := range r.tools {
 		entries = append(entries, e)
 	}
+	r.mu.RUnlock()
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].priority > entries[j].priority
 	})
@@ -96,35 +175,32 @@ func (r *Registry) GetBaseToolDefinitions() []api.Tool {
 	return defs
 }
 
-// CallTool 根據 AI 的要求執行對應工具
-func (r *Registry) CallTool(name string, argsJSON string) (string, error) {
-	// [FIX] 工具名稱別名映射 (處理 LLM 幻覺)
-	aliasMap := map[string]string{
-		"manage_task":      "manage_cron_job",
-		"manage_scheduler": "manage_cron_job",
-		"schedule_task":    "manage_cron_job",
-		"task_planner":     "manage_cron_job",
-		"run_task":         "manage_cron_job",
-		"cron":             "manage_cron_job",
-		"manage_cron_task": "manage_cron_job",
-		"get_weather":      "get_taiwan_weather",
-		"check_weather":    "get_taiwan_weather",
-		"weather":          "get_taiwan_weather",
-	}
-	if alias, ok := aliasMap[name]; ok {
-		name = alias
+// ToolName 回傳名稱 (可為別名或 "namespace:name") 對應的實際工具名稱，找不到時 ok 為 false
+func (r *Registry) ToolName(name string) (string, bool) {
+	r.mu.RLock()
+	entry, ok := r.lookup(name)
+	r.mu.RUnlock()
+	if !ok {
+		return "", false
 	}
+	return entry.tool.Name(), true
+}
 
-	// [FIX] 全域 JSON 參數清理：處理 LLM 幻覺產生的巢狀物件
-	// 例如將 {"action":{"type":"string","value":"run_once"}}
-	// 轉為   {"action":"run_once"}
-	argsJSON = sanitizeToolArgs(argsJSON)
-
-	entry, ok := r.tools[name]
+// IsParallelSafe 判斷工具是否宣告為可平行執行 (未實作 ParallelSafe 的工具一律視為有副作用)
+func (r *Registry) IsParallelSafe(name string) bool {
+	r.mu.RLock()
+	entry, ok := r.lookup(name)
+	r.mu.RUnlock()
 	if !ok {
-		return "", fmt.Errorf("找不到工具: %s", name)
+		return false
 	}
-	return entry.tool.Run(argsJSON)
+	ps, ok := entry.tool.(ParallelSafe)
+	return ok && ps.ParallelSafe()
+}
+
+// CallTool 根據 AI 的要求執行對應工具 (沒有呼叫端資訊與時限，見 CallToolContext)
+func (r *Registry) CallTool(name string, argsJSON string) (string, error) {
+	return r.CallToolContext(context.Background(), nil, name, argsJSON)
 }
 
 // sanitizeToolArgs 清理 LLM 產生的巢狀 JSON 參數
@@ -0,0 +1,31 @@
+package core
+
+// IntentTrigger 是選用介面：工具宣告觸發詞 (例如 SKILL.md 的 triggers)，
+// 使用者輸入命中時 Agent 會提示模型使用此工具，安裝技能後不需修改意圖規則檔
+type IntentTrigger interface {
+	Triggers() []string
+}
+
+// ToolTrigger 是一個宣告了觸發詞的工具
+type ToolTrigger struct {
+	Name        string
+	Description string
+	Triggers    []string
+}
+
+// GetTriggers 回傳所有宣告觸發詞的工具 (依優先級排列)
+func (r *Registry) GetTriggers() []ToolTrigger {
+	var out []ToolTrigger
+	for _, e := range r.sortedEntries() {
+		it, ok := e.tool.(IntentTrigger)
+		if !ok || len(it.Triggers()) == 0 {
+			continue
+		}
+		out = append(out, ToolTrigger{
+			Name:        e.tool.Name(),
+			Description: e.tool.Definition().Function.Description,
+			Triggers:    it.Triggers(),
+		})
+	}
+	return out
+}
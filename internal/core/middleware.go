@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 工具中介層 (Tool Middleware)
+// ─────────────────────────────────────────────────────────────
+//
+// 每次 CallTool / CallToolContext 依序經過：
+//   工具名稱別名 (alias) -> 找出工具 (lookup) -> 參數清理 (sanitize) -> 參數驗證 (validate)
+//   -> Registry.Use 註冊的中介層 -> 工具本身
+// 重試、快取、速率限制、輸出遮蔽與計時等跨工具的行為都以中介層實作 (見 internal/toolmw)，
+// 中介層從 ToolCall 取得工具名稱與工具本身，自行決定是否套用 (例如依工具設定檔)。
+
+// ToolCall 是一次工具呼叫，經過中介層時可被修改 (例如參數)
+type ToolCall struct {
+	Name string    // 解析別名後的工具名稱
+	Tool AgentTool // 被呼叫的工具
+	Args string    // 參數 JSON
+	Env  *RunEnv   // 呼叫端資訊 (不會是 nil)
+}
+
+// ToolHandler 執行一次工具呼叫
+type ToolHandler func(ctx context.Context, call *ToolCall) (string, error)
+
+// Middleware 包裝 ToolHandler，回傳加上額外行為的 ToolHandler
+type Middleware func(next ToolHandler) ToolHandler
+
+// Use 在中介層鏈的最後 (最接近工具) 加入中介層；先加入的在外層
+func (r *Registry) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(append([]Middleware{}, r.middleware...), mw...)
+}
+
+// routingStages 在找出工具之前執行，只改寫 call.Name (此時 call.Tool 尚未設定)
+var routingStages = []Middleware{aliasStage}
+
+// handler 組合內建步驟與已註冊的中介層
+func (r *Registry) handler() ToolHandler {
+	r.mu.RLock()
+	chain := append(append([]Middleware{}, routingStages...), r.lookupStage, sanitizeStage, validateStage)
+	chain = append(chain, r.middleware...)
+	r.mu.RUnlock()
+
+	h := invokeTool
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h
+}
+
+// invokeTool 是鏈的最內層：以 ContextTool 介面執行工具
+func invokeTool(ctx context.Context, call *ToolCall) (string, error) {
+	return Adapt(call.Tool).RunContext(ctx, call.Env, call.Args)
+}
+
+// resolveToolName 以路由步驟 (例如別名) 求出實際的工具名稱，供不經過呼叫鏈的查詢使用 (風險、平行、子集)
+func resolveToolName(name string) string {
+	h := func(ctx context.Context, call *ToolCall) (string, error) { return call.Name, nil }
+	for i := len(routingStages) - 1; i >= 0; i-- {
+		h = routingStages[i](h)
+	}
+	resolved, _ := h(context.Background(), &ToolCall{Name: name, Env: &RunEnv{}})
+	return resolved
+}
+
+// toolAliases 工具名稱別名映射 (處理 LLM 幻覺)
+var toolAliases = map[string]string{
+	"manage_task":      "manage_cron_job",
+	"manage_scheduler": "manage_cron_job",
+	"schedule_task":    "manage_cron_job",
+	"task_planner":     "manage_cron_job",
+	"run_task":         "manage_cron_job",
+	"cron":             "manage_cron_job",
+	"manage_cron_task": "manage_cron_job",
+	"get_weather":      "get_taiwan_weather",
+	"check_weather":    "get_taiwan_weather",
+	"weather":          "get_taiwan_weather",
+}
+
+// aliasStage 將模型幻覺出的工具名稱換成實際名稱 (支援 "namespace:name")
+func aliasStage(next ToolHandler) ToolHandler {
+	return func(ctx context.Context, call *ToolCall) (string, error) {
+		ns, base, namespaced := strings.Cut(call.Name, ":")
+		if !namespaced {
+			base = ns
+		}
+		if alias, ok := toolAliases[base]; ok {
+			call.Name = alias
+			if namespaced {
+				call.Name = ns + ":" + alias
+			}
+		}
+		return next(ctx, call)
+	}
+}
+
+// lookupStage 依名稱或 "namespace:name" 找出工具；執行期間不持有鎖，工具可被同時重新載入
+func (r *Registry) lookupStage(next ToolHandler) ToolHandler {
+	return func(ctx context.Context, call *ToolCall) (string, error) {
+		r.mu.RLock()
+		entry, ok := r.find(call.Name)
+		r.mu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("找不到工具: %s", call.Name)
+		}
+		call.Name, call.Tool = entry.tool.Name(), entry.tool
+		return next(ctx, call)
+	}
+}
+
+// sanitizeStage 清理 LLM 產生的巢狀參數 (見 sanitizeToolArgs)
+func sanitizeStage(next ToolHandler) ToolHandler {
+	return func(ctx context.Context, call *ToolCall) (string, error) {
+		call.Args = sanitizeToolArgs(call.Args)
+		return next(ctx, call)
+	}
+}
+
+// validateStage 依參數 Schema 修正型別、套用預設值；無法修正時把問題清單交回模型
+func validateStage(next ToolHandler) ToolHandler {
+	return func(ctx context.Context, call *ToolCall) (string, error) {
+		args, err := validateToolArgs(call.Tool, call.Args)
+		if err != nil {
+			return "", err
+		}
+		call.Args = args
+		return next(ctx, call)
+	}
+}
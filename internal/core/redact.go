@@ -0,0 +1,55 @@
+package core
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 密鑰遮蔽 (Secret Redaction)
+// ─────────────────────────────────────────────────────────────
+
+// secretPatterns 比對常見的密鑰格式
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`\b(sk|pk|rk)-[A-Za-z0-9_\-]{16,}`),                                   // OpenAI / Anthropic / Stripe 類
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{30,}`),                                       // GitHub Token
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9\-]{10,}`),                                    // Slack Token
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),                                               // AWS Access Key
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`),                                         // Google API Key
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,}`), // JWT
+	regexp.MustCompile(`(?i)\b(bearer)\s+[A-Za-z0-9_\-.=]{16,}`),
+	regexp.MustCompile(`(?i)\b(password|passwd|secret|api[_-]?key|access[_-]?token)(\s*[:=]\s*)["']?[^\s"',;]{4,}`),
+}
+
+// secretEnvRe 比對存放密鑰的環境變數名稱
+var secretEnvRe = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|API_?KEY|PRIVATE)`)
+
+// RedactSecrets 遮蔽文字中的密鑰：常見密鑰格式與密鑰類環境變數的值
+// (toolmw 的輸出遮蔽與稽核紀錄的參數遮蔽共用)
+func RedactSecrets(text string) string {
+	if text == "" {
+		return text
+	}
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && len(value) >= 8 && secretEnvRe.MatchString(name) && strings.Contains(text, value) {
+			text = strings.ReplaceAll(text, value, "[REDACTED:"+name+"]")
+		}
+	}
+	for _, re := range secretPatterns {
+		text = re.ReplaceAllStringFunc(text, func(m string) string {
+			// 保留 "password=" 這類前綴，讓模型知道欄位存在
+			sub := re.FindStringSubmatch(m)
+			switch {
+			case len(sub) == 3:
+				return sub[1] + sub[2] + "[REDACTED]"
+			case len(sub) == 2 && strings.EqualFold(sub[1], "bearer"):
+				return sub[1] + " [REDACTED]"
+			}
+			return "[REDACTED]"
+		})
+	}
+	return text
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RiskLevel 是工具呼叫的風險等級，等級達到核准門檻時需經使用者同意才會執行
+type RiskLevel int
+
+const (
+	RiskNone   RiskLevel = iota // 唯讀或沒有副作用 (未宣告的工具)
+	RiskLow                     // 有副作用但影響輕微 (例如新增草稿)
+	RiskMedium                  // 會修改資料 (例如覆寫檔案)
+	RiskHigh                    // 難以復原或對外發送 (例如刪除檔案、執行指令、寄信)
+)
+
+var riskNames = []string{"none", "low", "medium", "high"}
+
+func (l RiskLevel) String() string {
+	if l >= 0 && int(l) < len(riskNames) {
+		return riskNames[l]
+	}
+	return fmt.Sprintf("RiskLevel(%d)", int(l))
+}
+
+// ParseRiskLevel 將 none/low/medium/high 轉為 RiskLevel，空字串視為 none
+func ParseRiskLevel(s string) (RiskLevel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return RiskNone, nil
+	}
+	for i, name := range riskNames {
+		if s == name {
+			return RiskLevel(i), nil
+		}
+	}
+	return RiskNone, fmt.Errorf("未知的風險等級: %s", s)
+}
+
+// MarshalText 讓 RiskLevel 在 JSON / YAML 中以名稱表示
+func (l RiskLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText 解析 none/low/medium/high
+func (l *RiskLevel) UnmarshalText(text []byte) error {
+	level, err := ParseRiskLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// RiskAssessor 是選用介面：宣告工具呼叫的風險等級，可依參數判斷 (例如只有 push 才是高風險)
+// 未實作此介面的工具視為 RiskNone
+type RiskAssessor interface {
+	Risk(argsJSON string) RiskLevel
+}
+
+// ArgsMatch 判斷參數是否符合條件：每個 key 的值 (不分大小寫) 必須是列出的值之一
+// 條件為空時一律符合，供 SKILL.md 的 risk_when 與核准設定檔的 when 使用
+func ArgsMatch(argsJSON string, when map[string][]string) bool {
+	if len(when) == 0 {
+		return true
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return false
+	}
+	for key, values := range when {
+		got := strings.TrimSpace(fmt.Sprint(args[key]))
+		matched := false
+		for _, v := range values {
+			if strings.EqualFold(got, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// ToolRisk 回傳工具宣告的風險等級 (找不到工具或未宣告時為 RiskNone)
+func (r *Registry) ToolRisk(name, argsJSON string) RiskLevel {
+	r.mu.RLock()
+	entry, ok := r.lookup(name)
+	r.mu.RUnlock()
+	if !ok {
+		return RiskNone
+	}
+	ra, ok := entry.tool.(RiskAssessor)
+	if !ok {
+		return RiskNone
+	}
+	return ra.Risk(sanitizeToolArgs(argsJSON))
+}
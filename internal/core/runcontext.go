@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/asccclass/pcai/llms"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 工具執行環境 (RunContext)
+// ─────────────────────────────────────────────────────────────
+//
+// AgentTool.Run 只收到參數，無法被取消，也不知道是誰在呼叫。
+// 實作 ContextTool 的工具會收到對話的 context (含取消與逾時) 與 RunEnv；
+// 舊工具透過 Adapt 包裝後照常運作。
+
+// RunEnv 描述一次工具呼叫的呼叫端與執行環境
+type RunEnv struct {
+	SessionID  string // 對話 Session ID
+	Channel    string // 來源頻道 (cli, api, telegram, whatsapp, websocket, heartbeat...)
+	Sender     string // 發送者 ID
+	ScratchDir string // 此 Session 專用的暫存目錄 (用 Scratch 建立)
+	Provider   string // 要求此呼叫的 LLM Provider (稽核用)
+	Model      string // 要求此呼叫的模型 (稽核用)
+
+	// 呼叫端這一輪使用的模型後端 (Gateway 路由或 /model 選出的)，委派的子 Agent 沿用
+	ChatModel  string
+	ChatStream llms.ChatStreamFunc
+
+	// Approve 讓工具在執行中請使用者核准額外的動作 (例如刪除檔案)，nil 代表無法詢問使用者
+	Approve func(ctx context.Context, action string) (ok bool, reason string)
+}
+
+// ContextTool 是選用介面：可被取消、知道呼叫端的工具
+type ContextTool interface {
+	RunContext(ctx context.Context, env *RunEnv, argsJSON string) (string, error)
+}
+
+// Scratch 建立並回傳暫存目錄
+func (e *RunEnv) Scratch() (string, error) {
+	if e == nil || e.ScratchDir == "" {
+		return "", fmt.Errorf("此呼叫沒有可用的暫存目錄")
+	}
+	if err := os.MkdirAll(e.ScratchDir, 0750); err != nil {
+		return "", fmt.Errorf("建立暫存目錄失敗: %w", err)
+	}
+	return e.ScratchDir, nil
+}
+
+// Environ 回傳子程序使用的環境變數：有暫存目錄時加上 PCAI_SCRATCH；回傳 nil 代表沿用目前行程的環境
+func (e *RunEnv) Environ() []string {
+	dir, err := e.Scratch()
+	if err != nil {
+		return nil
+	}
+	return append(os.Environ(), "PCAI_SCRATCH="+dir)
+}
+
+// Confirm 請使用者核准動作；沒有核准管道時一律拒絕
+func (e *RunEnv) Confirm(ctx context.Context, action string) (bool, string) {
+	if e == nil || e.Approve == nil {
+		return false, "目前的頻道無法詢問使用者"
+	}
+	return e.Approve(ctx, action)
+}
+
+// legacyTool 將只實作 Run 的工具包裝為 ContextTool
+type legacyTool struct {
+	AgentTool
+}
+
+// Adapt 回傳工具的 ContextTool 版本：已實作 ContextTool 的工具原樣回傳；
+// 舊工具在背景執行，ctx 取消或逾時時立即回傳錯誤 (工具本身會執行完畢，結果被捨棄)
+func Adapt(t AgentTool) ContextTool {
+	if ct, ok := t.(ContextTool); ok {
+		return ct
+	}
+	return legacyTool{t}
+}
+
+func (l legacyTool) RunContext(ctx context.Context, env *RunEnv, argsJSON string) (string, error) {
+	if ctx.Done() == nil {
+		return l.Run(argsJSON)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("工具 %s 執行時發生錯誤: %v", l.Name(), r)}
+			}
+		}()
+		result, err := l.Run(argsJSON)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("工具 %s 未在時限內完成: %w", l.Name(), ctx.Err())
+	}
+}
+
+// CallToolContext 以呼叫端的 context 與執行環境執行工具，依序經過別名、查找、參數清理、驗證與中介層 (見 middleware.go)
+func (r *Registry) CallToolContext(ctx context.Context, env *RunEnv, name string, argsJSON string) (result string, err error) {
+	if env == nil {
+		env = &RunEnv{}
+	}
+	call := &ToolCall{Name: name, Args: argsJSON, Env: env}
+	start := time.Now()
+	defer func() { recordCall(env, call.Name, call.Args, start, result, err) }()
+
+	return r.handler()(ctx, call)
+}
@@ -0,0 +1,397 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 工具參數驗證 (Argument Validation)
+// ─────────────────────────────────────────────────────────────
+//
+// 執行工具前依 Definition 的參數 Schema 檢查並修正模型給的參數：
+//   - 工具可實作 ArgNormalizer 先改寫參數名稱與別名 (例如 Skill 的 option_aliases)
+//   - 明顯的型別錯誤直接轉換 (字串 "10" -> 整數 10、"true" -> 布林)
+//   - 缺少的參數套用工具提供的預設值 (ArgDefaults)
+//   - enum 參數以編輯距離找最接近的選項 (例如 long-term -> long_term)
+// 無法修正時回傳 ArgError，列出每個需要修正的參數，讓模型在下一輪自行更正。
+
+// ArgDefaults 是選用介面：提供參數的預設值 (api.ToolProperty 沒有 default 欄位)
+type ArgDefaults interface {
+	ArgDefaults() map[string]any
+}
+
+// ArgNormalizer 是選用介面：在 Schema 驗證前改寫參數 (例如 Skill 的參數名稱別名與 option_aliases)，回傳已修正的項目
+type ArgNormalizer interface {
+	NormalizeArgs(args map[string]any) (fixes []string)
+}
+
+// ArgProblem 描述一個需要修正的參數
+type ArgProblem struct {
+	Param   string `json:"param"`
+	Problem string `json:"problem"`
+	Expect  string `json:"expected,omitempty"`
+}
+
+// ArgError 是參數驗證失敗的錯誤，內容會以工具結果交給模型
+type ArgError struct {
+	Tool     string
+	Problems []ArgProblem
+}
+
+func (e *ArgError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "工具 %s 的參數不正確，請依下列說明修正後重新呼叫：", e.Tool)
+	for _, p := range e.Problems {
+		fmt.Fprintf(&sb, "\n- %s: %s", p.Param, p.Problem)
+		if p.Expect != "" {
+			fmt.Fprintf(&sb, " (%s)", p.Expect)
+		}
+	}
+	return sb.String()
+}
+
+// validateToolArgs 驗證並修正 argsJSON，回傳修正後的參數
+func validateToolArgs(tool AgentTool, argsJSON string) (string, error) {
+	def := tool.Definition()
+	if def.Function.Parameters.Properties == nil && len(def.Function.Parameters.Required) == 0 {
+		return argsJSON, nil
+	}
+
+	args := map[string]any{}
+	clean := argsJSON // 實際能解析的參數 (去掉 ```json 區塊後的內容)
+	if text := strings.TrimSpace(argsJSON); text != "" {
+		if err := json.Unmarshal([]byte(text), &args); err != nil {
+			// 模型有時會把參數包在 ```json 區塊中
+			text = strings.Trim(text, "`json\n ")
+			if err := json.Unmarshal([]byte(text), &args); err != nil || args == nil {
+				return "", &ArgError{Tool: tool.Name(), Problems: []ArgProblem{
+					{Param: "(參數)", Problem: "不是合法的 JSON 物件", Expect: "例如 {\"參數名稱\": \"值\"}"},
+				}}
+			}
+			clean = text
+		}
+		if args == nil {
+			args, clean = map[string]any{}, "{}" // 模型送出 null 時視為沒有參數，避免後續寫入 nil map
+		}
+	}
+
+	var fixes []string
+	if n, ok := tool.(ArgNormalizer); ok {
+		fixes = n.NormalizeArgs(args)
+	}
+	var defaults map[string]any
+	if d, ok := tool.(ArgDefaults); ok {
+		defaults = d.ArgDefaults()
+	}
+	validated, problems := ValidateArgs(def, args, defaults)
+	if len(problems) > 0 {
+		return "", &ArgError{Tool: tool.Name(), Problems: problems}
+	}
+	fixes = append(fixes, validated...)
+	if len(fixes) == 0 {
+		return clean, nil
+	}
+	fixed, err := json.Marshal(args)
+	if err != nil {
+		return clean, nil
+	}
+	fmt.Printf("🔧 [Args] %s 參數已修正: %s\n", tool.Name(), strings.Join(fixes, ", "))
+	return string(fixed), nil
+}
+
+// ValidateArgs 依工具定義就地修正 args，回傳已修正的項目與無法修正的問題
+func ValidateArgs(def api.Tool, args map[string]any, defaults map[string]any) (fixes []string, problems []ArgProblem) {
+	params := def.Function.Parameters
+	if params.Properties != nil {
+		for name, prop := range params.Properties.All() {
+			value, ok := args[name]
+			if ok && value == nil {
+				delete(args, name)
+				ok = false
+			}
+			if !ok {
+				if d, has := defaults[name]; has {
+					args[name] = d
+					fixes = append(fixes, fmt.Sprintf("%s 使用預設值 %v", name, d))
+				}
+				continue
+			}
+
+			coerced, problem := coerceArg(value, prop)
+			if problem != "" {
+				problems = append(problems, ArgProblem{Param: name, Problem: problem, Expect: expectOf(prop)})
+				continue
+			}
+			if len(prop.Enum) > 0 {
+				matched, ok := matchEnum(coerced, prop.Enum)
+				if !ok {
+					problems = append(problems, ArgProblem{Param: name, Problem: fmt.Sprintf("%v 不在允許的選項中", value), Expect: expectOf(prop)})
+					continue
+				}
+				coerced = matched
+			}
+			if fmt.Sprintf("%#v", coerced) != fmt.Sprintf("%#v", value) {
+				args[name] = coerced
+				before, _ := json.Marshal(value)
+				after, _ := json.Marshal(coerced)
+				fixes = append(fixes, fmt.Sprintf("%s: %s -> %s", name, before, after))
+			}
+		}
+	}
+
+	for _, name := range params.Required {
+		if _, ok := args[name]; ok {
+			continue
+		}
+		expect := ""
+		if params.Properties != nil {
+			if prop, ok := params.Properties.Get(name); ok {
+				expect = expectOf(prop)
+				if desc := []rune(prop.Description); len(desc) > 0 {
+					expect += "：" + string(desc[:min(len(desc), 60)])
+				}
+			}
+		}
+		problems = append(problems, ArgProblem{Param: name, Problem: "缺少必要參數", Expect: expect})
+	}
+	return fixes, problems
+}
+
+// propTypes 回傳參數允許的型別 (含 anyOf)
+func propTypes(prop api.ToolProperty) []string {
+	types := append([]string{}, prop.Type...)
+	for _, alt := range prop.AnyOf {
+		types = append(types, alt.Type...)
+	}
+	return types
+}
+
+// expectOf 描述參數需要的型別與選項
+func expectOf(prop api.ToolProperty) string {
+	var parts []string
+	if types := propTypes(prop); len(types) > 0 {
+		parts = append(parts, "需要 "+strings.Join(types, " 或 "))
+	}
+	if len(prop.Enum) > 0 {
+		opts := make([]string, len(prop.Enum))
+		for i, v := range prop.Enum {
+			opts[i] = fmt.Sprintf("%v", v)
+		}
+		parts = append(parts, "可用值: "+strings.Join(opts, ", "))
+	}
+	return strings.Join(parts, "，")
+}
+
+// coerceArg 將值轉為參數宣告的型別，無法轉換時回傳問題說明
+func coerceArg(value any, prop api.ToolProperty) (any, string) {
+	types := propTypes(prop)
+	if len(types) == 0 {
+		return value, ""
+	}
+	for _, t := range types {
+		if matchesType(value, t) {
+			return value, ""
+		}
+	}
+	for _, t := range types {
+		if v, ok := convertArg(value, t); ok {
+			return v, ""
+		}
+	}
+	return nil, fmt.Sprintf("型別錯誤，收到 %s", jsonKind(value))
+}
+
+// jsonKind 回傳 JSON 值的型別名稱
+func jsonKind(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// matchesType 判斷值是否已符合型別
+func matchesType(value any, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		return jsonKind(value) == "number"
+	case "string", "boolean", "array", "object", "null":
+		return jsonKind(value) == t
+	}
+	return true // 未知型別不檢查
+}
+
+// convertArg 嘗試把值轉換為型別 t
+func convertArg(value any, t string) (any, bool) {
+	s, isString := value.(string)
+	s = strings.TrimSpace(s)
+	switch t {
+	case "integer":
+		if isString {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n, true
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil && f == math.Trunc(f) {
+				return int64(f), true
+			}
+		}
+	case "number":
+		if isString {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, true
+			}
+		}
+	case "boolean":
+		if isString {
+			switch strings.ToLower(s) {
+			case "true", "yes", "1", "是":
+				return true, true
+			case "false", "no", "0", "否":
+				return false, true
+			}
+		}
+		if f, ok := value.(float64); ok && (f == 0 || f == 1) {
+			return f == 1, true
+		}
+	case "string":
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		case map[string]any:
+			// 模型有時會把值包成 {"type":"string","value":"..."}
+			if inner, ok := v["value"].(string); ok {
+				return inner, true
+			}
+		}
+	case "array":
+		if isString && strings.HasPrefix(s, "[") {
+			var arr []any
+			if err := json.Unmarshal([]byte(s), &arr); err == nil {
+				return arr, true
+			}
+		}
+		if kind := jsonKind(value); kind != "array" && kind != "object" {
+			return []any{value}, true
+		}
+	case "object":
+		if isString && strings.HasPrefix(s, "{") {
+			var obj map[string]any
+			if err := json.Unmarshal([]byte(s), &obj); err == nil {
+				return obj, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// matchEnum 在 enum 中找出值 (字串以 ClosestMatch 容許拼字差異)
+func matchEnum(value any, enum []any) (any, bool) {
+	var options []string
+	for _, opt := range enum {
+		if fmt.Sprintf("%v", opt) == fmt.Sprintf("%v", value) {
+			return opt, true
+		}
+		if s, ok := opt.(string); ok {
+			options = append(options, s)
+		}
+	}
+	if s, ok := value.(string); ok && len(options) > 0 {
+		if match, found := ClosestMatch(strings.TrimSpace(s), options); found {
+			return match, true
+		}
+	}
+	return nil, false
+}
+
+// levenshtein 計算兩個字串的編輯距離
+func levenshtein(s1, s2 string) int {
+	r1, r2 := []rune(s1), []rune(s2)
+	len1, len2 := len(r1), len(r2)
+
+	matrix := make([][]int, len1+1)
+	for i := range matrix {
+		matrix[i] = make([]int, len2+1)
+	}
+
+	for i := 0; i <= len1; i++ {
+		matrix[i][0] = i
+	}
+	for j := 0; j <= len2; j++ {
+		matrix[0][j] = j
+	}
+
+	for i := 1; i <= len1; i++ {
+		for j := 1; j <= len2; j++ {
+			cost := 0
+			if r1[i-1] != r2[j-1] {
+				cost = 1
+			}
+			matrix[i][j] = min(
+				matrix[i-1][j]+1,      // deletion
+				matrix[i][j-1]+1,      // insertion
+				matrix[i-1][j-1]+cost, // substitution
+			)
+		}
+	}
+	return matrix[len1][len2]
+}
+
+// ClosestMatch 在選項列表中尋找最接近的匹配 (不分大小寫的精確匹配優先，其次是編輯距離)
+func ClosestMatch(input string, options []string) (string, bool) {
+	bestMatch := ""
+	minDist := 1000 // 任意大數
+
+	// 1. 先嘗試精確匹配 (Case Indifferent)
+	for _, opt := range options {
+		if strings.EqualFold(input, opt) {
+			return opt, true
+		}
+	}
+
+	// 2. 嘗試模糊匹配
+	for _, opt := range options {
+		dist := levenshtein(input, opt)
+		if dist < minDist {
+			minDist = dist
+			bestMatch = opt
+		}
+	}
+
+	// 設定閾值：對於短字串(如地名)，允許編輯距離
+	// 例如：台北市 (3 chars) -> 臺北市 (3 chars), dist=1 (台!=臺)
+	// 林口 (2 chars) -> 宜蘭 (2 chars), dist=2 -> 不應匹配
+	threshold := 2
+	inputLen := len([]rune(input))
+	if inputLen <= 2 {
+		threshold = 1 // 2字以下只允許錯1字 (e.g. 台南->臺南)
+	} else if inputLen > 4 {
+		threshold = 3
+	}
+
+	if minDist <= threshold {
+		return bestMatch, true
+	}
+
+	return "", false
+}
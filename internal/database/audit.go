@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteTime 是 SQLite CURRENT_TIMESTAMP 的格式 (UTC)
+const sqliteTime = "2006-01-02 15:04:05"
+
+// ToolAuditEntry 是一次工具呼叫的稽核紀錄
+type ToolAuditEntry struct {
+	ID         int64     `json:"id"`
+	SessionID  string    `json:"session_id"`
+	Channel    string    `json:"channel"`
+	Sender     string    `json:"sender"`
+	Tool       string    `json:"tool"`
+	Args       string    `json:"args"`
+	ResultSize int       `json:"result_size"`
+	ResultHash string    `json:"result_hash"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model"`
+	CreatedAt  string    `json:"created_at"` // 本地時間
+	Timestamp  time.Time `json:"-"`          // 寫入時使用，零值代表現在
+}
+
+// AuditFilter 是稽核紀錄的查詢條件，零值欄位代表不限制
+type AuditFilter struct {
+	Since      time.Time
+	Until      time.Time
+	SessionID  string
+	Channel    string
+	Sender     string
+	Tool       string
+	ErrorsOnly bool
+	Limit      int // 預設 100
+}
+
+// AddToolAudit 新增一筆工具呼叫稽核紀錄
+func (db *DB) AddToolAudit(ctx context.Context, e ToolAuditEntry) error {
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	query := `INSERT INTO tool_audit (session_id, channel, sender, tool, args, result_size, result_hash,
+			  duration_ms, error, provider, model, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, e.SessionID, e.Channel, e.Sender, e.Tool, e.Args, e.ResultSize, e.ResultHash,
+		e.DurationMs, e.Error, e.Provider, e.Model, ts.UTC().Format(sqliteTime))
+	return err
+}
+
+// QueryToolAudit 依條件查詢稽核紀錄 (新到舊)
+func (db *DB) QueryToolAudit(ctx context.Context, f AuditFilter) ([]ToolAuditEntry, error) {
+	var where []string
+	var args []interface{}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format(sqliteTime))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until.UTC().Format(sqliteTime))
+	}
+	for col, val := range map[string]string{"session_id": f.SessionID, "channel": f.Channel, "sender": f.Sender, "tool": f.Tool} {
+		if val != "" {
+			where = append(where, col+" = ?")
+			args = append(args, val)
+		}
+	}
+	if f.ErrorsOnly {
+		where = append(where, "COALESCE(error, '') != ''")
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	query := `SELECT id, COALESCE(session_id, ''), COALESCE(channel, ''), COALESCE(sender, ''), tool, COALESCE(args, ''),
+			  result_size, COALESCE(result_hash, ''), duration_ms, COALESCE(error, ''), COALESCE(provider, ''),
+			  COALESCE(model, ''), datetime(created_at, 'localtime')
+			  FROM tool_audit`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ToolAuditEntry
+	for rows.Next() {
+		var e ToolAuditEntry
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.Channel, &e.Sender, &e.Tool, &e.Args, &e.ResultSize, &e.ResultHash,
+			&e.DurationMs, &e.Error, &e.Provider, &e.Model, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("讀取稽核紀錄失敗: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// PruneToolAudit 刪除超過 days 天的稽核紀錄
+func (db *DB) PruneToolAudit(ctx context.Context, days int) (int64, error) {
+	if days <= 0 {
+		return 0, nil
+	}
+	result, err := db.ExecContext(ctx, "DELETE FROM tool_audit WHERE created_at < datetime('now', ?)", fmt.Sprintf("-%d days", days))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// WriteToolAuditCSV 將稽核紀錄匯出為 CSV
+func WriteToolAuditCSV(w io.Writer, entries []ToolAuditEntry) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "created_at", "session_id", "channel", "sender", "tool", "args",
+		"result_size", "result_hash", "duration_ms", "error", "provider", "model"})
+	for _, e := range entries {
+		_ = cw.Write([]string{strconv.FormatInt(e.ID, 10), e.CreatedAt, e.SessionID, e.Channel, e.Sender, e.Tool, e.Args,
+			strconv.Itoa(e.ResultSize), e.ResultHash, strconv.FormatInt(e.DurationMs, 10), e.Error, e.Provider, e.Model})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ParseLocalTime 解析查詢用的時間：today、yesterday、2006-01-02、2006-01-02 15:04 或 RFC3339 (本地時區)
+func ParseLocalTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch strings.ToLower(s) {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("無法解析時間: %s (可用 today、yesterday、2006-01-02 或 2006-01-02 15:04)", s)
+}
@@ -73,7 +73,41 @@ func (db *DB) migrate() error {
 		tags TEXT,              -- e.g., 'ui', 'user_info'
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		UNIQUE(category, key)
-	);`
+	);
+	CREATE TABLE IF NOT EXISTS llm_usage (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT,
+		channel TEXT,
+		workflow TEXT,               -- chat, heartbeat, patrol, morning_briefing ...
+		iteration INTEGER DEFAULT 0, -- Agent 工具迴圈第幾輪
+		provider TEXT,
+		model TEXT,
+		prompt_tokens INTEGER DEFAULT 0,
+		completion_tokens INTEGER DEFAULT 0,
+		latency_ms INTEGER DEFAULT 0,
+		tokens_per_sec REAL DEFAULT 0,
+		success INTEGER DEFAULT 1,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_llm_usage_created ON llm_usage(created_at);
+	CREATE TABLE IF NOT EXISTS tool_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT,
+		channel TEXT,
+		sender TEXT,
+		tool TEXT NOT NULL,
+		args TEXT,                   -- 已遮蔽敏感欄位的參數 JSON
+		result_size INTEGER DEFAULT 0,
+		result_hash TEXT,
+		duration_ms INTEGER DEFAULT 0,
+		error TEXT,
+		provider TEXT,               -- 要求此呼叫的 LLM Provider
+		model TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_tool_audit_created ON tool_audit(created_at);
+	CREATE INDEX IF NOT EXISTS idx_tool_audit_tool ON tool_audit(tool);`
 
 	_, err := db.Exec(query)
 	if err != nil {
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// LLMUsageEntry 是一次 LLM 呼叫的用量紀錄
+type LLMUsageEntry struct {
+	ID               int64   `json:"id"`
+	SessionID        string  `json:"session_id"`
+	Channel          string  `json:"channel"`
+	Workflow         string  `json:"workflow"`
+	Iteration        int     `json:"iteration"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	LatencyMs        int64   `json:"latency_ms"`
+	TokensPerSec     float64 `json:"tokens_per_sec"`
+	Success          bool    `json:"success"`
+	Error            string  `json:"error,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+}
+
+// LLMUsageSummary 是依某個維度彙總的用量
+type LLMUsageSummary struct {
+	Key              string  `json:"key"`
+	Calls            int     `json:"calls"`
+	Failures         int     `json:"failures"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	AvgTokensPerSec  float64 `json:"avg_tokens_per_sec"`
+}
+
+// usageGroupColumns 允許的彙總維度 (避免把使用者輸入直接拼進 SQL)
+var usageGroupColumns = map[string]string{
+	"workflow": "COALESCE(NULLIF(workflow, ''), '(none)')",
+	"channel":  "COALESCE(NULLIF(channel, ''), '(none)')",
+	"provider": "provider",
+	"model":    "model",
+	"session":  "COALESCE(NULLIF(session_id, ''), '(none)')",
+	"day":      "date(created_at, 'localtime')",
+}
+
+// AddLLMUsage 新增一筆 LLM 用量紀錄
+func (db *DB) AddLLMUsage(ctx context.Context, e LLMUsageEntry) error {
+	query := `INSERT INTO llm_usage (session_id, channel, workflow, iteration, provider, model,
+			  prompt_tokens, completion_tokens, latency_ms, tokens_per_sec, success, error)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, e.SessionID, e.Channel, e.Workflow, e.Iteration, e.Provider, e.Model,
+		e.PromptTokens, e.CompletionTokens, e.LatencyMs, e.TokensPerSec, e.Success, e.Error)
+	return err
+}
+
+// GetLLMUsageSummary 依指定維度彙總最近 days 天的用量
+// groupBy 可為 workflow, channel, provider, model, session, day
+func (db *DB) GetLLMUsageSummary(ctx context.Context, groupBy string, days int) ([]LLMUsageSummary, error) {
+	col, ok := usageGroupColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("不支援的彙總維度: %s", groupBy)
+	}
+	if days <= 0 {
+		days = 7
+	}
+
+	query := fmt.Sprintf(`SELECT %s AS k, COUNT(*), SUM(CASE WHEN success = 0 THEN 1 ELSE 0 END),
+			  SUM(prompt_tokens), SUM(completion_tokens), AVG(latency_ms),
+			  AVG(CASE WHEN tokens_per_sec > 0 THEN tokens_per_sec END)
+			  FROM llm_usage
+			  WHERE created_at >= datetime('now', ?)
+			  GROUP BY k
+			  ORDER BY SUM(prompt_tokens) + SUM(completion_tokens) DESC`, col)
+	rows, err := db.QueryContext(ctx, query, fmt.Sprintf("-%d days", days))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LLMUsageSummary
+	for rows.Next() {
+		var s LLMUsageSummary
+		var tps sql.NullFloat64
+		if err := rows.Scan(&s.Key, &s.Calls, &s.Failures, &s.PromptTokens, &s.CompletionTokens, &s.AvgLatencyMs, &tps); err != nil {
+			return nil, fmt.Errorf("讀取用量彙總失敗: %w", err)
+		}
+		s.AvgTokensPerSec = tps.Float64
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// GetRecentLLMUsage 取得最近的 LLM 呼叫紀錄
+func (db *DB) GetRecentLLMUsage(ctx context.Context, limit int) ([]LLMUsageEntry, error) {
+	query := `SELECT id, COALESCE(session_id, ''), COALESCE(channel, ''), COALESCE(workflow, ''), iteration,
+			  COALESCE(provider, ''), COALESCE(model, ''), prompt_tokens, completion_tokens, latency_ms,
+			  tokens_per_sec, success, COALESCE(error, ''), created_at
+			  FROM llm_usage ORDER BY id DESC LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LLMUsageEntry
+	for rows.Next() {
+		var e LLMUsageEntry
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.Channel, &e.Workflow, &e.Iteration, &e.Provider, &e.Model,
+			&e.PromptTokens, &e.CompletionTokens, &e.LatencyMs, &e.TokensPerSec, &e.Success, &e.Error, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("讀取用量紀錄失敗: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -1,7 +1,10 @@
 package gateway
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,12 +12,14 @@ import (
 	"github.com/asccclass/pcai/internal/channel"
 	"github.com/asccclass/pcai/internal/core"
 	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms"
 	"github.com/asccclass/pcai/llms/ollama"
 )
 
 // AgentAdapter 負責管理多個 Telegram 使用者的 Agent 實例
 type AgentAdapter struct {
 	agents             map[string]*agent.Agent
+	sessionLocks       map[string]*sync.Mutex // 每個 Session 一把鎖，同一位使用者的訊息依序處理
 	registry           *core.Registry
 	modelName          string
 	systemPrompt       string
@@ -28,12 +33,14 @@ type AgentAdapter struct {
 	onAcquireTaskLock  func() bool                  // 獲取任務鎖
 	onReleaseTaskLock  func()                       // 釋放任務鎖
 	onIsTaskLocked     func() bool                  // 檢查任務鎖
+	subscribers        []func(agent.Event)          // [NEW] 所有 Session 的 Agent 事件訂閱者
 }
 
 // NewAgentAdapter 建立新的 Adapter
 func NewAgentAdapter(registry *core.Registry, modelName, systemPrompt string, debug bool, logger *agent.SystemLogger) *AgentAdapter {
 	return &AgentAdapter{
 		agents:       make(map[string]*agent.Agent),
+		sessionLocks: make(map[string]*sync.Mutex),
 		registry:     registry,
 		modelName:    modelName,
 		systemPrompt: systemPrompt,
@@ -65,27 +72,73 @@ func (a *AgentAdapter) SetTaskLockCallbacks(acquire func() bool, release func(),
 	a.onIsTaskLocked = isLocked
 }
 
+// Subscribe 訂閱所有 Session 的 Agent 事件 (例如日誌、統計)，已建立的 Agent 也會套用
+func (a *AgentAdapter) Subscribe(fn func(agent.Event)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.subscribers = append(a.subscribers, fn)
+	for _, ag := range a.agents {
+		ag.Events.Subscribe(fn)
+	}
+}
+
 // Process 實作 Processor 介面
 func (a *AgentAdapter) Process(env channel.Envelope) string {
 	// 產生 Session ID (加上前綴以區隔)
 	sessionID := fmt.Sprintf("telegram_%s", env.SenderID)
 
 	// 取得或建立 Agent
-	myAgent := a.getOrCreateAgent(sessionID)
+	myAgent := a.getOrCreateAgent(sessionID, env.Platform)
+
+	// 同一個 Session 的 Agent 是共用的，本輪的模型、圖片與核准設定寫在 Agent 上，
+	// 整輪對話持有 Session 鎖，避免連續兩則訊息互相覆寫 (核准回覆由 Dispatcher 先行攔截，不會卡在這裡)
+	lock := a.sessionLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// [NEW] /model 固定路由指令 (不送進 Agent)
+	if reply, handled := a.router.HandleCommand(sessionID, env.Content); handled {
+		return reply
+	}
 
 	// [NEW] 動態路由決策
 	// 在每次對話前，先問 Router 這次該用誰
-	routeResult, err := a.router.Route(env.Content)
+	content := env.Content
+	routeResult, err := a.router.Route(RouteRequest{
+		SessionID: sessionID,
+		Channel:   env.Platform,
+		Sender:    env.SenderID,
+		Content:   env.Content,
+		HasImages: len(env.Images) > 0,
+	})
 	if err == nil {
 		// 動態切換 Agent 的腦袋
 		// 這裡假設 Agent 是同一個實例，但在這一輪對話中臨時切換配置
 		// 注意：如果底層 history 是共用的，這樣做沒問題。
 		myAgent.SetModelConfig(routeResult.ModelName, routeResult.Provider)
+		if routeResult.Profile != "" {
+			myAgent.Options = llms.ProfileOptions(routeResult.Profile)
+		} else {
+			myAgent.UseProfile(env.Platform)
+		}
+		content = routeResult.Content
+		log.Printf("🧭 [Router] (%s) %s", sessionID, routeResult)
 	}
+	if strings.TrimSpace(content) == "" {
+		if len(env.Images) == 0 {
+			return "⚠️ 請在指令後輸入訊息內容"
+		}
+		content = "請描述這張圖片" // 只傳圖片、沒有說明文字
+	}
+
+	// [APPROVAL] 有副作用的工具透過發出訊息的頻道詢問使用者
+	myAgent.Approver = env.Approve
+	myAgent.Channel, myAgent.Sender = env.Platform, env.SenderID
+	myAgent.Images = env.Images // 圖片附在本輪的使用者訊息，交給 (路由選出的) 視覺模型
 
 	// 呼叫 Agent 進行對話
 	if a.debug {
-		fmt.Printf("[Telegram DEBUG] (%s) Sending prompt to Agent: %s\n", sessionID, env.Content)
+		fmt.Printf("[Telegram DEBUG] (%s) Sending prompt to Agent: %s\n", sessionID, content)
 	}
 
 	// [NEW] 顯示正在輸入中 (Typing Indicator)
@@ -112,7 +165,9 @@ func (a *AgentAdapter) Process(env channel.Envelope) string {
 
 	// 注意：這裡暫時不使用 stream callback (傳 nil)，因為 Telegram API 通常是一次性回覆
 	// 若要支援打字中或串流更新，需要更複雜的 channel 整合
-	response, err := myAgent.Chat(env.Content, nil)
+	// 頻道訊息目前沒有取消來源，使用 Background Context
+	ctx := llms.WithUsageTags(context.Background(), llms.UsageTags{Channel: env.Platform, Workflow: "chat"})
+	response, err := myAgent.Chat(ctx, content, nil)
 	close(stopTyping) // 停止輸入狀態
 
 	if err != nil {
@@ -135,7 +190,7 @@ func (a *AgentAdapter) Process(env channel.Envelope) string {
 				fmt.Printf("[Telegram] Recovered from panic in CheckAndSummarize: %v\n", r)
 			}
 		}()
-		history.CheckAndSummarize(myAgent.Session, a.modelName, a.systemPrompt)
+		history.CheckAndSummarize(context.Background(), myAgent.Session, a.modelName, a.systemPrompt)
 	}()
 
 	// [SHORT-TERM MEMORY] 自動儲存對話回應
@@ -146,7 +201,19 @@ func (a *AgentAdapter) Process(env channel.Envelope) string {
 	return response
 }
 
-func (a *AgentAdapter) getOrCreateAgent(sessionID string) *agent.Agent {
+// sessionLock 回傳 Session 專用的鎖
+func (a *AgentAdapter) sessionLock(sessionID string) *sync.Mutex {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lock, ok := a.sessionLocks[sessionID]
+	if !ok {
+		lock = &sync.Mutex{}
+		a.sessionLocks[sessionID] = lock
+	}
+	return lock
+}
+
+func (a *AgentAdapter) getOrCreateAgent(sessionID, platform string) *agent.Agent {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -164,11 +231,7 @@ func (a *AgentAdapter) getOrCreateAgent(sessionID string) *agent.Agent {
 
 	// 建立 Agent
 	newAgent := agent.NewAgent(a.modelName, a.systemPrompt, session, a.registry, a.logger)
-
-	// 設定短期記憶回調
-	if a.onShortTermMemory != nil {
-		newAgent.OnShortTermMemory = a.onShortTermMemory
-	}
+	newAgent.UseProfile(platform) // 各平台可在 profiles.yaml 綁定不同 Profile
 
 	// 設定記憶預搜尋回調
 	if a.onMemorySearch != nil {
@@ -187,14 +250,19 @@ func (a *AgentAdapter) getOrCreateAgent(sessionID string) *agent.Agent {
 		newAgent.OnIsTaskLocked = a.onIsTaskLocked
 	}
 
-	// 設定 Callbacks (為了 debug)
+	// 訂閱事件 (為了 debug)
 	if a.debug {
-		newAgent.OnToolCall = func(name, args string) {
-			fmt.Printf("[Telegram DEBUG] (%s) Tool Call: %s args: %s\n", sessionID, name, args)
-		}
-		newAgent.OnModelMessageComplete = func(content string) {
-			fmt.Printf("[Telegram DEBUG] (%s) AI Message Complete: %s...\n", sessionID, content[:min(len(content), 50)])
-		}
+		newAgent.Events.Subscribe(func(e agent.Event) {
+			switch e.Kind {
+			case agent.KindToolCall:
+				fmt.Printf("[Telegram DEBUG] (%s) Tool Call: %s args: %s\n", sessionID, e.Tool, e.Args)
+			case agent.KindMessage:
+				fmt.Printf("[Telegram DEBUG] (%s) AI Message Complete: %s...\n", sessionID, e.Text[:min(len(e.Text), 50)])
+			}
+		})
+	}
+	for _, fn := range a.subscribers {
+		newAgent.Events.Subscribe(fn)
 	}
 
 	a.agents[sessionID] = newAgent
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/asccclass/pcai/internal/approval"
+	"github.com/asccclass/pcai/internal/channel"
+)
+
+// approvalPrompt 附加在文字核准請求後的操作說明
+const approvalPrompt = "\n\n請回覆 y (允許)、a (本次對話一律允許) 或 n (拒絕)"
+
+// textApprover 以一般訊息詢問使用者是否核准工具呼叫，供沒有按鈕可用的頻道 (WhatsApp、WebSocket) 使用
+// 等待期間同一位使用者的下一則訊息會被視為回覆，不會送進 Agent
+func (d *Dispatcher) textApprover(env channel.Envelope) approval.Approver {
+	key := env.Platform + ":" + env.SenderID
+	return func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+		answer := make(chan string, 1)
+		if _, busy := d.approvals.LoadOrStore(key, answer); busy {
+			return approval.Deny, fmt.Errorf("已有等待中的核准請求")
+		}
+		defer d.approvals.Delete(key)
+
+		if err := env.Reply(req.Prompt() + approvalPrompt); err != nil {
+			return approval.Deny, err
+		}
+		select {
+		case text := <-answer:
+			return approval.ParseDecision(text), nil
+		case <-ctx.Done():
+			_ = env.Reply("⌛ 等待核准逾時，工具未執行")
+			return approval.Deny, ctx.Err()
+		}
+	}
+}
+
+// answerApproval 若使用者有等待中的核准請求，把這則訊息當作回覆
+func (d *Dispatcher) answerApproval(env channel.Envelope) bool {
+	v, ok := d.approvals.Load(env.Platform + ":" + env.SenderID)
+	if !ok {
+		return false
+	}
+	select {
+	case v.(chan string) <- env.Content:
+		log.Printf("[%s] 收到核准回覆 (來自 %s): %s", env.Platform, env.SenderID, env.Content)
+	default:
+	}
+	return true
+}
@@ -19,6 +19,8 @@ type Dispatcher struct {
 	// 使用 Map 存儲授權用戶，並用 RWMutex 保證並發安全
 	authorizedUsers sync.Map
 	adminID         string
+	// 等待文字回覆的核准請求 (Platform:SenderID -> chan string)
+	approvals sync.Map
 
 	// 事件回調
 	OnCompletion func()
@@ -46,14 +48,22 @@ func (d *Dispatcher) HandleMessage(env channel.Envelope) {
 		return
 	}
 
-	// 2. 指令解析 (如果是核心系統指令)
+	// 2. 等待核准中的使用者，這則訊息就是回覆
+	if d.answerApproval(env) {
+		return
+	}
+	if env.Approve == nil {
+		env.Approve = d.textApprover(env)
+	}
+
+	// 3. 指令解析 (如果是核心系統指令)
 	if strings.HasPrefix(env.Content, "/") {
 		if d.handleSystemCommand(env) {
 			return // 如果是系統指令且處理完成，則直接返回
 		}
 	}
 
-	// 3. 業務邏輯處理 (交給 Processor，例如 AI 或 CMD 工具)
+	// 4. 業務邏輯處理 (交給 Processor，例如 AI 或 CMD 工具)
 	// 這裡可以做非同步處理，避免阻塞下一個訊息接收
 	go func() {
 		defer func() {
@@ -1,75 +1,399 @@
 package gateway
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
 
+	"github.com/asccclass/pcai/internal/agent"
 	"github.com/asccclass/pcai/llms"
+	"gopkg.in/yaml.v3"
 )
 
+// ──────────────────────────────────────────────────────
+// 規則式模型路由
+// ──────────────────────────────────────────────────────
+//
+// 每則訊息依序比對 routes.yaml (路徑可用 PCAI_ROUTES 指定) 中的規則，第一條命中的規則
+// 決定這一輪使用的 Provider、模型與生成參數 Profile；設定檔的規則優先於內建的
+// /copilot、/code、/gpt 前綴規則。同一條規則內的條件必須全部成立：
+//
+//	rules:
+//	  - name: vision
+//	    images: true
+//	    provider: ollama
+//	    model: llava:13b
+//	  - name: calendar
+//	    intent: manage_calendar
+//	    profile: tool-routing
+//	  - name: deep
+//	    prefix: /deep
+//	    provider: claude
+//	    model: ${ANTHROPIC_MODEL:-claude-3-5-sonnet-latest}
+//
+// 使用者可用 /model 在自己的 Session 設定固定路由，直到 /model reset 為止；
+// 明確輸入的前綴指令仍優先於固定路由。
+
+// RouteRule 是一條路由規則，留空的條件不參與比對
+type RouteRule struct {
+	Name    string `yaml:"name"`
+	Prefix  string `yaml:"prefix"`  // 訊息開頭的指令 (例如 /code)，命中後會從訊息中移除
+	Regex   string `yaml:"regex"`   // 訊息內容需符合的正規表示式
+	Channel string `yaml:"channel"` // 平台名稱: telegram, whatsapp, websocket
+	Sender  string `yaml:"sender"`  // 發送者 ID
+	Intent  string `yaml:"intent"`  // 偵測到的工具意圖 (例如 manage_calendar)
+	Images  *bool  `yaml:"images"`  // true 表示需附帶圖片，false 表示不可附帶圖片
+
+	Provider   string `yaml:"provider"`    // 留空使用預設 Provider (含 PCAI_PROVIDER_CHAIN)
+	Model      string `yaml:"model"`       // 支援 ${ENV} 與 ${ENV:-預設值}；留空沿用預設模型
+	Profile    string `yaml:"profile"`     // 生成參數 Profile；留空沿用平台綁定的 Profile
+	KeepPrefix bool   `yaml:"keep_prefix"` // 保留訊息中的前綴指令
+
+	re *regexp.Regexp
+}
+
+// RouteConfig 是 routes.yaml 的內容
+type RouteConfig struct {
+	Rules []RouteRule `yaml:"rules"`
+}
+
+// RouteRequest 是路由比對所需的訊息資訊
+type RouteRequest struct {
+	SessionID string
+	Channel   string
+	Sender    string
+	Content   string
+	HasImages bool
+}
+
 // RouteResult 包含了路由決策的結果
 type RouteResult struct {
-	ModelName string
-	Provider  llms.ChatStreamFunc
+	Rule         string // 命中的規則名稱 (default 表示沒有命中，sticky 表示 /model 固定路由)
+	ProviderName string
+	ModelName    string
+	Profile      string
+	Content      string // 移除前綴後要送給 Agent 的訊息
+	Provider     llms.ChatStreamFunc
+}
+
+// String 供除錯日誌顯示
+func (r *RouteResult) String() string {
+	provider := r.ProviderName
+	if provider == "" {
+		provider = "default"
+	}
+	profile := r.Profile
+	if profile == "" {
+		profile = "-"
+	}
+	return fmt.Sprintf("規則 %s → %s/%s (profile: %s)", r.Rule, provider, r.ModelName, profile)
+}
+
+// stickyRoute 是使用者以 /model 設定的固定路由
+type stickyRoute struct {
+	Provider string
+	Model    string
+	Profile  string
+}
+
+// builtinRouteRules 沿用原本寫死的前綴指令
+func builtinRouteRules() []RouteRule {
+	return []RouteRule{
+		{Name: "copilot", Prefix: "/copilot", Provider: "copilot", Model: "${PCAI_MODEL:-gpt-4o}"},
+		{Name: "code", Prefix: "/code", Provider: "claude", Model: "${ANTHROPIC_MODEL:-claude-3-5-sonnet-latest}"},
+		// 可透過 OPENAI_MODEL 指向 llama.cpp / vLLM 上載入的模型
+		{Name: "gpt", Prefix: "/gpt", Provider: "openai", Model: "${OPENAI_MODEL:-gpt-4o}"},
+	}
 }
 
 // Router 負責根據使用者輸入決定使用哪個模型與供應商
 type Router struct {
 	DefaultModel string
+
+	mu     sync.RWMutex
+	rules  []RouteRule
+	sticky map[string]stickyRoute  // SessionID -> 固定路由
+	last   map[string]*RouteResult // SessionID -> 最近一次路由結果
 }
 
-// NewRouter 建立新的路由
+// NewRouter 建立新的路由，並載入 PCAI_ROUTES (預設 routes.yaml) 的規則
 func NewRouter(defaultModel string) *Router {
-	return &Router{
+	r := &Router{
 		DefaultModel: defaultModel,
+		sticky:       make(map[string]stickyRoute),
+		last:         make(map[string]*RouteResult),
+	}
+
+	path := os.Getenv("PCAI_ROUTES")
+	if path == "" {
+		path = "routes.yaml"
+	}
+	rules, err := LoadRouteRules(path)
+	if err == nil {
+		err = r.SetRules(rules)
+	}
+	if err != nil {
+		log.Printf("⚠️ [Router] %v，改用內建路由規則", err)
+		_ = r.SetRules(builtinRouteRules())
+	}
+	return r
+}
+
+// LoadRouteRules 讀取路由設定檔，回傳設定檔規則加上內建規則；檔案不存在時只有內建規則
+func LoadRouteRules(path string) ([]RouteRule, error) {
+	var cfg RouteConfig
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("讀取路由設定失敗: %w", err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("解析路由設定失敗 (%s): %w", path, err)
+		}
+	}
+	return append(cfg.Rules, builtinRouteRules()...), nil
+}
+
+// SetRules 替換路由規則並編譯正規表示式
+func (r *Router) SetRules(rules []RouteRule) error {
+	compiled := make([]RouteRule, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("路由規則 %s 的 regex 無效: %w", rule.Name, err)
+			}
+			rule.re = re
+		}
+		compiled[i] = rule
+	}
+
+	r.mu.Lock()
+	r.rules = compiled
+	r.mu.Unlock()
+	return nil
+}
+
+// Route 執行路由邏輯，並記錄為該 Session 的最近一次路由
+func (r *Router) Route(req RouteRequest) (*RouteResult, error) {
+	result := r.resolve(req)
+
+	if result.ProviderName == "" {
+		// 預設路由交給 GetDefaultChatStream (支援 PCAI_PROVIDER_CHAIN Failover 鏈)
+		result.Provider = llms.GetDefaultChatStream()
+	} else {
+		provider, err := llms.GetProviderFunc(result.ProviderName)
+		if err != nil {
+			// 如果找不到該 Provider，降級回預設
+			log.Printf("⚠️ 路由失敗 (%s): %v。降級回預設模型。", result.ProviderName, err)
+			result.ProviderName = ""
+			result.ModelName = r.DefaultModel
+			provider = llms.GetDefaultChatStream()
+		}
+		result.Provider = provider
+	}
+
+	r.mu.Lock()
+	r.last[req.SessionID] = result
+	r.mu.Unlock()
+	return result, nil
+}
+
+// LastRoute 回傳該 Session 最近一次的路由結果 (供除錯)
+func (r *Router) LastRoute(sessionID string) *RouteResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.last[sessionID]
+}
+
+// resolve 依規則與固定路由決定結果 (不建立 Provider)
+func (r *Router) resolve(req RouteRequest) *RouteResult {
+	r.mu.RLock()
+	rules := r.rules
+	sticky, hasSticky := r.sticky[req.SessionID]
+	r.mu.RUnlock()
+
+	intent := ""
+	for _, rule := range rules {
+		if rule.Intent != "" {
+			intent = agent.DetectToolIntent(req.Content)
+			break
+		}
+	}
+
+	for _, rule := range rules {
+		// 固定路由只讓位給明確輸入的前綴指令
+		if hasSticky && rule.Prefix == "" {
+			continue
+		}
+		rest, ok := rule.match(req, intent)
+		if !ok {
+			continue
+		}
+		result := &RouteResult{
+			Rule:         rule.Name,
+			ProviderName: rule.Provider,
+			ModelName:    expandModel(rule.Model),
+			Profile:      rule.Profile,
+			Content:      rest,
+		}
+		if result.ModelName == "" {
+			result.ModelName = r.DefaultModel
+		}
+		return result
+	}
+
+	result := &RouteResult{Rule: "default", ModelName: r.DefaultModel, Content: req.Content}
+	if hasSticky {
+		result.Rule = "sticky"
+		result.ProviderName = sticky.Provider
+		result.Profile = sticky.Profile
+		if sticky.Model != "" {
+			result.ModelName = sticky.Model
+		}
+	}
+	return result
+}
+
+// match 檢查規則的所有條件，命中時回傳移除前綴後的訊息
+func (rule *RouteRule) match(req RouteRequest, intent string) (string, bool) {
+	content := req.Content
+	if rule.Prefix != "" {
+		rest, ok := cutCommand(content, rule.Prefix)
+		if !ok {
+			return "", false
+		}
+		if !rule.KeepPrefix {
+			content = rest
+		}
+	}
+	if rule.re != nil && !rule.re.MatchString(req.Content) {
+		return "", false
+	}
+	if rule.Channel != "" && !strings.EqualFold(rule.Channel, req.Channel) {
+		return "", false
+	}
+	if rule.Sender != "" && rule.Sender != req.Sender {
+		return "", false
+	}
+	if rule.Intent != "" && rule.Intent != intent {
+		return "", false
 	}
+	if rule.Images != nil && *rule.Images != req.HasImages {
+		return "", false
+	}
+	return content, true
 }
 
-// Route 執行路由邏輯
-func (r *Router) Route(input string) (*RouteResult, error) {
-	// 簡單的規則範例：
-	// 如果開頭是 /code -> 使用 Claude (需實作 Claude Provider)
-	// 如果開頭是 /gpt -> 使用 OpenAI
-	// 否則 -> 使用預設 (Ollama)
+// cutCommand 檢查訊息是否以指令開頭 (後面需為空白或結尾)，回傳指令之後的內容
+func cutCommand(input, command string) (string, bool) {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, command) {
+		return "", false
+	}
+	rest := input[len(command):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\n' && rest[0] != '\t' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
 
-	var targetModel string
-	var providerName string
+// expandModel 展開模型名稱中的環境變數，支援 ${NAME:-預設值}
+func expandModel(s string) string {
+	return os.Expand(s, func(key string) string {
+		name, def, _ := strings.Cut(key, ":-")
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return def
+	})
+}
 
-	switch {
-	case strings.HasPrefix(input, "/copilot"):
-		copilotModel := os.Getenv("PCAI_MODEL")
-		if copilotModel == "" {
-			copilotModel = "gpt-4o"
+// HandleCommand 處理 /model 指令，回傳要給使用者的回覆；不是 /model 指令時 handled 為 false
+//
+//	/model                         顯示目前設定與最近一次路由
+//	/model reset                   清除固定路由
+//	/model <規則名稱>              固定使用某條規則的 Provider、模型與 Profile
+//	/model <provider>[:<model>] [profile]
+func (r *Router) HandleCommand(sessionID, input string) (reply string, handled bool) {
+	args, ok := cutCommand(input, "/model")
+	if !ok {
+		return "", false
+	}
+	fields := strings.Fields(args)
+
+	if len(fields) == 0 {
+		r.mu.RLock()
+		sticky, hasSticky := r.sticky[sessionID]
+		last := r.last[sessionID]
+		r.mu.RUnlock()
+
+		var sb strings.Builder
+		if hasSticky {
+			sb.WriteString(fmt.Sprintf("📌 固定路由: %s/%s (profile: %s)\n", orDefault(sticky.Provider), orDefault(sticky.Model), orDefault(sticky.Profile)))
+		} else {
+			sb.WriteString("📌 未設定固定路由，依規則選擇模型\n")
 		}
-		targetModel = copilotModel
-		providerName = "copilot"
-	case strings.HasPrefix(input, "/code"):
-		targetModel = "claude-3-5-sonnet-latest"
-		providerName = "claude"
-	case strings.HasPrefix(input, "/gpt"):
-		targetModel = "gpt-4o"
-		providerName = "openai"
-	default:
-		defaultProvider := os.Getenv("PCAI_PROVIDER")
-		if defaultProvider == "" {
-			defaultProvider = "ollama"
+		if last != nil {
+			sb.WriteString("🧭 上一輪: " + last.String() + "\n")
 		}
-		targetModel = r.DefaultModel
-		providerName = defaultProvider
+		sb.WriteString("用法: /model <provider>[:<model>] [profile]、/model <規則名稱>、/model reset")
+		return sb.String(), true
+	}
+
+	if fields[0] == "reset" {
+		r.mu.Lock()
+		delete(r.sticky, sessionID)
+		r.mu.Unlock()
+		return "✅ 已清除固定路由，恢復依規則選擇模型", true
 	}
 
-	provider, err := llms.GetProviderFunc(providerName)
+	sticky, err := r.parseSticky(fields)
 	if err != nil {
-		// 如果找不到該 Provider (例如 claude 未實作)，降級回預設
-		log.Printf("⚠️ 路由失敗 (%s): %v。降級回預設模型。", providerName, err)
-		targetModel = r.DefaultModel
-		providerName = "ollama"
-		provider, _ = llms.GetProviderFunc("ollama")
+		return "⚠️ " + err.Error(), true
 	}
+	r.mu.Lock()
+	r.sticky[sessionID] = sticky
+	r.mu.Unlock()
+	return fmt.Sprintf("✅ 已固定路由: %s/%s (profile: %s)", orDefault(sticky.Provider), orDefault(sticky.Model), orDefault(sticky.Profile)), true
+}
+
+// parseSticky 解析 /model 參數：先比對規則名稱，再視為 provider[:model] [profile]
+func (r *Router) parseSticky(fields []string) (stickyRoute, error) {
+	r.mu.RLock()
+	for _, rule := range r.rules {
+		if rule.Name == fields[0] {
+			r.mu.RUnlock()
+			return stickyRoute{Provider: rule.Provider, Model: expandModel(rule.Model), Profile: rule.Profile}, nil
+		}
+	}
+	r.mu.RUnlock()
 
-	return &RouteResult{
-		ModelName: targetModel,
-		Provider:  provider,
-	}, nil
+	provider, model, _ := strings.Cut(fields[0], ":")
+	if provider == "default" {
+		provider = ""
+	}
+	if provider != "" {
+		if _, err := llms.GetProviderFunc(provider); err != nil {
+			return stickyRoute{}, fmt.Errorf("不支援的 Provider: %s", provider)
+		}
+	}
+	sticky := stickyRoute{Provider: provider, Model: model}
+	if len(fields) > 1 {
+		sticky.Profile = fields[1]
+	}
+	return sticky, nil
+}
+
+func orDefault(s string) string {
+	if s == "" {
+		return "default"
+	}
+	return s
 }
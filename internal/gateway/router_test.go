@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestRouter(t *testing.T, rules ...RouteRule) *Router {
+	t.Helper()
+	r := &Router{
+		DefaultModel: "llama3.1:8b",
+		sticky:       make(map[string]stickyRoute),
+		last:         make(map[string]*RouteResult),
+	}
+	if err := r.SetRules(append(rules, builtinRouteRules()...)); err != nil {
+		t.Fatalf("SetRules: %v", err)
+	}
+	return r
+}
+
+func TestRouterStripsPrefix(t *testing.T) {
+	t.Setenv("ANTHROPIC_MODEL", "")
+	r := newTestRouter(t)
+
+	got := r.resolve(RouteRequest{SessionID: "s1", Content: "/code  幫我看這段程式"})
+	if got.Rule != "code" || got.ProviderName != "claude" {
+		t.Fatalf("rule = %s/%s, want code/claude", got.Rule, got.ProviderName)
+	}
+	if got.ModelName != "claude-3-5-sonnet-latest" {
+		t.Errorf("model = %q, want env default", got.ModelName)
+	}
+	if got.Content != "幫我看這段程式" {
+		t.Errorf("content = %q, prefix not stripped", got.Content)
+	}
+
+	// 前綴必須是完整指令
+	if got := r.resolve(RouteRequest{SessionID: "s1", Content: "/codex 你好"}); got.Rule != "default" {
+		t.Errorf("/codex matched rule %s", got.Rule)
+	}
+}
+
+func TestRouterMatchConditions(t *testing.T) {
+	yes := true
+	r := newTestRouter(t,
+		RouteRule{Name: "vision", Images: &yes, Provider: "ollama", Model: "llava:13b"},
+		RouteRule{Name: "boss", Channel: "telegram", Sender: "42", Profile: "briefing"},
+		RouteRule{Name: "sql", Regex: `(?i)\bselect\b.+\bfrom\b`, Provider: "openai", Model: "${ROUTER_TEST_MODEL:-sqlcoder}"},
+		RouteRule{Name: "calendar", Intent: "manage_calendar", Profile: "tool-routing"},
+	)
+
+	cases := []struct {
+		req  RouteRequest
+		want string
+	}{
+		{RouteRequest{Content: "這是什麼", HasImages: true}, "vision"},
+		{RouteRequest{Channel: "telegram", Sender: "42", Content: "早安"}, "boss"},
+		{RouteRequest{Channel: "whatsapp", Sender: "42", Content: "早安"}, "default"},
+		{RouteRequest{Content: "SELECT name FROM users"}, "sql"},
+		{RouteRequest{Content: "明天的行程有哪些"}, "calendar"},
+		{RouteRequest{Content: "講個笑話"}, "default"},
+	}
+	for _, tc := range cases {
+		if got := r.resolve(tc.req); got.Rule != tc.want {
+			t.Errorf("resolve(%q) = %s, want %s", tc.req.Content, got.Rule, tc.want)
+		}
+	}
+
+	if got := r.resolve(RouteRequest{Content: "select 1 from dual"}); got.ModelName != "sqlcoder" {
+		t.Errorf("model = %q, want sqlcoder", got.ModelName)
+	}
+}
+
+func TestRouterStickyOverride(t *testing.T) {
+	r := newTestRouter(t, RouteRule{Name: "calendar", Intent: "manage_calendar", Profile: "tool-routing"})
+
+	if _, handled := r.HandleCommand("s1", "/models 不是指令"); handled {
+		t.Fatal("/models should not be handled")
+	}
+	if reply, _ := r.HandleCommand("s1", "/model nosuch:foo"); !strings.HasPrefix(reply, "⚠️") {
+		t.Errorf("unknown provider reply = %q", reply)
+	}
+	r.HandleCommand("s1", "/model openai:gpt-4o-mini summarize")
+
+	got := r.resolve(RouteRequest{SessionID: "s1", Content: "明天的行程"})
+	if got.Rule != "sticky" || got.ProviderName != "openai" || got.ModelName != "gpt-4o-mini" || got.Profile != "summarize" {
+		t.Errorf("sticky route = %+v", got)
+	}
+	// 其他 Session 不受影響
+	if got := r.resolve(RouteRequest{SessionID: "s2", Content: "明天的行程"}); got.Rule != "calendar" {
+		t.Errorf("s2 rule = %s, want calendar", got.Rule)
+	}
+	// 明確的前綴指令優先
+	if got := r.resolve(RouteRequest{SessionID: "s1", Content: "/gpt hi"}); got.Rule != "gpt" {
+		t.Errorf("prefix under sticky = %s, want gpt", got.Rule)
+	}
+
+	r.HandleCommand("s1", "/model reset")
+	if got := r.resolve(RouteRequest{SessionID: "s1", Content: "明天的行程"}); got.Rule != "calendar" {
+		t.Errorf("after reset rule = %s, want calendar", got.Rule)
+	}
+}
+
+func TestLoadRouteRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	data := "rules:\n  - name: deep\n    prefix: /deep\n    provider: claude\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRouteRules(path)
+	if err != nil {
+		t.Fatalf("LoadRouteRules: %v", err)
+	}
+	if len(rules) != len(builtinRouteRules())+1 || rules[0].Name != "deep" {
+		t.Errorf("rules = %+v, want file rule first then builtins", rules)
+	}
+
+	rules, err = LoadRouteRules(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil || len(rules) != len(builtinRouteRules()) {
+		t.Errorf("missing file: rules=%d err=%v", len(rules), err)
+	}
+}
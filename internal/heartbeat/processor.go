@@ -31,15 +31,15 @@ const (
 )
 
 type HeartbeatDecision struct {
-	Decision string `json:"decision"` // ACTION: NOTIFY_USER, STATUS: IDLE, STATUS: LOGGED
-	Reason   string `json:"reason"`   // 為什麼做出這個決定
-	Score    int    `json:"score"`    // 0-100 的信心分數
+	Decision string `json:"decision" jsonschema:"enum=ACTION: NOTIFY_USER|ACTION: SELF_TEST|STATUS: IDLE|STATUS: LOGGED"` // ACTION: NOTIFY_USER, ACTION: SELF_TEST, STATUS: IDLE, STATUS: LOGGED
+	Reason   string `json:"reason"`                                                                                       // 為什麼做出這個決定
+	Score    int    `json:"score" jsonschema:"minimum=0;maximum=100"`                                                     // 0-100 的信心分數
 }
 
 type IntentResponse struct {
-	Intent string                 `json:"intent"` // 例如: SET_FILTER, CHAT, UNKNOWN
-	Params map[string]interface{} `json:"params"` // 提取出的參數，如 pattern, action
-	Reply  string                 `json:"reply"`  // AI 給用戶的直接回覆內容
+	Intent string                 `json:"intent" jsonschema:"enum=SET_FILTER|CHAT|TOOL_USE"` // 例如: SET_FILTER, CHAT, UNKNOWN
+	Params map[string]interface{} `json:"params"`                                            // 提取出的參數，如 pattern, action
+	Reply  string                 `json:"reply"`                                             // AI 給用戶的直接回覆內容
 }
 
 type ContactInfo struct {
@@ -129,32 +129,14 @@ func (b *PCAIBrain) analyzeIntentWithOllama(ctx context.Context, userInput strin
 	}
 	formattedPrompt := fmt.Sprintf(systemPrompt, runtime.GOOS, toolPrompt, userInput)
 
-	// 呼叫 LLM (使用設定的 Provider)
-	response, err := b.AskLLM(ctx, formattedPrompt)
+	// 呼叫 LLM (使用設定的 Provider，結構化輸出並驗證)
+	// 沿用原本的 temperature 0.3 (heartbeat Profile)；可在 profiles.yaml 以 bindings.intent 改用其他 Profile
+	intent, err := llms.ChatJSON[IntentResponse](ctx, llms.GetDefaultChatStream(), b.modelName, []ollama.Message{
+		{Role: "user", Content: formattedPrompt},
+	}, llms.ProfileFor("intent", llms.ProfileHeartbeat))
 	if err != nil {
-		return nil, err
-	}
-
-	var result struct{ Response string }
-	result.Response = strings.TrimSpace(response)
-
-	// 嘗試從文字中提取 JSON
-	jsonStr := result.Response
-	startIdx := strings.Index(jsonStr, "{")
-	endIdx := strings.LastIndex(jsonStr, "}")
-	if startIdx != -1 && endIdx != -1 && endIdx > startIdx {
-		jsonStr = jsonStr[startIdx : endIdx+1]
-	}
-
-	// 解析 LLM 的 JSON 回覆
-	var intent IntentResponse
-	if err := json.Unmarshal([]byte(jsonStr), &intent); err != nil {
-		runes := []rune(result.Response)
-		if len(runes) > 100 {
-			runes = runes[:100]
-		}
-		fmt.Printf("⚠️ 解析意圖失敗: %v\n原始回覆:\n%s...\n", err, string(runes))
-		return nil, fmt.Errorf("解析意圖失敗: %v", err)
+		fmt.Printf("⚠️ 解析意圖失敗: %v\n", err)
+		return nil, fmt.Errorf("解析意圖失敗: %w", err)
 	}
 
 	return &intent, nil
@@ -215,6 +197,7 @@ func (b *PCAIBrain) CollectEnv(ctx context.Context) string {
 // 2. 決策與自我學習 (Logic Path)
 // ---------------------------------------------------------
 func (b *PCAIBrain) Think(ctx context.Context, snapshot string) (string, error) {
+	ctx = llms.WithUsageTags(ctx, llms.UsageTags{Channel: "background", Workflow: "heartbeat"})
 	// 心跳邏輯的 Prompt
 	prompt := fmt.Sprintf(`
 你現在是 PCAI 自動化決策大腦。請分析以下環境快照並給出 JSON 格式的決策。
@@ -236,43 +219,17 @@ func (b *PCAIBrain) Think(ctx context.Context, snapshot string) (string, error)
 
 	fmt.Printf("[Brain] 正在思考決策... \n內容:\n%s\n", snapshot)
 
-	// 真正呼叫 LLM (使用設定的 Provider)
-	response, err := b.AskLLM(ctx, prompt)
+	// 真正呼叫 LLM (使用設定的 Provider，結構化輸出並驗證)
+	dec, err := llms.ChatJSON[HeartbeatDecision](ctx, llms.GetDefaultChatStream(), b.modelName, []ollama.Message{
+		{Role: "user", Content: prompt},
+	}, llms.ProfileOptions(llms.ProfileHeartbeat))
 	if err != nil {
-		return "", fmt.Errorf("LLM 連線失敗: %w", err)
-	}
-
-	var result struct{ Response string }
-	result.Response = response
-
-	// 3. 清理回傳字串（移除 AI 可能多加的空格或換行）
-	decision := strings.TrimSpace(result.Response)
-	if decision == "" {
-		return "", fmt.Errorf("Ollama 回傳內容為空")
-	}
-
-	// 嘗試從可能包有 markdown 或多餘對話的文字中提取 JSON
-	jsonStr := decision
-	startIdx := strings.Index(jsonStr, "{")
-	endIdx := strings.LastIndex(jsonStr, "}")
-	if startIdx != -1 && endIdx != -1 && endIdx > startIdx {
-		jsonStr = jsonStr[startIdx : endIdx+1]
-	}
-
-	// 解析 JSON 結果
-	var dec HeartbeatDecision
-	if err := json.Unmarshal([]byte(jsonStr), &dec); err != nil {
-		// 容錯：如果是因為超時或其他原因導致回傳了非 JSON 字串 (例如 HTML 錯誤頁面)
-		// 我們記錄錯誤但不讓程式崩潰 (雖然這裡 return err 會被上層 recover，或是 log print)
-		runes := []rune(decision)
-		if len(runes) > 50 {
-			runes = runes[:50]
-		}
-		return "", fmt.Errorf("解析決策 JSON 失敗: %v (原始內容: %s...)", err, string(runes))
+		return "", fmt.Errorf("取得決策失敗: %w", err)
 	}
+	raw, _ := json.Marshal(dec)
 
 	// 核心：將思考過程存入資料庫
-	b.DB.CreateHeartbeatLog(ctx, snapshot, dec.Decision, dec.Reason, dec.Score, result.Response)
+	b.DB.CreateHeartbeatLog(ctx, snapshot, dec.Decision, dec.Reason, dec.Score, string(raw))
 
 	// 我們將決策與理由組合成一個字串回傳給 ExecuteDecision，或者修改 interface 傳遞 struct
 	// 這裡採用簡單的格式化回傳，方便 ExecuteDecision 處理
@@ -426,9 +383,9 @@ func (b *PCAIBrain) ExecuteDecision(ctx context.Context, decisionStr string, sna
 func (b *PCAIBrain) AskLLM(ctx context.Context, prompt string) (string, error) {
 	var sb strings.Builder
 	chatFn := llms.GetDefaultChatStream()
-	_, err := chatFn(b.modelName, []ollama.Message{
+	_, err := chatFn(ctx, b.modelName, []ollama.Message{
 		{Role: "user", Content: prompt},
-	}, nil, ollama.Options{Temperature: 0.3}, func(c string) { sb.WriteString(c) })
+	}, nil, llms.ProfileOptions(llms.ProfileHeartbeat), func(c string) { sb.WriteString(c) })
 	if err != nil {
 		return "", fmt.Errorf("LLM 請求失敗: %w", err)
 	}
@@ -441,6 +398,7 @@ func (b *PCAIBrain) AskOllama(ctx context.Context, prompt string) (string, error
 }
 
 func (b *PCAIBrain) GenerateMorningBriefing(ctx context.Context) error {
+	ctx = llms.WithUsageTags(ctx, llms.UsageTags{Channel: "background", Workflow: "morning_briefing"})
 	// 1. 撈取昨晚 23:00 以後的日誌
 	// 這裡建議在資料庫增加一個 is_briefed 欄位來過濾
 	query := `SELECT id, snapshot, reason FROM heartbeat_logs 
@@ -513,6 +471,7 @@ func (b *PCAIBrain) GenerateMorningBriefing(ctx context.Context) error {
 
 // RunSelfTest 執行系統自我檢測
 func (b *PCAIBrain) RunSelfTest(ctx context.Context) error {
+	ctx = llms.WithUsageTags(ctx, llms.UsageTags{Channel: "background", Workflow: "self_test"})
 	fmt.Println("🛠️ [SelfTest] Starting daily system self-test...")
 
 	// 1. Database Check
@@ -611,6 +570,7 @@ func (b *PCAIBrain) RunSelfTest(ctx context.Context) error {
 
 // RunPatrol 執行閒置時的背景巡邏，讀取 HEARTBEAT.md 的指令並啟動一個 Agent 流程來執行 Tool Calls
 func (b *PCAIBrain) RunPatrol(ctx context.Context) error {
+	ctx = llms.WithUsageTags(ctx, llms.UsageTags{Channel: "background", Workflow: "patrol"})
 	home, _ := os.Getwd()
 	data, err := os.ReadFile(filepath.Join(home, "botcharacter", "HEARTBEAT.md"))
 	if err != nil {
@@ -633,13 +593,15 @@ func (b *PCAIBrain) RunPatrol(ctx context.Context) error {
 
 	// 建立背景 Agent (不需 Logger 避免洗版)
 	myAgent := agent.NewAgent(b.modelName, systemPrompt, sess, registry, nil)
+	myAgent.UseProfile("patrol")
+	myAgent.Channel = "heartbeat"
 
 	fmt.Println("🕵️ [Heartbeat] 啟動背景巡邏 (Patrol)...")
 
 	// 我們加上 "SILENT" 短語的預防針在輸入中，這樣如果 AI 決定不要回報任何事情，它就只會輸出 SILENT
 	input := fmt.Sprintf("開始執行 Heartbeat 巡邏指令。現在時間是: %s。\n請嚴格遵守執行原則。如果你判斷不需要主動通知我任何事（例如現在是深夜勿擾時間，或者無任何異常），請只回答 'SILENT'。", time.Now().Format("2006-01-02 15:04:05"))
 
-	response, err := myAgent.Chat(input, nil)
+	response, err := myAgent.Chat(ctx, input, nil)
 	if err != nil {
 		return fmt.Errorf("巡邏執行錯誤: %w", err)
 	}
@@ -665,10 +627,11 @@ func (b *PCAIBrain) RunPatrol(ctx context.Context) error {
 			recoverySess.Messages = append(recoverySess.Messages, ollama.Message{Role: "system", Content: systemPrompt})
 
 			recoveryAgent := agent.NewAgent(b.modelName, systemPrompt, recoverySess, registry, nil)
+			recoveryAgent.Channel = "heartbeat"
 
 			// 給 Recovery Agent 注入恢復指令
 			recoveryInput := fmt.Sprintf("系統偵測到未完成的任務計畫，請繼續執行。\n\n%s", resumeHint)
-			recoveryResp, err := recoveryAgent.Chat(recoveryInput, nil)
+			recoveryResp, err := recoveryAgent.Chat(ctx, recoveryInput, nil)
 			if err != nil {
 				fmt.Printf("⚠️ [Heartbeat] 任務恢復執行失敗: %v\n", err)
 			} else {
@@ -33,6 +33,7 @@ func NewActiveBuffer(limit int, logger *DailyLogger) *ActiveBuffer {
 func (b *ActiveBuffer) Add(msg ollama.Message) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	msg.Content = ollama.StripThinking(msg.Content) // 推理內容不進入歸納
 	b.Messages = append(b.Messages, msg)
 }
 
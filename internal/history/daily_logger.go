@@ -30,6 +30,9 @@ func NewDailyLogger(workspaceDir string) *DailyLogger {
 
 // Record 記錄一條訊息到每日日誌 (YYYY-MM-DD.json)
 func (l *DailyLogger) Record(msg ollama.Message) error {
+	// 推理內容不寫入日誌
+	msg.Content = ollama.StripThinking(msg.Content)
+
 	// 噪音過濾
 	opts := memory.DefaultNoiseFilterOptions()
 	if memory.IsNoise(msg.Content, opts) {
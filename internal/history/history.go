@@ -1,6 +1,7 @@
 package history
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -46,7 +47,7 @@ func ListHistory() {
 // CheckAndSummarize 執行閒置歸納邏輯 (RAG 核心)
 // 如果最後更新時間超過一小時，則進行歸納並清理 Session
 // [Refactor] Now also triggers Memory Skills via Controller
-func CheckAndSummarize(s *Session, modelName string, systemPrompt string) {
+func CheckAndSummarize(ctx context.Context, s *Session, modelName string, systemPrompt string) {
 	if s == nil || len(s.Messages) < 2 {
 		return
 	}
@@ -76,9 +77,10 @@ func CheckAndSummarize(s *Session, modelName string, systemPrompt string) {
 		var summaryResult strings.Builder
 
 		// 呼叫 LLM 進行歸納 (使用較低的 Temperature 確保穩定)
-		opts := ollama.Options{Temperature: 0.3, TopP: 0.9}
+		opts := llms.ProfileOptions(llms.ProfileSummarize)
 		chatFn := llms.GetDefaultChatStream()
-		_, err := chatFn(modelName, []ollama.Message{
+		ctx = llms.WithUsageTags(ctx, llms.UsageTags{SessionID: s.ID, Workflow: "summarize"})
+		_, err := chatFn(ctx, modelName, []ollama.Message{
 			{Role: "system", Content: "你是一個知識萃取專家"},
 			{Role: "user", Content: summaryPrompt},
 		}, nil, opts, func(c string) {
@@ -106,7 +108,8 @@ func sessionToText(s *Session) string {
 		if m.Role == "system" {
 			continue
 		}
-		sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
+		// 舊 Session 可能仍夾帶 <think> 區塊，推理內容不歸納進知識庫
+		sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, ollama.StripThinking(m.Content)))
 	}
 	return sb.String()
 }
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/asccclass/pcai/internal/database"
+	"github.com/asccclass/pcai/llms"
+	"github.com/asccclass/pcai/llms/ollama"
 )
 
 // PersonalizationWorker 負責背景分析對話日誌以提取用戶偏好
@@ -17,21 +19,34 @@ type PersonalizationWorker struct {
 	WorkspaceDir string
 	DB           *database.DB
 	ModelName    string
-	AnalyzeFunc  func(model string, prompt string) (string, error)
+	ChatFn       llms.ChatStreamFunc
+}
+
+// PersonalizationItem 是 LLM 從對話中萃取出的一筆偏好或實體
+type PersonalizationItem struct {
+	Category string `json:"category" jsonschema:"enum=preference|entity"`
+	Key      string `json:"key" jsonschema:"description=英文標識"`
+	Value    string `json:"value"`
+	Tags     string `json:"tags" jsonschema:"description=逗號分隔的標籤"`
+}
+
+// PersonalizationResult 是萃取結果 (結構化輸出的根節點必須是物件)
+type PersonalizationResult struct {
+	Items []PersonalizationItem `json:"items"`
 }
 
 // NewPersonalizationWorker 建立新的背景工作者
-func NewPersonalizationWorker(workspaceDir string, db *database.DB, model string, analyzeFunc func(model string, prompt string) (string, error)) *PersonalizationWorker {
+func NewPersonalizationWorker(workspaceDir string, db *database.DB, model string, chatFn llms.ChatStreamFunc) *PersonalizationWorker {
 	return &PersonalizationWorker{
 		WorkspaceDir: workspaceDir,
 		DB:           db,
 		ModelName:    model,
-		AnalyzeFunc:  analyzeFunc,
+		ChatFn:       chatFn,
 	}
 }
 
 // RunOnce 執行一次分析任務
-func (w *PersonalizationWorker) RunOnce() error {
+func (w *PersonalizationWorker) RunOnce(ctx context.Context) error {
 	historyDir := filepath.Join(w.WorkspaceDir, "history")
 	files, err := os.ReadDir(historyDir)
 	if err != nil {
@@ -57,7 +72,7 @@ func (w *PersonalizationWorker) RunOnce() error {
 			continue
 		}
 
-		if err := w.analyzeFile(filepath.Join(historyDir, file.Name())); err != nil {
+		if err := w.analyzeFile(ctx, filepath.Join(historyDir, file.Name())); err != nil {
 			fmt.Fprintf(os.Stderr, "⚠️ [Personalization] 分析檔案失敗 %s: %v\n", file.Name(), err)
 		}
 	}
@@ -65,7 +80,7 @@ func (w *PersonalizationWorker) RunOnce() error {
 	return nil
 }
 
-func (w *PersonalizationWorker) analyzeFile(path string) error {
+func (w *PersonalizationWorker) analyzeFile(ctx context.Context, path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -87,42 +102,28 @@ func (w *PersonalizationWorker) analyzeFile(path string) error {
 	}
 
 	prompt := fmt.Sprintf(`請分析以下對話紀錄，提取「用戶偏好 (Preferences)」與「關鍵實體 (Key Entities)」。
-請以 JSON 陣列格式輸出，每個物件包含 category (名稱為 preference 或 entity), key (英文標識), value (具體內容), tags (逗號分隔的標籤)。
-範例：[{"category": "preference", "key": "language", "value": "繁體中文", "tags": "ui,communication"}]
-如果沒有提取到任何內容，請回傳空陣列 []。不要包含任何 Markdown 標記，直接輸出 JSON。
+請以 JSON 物件格式輸出，items 陣列中每個物件包含 category (名稱為 preference 或 entity), key (英文標識), value (具體內容), tags (逗號分隔的標籤)。
+範例：{"items": [{"category": "preference", "key": "language", "value": "繁體中文", "tags": "ui,communication"}]}
+如果沒有提取到任何內容，請回傳 {"items": []}。不要包含任何 Markdown 標記，直接輸出 JSON。
 
 對話紀錄：
 %s`, sb.String())
 
-	resultStr, err := w.AnalyzeFunc(w.ModelName, prompt)
+	result, err := llms.ChatJSON[PersonalizationResult](ctx, w.ChatFn, w.ModelName, []ollama.Message{
+		{Role: "system", Content: "你是一個個性化分析專家。"},
+		{Role: "user", Content: prompt},
+	}, llms.ProfileFor("personalization_extraction", llms.ProfileSummarize))
 	if err != nil {
-		return err
-	}
-
-	// 解析 AI 回傳的 JSON (簡單清理 markdown 標籤)
-	cleanJSON := strings.TrimSpace(resultStr)
-	if idx := strings.Index(cleanJSON, "["); idx != -1 {
-		if lastIdx := strings.LastIndex(cleanJSON, "]"); lastIdx != -1 {
-			cleanJSON = cleanJSON[idx : lastIdx+1]
+		if ctx.Err() != nil {
+			return err
 		}
-	}
-
-	var extraction []struct {
-		Category string `json:"category"`
-		Key      string `json:"key"`
-		Value    string `json:"value"`
-		Tags     string `json:"tags"`
-	}
-
-	if err := json.Unmarshal([]byte(cleanJSON), &extraction); err != nil {
 		// Log error but don't fail entire file
-		fmt.Fprintf(os.Stderr, "⚠️ [Personalization] JSON 解析失敗: %v\n原始文字: %s\n", err, cleanJSON)
+		fmt.Fprintf(os.Stderr, "⚠️ [Personalization] JSON 解析失敗: %v\n", err)
 		return nil
 	}
 
 	// 存入資料庫
-	ctx := context.Background()
-	for _, item := range extraction {
+	for _, item := range result.Items {
 		if err := w.DB.AddPermanentMemory(ctx, item.Category, item.Key, item.Value, item.Tags); err != nil {
 			fmt.Fprintf(os.Stderr, "⚠️ [Personalization] 存入資料庫失敗: %v\n", err)
 		}
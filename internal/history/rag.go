@@ -1,6 +1,7 @@
 package history
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -51,7 +52,7 @@ func ClearKnowledgeBase() error {
 }
 
 // GetSummaryFromAI 呼叫 AI 進行單次總結 (用於存檔前的手動呼叫或自動排程)
-func GetSummaryFromAI(modelName string, messages []ollama.Message) (string, error) {
+func GetSummaryFromAI(ctx context.Context, modelName string, messages []ollama.Message) (string, error) {
 	if len(messages) == 0 {
 		return "", fmt.Errorf("沒有對話紀錄可以歸納")
 	}
@@ -61,17 +62,18 @@ func GetSummaryFromAI(modelName string, messages []ollama.Message) (string, erro
 		if m.Role == "system" {
 			continue
 		}
-		sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
+		sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, ollama.StripThinking(m.Content)))
 	}
 
 	prompt := "請將上述對話內容精煉成 3 個重點，使用 Markdown 列表格式輸出。只回傳列表內容，不要有額外開場白。"
 
 	var summaryResult strings.Builder
 	chatFn := llms.GetDefaultChatStream()
-	_, err := chatFn(modelName, []ollama.Message{
+	ctx = llms.WithUsageTags(ctx, llms.UsageTags{Workflow: "summarize"})
+	_, err := chatFn(ctx, modelName, []ollama.Message{
 		{Role: "system", Content: "你是一個專業的資料歸納員"},
 		{Role: "user", Content: "對話內容如下：\n" + sb.String() + "\n\n" + prompt},
-	}, nil, ollama.Options{Temperature: 0.1}, func(c string) {
+	}, nil, llms.ProfileOptions(llms.ProfileExtraction), func(c string) {
 		// 歸納時通常不顯示在 UI 上，僅收集結果
 		summaryResult.WriteString(c)
 	})
@@ -316,6 +316,11 @@ func cosineSimilarity(a, b []float32) float64 {
 	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
 }
 
+// CosineSimilarity 計算兩個向量的餘弦相似度 (供 Agent 的工具挑選等其他模組使用)
+func CosineSimilarity(a, b []float32) float64 {
+	return cosineSimilarity(a, b)
+}
+
 // cjkSpaced 將字串中的 CJK 字元（漢字、平假名、片假名、韓文）前後補上空白，使其在 SQLite unicode61 下能被獨立切分為 Token
 func cjkSpaced(s string) string {
 	var b strings.Builder
@@ -263,6 +263,11 @@ func (m *Manager) SetEmbedder(e EmbeddingProvider) {
 	m.embedder = e
 }
 
+// Embedder 回傳目前的 Embedding Provider (未設定時為 nil)
+func (m *Manager) Embedder() EmbeddingProvider {
+	return m.embedder
+}
+
 // dbPath SQLite 存儲路徑
 func (m *Manager) dbPath() string {
 	storePath := m.cfg.Search.Store.Path
@@ -14,11 +14,12 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/toolmw"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
@@ -33,9 +34,14 @@ type SkillDefinition struct {
 	Description   string                       `yaml:"description"`
 	Command       string                       `yaml:"command"`        // 入口指令 (e.g. "python main.py {{args}}")
 	Image         string                       `yaml:"image"`          // Docker Image (e.g. "python:3.9-slim"), 若為空則使用本地 Shell
-	CacheDuration string                       `yaml:"cache_duration"` // 支援快取時間設定 (e.g. "3h", "10m")
+	CacheDuration string                       `yaml:"cache_duration"` // 支援快取時間設定 (e.g. "3h", "10m")，等同 middleware.cache
+	Middleware    toolmw.Policy                `yaml:"middleware"`     // 工具中介層設定 (retry, cache, rate, redact, slow)
 	Options       map[string][]string          `yaml:"options"`        // 參數選項 (param -> [option1, option2])
 	OptionAliases map[string]map[string]string `yaml:"option_aliases"` // 參數別名 (param -> {alias: canonical_value})
+	ParallelSafe  bool                         `yaml:"parallel_safe"`  // 沒有副作用，可與其他工具同時執行
+	Risk          string                       `yaml:"risk"`           // 風險等級 (low, medium, high)，達到門檻時需經使用者核准
+	RiskWhen      map[string][]string          `yaml:"risk_when"`      // 只有參數符合時才套用 Risk (e.g. mode: [delete])
+	Triggers      []string                     `yaml:"triggers"`       // 觸發詞，使用者輸入命中時提示模型使用此技能 (e.g. [匯率, 換算美金])
 	Params        []string                     `yaml:"-"`              // 從 Command 解析出的參數參數名 (e.g. "query", "args")
 	RepoPath      string                       `yaml:"-"`              // 本地代碼路徑 (包含 SKILL.md 的目錄)
 }
@@ -116,126 +122,137 @@ func ParseParams(cmd string) []string {
 	return params
 }
 
-// min 回傳三個整數中的最小值
-func min(a, b, c int) int {
-	if a < b {
-		if a < c {
-			return a
-		}
-		return c
-	}
-	if b < c {
-		return b
-	}
-	return c
+// DynamicTool 實作 core.AgentTool 介面
+type DynamicTool struct {
+	Def          *SkillDefinition
+	Registry     *core.Registry
+	DockerClient *client.Client // 支援 Docker 執行
 }
 
-// levenshtein 計算兩個字串的編輯距離
-func levenshtein(s1, s2 string) int {
-	r1, r2 := []rune(s1), []rune(s2)
-	len1, len2 := len(r1), len(r2)
-
-	matrix := make([][]int, len1+1)
-	for i := range matrix {
-		matrix[i] = make([]int, len2+1)
+func NewDynamicTool(def *SkillDefinition, registry *core.Registry, dockerCli *client.Client) *DynamicTool {
+	return &DynamicTool{
+		Def:          def,
+		Registry:     registry,
+		DockerClient: dockerCli,
 	}
+}
 
-	for i := 0; i <= len1; i++ {
-		matrix[i][0] = i
-	}
-	for j := 0; j <= len2; j++ {
-		matrix[0][j] = j
-	}
+func (t *DynamicTool) Name() string {
+	// 將名稱轉為 snake_case 符合工具命名慣例 (e.g. GoogleSearch -> google_search)
+	// 這裡簡單轉小寫並把空白換底線
+	return strings.ToLower(strings.ReplaceAll(t.Def.Name, " ", "_"))
+}
 
-	for i := 1; i <= len1; i++ {
-		for j := 1; j <= len2; j++ {
-			cost := 0
-			if r1[i-1] != r2[j-1] {
-				cost = 1
-			}
-			matrix[i][j] = min(
-				matrix[i-1][j]+1,      // deletion
-				matrix[i][j-1]+1,      // insertion
-				matrix[i-1][j-1]+cost, // substitution
-			)
-		}
-	}
-	return matrix[len1][len2]
+func (t *DynamicTool) IsSkill() bool {
+	return true
 }
 
-// findClosestMatch 在選項列表中尋找最接近的匹配
-func findClosestMatch(input string, options []string) (string, bool) {
-	bestMatch := ""
-	minDist := 1000 // 任意大數
+// paramNameAliases 常見語義別名：LLM 可能傳 "city"，但 SKILL.md 定義的參數是 "location"
+var paramNameAliases = map[string]string{
+	"city":     "location",
+	"place":    "location",
+	"area":     "location",
+	"region":   "location",
+	"地區":       "location",
+	"城市":       "location",
+	"地點":       "location",
+	"keyword":  "query",
+	"keywords": "query",
+	"search":   "query",
+	"q":        "query",
+	"關鍵字":      "query",
+}
 
-	// 1. 先嘗試精確匹配 (Case Indifferent)
-	for _, opt := range options {
-		if strings.EqualFold(input, opt) {
-			return opt, true
-		}
+// NormalizeArgs 在 Registry 的 Schema 驗證前，將未知參數名稱映射到已定義的參數，
+// 並依 option_aliases 把別名換成標準值 (例如 "信義區" -> "臺北市")；型別、預設值與選項校正由驗證處理
+func (t *DynamicTool) NormalizeArgs(args map[string]any) []string {
+	var fixes []string
+	knownParams := make(map[string]bool)
+	for _, p := range t.Def.Params {
+		knownParams[p] = true
 	}
 
-	// 2. 嘗試模糊匹配
-	for _, opt := range options {
-		dist := levenshtein(input, opt)
-		if dist < minDist {
-			minDist = dist
-			bestMatch = opt
+	for k, v := range args {
+		if knownParams[k] {
+			continue
 		}
-	}
+		delete(args, k)
 
-	// 設定閾值：對於短字串(如地名)，允許編輯距離
-	// 例如：台北市 (3 chars) -> 臺北市 (3 chars), dist=1 (台!=臺)
-	// 林口 (2 chars) -> 宜蘭 (2 chars), dist=2 -> 不應匹配
-	threshold := 2
-	inputLen := len([]rune(input))
-	if inputLen <= 2 {
-		threshold = 1 // 2字以下只允許錯1字 (e.g. 台南->臺南)
-	} else if inputLen > 4 {
-		threshold = 3
+		// 嘗試透過語義別名映射；沒命中且只有一個已知參數時，自動映射到那個參數
+		target := paramNameAliases[strings.ToLower(k)]
+		if !knownParams[target] && len(t.Def.Params) == 1 {
+			target = t.Def.Params[0]
+		}
+		if _, exists := args[target]; knownParams[target] && !exists {
+			args[target] = v
+			fixes = append(fixes, fmt.Sprintf("參數名稱 %s -> %s", k, target))
+		}
+		// 其他未知參數（如 "date"）直接丟棄，不影響執行
 	}
 
-	if minDist <= threshold {
-		return bestMatch, true
+	for k, aliases := range t.Def.OptionAliases {
+		valStr, ok := args[k].(string)
+		if !ok || len(aliases) == 0 || slices.Contains(t.Def.Options[k], valStr) {
+			continue
+		}
+		aliasKeys := make([]string, 0, len(aliases))
+		for alias := range aliases {
+			aliasKeys = append(aliasKeys, alias)
+		}
+		if match, found := core.ClosestMatch(valStr, aliasKeys); found {
+			args[k] = aliases[match]
+			fixes = append(fixes, fmt.Sprintf("%s: %q (別名 %s) -> %q", k, valStr, match, aliases[match]))
+		}
 	}
-
-	return "", false
+	return fixes
 }
 
-// CacheEntry 快取項目
-type CacheEntry struct {
-	Response  string
-	ExpiresAt time.Time
+// ArgDefaults 有定義 options 的參數以第一個選項為預設值；不替其他參數 (例如日期) 捏造值
+func (t *DynamicTool) ArgDefaults() map[string]any {
+	defaults := make(map[string]any)
+	for p, opts := range t.Def.Options {
+		if len(opts) > 0 {
+			defaults[p] = opts[0]
+		}
+	}
+	return defaults
 }
 
-// DynamicTool 實作 core.AgentTool 介面
-type DynamicTool struct {
-	Def          *SkillDefinition
-	Registry     *core.Registry
-	DockerClient *client.Client // 支援 Docker 執行
-
-	// 快取相關
-	Cache      map[string]CacheEntry
-	CacheMutex sync.RWMutex
+// MiddlewarePolicy 回傳 SKILL.md 宣告的中介層設定 (結果快取由 toolmw 處理，cache_duration 對應 middleware.cache)
+func (t *DynamicTool) MiddlewarePolicy() toolmw.Policy {
+	p := t.Def.Middleware
+	if p.Cache == nil && t.Def.CacheDuration != "" {
+		if d, err := time.ParseDuration(t.Def.CacheDuration); err == nil {
+			cache := toolmw.Duration(d)
+			p.Cache = &cache
+		} else {
+			fmt.Printf("⚠️ [DynamicTool] Invalid cache_duration format: %s\n", t.Def.CacheDuration)
+		}
+	}
+	return p
 }
 
-func NewDynamicTool(def *SkillDefinition, registry *core.Registry, dockerCli *client.Client) *DynamicTool {
-	return &DynamicTool{
-		Def:          def,
-		Registry:     registry,
-		DockerClient: dockerCli,
-		Cache:        make(map[string]CacheEntry),
-	}
+// ParallelSafe 由 SKILL.md 的 parallel_safe 宣告是否可與其他工具同時執行
+func (t *DynamicTool) ParallelSafe() bool {
+	return t.Def.ParallelSafe
 }
 
-func (t *DynamicTool) Name() string {
-	// 將名稱轉為 snake_case 符合工具命名慣例 (e.g. GoogleSearch -> google_search)
-	// 這裡簡單轉小寫並把空白換底線
-	return strings.ToLower(strings.ReplaceAll(t.Def.Name, " ", "_"))
+// Risk 由 SKILL.md 的 risk 與 risk_when 宣告風險等級
+func (t *DynamicTool) Risk(argsJSON string) core.RiskLevel {
+	level, err := core.ParseRiskLevel(t.Def.Risk)
+	if err != nil {
+		// 寫錯的等級以最高風險處理，避免意外略過核准
+		return core.RiskHigh
+	}
+	if !core.ArgsMatch(argsJSON, t.Def.RiskWhen) {
+		return core.RiskNone
+	}
+	return level
 }
 
-func (t *DynamicTool) IsSkill() bool {
-	return true
+// Triggers 由 SKILL.md 的 triggers 宣告觸發詞，供 Agent 的意圖規則使用
+func (t *DynamicTool) Triggers() []string {
+	return t.Def.Triggers
 }
 
 func (t *DynamicTool) Definition() api.Tool {
@@ -258,7 +275,9 @@ func (t *DynamicTool) Definition() api.Tool {
 		}
 
 		propsMap[p] = paramSchema
-		required = append(required, p)
+		if isStandaloneParam(t.Def.Command, p) {
+			required = append(required, p)
+		}
 	}
 
 	// 透過 JSON轉換 來產生 api.ToolPropertiesMap，避免內部型別不一致的問題
@@ -280,6 +299,13 @@ func (t *DynamicTool) Definition() api.Tool {
 	}
 }
 
+// isStandaloneParam 判斷參數是否以獨立 token 出現在指令中 (例如 --from {{from}})；
+// 這類參數缺少時指令無法執行，視為必要參數，其他 (例如 --cal={{cal}}) 未提供時以空字串替換
+func isStandaloneParam(command, param string) bool {
+	re := regexp.MustCompile(`(^|\s)\{\{(?:url:)?` + regexp.QuoteMeta(param) + `\}\}(\s|$)`)
+	return re.MatchString(command)
+}
+
 // getStringValue extracts string from potential complex structures
 func getStringValue(v interface{}) string {
 	switch val := v.(type) {
@@ -296,6 +322,11 @@ func getStringValue(v interface{}) string {
 }
 
 func (t *DynamicTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+// RunContext 執行技能：Shell 指令與 Docker 容器都會隨 ctx 取消或逾時而終止
+func (t *DynamicTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	debug := false
 	if os.Getenv("Debug_Info") == "true" {
 		debug = true
@@ -305,143 +336,7 @@ func (t *DynamicTool) Run(argsJSON string) (string, error) {
 	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
 		return "", fmt.Errorf("解析參數失敗: %v", err)
 	}
-
-	// 1.5 [PARAM NAME NORMALIZATION] 將 LLM 傳來的未知參數名映射到已定義的參數名
-	// 例如 LLM 可能傳 "city" 但 SKILL.md 定義的參數是 "location"
-	knownParams := make(map[string]bool)
-	for _, p := range t.Def.Params {
-		knownParams[p] = true
-	}
-
-	// 常見語義別名映射 (param name aliases)
-	paramNameAliases := map[string]string{
-		"city":     "location",
-		"place":    "location",
-		"area":     "location",
-		"region":   "location",
-		"地區":       "location",
-		"城市":       "location",
-		"地點":       "location",
-		"keyword":  "query",
-		"keywords": "query",
-		"search":   "query",
-		"q":        "query",
-		"關鍵字":      "query",
-	}
-
-	normalizedArgs := make(map[string]interface{})
-	for k, v := range args {
-		if knownParams[k] {
-			// 已知參數，直接使用
-			normalizedArgs[k] = v
-			continue
-		}
-
-		// 嘗試透過語義別名映射
-		mapped := false
-		if alias, ok := paramNameAliases[strings.ToLower(k)]; ok && knownParams[alias] {
-			if _, exists := normalizedArgs[alias]; !exists {
-				fmt.Printf("🔄 [DynamicTool] Param name mapping: '%s' → '%s'\n", k, alias)
-				normalizedArgs[alias] = v
-				mapped = true
-			}
-		}
-
-		// 如果別名沒命中，且只有一個已知參數，就自動映射到那個參數
-		if !mapped && len(t.Def.Params) == 1 {
-			target := t.Def.Params[0]
-			if _, exists := normalizedArgs[target]; !exists {
-				fmt.Printf("🔄 [DynamicTool] Auto-mapping unknown param '%s' → '%s' (single param skill)\n", k, target)
-				normalizedArgs[target] = v
-			}
-		}
-		// 其他未知參數（如 "date"）直接丟棄，不影響執行
-	}
-
-	// Only use explicit option defaults. Do not invent missing dates or other values.
-	for _, p := range t.Def.Params {
-		if _, exists := normalizedArgs[p]; !exists {
-			if opts, ok := t.Def.Options[p]; ok && len(opts) > 0 {
-				fmt.Printf("⚠️ [DynamicTool] Missing param '%s', using default: '%s'\n", p, opts[0])
-				normalizedArgs[p] = opts[0]
-			}
-		}
-	}
-
-	args = normalizedArgs
-
-	// 1.5 [VALIDATION & AUTO-CORRECTION] 參數驗證與自動校正
-	for k, v := range args {
-		// [FIX] Handle complex value types (e.g. {"type":"string", "value":"..."})
-		valStr := getStringValue(v)
-
-		// 1.5.1 檢查是否有別名 (Alias) 設定
-		if aliases, ok := t.Def.OptionAliases[k]; ok && len(aliases) > 0 {
-			// 建立別名清單以供 fuzzy match
-			aliasKeys := make([]string, 0, len(aliases))
-			for alias := range aliases {
-				aliasKeys = append(aliasKeys, alias)
-			}
-
-			// 嘗試尋找別名匹配 (例如: "信義區" -> "臺北市")
-			match, found := findClosestMatch(valStr, aliasKeys)
-			if found {
-				canonical := aliases[match]
-				fmt.Printf("⚠️ [DynamicTool] Alias mapping: '%s' (matches alias '%s') -> '%s' (param: %s)\n", valStr, match, canonical, k)
-				valStr = canonical
-				args[k] = canonical // 更新為標準值，以便後續驗證與快取
-			}
-		}
-
-		// Update args with normalized string anyway, so subsequent logic sees string
-		args[k] = valStr
-
-		// 檢查該參數是否有定義選項
-		if opts, ok := t.Def.Options[k]; ok && len(opts) > 0 {
-			// 嘗試尋找最佳匹配
-			match, found := findClosestMatch(valStr, opts)
-			if found {
-				if match != valStr {
-					fmt.Printf("⚠️ [DynamicTool] Auto-correction: '%s' -> '%s' (param: %s)\n", valStr, match, k)
-					args[k] = match // 更新為正確的值
-				}
-			} else {
-				fmt.Printf("⚠️ [DynamicTool] Warning: Value '%s' for param '%s' is not in allowed options.\n", valStr, k)
-			}
-		}
-	}
-
-	// [CACHE CHECK] (搬移到參數校正之後，這樣 Cache Key 才是校正後的... 但 argsJSON 是原始的)
-	// 為了讓「錯字也能命中快取」，我們應該用「校正後的 args」來產 CacheKey。
-	// 所以我們重新 Marshal args
-	canonicalArgsJSON := argsJSON // 預設用原始的
-	if correctedArgsBytes, err := json.Marshal(args); err == nil {
-		canonicalArgsJSON = string(correctedArgsBytes)
-	}
-
-	// [CACHE CHECK] 如果有設定 CacheDuration，先檢查快取
-	var cacheDuration time.Duration
-	if t.Def.CacheDuration != "" {
-		if d, err := time.ParseDuration(t.Def.CacheDuration); err == nil {
-			cacheDuration = d
-			// 使用校正後的參數 JSON 作為快取 Key
-			cacheKey := canonicalArgsJSON
-
-			t.CacheMutex.RLock()
-			entry, exists := t.Cache[cacheKey]
-			t.CacheMutex.RUnlock()
-
-			if exists {
-				if time.Now().Before(entry.ExpiresAt) {
-					// 快取有效，直接回傳
-					fmt.Printf("[DynamicTool] Returning cached response for %s (Expires: %v)\n", t.Name(), entry.ExpiresAt.Format("15:04:05"))
-					return entry.Response, nil
-				}
-			}
-		} else {
-			fmt.Printf("⚠️ [DynamicTool] Invalid cache_duration format: %s\n", t.Def.CacheDuration)
-		}
-	}
+	// 經 Registry 呼叫時，參數已通過 Schema 驗證並由 NormalizeArgs 正規化
 
 	// 2. 替換指令中的變數 (支援 {{param}} 和 {{url:param}})
 	finalCmd := t.Def.Command
@@ -514,13 +409,12 @@ func (t *DynamicTool) Run(argsJSON string) (string, error) {
 
 				jsonParams := fmt.Sprintf(`{"url": "%s"}`, targetURL)
 				// Call 'web_fetch' tool (registered name)
-				result, executionErr = t.Registry.CallTool("web_fetch", jsonParams)
+				result, executionErr = t.Registry.CallToolContext(ctx, env, "web_fetch", jsonParams)
 			}
 		}
 	} else {
 		// --- Docker Execution (Sidecar Mode) ---
 		fmt.Printf("🚀 [DynamicSkill] Executing %s in Docker (Image: %s)...\n", t.Name(), t.Def.Image)
-		ctx := context.Background()
 
 		// 1. 檢查映像檔
 		_, _, err := t.DockerClient.ImageInspectWithRaw(ctx, t.Def.Image)
@@ -557,6 +451,11 @@ func (t *DynamicTool) Run(argsJSON string) (string, error) {
 			AutoRemove: false,                                          // 必須設為 false，否則執行完瞬間就被刪除，讀不到 logs
 			Resources:  container.Resources{Memory: 256 * 1024 * 1024}, // 256MB
 		}
+		// 有 Session 暫存目錄時掛載到 /scratch (可寫入)，讓技能保存中間檔案
+		if dir, err := env.Scratch(); err == nil {
+			hostConfig.Binds = append(hostConfig.Binds, fmt.Sprintf("%s:/scratch", dir))
+			containerConfig.Env = append(containerConfig.Env, "PCAI_SCRATCH=/scratch")
+		}
 
 		// 3. 建立並啟動
 		resp, err := t.DockerClient.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, "")
@@ -573,21 +472,28 @@ func (t *DynamicTool) Run(argsJSON string) (string, error) {
 			return "", fmt.Errorf("container start failed: %v", err)
 		}
 
-		// 4. 等待結果
-		statusCh, errCh := t.DockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+		// 4. 等待結果 (ctx 沒有期限時預設 60 秒)
+		timeout := 60 * time.Second
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		statusCh, errCh := t.DockerClient.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
 		select {
 		case err := <-errCh:
 			executionErr = fmt.Errorf("container error: %v", err)
 		case <-statusCh:
 			// Success, read logs
-		case <-time.After(60 * time.Second): // Timeout
-			_ = t.DockerClient.ContainerKill(ctx, resp.ID, "SIGKILL")
+		case <-ctx.Done():
+			_ = t.DockerClient.ContainerKill(context.Background(), resp.ID, "SIGKILL")
+			executionErr = fmt.Errorf("timeout")
+		case <-time.After(timeout): // Timeout
+			_ = t.DockerClient.ContainerKill(context.Background(), resp.ID, "SIGKILL")
 			executionErr = fmt.Errorf("timeout")
 		}
 
 		// 5. 讀取 Logs
 		if executionErr == nil || executionErr.Error() == "timeout" {
-			out, err := t.DockerClient.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+			out, err := t.DockerClient.ContainerLogs(context.Background(), resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
 			if err == nil {
 				defer out.Close()
 				var stdout, stderr bytes.Buffer
@@ -625,8 +531,10 @@ func (t *DynamicTool) Run(argsJSON string) (string, error) {
 		if debug {
 			fmt.Printf("🔧 [DynamicSkill] Executing shell command: cmd /C %s in %s\n", finalCmd, projectRoot)
 		}
-		cmd := exec.Command("cmd", "/C", finalCmd)
+		cmd := exec.CommandContext(ctx, "cmd", "/C", finalCmd)
 		cmd.Dir = projectRoot // 設定工作目錄為專案根目錄
+		// 有 Session 暫存目錄時以 PCAI_SCRATCH 提供給技能
+		cmd.Env = env.Environ()
 		/*
 			cmd.Env = append(os.Environ(), "PATH="+pathEnv)
 			// [FIX] 注入 ZONEINFO 以修復 Windows 上的時區解析問題
@@ -650,16 +558,6 @@ func (t *DynamicTool) Run(argsJSON string) (string, error) {
 		result = output
 	}
 
-	// [CACHE SET] 如果執行成功且需要快取，則寫入
-	if executionErr == nil && cacheDuration > 0 && result != "" {
-		t.CacheMutex.Lock()
-		t.Cache[canonicalArgsJSON] = CacheEntry{
-			Response:  result,
-			ExpiresAt: time.Now().Add(cacheDuration),
-		}
-		t.CacheMutex.Unlock()
-	}
-
 	if executionErr != nil {
 		// 將 stdout/stderr 併入錯誤訊息，讓 Agent 知道發生什麼事
 		if result != "" {
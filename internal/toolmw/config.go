@@ -0,0 +1,226 @@
+// Package toolmw 提供工具執行的中介層：重試、結果快取、速率限制與配額、輸出遮蔽、計時與短期記憶。
+//
+// 每個工具的設定依序合併：內建預設 -> 工具自行宣告 (例如 SKILL.md 的 middleware 與 cache_duration)
+// -> 設定檔 (PCAI_TOOL_MIDDLEWARE，預設 toolmiddleware.yaml) 的 defaults 與 tools.<工具名稱>。
+// 設定檔存檔後，下一次工具呼叫即自動重新載入。
+package toolmw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration 是可在 YAML 中寫成 "10m"、"1.5s" 的時間長度
+type Duration time.Duration
+
+// UnmarshalYAML 解析 time.ParseDuration 格式的字串
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("無效的時間長度 %q: %w", node.Value, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RetryPolicy 失敗時的重試設定
+type RetryPolicy struct {
+	Attempts   int      `yaml:"attempts"`    // 總執行次數 (含第一次)，1 代表不重試
+	Backoff    Duration `yaml:"backoff"`     // 第一次重試前的等待時間，之後每次加倍 (預設 500ms)
+	MaxBackoff Duration `yaml:"max_backoff"` // 等待時間上限 (預設 10s)
+}
+
+// RatePolicy 速率限制與每日配額
+type RatePolicy struct {
+	PerMinute int `yaml:"per_minute"` // 每分鐘最多呼叫次數，0 代表不限
+	PerDay    int `yaml:"per_day"`    // 每日配額 (跨重新啟動累計)，0 代表不限
+}
+
+// Policy 是單一工具的中介層設定，nil 欄位代表沿用上一層的設定
+type Policy struct {
+	Retry  *RetryPolicy `yaml:"retry"`
+	Cache  *Duration    `yaml:"cache"` // 相同參數的結果快取時間，0 代表不快取
+	Rate   *RatePolicy  `yaml:"rate"`
+	Redact *bool        `yaml:"redact"` // 遮蔽輸出中的密鑰與 Token
+	Slow   *Duration    `yaml:"slow"`   // 執行超過此時間時記錄警告，0 代表不記錄
+	Memory *string      `yaml:"memory"` // 成功的結果存入短期記憶的來源分類 (例如 weather)，空字串代表不存
+}
+
+// PolicyTool 是選用介面：工具自行宣告需要的中介層設定
+type PolicyTool interface {
+	MiddlewarePolicy() Policy
+}
+
+// merge 以 o 中有設定的欄位覆寫 p
+func (p Policy) merge(o Policy) Policy {
+	if o.Retry != nil {
+		p.Retry = o.Retry
+	}
+	if o.Cache != nil {
+		p.Cache = o.Cache
+	}
+	if o.Rate != nil {
+		p.Rate = o.Rate
+	}
+	if o.Redact != nil {
+		p.Redact = o.Redact
+	}
+	if o.Slow != nil {
+		p.Slow = o.Slow
+	}
+	if o.Memory != nil {
+		p.Memory = o.Memory
+	}
+	return p
+}
+
+// Config 是中介層設定檔
+type Config struct {
+	Defaults Policy            `yaml:"defaults"`
+	Tools    map[string]Policy `yaml:"tools"`
+}
+
+// builtinConfig 是沒有設定檔時的內建設定：遮蔽輸出、記錄慢速呼叫，網路工具失敗時重試，
+// 天氣、行事曆、郵件、搜尋與知識庫的結果存入短期記憶 (來源需與 agent 的記憶預搜尋一致)
+func builtinConfig() *Config {
+	on := true
+	slow := Duration(10 * time.Second)
+	retry := &RetryPolicy{Attempts: 3, Backoff: Duration(time.Second)}
+	memory := func(source string) *string { return &source }
+	return &Config{
+		Defaults: Policy{Redact: &on, Slow: &slow},
+		Tools: map[string]Policy{
+			"web_fetch":          {Retry: retry},
+			"web_search":         {Retry: retry, Memory: memory("search")},
+			"get_taiwan_weather": {Memory: memory("weather")},
+			"manage_calendar":    {Memory: memory("calendar")},
+			"manage_email":       {Memory: memory("email")},
+			"knowledge_search":   {Memory: memory("knowledge_query")},
+		},
+	}
+}
+
+// LoadConfig 讀取設定檔並與內建設定合併 (同名工具的欄位以設定檔為準)；path 為空時只使用內建設定
+func LoadConfig(path string) (*Config, error) {
+	cfg := builtinConfig()
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("讀取中介層設定失敗: %w", err)
+	}
+	var custom Config
+	if err := yaml.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("解析中介層設定失敗 (%s): %w", path, err)
+	}
+	cfg.Defaults = cfg.Defaults.merge(custom.Defaults)
+	for name, p := range custom.Tools {
+		cfg.Tools[name] = cfg.Tools[name].merge(p)
+	}
+	return cfg, nil
+}
+
+// Chain 依設定提供工具中介層，設定檔變動時自動重新載入
+type Chain struct {
+	path    string
+	cache   *cacheStore
+	limiter *limiter
+
+	mu       sync.Mutex
+	cfg      *Config
+	modTime  time.Time
+	size     int64
+	remember func(source, content string) // 短期記憶回調 (SetShortTermMemory)
+	channels []string                     // 寫入短期記憶的對話頻道
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultChain *Chain
+)
+
+// New 建立中介層鏈；設定檔路徑為空時只使用內建設定，dir 保存快取與配額 (空字串代表只保存在記憶體)
+func New(path, dir string) *Chain {
+	return &Chain{path: path, cache: newCacheStore(dir), limiter: newLimiter(dir)}
+}
+
+// Default 回傳共用的中介層鏈 (PCAI_TOOL_MIDDLEWARE，預設 toolmiddleware.yaml；資料保存在 botmemory)
+func Default() *Chain {
+	defaultOnce.Do(func() {
+		path := os.Getenv("PCAI_TOOL_MIDDLEWARE")
+		if path == "" {
+			path = "toolmiddleware.yaml"
+		}
+		home, _ := os.Getwd()
+		defaultChain = New(path, filepath.Join(home, "botmemory"))
+	})
+	return defaultChain
+}
+
+// Install 將中介層加入註冊表
+func (c *Chain) Install(reg *core.Registry) {
+	reg.Use(c.Middleware()...)
+}
+
+// SetShortTermMemory 設定短期記憶回調：channels 頻道的對話中 (不含委派的子 Agent 與背景工作)，
+// 設定了 memory 的工具成功後，結果以該來源分類交給 fn
+func (c *Chain) SetShortTermMemory(fn func(source, content string), channels ...string) {
+	c.mu.Lock()
+	c.remember, c.channels = fn, channels
+	c.mu.Unlock()
+}
+
+// Middleware 回傳由外而內的中介層：計時 -> 快取 -> 短期記憶 -> 遮蔽 -> 速率限制 -> 重試
+// (快取命中不計入速率限制，也不會重複寫入短期記憶；快取與短期記憶保存的都是遮蔽後的結果)
+func (c *Chain) Middleware() []core.Middleware {
+	return []core.Middleware{c.timing, c.caching, c.remembering, c.redacting, c.rateLimiting, c.retrying}
+}
+
+// config 回傳目前的設定，設定檔有變動時重新載入
+func (c *Chain) config() *Config {
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(c.path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg != nil && modTime.Equal(c.modTime) && size == c.size {
+		return c.cfg
+	}
+	cfg, err := LoadConfig(c.path)
+	if err != nil {
+		fmt.Printf("⚠️ [ToolMW] %v，沿用目前的設定\n", err)
+		if c.cfg == nil {
+			c.cfg = builtinConfig()
+		}
+	} else {
+		if c.cfg != nil {
+			fmt.Printf("🔁 [ToolMW] 已重新載入工具中介層設定\n")
+		}
+		c.cfg = cfg
+	}
+	c.modTime, c.size = modTime, size
+	return c.cfg
+}
+
+// Policy 回傳工具的有效設定
+func (c *Chain) Policy(name string, tool core.AgentTool) Policy {
+	cfg := c.config()
+	p := cfg.Defaults
+	if pt, ok := tool.(PolicyTool); ok {
+		p = p.merge(pt.MiddlewarePolicy())
+	}
+	return p.merge(cfg.Tools[name])
+}
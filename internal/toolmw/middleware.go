@@ -0,0 +1,143 @@
+package toolmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/llms"
+)
+
+// timing 記錄超過 slow 設定的工具呼叫
+func (c *Chain) timing(next core.ToolHandler) core.ToolHandler {
+	return func(ctx context.Context, call *core.ToolCall) (string, error) {
+		start := time.Now()
+		result, err := next(ctx, call)
+		p := c.Policy(call.Name, call.Tool)
+		if p.Slow != nil && *p.Slow > 0 {
+			if elapsed := time.Since(start); elapsed > time.Duration(*p.Slow) {
+				fmt.Printf("🐢 [ToolMW] %s 執行 %v (超過 %v)\n", call.Name, elapsed.Round(time.Millisecond), time.Duration(*p.Slow))
+			}
+		}
+		return result, err
+	}
+}
+
+// remembering 將設定了 memory 的工具結果存入短期記憶 (只限 SetShortTermMemory 指定頻道的對話)
+func (c *Chain) remembering(next core.ToolHandler) core.ToolHandler {
+	return func(ctx context.Context, call *core.ToolCall) (string, error) {
+		result, err := next(ctx, call)
+		if err != nil || result == "" {
+			return result, err
+		}
+		c.mu.Lock()
+		remember, channels := c.remember, c.channels
+		c.mu.Unlock()
+		if remember == nil || !slices.Contains(channels, call.Env.Channel) || llms.UsageTagsFrom(ctx).Workflow != "chat" {
+			return result, err
+		}
+		if p := c.Policy(call.Name, call.Tool); p.Memory != nil && *p.Memory != "" {
+			remember(*p.Memory, result)
+		}
+		return result, err
+	}
+}
+
+// caching 相同工具、發送者與參數在快取時間內直接回傳保存的結果
+func (c *Chain) caching(next core.ToolHandler) core.ToolHandler {
+	return func(ctx context.Context, call *core.ToolCall) (string, error) {
+		p := c.Policy(call.Name, call.Tool)
+		if p.Cache == nil || *p.Cache <= 0 {
+			return next(ctx, call)
+		}
+		key := cacheKey(call)
+		if result, ok := c.cache.get(key); ok {
+			fmt.Printf("💾 [ToolMW] %s 使用快取結果\n", call.Name)
+			return result, nil
+		}
+		result, err := next(ctx, call)
+		if err == nil && result != "" {
+			c.cache.put(key, call.Name, result, time.Duration(*p.Cache))
+		}
+		return result, err
+	}
+}
+
+// rateLimiting 超過每分鐘上限或每日配額時拒絕執行
+func (c *Chain) rateLimiting(next core.ToolHandler) core.ToolHandler {
+	return func(ctx context.Context, call *core.ToolCall) (string, error) {
+		p := c.Policy(call.Name, call.Tool)
+		if p.Rate != nil {
+			if err := c.limiter.allow(call.Name, *p.Rate); err != nil {
+				return "", err
+			}
+		}
+		return next(ctx, call)
+	}
+}
+
+// retrying 失敗時以指數退避重試 (參數錯誤與取消不重試)
+func (c *Chain) retrying(next core.ToolHandler) core.ToolHandler {
+	return func(ctx context.Context, call *core.ToolCall) (string, error) {
+		p := c.Policy(call.Name, call.Tool)
+		if p.Retry == nil || p.Retry.Attempts <= 1 {
+			return next(ctx, call)
+		}
+		backoff := time.Duration(p.Retry.Backoff)
+		if backoff <= 0 {
+			backoff = 500 * time.Millisecond
+		}
+		maxBackoff := time.Duration(p.Retry.MaxBackoff)
+		if maxBackoff <= 0 {
+			maxBackoff = 10 * time.Second
+		}
+
+		args := call.Args
+		var result string
+		var err error
+		for attempt := 1; ; attempt++ {
+			call.Args = args
+			result, err = next(ctx, call)
+			if err == nil || attempt >= p.Retry.Attempts || !retryable(ctx, err) {
+				return result, err
+			}
+			fmt.Printf("🔁 [ToolMW] %s 失敗，%v 後第 %d 次重試: %v\n", call.Name, backoff, attempt, err)
+			select {
+			case <-ctx.Done():
+				return result, err
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+	}
+}
+
+// retryable 判斷錯誤是否值得重試
+func retryable(ctx context.Context, err error) bool {
+	var argErr *core.ArgError
+	if errors.As(err, &argErr) || ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// redacting 遮蔽結果與錯誤訊息中的密鑰
+func (c *Chain) redacting(next core.ToolHandler) core.ToolHandler {
+	return func(ctx context.Context, call *core.ToolCall) (string, error) {
+		result, err := next(ctx, call)
+		p := c.Policy(call.Name, call.Tool)
+		if p.Redact == nil || !*p.Redact {
+			return result, err
+		}
+		result = core.RedactSecrets(result)
+		if err != nil {
+			if msg := core.RedactSecrets(err.Error()); msg != err.Error() {
+				err = errors.New(msg)
+			}
+		}
+		return result, err
+	}
+}
@@ -0,0 +1,179 @@
+package toolmw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asccclass/pcai/internal/core"
+)
+
+// ─────────────────────────────────────────────────────────────
+// 結果快取 (botmemory/tool_cache/<key>.json)
+// ─────────────────────────────────────────────────────────────
+
+type cacheEntry struct {
+	Tool      string    `json:"tool"`
+	Result    string    `json:"result"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cacheStore 以記憶體加檔案保存快取，重新啟動後仍可命中
+type cacheStore struct {
+	dir string // 空字串代表只保存在記憶體
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCacheStore(dir string) *cacheStore {
+	if dir != "" {
+		dir = filepath.Join(dir, "tool_cache")
+	}
+	return &cacheStore{dir: dir, entries: make(map[string]cacheEntry)}
+}
+
+// cacheKey 以工具名稱、發送者與正規化後的參數 (鍵排序) 產生快取鍵；不同發送者不共用快取
+func cacheKey(call *core.ToolCall) string {
+	args := call.Args
+	var raw any
+	if err := json.Unmarshal([]byte(args), &raw); err == nil {
+		if canonical, err := json.Marshal(raw); err == nil {
+			args = string(canonical)
+		}
+	}
+	sum := sha256.Sum256([]byte(call.Name + "\x00" + call.Env.Sender + "\x00" + args))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *cacheStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok && s.dir != "" {
+		if data, err := os.ReadFile(filepath.Join(s.dir, key+".json")); err == nil && json.Unmarshal(data, &e) == nil {
+			ok = true
+			s.entries[key] = e
+		}
+	}
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.ExpiresAt) {
+		delete(s.entries, key)
+		if s.dir != "" {
+			_ = os.Remove(filepath.Join(s.dir, key+".json"))
+		}
+		return "", false
+	}
+	return e.Result, true
+}
+
+func (s *cacheStore) put(key, tool, result string, ttl time.Duration) {
+	e := cacheEntry{Tool: tool, Result: result, ExpiresAt: time.Now().Add(ttl)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = e
+	if s.dir == "" {
+		return
+	}
+	if err := writeJSON(filepath.Join(s.dir, key+".json"), e); err != nil {
+		fmt.Printf("⚠️ [ToolMW] 寫入快取失敗: %v\n", err)
+	}
+}
+
+// writeJSON 建立上層目錄並將 v 寫入 path
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0640)
+}
+
+// ─────────────────────────────────────────────────────────────
+// 速率限制與每日配額 (配額計數保存在 botmemory/tool_quota.json)
+// ─────────────────────────────────────────────────────────────
+
+type quotaFile struct {
+	Date   string         `json:"date"`
+	Counts map[string]int `json:"counts"`
+}
+
+type limiter struct {
+	path string // 空字串代表不保存配額
+
+	mu     sync.Mutex
+	recent map[string][]time.Time // 工具 -> 最近一分鐘的呼叫時間
+	quota  quotaFile
+	loaded bool
+}
+
+func newLimiter(dir string) *limiter {
+	l := &limiter{recent: make(map[string][]time.Time)}
+	if dir != "" {
+		l.path = filepath.Join(dir, "tool_quota.json")
+	}
+	return l
+}
+
+// allow 檢查並記錄一次呼叫，超過限制時回傳給模型看的錯誤
+func (l *limiter) allow(tool string, p RatePolicy) error {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if p.PerMinute > 0 {
+		calls := l.recent[tool]
+		for len(calls) > 0 && now.Sub(calls[0]) >= time.Minute {
+			calls = calls[1:]
+		}
+		if len(calls) >= p.PerMinute {
+			wait := time.Minute - now.Sub(calls[0])
+			l.recent[tool] = calls
+			return fmt.Errorf("工具 %s 已達速率上限 (每分鐘 %d 次)，請 %d 秒後再試或改用其他方式", tool, p.PerMinute, int(wait.Seconds())+1)
+		}
+		l.recent[tool] = append(calls, now)
+	}
+
+	if p.PerDay > 0 {
+		l.loadQuota(now)
+		if l.quota.Counts[tool] >= p.PerDay {
+			return fmt.Errorf("工具 %s 今日配額已用完 (每日 %d 次)，請明天再試或告知使用者", tool, p.PerDay)
+		}
+		l.quota.Counts[tool]++
+		l.saveQuota()
+	}
+	return nil
+}
+
+// loadQuota 讀取今日的配額計數 (跨日時歸零)
+func (l *limiter) loadQuota(now time.Time) {
+	today := now.Format("2006-01-02")
+	if !l.loaded && l.path != "" {
+		if data, err := os.ReadFile(l.path); err == nil {
+			_ = json.Unmarshal(data, &l.quota)
+		}
+		l.loaded = true
+	}
+	if l.quota.Date != today || l.quota.Counts == nil {
+		l.quota = quotaFile{Date: today, Counts: make(map[string]int)}
+	}
+}
+
+func (l *limiter) saveQuota() {
+	if l.path == "" {
+		return
+	}
+	if err := writeJSON(l.path, l.quota); err != nil {
+		fmt.Printf("⚠️ [ToolMW] 寫入配額計數失敗: %v\n", err)
+	}
+}
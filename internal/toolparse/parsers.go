@@ -0,0 +1,398 @@
+package toolparse
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// 內建解析器，註冊順序即預設 Pipeline 順序
+func init() {
+	Register(HermesParser{})
+	Register(MistralParser{})
+	Register(JSONParser{})
+	Register(PythonParser{})
+	Register(BracketParser{})
+	Register(BareParser{})
+	Register(NarrativeParser{})
+	Register(NarrativeKVParser{})
+}
+
+var (
+	hermesRe  = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*</tool_call>`)
+	pyCallRe  = regexp.MustCompile(`(\w+)\((\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+)(?:\s*,\s*\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+))*)\)`)
+	bracketRe = regexp.MustCompile(`\[(\w+)\s+((?:\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+)\s*)+)\]`)
+	bareRe    = regexp.MustCompile(`^([\w_]+)\s+((?:\w+\s*=\s*(?:"[^"]*"|'[^']*'|\S+)\s*)+)$`)
+	kvArgRe   = regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)'|(\S+))`)
+	kvLineRe  = regexp.MustCompile(`(?m)^\s*(\w+)\s*[:：]\s*(.+?)\s*$`)
+)
+
+// ─────────────────────────────────────────────────────────────
+// hermes: <tool_call>{"name": ..., "arguments": {...}}</tool_call>
+// Qwen、Hermes 系列模型的格式
+// ─────────────────────────────────────────────────────────────
+
+type HermesParser struct{}
+
+func (HermesParser) Name() string { return "hermes" }
+
+func (HermesParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	matches := hermesRe.FindAllStringSubmatch(content, -1)
+	var calls []api.ToolCall
+	for _, m := range matches {
+		if call, ok := decodeNamedCall([]byte(m[1])); ok {
+			calls = append(calls, call)
+		}
+	}
+	if len(calls) == 0 {
+		return nil, "", false
+	}
+	return calls, strings.TrimSpace(hermesRe.ReplaceAllString(content, "")), true
+}
+
+// ─────────────────────────────────────────────────────────────
+// mistral: [TOOL_CALLS][{"name": ..., "arguments": {...}}]
+// ─────────────────────────────────────────────────────────────
+
+type MistralParser struct{}
+
+func (MistralParser) Name() string { return "mistral" }
+
+func (MistralParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	const marker = "[TOOL_CALLS]"
+	idx := strings.Index(content, marker)
+	if idx < 0 {
+		return nil, "", false
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(strings.NewReader(content[idx+len(marker):])).Decode(&raw); err != nil {
+		return nil, "", false
+	}
+	items := []json.RawMessage{raw}
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, "", false
+		}
+	}
+
+	var calls []api.ToolCall
+	for _, item := range items {
+		if call, ok := decodeNamedCall(item); ok {
+			calls = append(calls, call)
+		}
+	}
+	if len(calls) == 0 {
+		return nil, "", false
+	}
+	return calls, strings.TrimSpace(content[:idx]), true
+}
+
+// ─────────────────────────────────────────────────────────────
+// json: 內容中的一或多個 JSON 區塊
+// 例如 {"type": "function", "name": "fs_append_to_file", "parameters": {...}}
+// ─────────────────────────────────────────────────────────────
+
+type JSONParser struct{}
+
+func (JSONParser) Name() string { return "json" }
+
+func (JSONParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	var calls []api.ToolCall
+	for _, jsonStr := range ExtractJSONBlocks(content) {
+		var rawCall struct {
+			Name       string                         `json:"name"`
+			Action     string                         `json:"action"` // Support "action" instead of "name"
+			Parameters *api.ToolCallFunctionArguments `json:"parameters"`
+			Arguments  *api.ToolCallFunctionArguments `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(jsonStr), &rawCall); err != nil {
+			continue
+		}
+
+		funcName := rawCall.Name
+		if funcName == "" {
+			funcName = rawCall.Action
+		}
+
+		// 嘗試從參數特徵推斷 (如果 AI 漏寫 action/name)
+		if funcName == "" {
+			var inferMap map[string]interface{}
+			if json.Unmarshal([]byte(jsonStr), &inferMap) == nil {
+				// 若包含 content 和 category，高機率是 memory_save 的參數體
+				if _, hasContent := inferMap["content"]; hasContent {
+					if _, hasCategory := inferMap["category"]; hasCategory {
+						funcName = "memory_save"
+					}
+				}
+			}
+		}
+		if funcName == "" {
+			continue
+		}
+
+		// 參數相容性處理: 有些模型會用 parameters 代替 arguments
+		var finalArgs api.ToolCallFunctionArguments
+		if rawCall.Arguments != nil {
+			finalArgs = *rawCall.Arguments
+		} else if rawCall.Parameters != nil {
+			finalArgs = *rawCall.Parameters
+		} else {
+			// 嘗試將整個 JSON 視為 Arguments
+			var fullArgs map[string]interface{}
+			if err := json.Unmarshal([]byte(jsonStr), &fullArgs); err == nil {
+				delete(fullArgs, "name")
+				delete(fullArgs, "action")
+				finalArgs = toArgs(fullArgs)
+			}
+		}
+		calls = append(calls, newCall(funcName, finalArgs))
+	}
+	if len(calls) == 0 {
+		return nil, "", false
+	}
+	return calls, "", true
+}
+
+// ExtractJSONBlocks 透過計算大括號的數量，精確提取巢狀的 JSON 區塊
+func ExtractJSONBlocks(text string) []string {
+	var blocks []string
+	startIdx := -1
+	braceCount := 0
+
+	for i, r := range text {
+		switch r {
+		case '{':
+			if braceCount == 0 {
+				startIdx = i
+			}
+			braceCount++
+		case '}':
+			braceCount--
+			if braceCount == 0 && startIdx != -1 {
+				blocks = append(blocks, text[startIdx:i+1])
+				startIdx = -1
+			} else if braceCount < 0 {
+				braceCount = 0 // Ignore unmatched closing braces
+			}
+		}
+	}
+	return blocks
+}
+
+// ─────────────────────────────────────────────────────────────
+// python: <|python_tag|>get_weather(city="苗栗") 或嵌在文字中的 name(key="value")
+// ─────────────────────────────────────────────────────────────
+
+type PythonParser struct{}
+
+func (PythonParser) Name() string { return "python" }
+
+func (PythonParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	cleaned := content
+	if idx := strings.Index(cleaned, "<|python_tag|>"); idx != -1 {
+		cleaned = strings.TrimSpace(cleaned[idx+len("<|python_tag|>"):])
+	}
+	m := pyCallRe.FindStringSubmatch(cleaned)
+	if m == nil {
+		return nil, "", false
+	}
+	return []api.ToolCall{newCall(m[1], toArgs(parseKVArgs(m[2])))}, "", true
+}
+
+// ─────────────────────────────────────────────────────────────
+// bracket: [get_taiwan_weather location="苗栗縣"]
+// ─────────────────────────────────────────────────────────────
+
+type BracketParser struct{}
+
+func (BracketParser) Name() string { return "bracket" }
+
+func (BracketParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	m := bracketRe.FindStringSubmatch(content)
+	if m == nil {
+		return nil, "", false
+	}
+	return []api.ToolCall{newCall(m[1], toArgs(parseKVArgs(m[2])))}, "", true
+}
+
+// ─────────────────────────────────────────────────────────────
+// bare: 單獨一行的 browser_open url="https://..."
+// 只接受已註冊的工具名稱，並保留前面的說話內容
+// ─────────────────────────────────────────────────────────────
+
+type BareParser struct{}
+
+func (BareParser) Name() string { return "bare" }
+
+func (BareParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	// 為了處理可能前面有一些空行或提示詞，從最後一行往前檢查
+	lines := strings.Split(content, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		m := bareRe.FindStringSubmatch(strings.TrimSpace(lines[i]))
+		if len(m) != 3 || findTool(tools, m[1]) == nil {
+			continue
+		}
+		// 移除工具呼叫那一行，保留前面的說話內容
+		lines[i] = ""
+		return []api.ToolCall{newCall(m[1], toArgs(parseKVArgs(m[2])))}, strings.Join(lines, "\n"), true
+	}
+	return nil, "", false
+}
+
+// ─────────────────────────────────────────────────────────────
+// narrative: 自然語言提到工具名稱，參數寫成
+//   (a) 裸 JSON: "我會呼叫 get_taiwan_weather... { "location": "苗栗縣" }"
+//   (b) URL query string: "get_taiwan_weather?location=苗栗縣"
+// ─────────────────────────────────────────────────────────────
+
+type NarrativeParser struct{}
+
+func (NarrativeParser) Name() string { return "narrative" }
+
+func (NarrativeParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	tool := mentionedTool(content, tools)
+	if tool == nil {
+		return nil, "", false
+	}
+	name := tool.Function.Name
+
+	// (a) 嘗試裸 JSON 參數
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start != -1 && end > start {
+		var argsMap map[string]interface{}
+		if err := json.Unmarshal([]byte(content[start:end+1]), &argsMap); err == nil {
+			if _, hasName := argsMap["name"]; !hasName {
+				return []api.ToolCall{newCall(name, toArgs(argsMap))}, "", true
+			}
+		}
+	}
+
+	// (b) 嘗試 URL query string: tool_name?key=value&key2=value2
+	qsRe := regexp.MustCompile(regexp.QuoteMeta(name) + `\?([^\s]+)`)
+	if m := qsRe.FindStringSubmatch(content); m != nil {
+		argsMap := make(map[string]interface{})
+		for _, pair := range strings.Split(m[1], "&") {
+			if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+				argsMap[kv[0]] = kv[1]
+			}
+		}
+		if len(argsMap) > 0 {
+			return []api.ToolCall{newCall(name, toArgs(argsMap))}, "", true
+		}
+	}
+	return nil, "", false
+}
+
+// ─────────────────────────────────────────────────────────────
+// narrative_kv: "我將呼叫 read_calendars 工具...\n from: 2026-02-26\n to: 2026-02-27"
+// 只接受工具定義中 required 參數的 key
+// ─────────────────────────────────────────────────────────────
+
+type NarrativeKVParser struct{}
+
+func (NarrativeKVParser) Name() string { return "narrative_kv" }
+
+func (NarrativeKVParser) Parse(content string, tools []api.Tool) ([]api.ToolCall, string, bool) {
+	tool := mentionedTool(content, tools)
+	if tool == nil || len(tool.Function.Parameters.Required) == 0 {
+		return nil, "", false
+	}
+
+	argsMap := make(map[string]interface{})
+	for _, m := range kvLineRe.FindAllStringSubmatch(content, -1) {
+		key := strings.TrimSpace(m[1])
+		val := strings.TrimSpace(m[2])
+		for _, p := range tool.Function.Parameters.Required {
+			if strings.EqualFold(key, p) {
+				argsMap[p] = val
+				break
+			}
+		}
+	}
+	if len(argsMap) == 0 {
+		return nil, "", false
+	}
+	return []api.ToolCall{newCall(tool.Function.Name, toArgs(argsMap))}, "", true
+}
+
+// ─────────────────────────────────────────────────────────────
+// 共用工具函式
+// ─────────────────────────────────────────────────────────────
+
+// decodeNamedCall 解析 {"name": ..., "arguments": ...} 物件，arguments 也可能是 JSON 字串
+func decodeNamedCall(data []byte) (api.ToolCall, bool) {
+	var raw struct {
+		Name       string          `json:"name"`
+		Arguments  json.RawMessage `json:"arguments"`
+		Parameters json.RawMessage `json:"parameters"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil || raw.Name == "" {
+		return api.ToolCall{}, false
+	}
+
+	argsData := raw.Arguments
+	if len(argsData) == 0 {
+		argsData = raw.Parameters
+	}
+	var encoded string
+	if json.Unmarshal(argsData, &encoded) == nil {
+		argsData = []byte(encoded)
+	}
+	var args api.ToolCallFunctionArguments
+	if len(argsData) > 0 {
+		_ = json.Unmarshal(argsData, &args)
+	}
+	return newCall(raw.Name, args), true
+}
+
+// parseKVArgs 解析 key="value"、key='value' 或 key=value 參數
+func parseKVArgs(argsStr string) map[string]interface{} {
+	argsMap := make(map[string]interface{})
+	for _, am := range kvArgRe.FindAllStringSubmatch(argsStr, -1) {
+		val := am[2] // double-quoted
+		if val == "" {
+			val = am[3] // single-quoted
+		}
+		if val == "" {
+			val = am[4] // unquoted
+		}
+		argsMap[am[1]] = val
+	}
+	return argsMap
+}
+
+// toArgs 將 map 轉換為 api.ToolCallFunctionArguments
+func toArgs(m map[string]interface{}) api.ToolCallFunctionArguments {
+	argsBytes, _ := json.Marshal(m)
+	var args api.ToolCallFunctionArguments
+	_ = json.Unmarshal(argsBytes, &args)
+	return args
+}
+
+func newCall(name string, args api.ToolCallFunctionArguments) api.ToolCall {
+	return api.ToolCall{Function: api.ToolCallFunction{Name: name, Arguments: args}}
+}
+
+// findTool 依名稱找出工具定義
+func findTool(tools []api.Tool, name string) *api.Tool {
+	for i := range tools {
+		if tools[i].Function.Name == name {
+			return &tools[i]
+		}
+	}
+	return nil
+}
+
+// mentionedTool 回傳內容中第一個被提到的工具
+func mentionedTool(content string, tools []api.Tool) *api.Tool {
+	for i := range tools {
+		if strings.Contains(content, tools[i].Function.Name) {
+			return &tools[i]
+		}
+	}
+	return nil
+}
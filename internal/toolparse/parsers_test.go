@@ -0,0 +1,148 @@
+package toolparse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func testTools() []api.Tool {
+	weather := api.Tool{Type: "function"}
+	weather.Function.Name = "get_taiwan_weather"
+	weather.Function.Parameters.Required = []string{"location"}
+
+	calendar := api.Tool{Type: "function"}
+	calendar.Function.Name = "read_calendars"
+	calendar.Function.Parameters.Required = []string{"from", "to"}
+
+	browser := api.Tool{Type: "function"}
+	browser.Function.Name = "browser_open"
+	return []api.Tool{weather, calendar, browser}
+}
+
+func argString(t *testing.T, call api.ToolCall, key string) string {
+	t.Helper()
+	v, _ := call.Function.Arguments.Get(key)
+	s, _ := v.(string)
+	return s
+}
+
+func TestParsers(t *testing.T) {
+	cases := []struct {
+		parser    ToolCallParser
+		content   string
+		name      string
+		key, val  string
+		remaining string
+	}{
+		{HermesParser{}, "好的\n<tool_call>\n{\"name\": \"get_taiwan_weather\", \"arguments\": {\"location\": \"苗栗縣\"}}\n</tool_call>",
+			"get_taiwan_weather", "location", "苗栗縣", "好的"},
+		{HermesParser{}, `<tool_call>{"name": "get_taiwan_weather", "arguments": "{\"location\": \"苗栗縣\"}"}</tool_call>`,
+			"get_taiwan_weather", "location", "苗栗縣", ""},
+		{MistralParser{}, `查詢中[TOOL_CALLS][{"name": "get_taiwan_weather", "arguments": {"location": "苗栗縣"}}]`,
+			"get_taiwan_weather", "location", "苗栗縣", "查詢中"},
+		{JSONParser{}, `{"type": "function", "name": "get_taiwan_weather", "parameters": {"location": "苗栗縣"}}`,
+			"get_taiwan_weather", "location", "苗栗縣", ""},
+		{JSONParser{}, `{"content": "喜歡咖啡", "category": "preference"}`,
+			"memory_save", "category", "preference", ""},
+		{PythonParser{}, `<|python_tag|>get_taiwan_weather(location="苗栗縣")`,
+			"get_taiwan_weather", "location", "苗栗縣", ""},
+		{BracketParser{}, `[get_taiwan_weather location="苗栗縣"]`,
+			"get_taiwan_weather", "location", "苗栗縣", ""},
+		{BareParser{}, "我來開網頁\nbrowser_open url=https://example.com",
+			"browser_open", "url", "https://example.com", "我來開網頁\n"},
+		{NarrativeParser{}, `我會呼叫 get_taiwan_weather 查詢 { "location": "苗栗縣" }`,
+			"get_taiwan_weather", "location", "苗栗縣", ""},
+		{NarrativeParser{}, `get_taiwan_weather?location=苗栗縣`,
+			"get_taiwan_weather", "location", "苗栗縣", ""},
+		{NarrativeKVParser{}, "我將呼叫 read_calendars 工具\n From: 2026-02-26\n to：2026-02-27",
+			"read_calendars", "from", "2026-02-26", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.parser.Name(), func(t *testing.T) {
+			calls, remaining, ok := tc.parser.Parse(tc.content, testTools())
+			if !ok || len(calls) != 1 {
+				t.Fatalf("ok=%v calls=%d, want 1 call", ok, len(calls))
+			}
+			if calls[0].Function.Name != tc.name {
+				t.Errorf("name = %q, want %q", calls[0].Function.Name, tc.name)
+			}
+			if got := argString(t, calls[0], tc.key); got != tc.val {
+				t.Errorf("%s = %q, want %q", tc.key, got, tc.val)
+			}
+			if remaining != tc.remaining {
+				t.Errorf("remaining = %q, want %q", remaining, tc.remaining)
+			}
+		})
+	}
+}
+
+func TestParsersRejectPlainText(t *testing.T) {
+	content := "苗栗縣明天晴時多雲，氣溫 18 到 25 度。"
+	for _, name := range order {
+		if calls, _, ok := registry[name].Parse(content, testTools()); ok {
+			t.Errorf("%s parsed plain text into %v", name, calls)
+		}
+	}
+	// bare 只接受已註冊的工具
+	if _, _, ok := (BareParser{}).Parse("unknown_tool key=value", testTools()); ok {
+		t.Error("bare accepted an unknown tool")
+	}
+}
+
+func TestPipelineFirstMatchWins(t *testing.T) {
+	content := `<tool_call>{"name": "get_taiwan_weather", "arguments": {"location": "苗栗縣"}}</tool_call>`
+	res, ok := Pipeline{JSONParser{}, HermesParser{}}.Parse(content, testTools())
+	if !ok || res.Parser != "json" {
+		t.Fatalf("parser = %q ok=%v, want json", res.Parser, ok)
+	}
+	res, ok = Pipeline{HermesParser{}, JSONParser{}}.Parse(content, testTools())
+	if !ok || res.Parser != "hermes" {
+		t.Fatalf("parser = %q ok=%v, want hermes", res.Parser, ok)
+	}
+}
+
+func TestForModelFamilies(t *testing.T) {
+	defer func() { config = nil }()
+
+	p := filepath.Join(t.TempDir(), "toolparsers.yaml")
+	yaml := `
+families:
+  - name: qwen
+    models: ["qwen*"]
+    parsers: [hermes, json]
+  - name: llama
+    models: ["llama3*"]
+    disable: [narrative, narrative_kv]
+`
+	if err := os.WriteFile(p, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(p); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	cases := map[string]string{
+		"qwen2.5:7b":          "hermes,json",
+		"library/qwen3:14b":   "hermes,json",
+		"llama3.1:8b":         "hermes,mistral,json,python,bracket,bare",
+		"mistral-nemo:latest": strings.Join(order, ","),
+	}
+	for model, want := range cases {
+		if got := strings.Join(ForModel(model).Names(), ","); got != want {
+			t.Errorf("ForModel(%s) = %s, want %s", model, got, want)
+		}
+	}
+
+	// 設定檔不存在時使用註冊順序
+	if err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err != nil {
+		t.Fatalf("LoadConfig missing: %v", err)
+	}
+	if got := strings.Join(ForModel("qwen2.5:7b").Names(), ","); got != strings.Join(order, ",") {
+		t.Errorf("default pipeline = %s", got)
+	}
+}
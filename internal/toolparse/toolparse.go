@@ -0,0 +1,207 @@
+// Package toolparse 從模型的文字回覆中還原工具呼叫
+//
+// 小模型常把工具呼叫寫在內容裡，而不是透過 Provider 的 tool_calls 欄位回傳。
+// 每種寫法由一個 ToolCallParser 處理，依模型家族組成有序的 Pipeline，
+// 第一個成功還原的解析器生效。解析器順序與開關可由 toolparsers.yaml
+// (路徑可用 PCAI_TOOL_PARSERS 指定) 依模型家族調整：
+//
+//	families:
+//	  - name: qwen
+//	    models: ["qwen*", "hermes*"]
+//	    parsers: [hermes, json]
+//	  - name: llama
+//	    models: ["llama3*"]
+//	    disable: [narrative_kv]
+//	default: [hermes, mistral, json, python, bracket, bare, narrative, narrative_kv]
+package toolparse
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"gopkg.in/yaml.v3"
+)
+
+// ToolCallParser 從模型回覆內容中還原工具呼叫
+type ToolCallParser interface {
+	// Name 回傳解析器名稱，用於設定檔與日誌
+	Name() string
+	// Parse 嘗試解析內容；成功時回傳工具呼叫與應保留給使用者的剩餘內容
+	Parse(content string, tools []api.Tool) (calls []api.ToolCall, remaining string, ok bool)
+}
+
+// Result 是 Pipeline 的解析結果
+type Result struct {
+	Parser  string         // 成功還原的解析器
+	Calls   []api.ToolCall // 還原出的工具呼叫
+	Content string         // 移除工具呼叫後剩下的內容
+}
+
+// Pipeline 是依序嘗試的解析器清單
+type Pipeline []ToolCallParser
+
+// Parse 依序嘗試每個解析器，回傳第一個成功的結果
+func (p Pipeline) Parse(content string, tools []api.Tool) (Result, bool) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return Result{}, false
+	}
+	for _, parser := range p {
+		if calls, remaining, ok := parser.Parse(content, tools); ok && len(calls) > 0 {
+			return Result{Parser: parser.Name(), Calls: calls, Content: remaining}, true
+		}
+	}
+	return Result{}, false
+}
+
+// Names 回傳 Pipeline 中解析器的名稱
+func (p Pipeline) Names() []string {
+	names := make([]string, len(p))
+	for i, parser := range p {
+		names[i] = parser.Name()
+	}
+	return names
+}
+
+// Family 是一個模型家族的解析器設定
+type Family struct {
+	Name    string   `yaml:"name"`
+	Models  []string `yaml:"models"`  // 模型名稱樣式，支援 * 萬用字元 (不分大小寫)
+	Parsers []string `yaml:"parsers"` // 指定順序；留空沿用預設順序
+	Disable []string `yaml:"disable"` // 從順序中移除的解析器
+}
+
+// Config 是 toolparsers.yaml 的內容
+type Config struct {
+	Families []Family `yaml:"families"`
+	Default  []string `yaml:"default"`
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]ToolCallParser{}
+	order    []string // 註冊順序，即預設 Pipeline 順序
+	config   *Config
+)
+
+// Register 註冊解析器；新格式只需實作 ToolCallParser 並註冊，不需修改 Agent 迴圈
+// 同名解析器會被取代，新名稱則加在預設順序的最後
+func Register(p ToolCallParser) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := registry[p.Name()]; !exists {
+		order = append(order, p.Name())
+	}
+	registry[p.Name()] = p
+}
+
+// LoadConfig 讀取模型家族設定；檔案不存在時使用預設順序
+func LoadConfig(path string) error {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("讀取工具解析器設定失敗: %w", err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("解析工具解析器設定失敗 (%s): %w", path, err)
+		}
+	}
+
+	mu.Lock()
+	config = cfg
+	mu.Unlock()
+	return nil
+}
+
+// currentConfig 回傳目前的設定，第一次使用時自動載入 PCAI_TOOL_PARSERS (預設 toolparsers.yaml)
+func currentConfig() *Config {
+	mu.RLock()
+	cfg := config
+	mu.RUnlock()
+	if cfg != nil {
+		return cfg
+	}
+
+	p := os.Getenv("PCAI_TOOL_PARSERS")
+	if p == "" {
+		p = "toolparsers.yaml"
+	}
+	if err := LoadConfig(p); err != nil {
+		fmt.Printf("⚠️ [ToolParse] %v，改用預設解析器順序\n", err)
+		mu.Lock()
+		config = &Config{}
+		mu.Unlock()
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return config
+}
+
+// ForModel 回傳指定模型使用的 Pipeline
+func ForModel(model string) Pipeline {
+	cfg := currentConfig()
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := order
+	if len(cfg.Default) > 0 {
+		names = cfg.Default
+	}
+	var disabled []string
+	if fam := cfg.match(model); fam != nil {
+		if len(fam.Parsers) > 0 {
+			names = fam.Parsers
+		}
+		disabled = fam.Disable
+	}
+
+	pipeline := make(Pipeline, 0, len(names))
+	for _, name := range names {
+		if contains(disabled, name) {
+			continue
+		}
+		p, ok := registry[name]
+		if !ok {
+			fmt.Printf("⚠️ [ToolParse] 未知的解析器: %s\n", name)
+			continue
+		}
+		pipeline = append(pipeline, p)
+	}
+	return pipeline
+}
+
+// match 回傳第一個符合模型名稱的家族
+func (c *Config) match(model string) *Family {
+	model = strings.ToLower(model)
+	base := model
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		base = model[i+1:]
+	}
+	for i := range c.Families {
+		for _, pattern := range c.Families[i].Models {
+			pattern = strings.ToLower(pattern)
+			if ok, _ := path.Match(pattern, model); ok {
+				return &c.Families[i]
+			}
+			if ok, _ := path.Match(pattern, base); ok {
+				return &c.Families[i]
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Package toolresult 保存過大的工具結果 (網頁內容、檔案、郵件清單、瀏覽器快照)，
+// 對話中只放預覽與代號 (handle)，模型需要時再透過 tool_result_read 分頁讀取或搜尋完整內容。
+//
+// 結果以 botmemory/tool_results/<handle>.txt 保存，超過保留時間 (PCAI_TOOL_RESULT_TTL_HOURS，預設 24) 的檔案會被清除。
+package toolresult
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadToolName 是分頁讀取與搜尋已保存結果的工具名稱
+const ReadToolName = "tool_result_read"
+
+// Store 以檔案保存工具結果
+type Store struct {
+	dir string
+	ttl time.Duration
+
+	mu  sync.Mutex
+	seq int
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultStore *Store
+)
+
+// handleRe 限制 handle 格式，避免讀取目錄外的檔案
+var handleRe = regexp.MustCompile(`^tr_\d{8}_\d{6}_\d+$`)
+
+// NewStore 建立結果存放區並清除過期的結果
+func NewStore(dir string, ttl time.Duration) *Store {
+	s := &Store{dir: dir, ttl: ttl}
+	s.cleanup()
+	return s
+}
+
+// Default 回傳共用的存放區 (目前目錄下的 botmemory/tool_results)
+func Default() *Store {
+	defaultOnce.Do(func() {
+		home, _ := os.Getwd()
+		ttl := 24 * time.Hour
+		if n, err := strconv.Atoi(os.Getenv("PCAI_TOOL_RESULT_TTL_HOURS")); err == nil && n > 0 {
+			ttl = time.Duration(n) * time.Hour
+		}
+		defaultStore = NewStore(filepath.Join(home, "botmemory", "tool_results"), ttl)
+	})
+	return defaultStore
+}
+
+// Save 保存內容並回傳 handle
+func (s *Store) Save(content string) (string, error) {
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return "", fmt.Errorf("建立結果目錄失敗: %w", err)
+	}
+	s.mu.Lock()
+	s.seq++
+	handle := fmt.Sprintf("tr_%s_%d", time.Now().Format("20060102_150405"), s.seq)
+	s.mu.Unlock()
+
+	if err := os.WriteFile(filepath.Join(s.dir, handle+".txt"), []byte(content), 0640); err != nil {
+		return "", fmt.Errorf("保存工具結果失敗: %w", err)
+	}
+	return handle, nil
+}
+
+// Load 讀取 handle 對應的完整內容
+func (s *Store) Load(handle string) (string, error) {
+	handle = strings.TrimSpace(handle)
+	if !handleRe.MatchString(handle) {
+		return "", fmt.Errorf("無效的結果代號: %s", handle)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, handle+".txt"))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("找不到結果 %s (可能已過期)", handle)
+	}
+	if err != nil {
+		return "", fmt.Errorf("讀取工具結果失敗: %w", err)
+	}
+	return string(data), nil
+}
+
+// cleanup 刪除超過保留時間的結果
+func (s *Store) cleanup() {
+	if s.ttl <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err == nil && !e.IsDir() && time.Since(info.ModTime()) > s.ttl {
+			_ = os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+// Page 以字元 (rune) 位移讀取一段內容，回傳片段與下一段的位移 (已到結尾時為 -1)
+func Page(content string, offset, length int) (string, int) {
+	runes := []rune(content)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(runes) {
+		return "", -1
+	}
+	end := offset + length
+	if length <= 0 || end >= len(runes) {
+		return string(runes[offset:]), -1
+	}
+	return string(runes[offset:end]), end
+}
+
+// Match 是內容中符合搜尋字詞的一行
+type Match struct {
+	Line   int    // 行號 (從 1 開始)
+	Offset int    // 該行開頭的字元位移，可交給 Page 讀取前後文
+	Text   string // 該行內容
+}
+
+// Search 找出包含 query (不分大小寫) 的行，最多回傳 max 筆
+func Search(content, query string, max int) []Match {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	var matches []Match
+	offset := 0
+	for i, line := range strings.Split(content, "\n") {
+		if strings.Contains(strings.ToLower(line), query) {
+			matches = append(matches, Match{Line: i + 1, Offset: offset, Text: strings.TrimSpace(line)})
+			if len(matches) >= max {
+				break
+			}
+		}
+		offset += len([]rune(line)) + 1
+	}
+	return matches
+}
@@ -0,0 +1,47 @@
+package toolresult
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreSaveLoadPageSearch(t *testing.T) {
+	s := NewStore(t.TempDir(), time.Hour)
+	content := "第一行 標題\n第二行 價格 100 元\n第三行 Price 200\n結尾"
+	handle, err := s.Save(content)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := s.Load(handle)
+	if err != nil || got != content {
+		t.Fatalf("Load = %q, %v", got, err)
+	}
+	for _, bad := range []string{"../../etc/passwd", "tr_x", ""} {
+		if _, err := s.Load(bad); err == nil {
+			t.Errorf("Load(%q) should fail", bad)
+		}
+	}
+
+	page, next := Page(content, 0, 3)
+	if page != "第一行" || next != 3 {
+		t.Errorf("Page(0,3) = %q, %d", page, next)
+	}
+	if page, next = Page(content, next, 0); !strings.HasSuffix(page, "結尾") || next != -1 {
+		t.Errorf("last page = %q, %d", page, next)
+	}
+	if page, next = Page(content, 1000, 10); page != "" || next != -1 {
+		t.Errorf("out of range = %q, %d", page, next)
+	}
+
+	matches := Search(content, "price", 10)
+	if len(matches) != 1 || matches[0].Line != 3 {
+		t.Fatalf("Search = %+v", matches)
+	}
+	if p, _ := Page(content, matches[0].Offset, 3); p != "第三行" {
+		t.Errorf("match offset points to %q", p)
+	}
+	if got := Search(content, "行", 2); len(got) != 2 {
+		t.Errorf("Search max = %d, want 2", len(got))
+	}
+}
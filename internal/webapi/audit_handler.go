@@ -0,0 +1,93 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asccclass/pcai/internal/database"
+)
+
+// AuditHandler 工具呼叫稽核紀錄 HTTP Handler
+type AuditHandler struct {
+	db *database.DB
+}
+
+// NewAuditHandler 建立新的稽核紀錄 Handler
+func NewAuditHandler(db *database.DB) *AuditHandler {
+	return &AuditHandler{db: db}
+}
+
+// AddRoutes 註冊 API 路由
+func (h *AuditHandler) AddRoutes(mux *http.ServeMux) {
+	// GET /api/audit?since=yesterday&until=today&channel=telegram&tool=&session=&sender=&errors=1&limit=100&format=csv
+	mux.HandleFunc("/api/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleQuery(w, r)
+	})
+}
+
+// handleQuery 依條件查詢並以 JSON 或 CSV 匯出
+func (h *AuditHandler) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if h.db == nil {
+		http.Error(w, "database not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := database.AuditFilter{
+		Channel:    q.Get("channel"),
+		Tool:       q.Get("tool"),
+		SessionID:  q.Get("session"),
+		Sender:     q.Get("sender"),
+		ErrorsOnly: q.Get("errors") == "1" || q.Get("errors") == "true",
+	}
+	filter.Limit, _ = strconv.Atoi(q.Get("limit"))
+
+	var err error
+	if s := q.Get("since"); s != "" {
+		filter.Since, err = database.ParseLocalTime(s)
+	} else {
+		days, _ := strconv.Atoi(q.Get("days"))
+		if days <= 0 {
+			days = 1
+		}
+		filter.Since = time.Now().AddDate(0, 0, -days)
+	}
+	if u := q.Get("until"); u != "" && err == nil {
+		filter.Until, err = database.ParseLocalTime(u)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	rows, err := h.db.QueryToolAudit(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if q.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="tool_audit.csv"`)
+		_ = database.WriteToolAuditCSV(w, rows)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"count":   len(rows),
+		"results": rows,
+	})
+}
@@ -1,13 +1,22 @@
 package webapi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/asccclass/pcai/internal/agent"
+	"github.com/asccclass/pcai/internal/approval"
 	"github.com/asccclass/pcai/internal/core"
 	"github.com/asccclass/pcai/internal/history"
+	"github.com/asccclass/pcai/llms"
 	"github.com/asccclass/pcai/llms/ollama"
 )
 
@@ -46,6 +55,9 @@ func (h *ChatHandler) handleChat(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		SenderID string `json:"sender_id"`
 		Message  string `json:"message"`
+		// 有副作用的工具需要核准時 POST 到此網址，未提供時一律拒絕
+		// 主機必須列在 PCAI_APPROVAL_CALLBACK_HOSTS 中 (避免被用來對內網發送請求)
+		ApprovalCallback string `json:"approval_callback"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -62,6 +74,13 @@ func (h *ChatHandler) handleChat(w http.ResponseWriter, r *http.Request) {
 		req.SenderID = "anonymous_bot"
 	}
 
+	if req.ApprovalCallback != "" {
+		if err := checkCallbackURL(req.ApprovalCallback, os.Getenv("PCAI_APPROVAL_CALLBACK_HOSTS")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	sessionID := "api_" + req.SenderID
 	sess := history.LoadSession(sessionID)
 
@@ -77,18 +96,48 @@ func (h *ChatHandler) handleChat(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		myAgent = agent.NewAgent(h.modelName, h.systemPrompt, sess, h.toolRegistry, h.logger)
+		myAgent.UseProfile("api")
 		h.agents[sessionID] = myAgent
 	} else {
 		myAgent.Session = sess
 	}
+	myAgent.Channel, myAgent.Sender = "api", req.SenderID
+	myAgent.Approver = nil
+	if req.ApprovalCallback != "" {
+		myAgent.Approver = callbackApprover(req.ApprovalCallback)
+	}
 
 	fmt.Printf("\n[API] Received [%s] message: %s\n", req.SenderID, req.Message)
 
-	reply, err := myAgent.Chat(req.Message, nil)
+	// [EVENTS] Accept: text/event-stream 時以 SSE 即時推送 Agent 事件，最後一個事件為 turn_finished
+	stream := false
+	if flusher, ok := w.(http.Flusher); ok && strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		unsubscribe := myAgent.Events.Subscribe(func(e agent.Event) {
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+			flusher.Flush()
+		})
+		defer unsubscribe()
+		stream = true
+	}
+
+	// 使用 Request Context：客戶端斷線時會中止 LLM 生成與後續工具呼叫
+	ctx := llms.WithUsageTags(r.Context(), llms.UsageTags{Channel: "api", Workflow: "chat"})
+	reply, err := myAgent.Chat(ctx, req.Message, nil)
 
 	history.SaveSession(sess)
-	history.CheckAndSummarize(sess, h.modelName, h.systemPrompt)
+	// 歸納屬於背景工作，不隨客戶端斷線而中止
+	history.CheckAndSummarize(context.Background(), sess, h.modelName, h.systemPrompt)
 
+	if err != nil && errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		fmt.Printf("[API] (%s) 客戶端已斷線，停止生成\n", req.SenderID)
+		return
+	}
+	if stream {
+		return // 回覆與錯誤已在 turn_finished 事件中送出
+	}
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -105,3 +154,57 @@ func (h *ChatHandler) handleChat(w http.ResponseWriter, r *http.Request) {
 		"reply":   reply,
 	})
 }
+
+// checkCallbackURL 確認核准回調網址為 http(s)，且主機 (或 主機:埠) 在允許清單 (逗號分隔) 中
+func checkCallbackURL(raw, allowlist string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("approval_callback 必須是 http(s) 網址")
+	}
+	for _, allowed := range strings.Split(allowlist, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && (allowed == strings.ToLower(u.Host) || allowed == strings.ToLower(u.Hostname())) {
+			return nil
+		}
+	}
+	return fmt.Errorf("approval_callback 主機 %s 不在 PCAI_APPROVAL_CALLBACK_HOSTS 允許清單中", u.Host)
+}
+
+// callbackClient 不跟隨轉址，避免允許的主機把請求轉到其他位址
+var callbackClient = &http.Client{
+	Timeout: 5 * time.Minute,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// callbackApprover 將核准請求 (approval.Request JSON) POST 到呼叫端提供的網址，
+// 回應格式為 {"decision": "allow" | "always" | "deny"}
+func callbackApprover(callbackURL string) approval.Approver {
+	return func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return approval.Deny, err
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			return approval.Deny, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := callbackClient.Do(httpReq)
+		if err != nil {
+			return approval.Deny, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return approval.Deny, fmt.Errorf("核准回調回應 HTTP %d", resp.StatusCode)
+		}
+		var answer struct {
+			Decision string `json:"decision"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+			return approval.Deny, fmt.Errorf("解析核准回調回應失敗: %w", err)
+		}
+		return approval.ParseDecision(answer.Decision), nil
+	}
+}
@@ -0,0 +1,101 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/asccclass/pcai/internal/database"
+)
+
+// UsageHandler LLM 用量統計 HTTP Handler
+type UsageHandler struct {
+	db *database.DB
+}
+
+// NewUsageHandler 建立新的用量統計 Handler
+func NewUsageHandler(db *database.DB) *UsageHandler {
+	return &UsageHandler{db: db}
+}
+
+// AddRoutes 註冊 API 路由
+func (h *UsageHandler) AddRoutes(mux *http.ServeMux) {
+	// GET /api/usage?days=7&by=workflow
+	mux.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleSummary(w, r)
+	})
+
+	// GET /api/usage/recent?limit=50
+	mux.HandleFunc("/api/usage/recent", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleRecent(w, r)
+	})
+}
+
+// handleSummary 依維度彙總用量
+func (h *UsageHandler) handleSummary(w http.ResponseWriter, r *http.Request) {
+	if h.db == nil {
+		http.Error(w, "database not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	if days <= 0 {
+		days = 7
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "workflow"
+	}
+
+	rows, err := h.db.GetLLMUsageSummary(r.Context(), by, days)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"days":    days,
+		"by":      by,
+		"results": rows,
+	})
+}
+
+// handleRecent 列出最近的呼叫紀錄
+func (h *UsageHandler) handleRecent(w http.ResponseWriter, r *http.Request) {
+	if h.db == nil {
+		http.Error(w, "database not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+
+	rows, err := h.db.GetRecentLLMUsage(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"results": rows,
+	})
+}
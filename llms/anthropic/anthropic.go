@@ -0,0 +1,519 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// ──────────────────────────────────────────────────────
+// 設定
+// ──────────────────────────────────────────────────────
+
+const (
+	defaultBaseURL   = "https://api.anthropic.com"
+	defaultVersion   = "2023-06-01"
+	defaultMaxTokens = 4096
+)
+
+// Config 定義 Anthropic Messages API 的連線設定
+type Config struct {
+	BaseURL   string
+	APIKey    string
+	Version   string // anthropic-version header
+	MaxTokens int    // Messages API 必填
+	Timeout   time.Duration
+}
+
+// ConfigFromEnv 從環境變數讀取設定
+//
+//	ANTHROPIC_API_KEY     API Key (必填)
+//	ANTHROPIC_BASE_URL    端點位址 (預設 https://api.anthropic.com)
+//	ANTHROPIC_MAX_TOKENS  單次回覆上限 (預設 4096)
+func ConfigFromEnv() Config {
+	cfg := Config{
+		BaseURL:   os.Getenv("ANTHROPIC_BASE_URL"),
+		APIKey:    os.Getenv("ANTHROPIC_API_KEY"),
+		Version:   defaultVersion,
+		MaxTokens: defaultMaxTokens,
+		Timeout:   300 * time.Second,
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultBaseURL
+	}
+	if n, err := strconv.Atoi(os.Getenv("ANTHROPIC_MAX_TOKENS")); err == nil && n > 0 {
+		cfg.MaxTokens = n
+	}
+	return cfg
+}
+
+func (c Config) endpoint() string {
+	base := strings.TrimSuffix(c.BaseURL, "/")
+	if base == "" {
+		base = defaultBaseURL
+	}
+	if strings.HasSuffix(base, "/messages") {
+		return base
+	}
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return base + "/messages"
+}
+
+// ──────────────────────────────────────────────────────
+// Messages API 資料結構
+// ──────────────────────────────────────────────────────
+
+type message struct {
+	Role    string         `json:"role"` // user, assistant
+	Content []contentBlock `json:"content"`
+}
+
+type contentBlock struct {
+	Type string `json:"type"` // text, image, tool_use, tool_result
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image
+	Source *imageSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type imageSource struct {
+	Type      string `json:"type"` // base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type messagesRequest struct {
+	Model       string    `json:"model"`
+	System      string    `json:"system,omitempty"`
+	Messages    []message `json:"messages"`
+	Tools       []tool    `json:"tools,omitempty"`
+	MaxTokens   int       `json:"max_tokens"`
+	Stream      bool      `json:"stream"`
+	Temperature *float64  `json:"temperature,omitempty"` // 指標：明確設定的 0 也要送出
+	// num_ctx、seed、repeat_penalty 在 Messages API 沒有對應參數
+	StopSequences []string `json:"stop_sequences,omitempty"`
+}
+
+// streamEvent 涵蓋 SSE 中會用到的所有事件欄位
+type streamEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Text string `json:"text"`
+	} `json:"content_block,omitempty"`
+	Delta *struct {
+		Type        string `json:"type"` // text_delta, input_json_delta, thinking_delta
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	// message_start 附帶 message.usage.input_tokens，message_delta 附帶 usage.output_tokens
+	Message *struct {
+		Usage *usage `json:"usage"`
+	} `json:"message,omitempty"`
+	Usage *usage `json:"usage,omitempty"`
+}
+
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// ──────────────────────────────────────────────────────
+// 格式轉換（Ollama → Anthropic）
+// ──────────────────────────────────────────────────────
+
+// convertMessages 將 PCAI 的訊息序列轉換為 system 字串與 Messages API 的訊息陣列
+// - system 訊息合併成頂層 system
+// - assistant 的 ToolCalls 轉為 tool_use 區塊
+// - role:tool 依序對應前一輪的 tool_use id，轉為 user 訊息中的 tool_result 區塊
+// - Messages API 要求 user/assistant 交替，相同角色的連續訊息會合併
+func convertMessages(msgs []ollama.Message) (string, []message) {
+	var systemParts []string
+	var out []message
+	var pendingToolUseIDs []string
+
+	appendBlocks := func(role string, blocks []contentBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, blocks...)
+			return
+		}
+		out = append(out, message{Role: role, Content: blocks})
+	}
+
+	for mi, m := range msgs {
+		switch m.Role {
+		case "system":
+			if strings.TrimSpace(m.Content) != "" {
+				systemParts = append(systemParts, m.Content)
+			}
+
+		case "assistant":
+			var blocks []contentBlock
+			if strings.TrimSpace(m.Content) != "" {
+				blocks = append(blocks, contentBlock{Type: "text", Text: m.Content})
+			}
+			if len(m.ToolCalls) > 0 {
+				pendingToolUseIDs = nil
+			}
+			for i, tc := range m.ToolCalls {
+				id := tc.ID
+				if id == "" {
+					id = fmt.Sprintf("toolu_%d_%d", mi, i)
+				}
+				pendingToolUseIDs = append(pendingToolUseIDs, id)
+				input, _ := json.Marshal(tc.Function.Arguments)
+				blocks = append(blocks, contentBlock{Type: "tool_use", ID: id, Name: tc.Function.Name, Input: input})
+			}
+			appendBlocks("assistant", blocks)
+
+		case "tool":
+			if len(pendingToolUseIDs) == 0 {
+				// 找不到對應的 tool_use，改以一般文字回傳以免 API 拒絕
+				appendBlocks("user", []contentBlock{{Type: "text", Text: m.Content}})
+				continue
+			}
+			id := pendingToolUseIDs[0]
+			pendingToolUseIDs = pendingToolUseIDs[1:]
+			appendBlocks("user", []contentBlock{{Type: "tool_result", ToolUseID: id, Content: m.Content}})
+
+		default: // user
+			var blocks []contentBlock
+			for _, img := range m.Images {
+				blocks = append(blocks, contentBlock{Type: "image", Source: &imageSource{
+					Type:      "base64",
+					MediaType: detectMediaType(img),
+					Data:      img,
+				}})
+			}
+			if strings.TrimSpace(m.Content) != "" || len(blocks) == 0 {
+				blocks = append(blocks, contentBlock{Type: "text", Text: m.Content})
+			}
+			appendBlocks("user", blocks)
+		}
+	}
+
+	return strings.Join(systemParts, "\n\n"), out
+}
+
+// detectMediaType 由 Base64 圖片內容判斷 MIME 類型
+func detectMediaType(img string) string {
+	if raw, err := base64.StdEncoding.DecodeString(img); err == nil {
+		if detected := http.DetectContentType(raw); strings.HasPrefix(detected, "image/") {
+			return detected
+		}
+	}
+	return "image/png"
+}
+
+func convertTools(tools []api.Tool) []tool {
+	var out []tool
+	for _, t := range tools {
+		out = append(out, tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: sanitizeSchema(t.Function.Parameters),
+		})
+	}
+	return out
+}
+
+// sanitizeSchema 確保 input_schema 為 type=object 的 JSON Schema
+func sanitizeSchema(params any) any {
+	empty := map[string]any{"type": "object", "properties": map[string]any{}}
+
+	data, err := json.Marshal(params)
+	if err != nil || string(data) == "null" || string(data) == "{}" {
+		return empty
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return empty
+	}
+	m["type"] = "object"
+	if m["properties"] == nil {
+		m["properties"] = map[string]any{}
+	}
+	if req, ok := m["required"]; ok && req == nil {
+		delete(m, "required")
+	}
+	return m
+}
+
+// ──────────────────────────────────────────────────────
+// ChatStream — 核心串流聊天函式
+// ──────────────────────────────────────────────────────
+
+// ChatStream 實作 llms.ChatStreamFunc 介面，設定取自環境變數
+func ChatStream(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+	return ConfigFromEnv().ChatStream(ctx, modelName, messages, tools, opts, callback)
+}
+
+// formatInstruction 將結構化輸出要求轉為 system 指示
+// Messages API 沒有對應 Ollama format / OpenAI response_format 的參數，只能以提示詞約束
+func formatInstruction(format json.RawMessage) string {
+	trimmed := strings.TrimSpace(string(format))
+	switch trimmed {
+	case "", "null":
+		return ""
+	case `"json"`:
+		return "只輸出一個合法的 JSON 值，不要加任何說明文字或 Markdown 標記。"
+	default:
+		return "只輸出一個符合以下 JSON Schema 的 JSON 值，不要加任何說明文字或 Markdown 標記：\n" + trimmed
+	}
+}
+
+// ChatStream 以指定設定呼叫 Messages API 並解析 SSE 串流
+func (c Config) ChatStream(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+	if c.APIKey == "" {
+		return ollama.Message{}, fmt.Errorf("未設定 ANTHROPIC_API_KEY")
+	}
+
+	system, msgs := convertMessages(messages)
+	if hint := formatInstruction(opts.Format); hint != "" {
+		system = strings.TrimSpace(system + "\n\n" + hint)
+	}
+	maxTokens := c.MaxTokens
+	if opts.NumPredict > 0 {
+		maxTokens = opts.NumPredict
+	}
+	if maxTokens <= 0 {
+		maxTokens = defaultMaxTokens
+	}
+	reqBody := messagesRequest{
+		Model:     modelName,
+		System:    system,
+		Messages:  msgs,
+		MaxTokens: maxTokens,
+		Stream:    true,
+		// 部分 Claude 模型不允許同時指定 temperature 與 top_p，這裡只傳 temperature
+		Temperature:   &opts.Temperature,
+		StopSequences: opts.Stop,
+	}
+	if len(tools) > 0 {
+		reqBody.Tools = convertTools(tools)
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return ollama.Message{}, fmt.Errorf("JSON 序列化失敗: %v", err)
+	}
+
+	version := c.Version
+	if version == "" {
+		version = defaultVersion
+	}
+	url := c.endpoint()
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("x-api-key", c.APIKey)
+		req.Header.Set("anthropic-version", version)
+		return req
+	}
+
+	client := &http.Client{Timeout: c.Timeout}
+	start := time.Now()
+
+	var resp *http.Response
+	maxRetries := 2
+	for i := 0; i <= maxRetries; i++ {
+		resp, err = client.Do(newRequest())
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ollama.Message{}, ctx.Err()
+		}
+		if i < maxRetries {
+			fmt.Printf("⚠️ Anthropic 連線失敗 (嘗試 %d/%d): %v\n", i+1, maxRetries+1, err)
+			select {
+			case <-ctx.Done():
+				return ollama.Message{}, ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
+		}
+	}
+	if err != nil {
+		return ollama.Message{}, fmt.Errorf("Anthropic API 連線失敗: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return ollama.Message{}, fmt.Errorf("Anthropic API 錯誤 (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	msg, err := parseStream(resp.Body, callback, ollama.ThinkingHandler(ctx))
+	if msg.Usage != nil {
+		msg.Usage.Provider = "claude"
+		msg.Usage.Model = modelName
+		msg.Usage.Latency = time.Since(start)
+	}
+	if ctx.Err() != nil {
+		// 串流途中被取消：只保留已收到的文字，不回傳可能不完整的工具呼叫
+		msg.ToolCalls = nil
+		return msg, ctx.Err()
+	}
+	return msg, err
+}
+
+// parseStream 解析 Messages API 的 SSE 事件，組合文字與 tool_use 區塊
+func parseStream(r io.Reader, callback, onThinking func(string)) (ollama.Message, error) {
+	fullMsg := ollama.Message{Role: "assistant"}
+
+	type pendingToolUse struct {
+		id, name string
+		input    strings.Builder
+	}
+	toolUses := make(map[int]*pendingToolUse)
+	var order []int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+stream:
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue // 忽略 "event:" 行，事件類型亦包含在 data 中
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+		var ev streamEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			continue
+		}
+
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil && ev.Message.Usage != nil {
+				fullMsg.Usage = &ollama.Usage{
+					PromptTokens:     ev.Message.Usage.InputTokens,
+					CompletionTokens: ev.Message.Usage.OutputTokens,
+				}
+			}
+
+		case "message_delta":
+			if ev.Usage != nil {
+				if fullMsg.Usage == nil {
+					fullMsg.Usage = &ollama.Usage{}
+				}
+				fullMsg.Usage.CompletionTokens = ev.Usage.OutputTokens
+			}
+
+		case "content_block_start":
+			if ev.ContentBlock == nil {
+				continue
+			}
+			switch ev.ContentBlock.Type {
+			case "tool_use":
+				toolUses[ev.Index] = &pendingToolUse{id: ev.ContentBlock.ID, name: ev.ContentBlock.Name}
+				order = append(order, ev.Index)
+			case "text":
+				if ev.ContentBlock.Text != "" {
+					fullMsg.Content += ev.ContentBlock.Text
+					if callback != nil {
+						callback(ev.ContentBlock.Text)
+					}
+				}
+			}
+
+		case "content_block_delta":
+			if ev.Delta == nil {
+				continue
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				fullMsg.Content += ev.Delta.Text
+				if callback != nil && ev.Delta.Text != "" {
+					callback(ev.Delta.Text)
+				}
+			case "input_json_delta":
+				if tu, ok := toolUses[ev.Index]; ok {
+					tu.input.WriteString(ev.Delta.PartialJSON)
+				}
+			case "thinking_delta":
+				fullMsg.Thinking += ev.Delta.Thinking
+				if onThinking != nil && ev.Delta.Thinking != "" {
+					onThinking(ev.Delta.Thinking)
+				}
+			}
+
+		case "error":
+			if ev.Error != nil {
+				return fullMsg, fmt.Errorf("Anthropic 串流錯誤 (%s): %s", ev.Error.Type, ev.Error.Message)
+			}
+			return fullMsg, fmt.Errorf("Anthropic 串流錯誤")
+
+		case "message_stop":
+			break stream
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fullMsg, fmt.Errorf("串流讀取錯誤: %v", err)
+	}
+
+	for i, idx := range order {
+		tu := toolUses[idx]
+		args := api.NewToolCallFunctionArguments()
+		if raw := strings.TrimSpace(tu.input.String()); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &args); err != nil {
+				fmt.Printf("⚠️ [Anthropic] 無法解析 %s 的參數: %v\n", tu.name, err)
+				args = api.NewToolCallFunctionArguments()
+			}
+		}
+		fullMsg.ToolCalls = append(fullMsg.ToolCalls, api.ToolCall{
+			ID: tu.id,
+			Function: api.ToolCallFunction{
+				Index:     i,
+				Name:      tu.name,
+				Arguments: args,
+			},
+		})
+	}
+
+	return fullMsg, nil
+}
@@ -0,0 +1,119 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func TestChatStream_TextAndToolUse(t *testing.T) {
+	var gotReq messagesRequest
+	var gotKey, gotVersion string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		gotKey = r.Header.Get("x-api-key")
+		gotVersion = r.Header.Get("anthropic-version")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &gotReq)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_1","role":"assistant"}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"讓我"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"看看"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"ping"}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_x","name":"fs_list_dir","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"pa"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"th\": \"src\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, e := range events {
+			var head struct{ Type string }
+			_ = json.Unmarshal([]byte(e), &head)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", head.Type, e)
+		}
+	}))
+	defer server.Close()
+
+	args := api.NewToolCallFunctionArguments()
+	args.Set("city", "臺北")
+	history := []ollama.Message{
+		{Role: "system", Content: "你是程式助理"},
+		{Role: "user", Content: "看這張圖", Images: []string{"iVBORw0KGgo="}},
+		{Role: "assistant", Content: "先查天氣", ToolCalls: []api.ToolCall{
+			{ID: "toolu_prev", Function: api.ToolCallFunction{Name: "get_taiwan_weather", Arguments: args}},
+		}},
+		{Role: "tool", Content: "晴天"},
+		{Role: "user", Content: "列出 src"},
+	}
+
+	cfg := Config{BaseURL: server.URL, APIKey: "ak-test", MaxTokens: 256}
+	var streamed strings.Builder
+	msg, err := cfg.ChatStream(context.Background(), "claude-test", history, []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "fs_list_dir", Description: "list"}}}, ollama.Options{Temperature: 0.2}, func(s string) {
+		streamed.WriteString(s)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream returned error: %v", err)
+	}
+
+	if gotKey != "ak-test" || gotVersion != defaultVersion {
+		t.Errorf("headers not set: key=%q version=%q", gotKey, gotVersion)
+	}
+	if gotReq.System != "你是程式助理" || gotReq.MaxTokens != 256 {
+		t.Errorf("unexpected system/max_tokens: %q %d", gotReq.System, gotReq.MaxTokens)
+	}
+	// user(image+text), assistant(text+tool_use), user(tool_result+text)
+	if len(gotReq.Messages) != 3 {
+		t.Fatalf("expected 3 alternating messages, got %d: %+v", len(gotReq.Messages), gotReq.Messages)
+	}
+	if b := gotReq.Messages[0].Content[0]; b.Type != "image" || b.Source == nil || b.Source.MediaType != "image/png" {
+		t.Errorf("image block not converted: %+v", b)
+	}
+	if b := gotReq.Messages[1].Content[1]; b.Type != "tool_use" || b.ID != "toolu_prev" {
+		t.Errorf("tool_use block not converted: %+v", b)
+	}
+	last := gotReq.Messages[2]
+	if last.Role != "user" || last.Content[0].Type != "tool_result" || last.Content[0].ToolUseID != "toolu_prev" || last.Content[1].Text != "列出 src" {
+		t.Errorf("tool_result not merged into following user turn: %+v", last)
+	}
+	if len(gotReq.Tools) != 1 || gotReq.Tools[0].Name != "fs_list_dir" {
+		t.Errorf("tools not converted: %+v", gotReq.Tools)
+	}
+
+	if msg.Content != "讓我看看" || streamed.String() != msg.Content {
+		t.Errorf("unexpected content: %q (streamed %q)", msg.Content, streamed.String())
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID != "toolu_x" || msg.ToolCalls[0].Function.Name != "fs_list_dir" {
+		t.Fatalf("unexpected tool calls: %+v", msg.ToolCalls)
+	}
+	if p, _ := msg.ToolCalls[0].Function.Arguments.Get("path"); p != "src" {
+		t.Errorf("partial json not assembled: %v", msg.ToolCalls[0].Function.Arguments.ToMap())
+	}
+}
+
+func TestChatStream_StreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	}))
+	defer server.Close()
+
+	_, err := Config{BaseURL: server.URL, APIKey: "k"}.ChatStream(context.Background(), "m", []ollama.Message{{Role: "user", Content: "hi"}}, nil, ollama.Options{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Fatalf("expected overloaded error, got %v", err)
+	}
+}
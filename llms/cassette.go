@@ -0,0 +1,294 @@
+package llms
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// ──────────────────────────────────────────────────────
+// Cassette：錄製 / 重播 Provider 回應
+// ──────────────────────────────────────────────────────
+//
+// 錄製模式呼叫真正的 Provider，並把每次請求 (模型、訊息、工具、參數) 與串流回應
+// 逐行寫入 JSONL；重播模式不連線任何模型，依請求找出對應紀錄並依序送出串流片段，
+// 讓 Agent.Chat 的工具呼叫流程 (含各種補救解析) 可以離線做回歸測試：
+//
+//	c, _ := llms.OpenCassette("testdata/weather.jsonl", llms.CassetteReplay)
+//	myAgent.Provider = c.Wrap(nil)
+//
+// 也可設定 PCAI_CASSETTE 與 PCAI_CASSETTE_MODE (record / replay)，
+// 讓 GetDefaultChatStream 直接錄製或重播實際執行的對話。
+
+// CassetteMode 是 Cassette 的運作模式
+type CassetteMode string
+
+const (
+	CassetteRecord CassetteMode = "record"
+	CassetteReplay CassetteMode = "replay"
+)
+
+// CassetteEntry 是一次 Provider 呼叫的紀錄
+// 手寫測試資料時可省略 key 與請求欄位，該筆紀錄只會依順序被取用
+type CassetteEntry struct {
+	Key      string           `json:"key,omitempty"` // 請求指紋，供重播時比對
+	Model    string           `json:"model,omitempty"`
+	Messages []ollama.Message `json:"messages,omitempty"`
+	Tools    []api.Tool       `json:"tools,omitempty"`
+	Options  ollama.Options   `json:"options"`
+
+	Chunks   []string       `json:"chunks,omitempty"` // 串流回調依序收到的片段
+	Response ollama.Message `json:"response"`
+	Thinking string         `json:"thinking,omitempty"` // Message.Thinking 不會序列化，另外保存
+	Error    string         `json:"error,omitempty"`
+}
+
+// Cassette 管理一個錄製檔
+type Cassette struct {
+	Path string
+	Mode CassetteMode
+	// Strict 為 true 時重播只接受指紋相符的紀錄；預設找不到相符紀錄時依錄製順序取下一筆
+	Strict bool
+
+	mu      sync.Mutex
+	entries []CassetteEntry
+	used    []bool
+	file    *os.File
+}
+
+// OpenCassette 開啟錄製檔：錄製模式會建立 (覆寫) 檔案，重播模式會載入所有紀錄
+func OpenCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode}
+	switch mode {
+	case CassetteRecord:
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("建立錄製檔失敗: %w", err)
+		}
+		c.file = f
+	case CassetteReplay:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("開啟錄製檔失敗: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "//") {
+				continue
+			}
+			var e CassetteEntry
+			if err := json.Unmarshal([]byte(text), &e); err != nil {
+				return nil, fmt.Errorf("解析錄製檔 %s 第 %d 行失敗: %w", path, line, err)
+			}
+			c.entries = append(c.entries, e)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("讀取錄製檔失敗: %w", err)
+		}
+		c.used = make([]bool, len(c.entries))
+	default:
+		return nil, fmt.Errorf("未知的 Cassette 模式: %q", mode)
+	}
+	return c, nil
+}
+
+// Close 關閉錄製檔
+func (c *Cassette) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// Remaining 回傳尚未被重播的紀錄數量 (測試可用來確認流程呼叫次數)
+func (c *Cassette) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, u := range c.used {
+		if !u {
+			n++
+		}
+	}
+	return n
+}
+
+// Wrap 回傳錄製或重播用的 ChatStreamFunc；重播模式不會呼叫 fn (可傳 nil)
+func (c *Cassette) Wrap(fn ChatStreamFunc) ChatStreamFunc {
+	if c.Mode == CassetteReplay {
+		return c.replay
+	}
+	return func(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+		var chunks []string
+		msg, err := fn(ctx, modelName, messages, tools, opts, func(s string) {
+			chunks = append(chunks, s)
+			if callback != nil {
+				callback(s)
+			}
+		})
+
+		entry := CassetteEntry{
+			Key:      cassetteKey(modelName, messages, tools),
+			Model:    modelName,
+			Messages: messages,
+			Tools:    tools,
+			Options:  opts,
+			Chunks:   chunks,
+			Response: msg,
+			Thinking: msg.Thinking,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if werr := c.append(entry); werr != nil {
+			fmt.Printf("⚠️ [Cassette] 寫入錄製檔失敗: %v\n", werr)
+		}
+		return msg, err
+	}
+}
+
+// append 寫入一筆紀錄 (每筆一行，程式中斷時已錄製的內容仍可使用)
+func (c *Cassette) append(e CassetteEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return errors.New("錄製檔已關閉")
+	}
+	_, err = c.file.Write(append(data, '\n'))
+	return err
+}
+
+// replay 找出對應的紀錄並重播串流片段與回應
+func (c *Cassette) replay(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+	entry, err := c.next(cassetteKey(modelName, messages, tools))
+	if err != nil {
+		return ollama.Message{}, err
+	}
+
+	for _, chunk := range entry.Chunks {
+		if err := ctx.Err(); err != nil {
+			return ollama.Message{Role: "assistant"}, err
+		}
+		if callback != nil {
+			callback(chunk)
+		}
+	}
+	if entry.Thinking != "" {
+		if onThinking := ollama.ThinkingHandler(ctx); onThinking != nil {
+			onThinking(entry.Thinking)
+		}
+	}
+
+	msg := entry.Response
+	if msg.Role == "" {
+		msg.Role = "assistant"
+	}
+	// 手寫資料只提供 chunks 時，以串流內容作為回應
+	if msg.Content == "" && len(msg.ToolCalls) == 0 {
+		msg.Content = strings.Join(entry.Chunks, "")
+	}
+	msg.Thinking = entry.Thinking
+	if entry.Error != "" {
+		return msg, errors.New(entry.Error)
+	}
+	return msg, nil
+}
+
+// next 取出第一筆指紋相符且未使用的紀錄；非 Strict 模式下退回下一筆未使用的紀錄
+func (c *Cassette) next(key string) (CassetteEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, e := range c.entries {
+		if !c.used[i] && e.Key != "" && e.Key == key {
+			c.used[i] = true
+			return e, nil
+		}
+	}
+	if !c.Strict {
+		for i, e := range c.entries {
+			if !c.used[i] {
+				c.used[i] = true
+				return e, nil
+			}
+		}
+	}
+	return CassetteEntry{}, fmt.Errorf("錄製檔 %s 中沒有對應的紀錄 (key=%s)", c.Path, key)
+}
+
+// cassetteKey 以模型、最後一則訊息與工具名稱計算請求指紋
+// 較早的訊息常含日期、記憶等每次執行都不同的內容，因此不納入
+func cassetteKey(modelName string, messages []ollama.Message, tools []api.Tool) string {
+	h := sha256.New()
+	h.Write([]byte(modelName))
+	if n := len(messages); n > 0 {
+		last := messages[n-1]
+		h.Write([]byte{0})
+		h.Write([]byte(last.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(last.Content))
+	}
+	names := make([]string, 0, len(tools))
+	for _, t := range tools {
+		names = append(names, t.Function.Name)
+	}
+	sort.Strings(names)
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+var (
+	envCassetteOnce sync.Once
+	envCassette     *Cassette
+)
+
+// withEnvCassette 依 PCAI_CASSETTE / PCAI_CASSETTE_MODE 包裝 Provider；未設定時原樣回傳
+func withEnvCassette(fn ChatStreamFunc) ChatStreamFunc {
+	envCassetteOnce.Do(func() {
+		path := os.Getenv("PCAI_CASSETTE")
+		if path == "" {
+			return
+		}
+		mode := CassetteMode(strings.ToLower(os.Getenv("PCAI_CASSETTE_MODE")))
+		if mode == "" {
+			mode = CassetteReplay
+		}
+		c, err := OpenCassette(path, mode)
+		if err != nil {
+			fmt.Printf("⚠️ [Cassette] %v，停用錄製/重播\n", err)
+			return
+		}
+		fmt.Printf("📼 [Cassette] %s 模式: %s\n", mode, path)
+		envCassette = c
+	})
+	if envCassette == nil {
+		return fn
+	}
+	return envCassette.Wrap(fn)
+}
@@ -0,0 +1,114 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	tools := []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "get_taiwan_weather"}}}
+
+	var args api.ToolCallFunctionArguments
+	args.Set("location", "苗栗縣")
+	live := func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		switch messages[len(messages)-1].Role {
+		case "user":
+			cb("查詢")
+			cb("中")
+			return ollama.Message{Role: "assistant", Content: "查詢中", Thinking: "需要天氣",
+				ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_taiwan_weather", Arguments: args}}}}, nil
+		case "tool":
+			cb("晴天")
+			return ollama.Message{Role: "assistant", Content: "晴天"}, nil
+		}
+		return ollama.Message{}, errors.New("boom")
+	}
+
+	rec, err := OpenCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := rec.Wrap(live)
+	turn1 := []ollama.Message{{Role: "user", Content: "苗栗天氣"}}
+	turn2 := append(turn1, ollama.Message{Role: "tool", Content: "晴"})
+	for _, msgs := range [][]ollama.Message{turn1, turn2} {
+		if _, err := recorded(context.Background(), "m", msgs, tools, ollama.Options{Temperature: 0.1}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := recorded(context.Background(), "m", []ollama.Message{{Role: "system"}}, nil, ollama.Options{}, nil); err == nil {
+		t.Fatal("recorder should pass provider errors through")
+	}
+	rec.Close()
+
+	play, err := OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play.Strict = true
+	replay := play.Wrap(nil)
+
+	// 依請求指紋比對，不受呼叫順序影響
+	msg, err := replay(context.Background(), "m", turn2, tools, ollama.Options{}, nil)
+	if err != nil || msg.Content != "晴天" {
+		t.Fatalf("turn2 replay = %q, %v", msg.Content, err)
+	}
+
+	var streamed, thought strings.Builder
+	ctx := ollama.WithThinkingHandler(context.Background(), func(s string) { thought.WriteString(s) })
+	msg, err = replay(ctx, "m", turn1, tools, ollama.Options{}, func(s string) { streamed.WriteString(s + "|") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed.String() != "查詢|中|" || thought.String() != "需要天氣" || msg.Thinking != "需要天氣" {
+		t.Errorf("chunks=%q thinking=%q/%q", streamed.String(), thought.String(), msg.Thinking)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Name != "get_taiwan_weather" {
+		t.Fatalf("tool calls not replayed: %+v", msg.ToolCalls)
+	}
+	if loc, _ := msg.ToolCalls[0].Function.Arguments.Get("location"); loc != "苗栗縣" {
+		t.Errorf("location = %v", loc)
+	}
+
+	if _, err := replay(context.Background(), "m", []ollama.Message{{Role: "system"}}, nil, ollama.Options{}, nil); err == nil || err.Error() != "boom" {
+		t.Errorf("recorded error = %v, want boom", err)
+	}
+	if play.Remaining() != 0 {
+		t.Errorf("remaining = %d", play.Remaining())
+	}
+	if _, err := replay(context.Background(), "m", turn1, tools, ollama.Options{}, nil); err == nil {
+		t.Error("strict replay should fail once the cassette is exhausted")
+	}
+}
+
+func TestCassetteReplaySequentialFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hand.jsonl")
+	data := "// 手寫資料：只提供回應，依順序取用\n" +
+		`{"chunks":["{\"name\": \"fs_list_dir\", ","\"arguments\": {\"path\": \".\"}}"]}` + "\n" +
+		`{"response":{"role":"assistant","content":"完成"}}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := c.Wrap(nil)
+	msg, _ := replay(context.Background(), "any", []ollama.Message{{Role: "user", Content: "列出檔案"}}, nil, ollama.Options{}, nil)
+	if msg.Content != `{"name": "fs_list_dir", "arguments": {"path": "."}}` {
+		t.Errorf("joined chunks = %q", msg.Content)
+	}
+	msg, _ = replay(context.Background(), "any", nil, nil, ollama.Options{}, nil)
+	if msg.Content != "完成" || msg.Role != "assistant" {
+		t.Errorf("second entry = %+v", msg)
+	}
+}
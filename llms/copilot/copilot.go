@@ -3,6 +3,7 @@ package copilot
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/asccclass/pcai/llms/openai"
 	"github.com/ollama/ollama/api"
 )
 
@@ -286,6 +288,13 @@ type chatRequest struct {
 	Messages []openAIMessage `json:"messages"`
 	Tools    []openAITool    `json:"tools,omitempty"`
 	Stream   bool            `json:"stream"`
+	// 生成參數 (OpenAI 相容)
+	Temperature float64  `json:"temperature,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	// 結構化輸出，格式與 OpenAI 相同
+	ResponseFormat *openai.ResponseFormat `json:"response_format,omitempty"`
 }
 
 // SSE 回應格式
@@ -298,6 +307,10 @@ type sseChunk struct {
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage,omitempty"`
 }
 
 // ──────────────────────────────────────────────────────
@@ -424,7 +437,7 @@ func sanitizeParams(params interface{}) interface{} {
 
 // ChatStream 實作 llms.ChatStreamFunc 介面
 // 透過 GitHub Copilot API 進行串流聊天
-func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+func ChatStream(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
 	// 1. 取得 GitHub Token
 	githubToken, err := GetGitHubToken()
 	if err != nil {
@@ -442,6 +455,12 @@ func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, o
 		Model:    modelName,
 		Messages: convertMessages(messages),
 		Stream:   true,
+
+		Temperature:    opts.Temperature,
+		TopP:           opts.TopP,
+		Stop:           opts.Stop,
+		MaxTokens:      opts.NumPredict,
+		ResponseFormat: openai.NewResponseFormat(opts.Format),
 	}
 
 	if len(tools) > 0 {
@@ -454,7 +473,7 @@ func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, o
 	}
 
 	// 4. 發送請求
-	req, _ := http.NewRequest("POST", copilotChatURL, bytes.NewBuffer(jsonData))
+	req, _ := http.NewRequestWithContext(ctx, "POST", copilotChatURL, bytes.NewBuffer(jsonData))
 	req.Header.Set("Authorization", "Bearer "+sessionToken)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "text/event-stream")
@@ -465,6 +484,7 @@ func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, o
 	req.Header.Set("Openai-Intent", "conversation-panel")
 
 	client := &http.Client{Timeout: 120 * time.Second}
+	start := time.Now()
 
 	var resp *http.Response
 	maxRetries := 2
@@ -473,11 +493,18 @@ func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, o
 		if err == nil {
 			break
 		}
+		if ctx.Err() != nil {
+			return ollama.Message{}, ctx.Err()
+		}
 		if i < maxRetries {
 			fmt.Printf("⚠️ Copilot 連線失敗 (嘗試 %d/%d): %v\n", i+1, maxRetries+1, err)
-			time.Sleep(2 * time.Second)
+			select {
+			case <-ctx.Done():
+				return ollama.Message{}, ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
 			// 重新建構 request body (因為 Body 已被讀取)
-			req, _ = http.NewRequest("POST", copilotChatURL, bytes.NewBuffer(jsonData))
+			req, _ = http.NewRequestWithContext(ctx, "POST", copilotChatURL, bytes.NewBuffer(jsonData))
 			req.Header.Set("Authorization", "Bearer "+sessionToken)
 			req.Header.Set("Content-Type", "application/json")
 			req.Header.Set("Accept", "text/event-stream")
@@ -531,6 +558,16 @@ func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, o
 			continue
 		}
 
+		// Copilot 會在最後的封包附帶 usage
+		if chunk.Usage != nil {
+			fullMsg.Usage = &ollama.Usage{
+				Provider:         "copilot",
+				Model:            modelName,
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+
 		if len(chunk.Choices) == 0 {
 			continue
 		}
@@ -588,6 +625,15 @@ func ChatStream(modelName string, messages []ollama.Message, tools []api.Tool, o
 		}
 	}
 
+	if fullMsg.Usage != nil {
+		fullMsg.Usage.Latency = time.Since(start)
+	}
+
+	// 串流途中被取消：回傳已收到的文字，不組合可能不完整的 tool_calls
+	if ctx.Err() != nil {
+		return fullMsg, ctx.Err()
+	}
+
 	// 6. 將 OpenAI 格式的 tool_calls 轉回 Ollama 格式
 	for i := 0; i < len(toolCallMap); i++ {
 		tc, ok := toolCallMap[i]
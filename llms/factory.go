@@ -5,18 +5,38 @@ import (
 	"os"
 	"strings"
 
+	"github.com/asccclass/pcai/llms/anthropic"
 	"github.com/asccclass/pcai/llms/copilot"
 	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/asccclass/pcai/llms/openai"
 )
 
 // GetProvider 回傳指定名稱的 Provider 函式
-// 目前支援: "ollama" (預設), "copilot" (GitHub Copilot)
+// 目前支援: "ollama" (預設), "copilot" (GitHub Copilot), "openai" (任何 OpenAI 相容端點), "claude" (Anthropic)
+// 回傳的函式會經過 Metered 包裝，自動回報 Token 用量，並以 SeparateThinking 分離推理內容
 func GetProviderFunc(providerName string) (ChatStreamFunc, error) {
+	fn, err := providerFunc(providerName)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(providerName)
+	if name == "" {
+		name = "ollama"
+	}
+	return Metered(name, SeparateThinking(fn)), nil
+}
+
+// providerFunc 回傳未包裝的 Provider 函式 (供 Failover 鏈內部使用，避免重複紀錄用量)
+func providerFunc(providerName string) (ChatStreamFunc, error) {
 	switch strings.ToLower(providerName) {
 	case "ollama", "": // 預設為 Ollama
 		return ollama.ChatStream, nil
 	case "copilot":
 		return copilot.ChatStream, nil
+	case "openai":
+		return openai.ChatStream, nil
+	case "claude", "anthropic":
+		return anthropic.ChatStream, nil
 	default:
 		return nil, errors.New("unsupported provider: " + providerName)
 	}
@@ -24,12 +44,21 @@ func GetProviderFunc(providerName string) (ChatStreamFunc, error) {
 
 // GetDefaultChatStream 回傳根據 PCAI_PROVIDER 環境變數設定的 ChatStreamFunc
 // 供背景服務(排程、歸納、Heartbeat)使用，不再寫死 Ollama
+// 若設定了 PCAI_PROVIDER_CHAIN，則回傳具備斷路器的 Failover 鏈
+// 若設定了 PCAI_CASSETTE，則改為錄製或重播 Provider 回應 (見 cassette.go)
 func GetDefaultChatStream() ChatStreamFunc {
-	provider := os.Getenv("PCAI_PROVIDER")
-	fn, err := GetProviderFunc(provider)
+	if chain := getDefaultChain(); chain != nil {
+		return Metered("chain", withEnvCassette(SeparateThinking(chain.ChatStream)))
+	}
+	provider := strings.ToLower(os.Getenv("PCAI_PROVIDER"))
+	fn, err := providerFunc(provider)
 	if err != nil {
 		// Fallback: Ollama
-		fn, _ = GetProviderFunc("ollama")
+		provider = "ollama"
+		fn = ollama.ChatStream
+	}
+	if provider == "" {
+		provider = "ollama"
 	}
-	return fn
+	return Metered(provider, withEnvCassette(SeparateThinking(fn)))
 }
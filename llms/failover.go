@@ -0,0 +1,350 @@
+package llms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/asccclass/pcai/llms/openai"
+	"github.com/ollama/ollama/api"
+)
+
+// ──────────────────────────────────────────────────────
+// Circuit Breaker
+// ──────────────────────────────────────────────────────
+
+// BreakerState 表示斷路器狀態
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // 正常，可送出請求
+	BreakerOpen     BreakerState = "open"      // 連續失敗，暫停使用直到冷卻結束
+	BreakerHalfOpen BreakerState = "half-open" // 冷卻結束，允許一次試探請求
+)
+
+// CircuitBreaker 是單一 Provider 的簡易斷路器
+// 連續失敗 Threshold 次後開啟，Cooldown 之後允許一次試探；試探成功即恢復
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	state    BreakerState
+	probing  bool
+}
+
+// NewCircuitBreaker 建立斷路器
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if cooldown <= 0 {
+		cooldown = time.Minute
+	}
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow 判斷目前是否可以送出請求
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// 同一時間只允許一個試探請求
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success 回報請求成功
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.state = BreakerClosed
+}
+
+// Failure 回報請求失敗
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release 請求被取消時釋放試探名額，不改變狀態 (取消不代表 Provider 成功或失敗)
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State 回傳目前狀態
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// ──────────────────────────────────────────────────────
+// Failover Chain
+// ──────────────────────────────────────────────────────
+
+// ChainEntry 是 Failover 鏈中的一個 Provider
+type ChainEntry struct {
+	Name     string         // 顯示用名稱，例如 "ollama@http://gpu2:11434"
+	Provider ChatStreamFunc // 實際的串流函式
+	Model    string         // 此 Provider 使用的模型名稱，空字串代表沿用呼叫端傳入的模型
+	Breaker  *CircuitBreaker
+}
+
+// ServeRecord 記錄某一輪對話由哪個 Provider 回應
+type ServeRecord struct {
+	Timestamp string   `json:"timestamp"`
+	Provider  string   `json:"provider"`
+	Model     string   `json:"model"`
+	Skipped   []string `json:"skipped,omitempty"` // 因斷路器開啟或失敗而略過的 Provider
+	Error     string   `json:"error,omitempty"`
+}
+
+// FailoverChain 依序嘗試多個 Provider，失敗時自動切換到下一個
+type FailoverChain struct {
+	Entries []*ChainEntry
+	LogPath string            // 服務紀錄 (JSONL)，空字串則不寫檔
+	OnServe func(ServeRecord) // 每一輪結束時的回調 (可選)
+
+	logMu sync.Mutex
+}
+
+// NewFailoverChain 建立 Failover 鏈
+func NewFailoverChain(entries ...*ChainEntry) *FailoverChain {
+	for _, e := range entries {
+		if e.Breaker == nil {
+			e.Breaker = NewCircuitBreaker(3, time.Minute)
+		}
+	}
+	return &FailoverChain{Entries: entries}
+}
+
+// ChatStream 實作 ChatStreamFunc
+// 若某個 Provider 已經開始串流輸出後才失敗，則不再切換 (避免重複輸出)，直接回傳錯誤
+func (c *FailoverChain) ChatStream(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+	var skipped []string
+	var errs []error
+
+	for _, e := range c.Entries {
+		if !e.Breaker.Allow() {
+			skipped = append(skipped, e.Name+"(open)")
+			continue
+		}
+
+		model := modelName
+		if e.Model != "" {
+			model = e.Model
+		}
+
+		streamed := false
+		msg, err := e.Provider(ctx, model, messages, tools, opts, func(s string) {
+			streamed = true
+			if callback != nil {
+				callback(s)
+			}
+		})
+
+		if err == nil {
+			e.Breaker.Success()
+			if msg.Usage == nil {
+				msg.Usage = &ollama.Usage{}
+			}
+			msg.Usage.Provider = e.Name
+			msg.Usage.Model = model
+			if len(skipped) > 0 {
+				fmt.Printf("🔀 [Failover] 本輪改由 %s (%s) 回應，略過: %s\n", e.Name, model, strings.Join(skipped, ", "))
+			}
+			c.record(ServeRecord{Provider: e.Name, Model: model, Skipped: skipped})
+			return msg, nil
+		}
+
+		// 取消不是 Provider 的錯，不計入斷路器也不切換 (但要釋放試探名額，否則半開狀態會永遠卡住)
+		if ctx.Err() != nil {
+			e.Breaker.Release()
+			return msg, err
+		}
+
+		e.Breaker.Failure()
+		errs = append(errs, fmt.Errorf("%s: %w", e.Name, err))
+		if streamed {
+			c.record(ServeRecord{Provider: e.Name, Model: model, Skipped: skipped, Error: err.Error()})
+			return msg, err
+		}
+
+		fmt.Printf("⚠️ [Failover] %s 失敗 (%v)，嘗試下一個 Provider\n", e.Name, err)
+		skipped = append(skipped, e.Name)
+	}
+
+	err := fmt.Errorf("所有 Provider 皆無法使用 (略過: %s): %w", strings.Join(skipped, ", "), errors.Join(errs...))
+	c.record(ServeRecord{Skipped: skipped, Error: err.Error()})
+	return ollama.Message{}, err
+}
+
+// Status 回傳每個 Provider 的斷路器狀態
+func (c *FailoverChain) Status() map[string]BreakerState {
+	status := make(map[string]BreakerState, len(c.Entries))
+	for _, e := range c.Entries {
+		status[e.Name] = e.Breaker.State()
+	}
+	return status
+}
+
+func (c *FailoverChain) record(rec ServeRecord) {
+	rec.Timestamp = time.Now().Format(time.RFC3339)
+	if c.OnServe != nil {
+		c.OnServe(rec)
+	}
+	if c.LogPath == "" {
+		return
+	}
+
+	c.logMu.Lock()
+	defer c.logMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(c.LogPath), 0755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(c.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, _ := json.Marshal(rec)
+	f.Write(append(data, '\n'))
+}
+
+// ──────────────────────────────────────────────────────
+// 從環境變數建立 Failover 鏈
+// ──────────────────────────────────────────────────────
+
+// ParseProviderChain 解析 Failover 鏈設定
+//
+//	chain:  "ollama, ollama@http://192.168.1.20:11434, copilot"
+//	models: "copilot=gpt-4o, ollama@http://192.168.1.20:11434=llama3.1:8b"
+//
+// name@url 目前支援 ollama (主機位址) 與 openai (Base URL)
+func ParseProviderChain(chain, models string, threshold int, cooldown time.Duration) (*FailoverChain, error) {
+	modelMap := make(map[string]string)
+	for _, pair := range strings.Split(models, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.TrimSpace(k) != "" {
+			modelMap[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	var entries []*ChainEntry
+	for _, spec := range strings.Split(chain, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, host, _ := strings.Cut(spec, "@")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		var fn ChatStreamFunc
+		switch {
+		case host != "" && name == "ollama":
+			// 鏈中的 Ollama 只嘗試一次，讓 Failover 盡快切換
+			fn = ollama.Client{Host: host, MaxRetries: 1}.ChatStream
+		case host != "" && name == "openai":
+			cfg := openai.ConfigFromEnv()
+			cfg.BaseURL = host
+			fn = cfg.ChatStream
+		case host != "":
+			return nil, fmt.Errorf("Provider %s 不支援指定位址 (%s)", name, spec)
+		case name == "ollama":
+			fn = ollama.Client{MaxRetries: 1}.ChatStream
+		default:
+			var err error
+			if fn, err = providerFunc(name); err != nil {
+				return nil, err
+			}
+		}
+
+		model := modelMap[spec]
+		if model == "" && host == "" {
+			model = modelMap[name]
+		}
+		entries = append(entries, &ChainEntry{
+			Name:     spec,
+			Provider: fn,
+			Model:    model,
+			Breaker:  NewCircuitBreaker(threshold, cooldown),
+		})
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("Provider 鏈為空")
+	}
+	return NewFailoverChain(entries...), nil
+}
+
+var (
+	defaultChainMu  sync.Mutex
+	defaultChain    *FailoverChain
+	defaultChainKey string
+)
+
+// getDefaultChain 依 PCAI_PROVIDER_CHAIN 建立 (並快取) 全域 Failover 鏈
+// 斷路器狀態需要跨請求保存，因此相同設定下重複使用同一個實例
+func getDefaultChain() *FailoverChain {
+	chain := os.Getenv("PCAI_PROVIDER_CHAIN")
+	if strings.TrimSpace(chain) == "" {
+		return nil
+	}
+	models := os.Getenv("PCAI_PROVIDER_MODELS")
+	threshold, _ := strconv.Atoi(os.Getenv("PCAI_FAILOVER_THRESHOLD"))
+	cooldownSec, _ := strconv.Atoi(os.Getenv("PCAI_FAILOVER_COOLDOWN"))
+
+	key := strings.Join([]string{chain, models, strconv.Itoa(threshold), strconv.Itoa(cooldownSec)}, "|")
+
+	defaultChainMu.Lock()
+	defer defaultChainMu.Unlock()
+	if defaultChain != nil && defaultChainKey == key {
+		return defaultChain
+	}
+
+	fc, err := ParseProviderChain(chain, models, threshold, time.Duration(cooldownSec)*time.Second)
+	if err != nil {
+		fmt.Printf("⚠️ [Failover] PCAI_PROVIDER_CHAIN 設定錯誤: %v\n", err)
+		return nil
+	}
+	home, _ := os.Getwd()
+	fc.LogPath = filepath.Join(home, "botmemory", "provider.log")
+
+	defaultChain, defaultChainKey = fc, key
+	return fc
+}
@@ -0,0 +1,142 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func fakeProvider(name string, fail *bool, gotModel *string) ChatStreamFunc {
+	return func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		*gotModel = model
+		if *fail {
+			return ollama.Message{}, errors.New(name + " down")
+		}
+		return ollama.Message{Role: "assistant", Content: "from " + name}, nil
+	}
+}
+
+func TestFailoverChain_SwitchesAndOpensBreaker(t *testing.T) {
+	primaryDown, backupDown := true, false
+	var primaryModel, backupModel string
+
+	chain := NewFailoverChain(
+		&ChainEntry{Name: "ollama", Provider: fakeProvider("ollama", &primaryDown, &primaryModel), Breaker: NewCircuitBreaker(2, 50*time.Millisecond)},
+		&ChainEntry{Name: "copilot", Provider: fakeProvider("copilot", &backupDown, &backupModel), Model: "gpt-4o"},
+	)
+	var served []ServeRecord
+	chain.OnServe = func(r ServeRecord) { served = append(served, r) }
+
+	msgs := []ollama.Message{{Role: "user", Content: "hi"}}
+	for i := 0; i < 2; i++ {
+		msg, err := chain.ChatStream(context.Background(), "llama3.3", msgs, nil, ollama.Options{}, nil)
+		if err != nil || msg.Content != "from copilot" {
+			t.Fatalf("round %d: expected backup reply, got %q, %v", i, msg.Content, err)
+		}
+	}
+	if primaryModel != "llama3.3" || backupModel != "gpt-4o" {
+		t.Errorf("model mapping not applied: primary=%q backup=%q", primaryModel, backupModel)
+	}
+	if got := chain.Status()["ollama"]; got != BreakerOpen {
+		t.Fatalf("expected primary breaker to be open after 2 failures, got %s", got)
+	}
+
+	// 斷路器開啟期間不應再呼叫主要 Provider
+	primaryModel = ""
+	if _, err := chain.ChatStream(context.Background(), "llama3.3", msgs, nil, ollama.Options{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if primaryModel != "" {
+		t.Errorf("primary provider was called while breaker open")
+	}
+	if last := served[len(served)-1]; last.Provider != "copilot" || len(last.Skipped) != 1 || last.Skipped[0] != "ollama(open)" {
+		t.Errorf("unexpected serve record: %+v", last)
+	}
+
+	// 冷卻結束後試探成功，恢復使用主要 Provider
+	time.Sleep(60 * time.Millisecond)
+	primaryDown = false
+	msg, err := chain.ChatStream(context.Background(), "llama3.3", msgs, nil, ollama.Options{}, nil)
+	if err != nil || msg.Content != "from ollama" {
+		t.Fatalf("expected primary to recover, got %q, %v", msg.Content, err)
+	}
+	if got := chain.Status()["ollama"]; got != BreakerClosed {
+		t.Errorf("expected breaker closed after successful probe, got %s", got)
+	}
+}
+
+func TestFailoverChain_NoSwitchAfterStreaming(t *testing.T) {
+	called := false
+	chain := NewFailoverChain(
+		&ChainEntry{Name: "a", Provider: func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+			cb("半")
+			return ollama.Message{Content: "半"}, errors.New("connection reset")
+		}},
+		&ChainEntry{Name: "b", Provider: func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+			called = true
+			return ollama.Message{}, nil
+		}},
+	)
+	if _, err := chain.ChatStream(context.Background(), "m", nil, nil, ollama.Options{}, func(string) {}); err == nil {
+		t.Fatal("expected error from partially streamed provider")
+	}
+	if called {
+		t.Error("chain must not fail over once output has been streamed")
+	}
+}
+
+func TestFailoverChain_CancelDuringProbeReleasesBreaker(t *testing.T) {
+	down := true
+	breaker := NewCircuitBreaker(1, 20*time.Millisecond)
+	chain := NewFailoverChain(&ChainEntry{Name: "a", Provider: func(ctx context.Context, m string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		if down {
+			return ollama.Message{}, errors.New("down")
+		}
+		<-ctx.Done()
+		return ollama.Message{}, ctx.Err()
+	}, Breaker: breaker})
+
+	_, _ = chain.ChatStream(context.Background(), "m", nil, nil, ollama.Options{}, nil)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected open breaker, got %s", breaker.State())
+	}
+
+	// 冷卻結束後的試探請求被取消
+	time.Sleep(30 * time.Millisecond)
+	down = false
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := chain.ChatStream(ctx, "m", nil, nil, ollama.Options{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	if breaker.State() != BreakerHalfOpen {
+		t.Errorf("cancellation must not change breaker state, got %s", breaker.State())
+	}
+	if !breaker.Allow() {
+		t.Error("probe slot should be released after cancellation")
+	}
+}
+
+func TestParseProviderChain(t *testing.T) {
+	chain, err := ParseProviderChain("ollama, ollama@http://gpu2:11434, copilot", "copilot=gpt-4o, ollama@http://gpu2:11434=llama3.1:8b", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(chain.Entries))
+	}
+	if chain.Entries[1].Name != "ollama@http://gpu2:11434" || chain.Entries[1].Model != "llama3.1:8b" {
+		t.Errorf("unexpected second entry: %+v", chain.Entries[1])
+	}
+	if chain.Entries[0].Model != "" || chain.Entries[2].Model != "gpt-4o" {
+		t.Errorf("unexpected model mapping: %q %q", chain.Entries[0].Model, chain.Entries[2].Model)
+	}
+
+	if _, err := ParseProviderChain("copilot@http://x", "", 0, 0); err == nil {
+		t.Error("expected error for unsupported host override")
+	}
+}
@@ -3,6 +3,7 @@ package ollama
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -15,9 +16,22 @@ import (
 )
 
 // Options 定義模型參數，用於調整 AI 的行為風格
+// 除 Temperature / TopP 外，零值欄位不送出，沿用 Provider / 模型的預設值
 type Options struct {
-	Temperature float64 `json:"temperature"`
-	TopP        float64 `json:"top_p"`
+	Temperature   float64  `json:"temperature"`
+	TopP          float64  `json:"top_p"`
+	NumCtx        int      `json:"num_ctx,omitempty"`        // 上下文長度 (小模型載入大量工具定義時需要調大)
+	NumPredict    int      `json:"num_predict,omitempty"`    // 最多生成的 Token 數
+	Seed          int      `json:"seed,omitempty"`           // 固定亂數種子，取得可重現的輸出 (測試用)
+	Stop          []string `json:"stop,omitempty"`           // 遇到這些字串即停止生成
+	RepeatPenalty float64  `json:"repeat_penalty,omitempty"` // 重複懲罰
+
+	// KeepAlive 模型在 Ollama 中常駐的時間 (例如 "10m"、"-1" 代表永久)，屬於請求層級參數
+	KeepAlive string `json:"-"`
+
+	// Format 要求結構化輸出："json" (任意 JSON) 或一份 JSON Schema
+	// 不屬於 Ollama 的 options 欄位，由各 Provider 轉換成對應的請求參數
+	Format json.RawMessage `json:"-"`
 }
 
 // Message 代表對話中的一則訊息（符合 Ollama /api/chat 標準）
@@ -26,34 +40,87 @@ type Message struct {
 	Content   string         `json:"content"`              // 訊息內容
 	Images    []string       `json:"images,omitempty"`     // 支援視覺模型 (Base64 陣列)
 	ToolCalls []api.ToolCall `json:"tool_calls,omitempty"` // AI 請求的工具呼叫
+	Usage     *Usage         `json:"-"`                    // Provider 回報的用量 (不送出、不存入 Session)
+	Thinking  string         `json:"-"`                    // 推理過程 (不送出、不存入 Session)
+}
+
+// Usage 記錄單次 LLM 呼叫的 Token 用量與耗時
+type Usage struct {
+	Provider         string        // 實際回應的 Provider
+	Model            string        // 實際使用的模型
+	PromptTokens     int           // 輸入 Token 數
+	CompletionTokens int           // 輸出 Token 數
+	Latency          time.Duration // 從送出請求到串流結束的總時間
+	EvalDuration     time.Duration // 純生成時間 (Provider 有回報時)
+}
+
+// TokensPerSecond 回傳輸出速度 (tokens/s)，優先使用純生成時間
+func (u *Usage) TokensPerSecond() float64 {
+	if u == nil || u.CompletionTokens == 0 {
+		return 0
+	}
+	d := u.EvalDuration
+	if d <= 0 {
+		d = u.Latency
+	}
+	if d <= 0 {
+		return 0
+	}
+	return float64(u.CompletionTokens) / d.Seconds()
 }
 
 // ChatRequest 定義發送至 /api/chat 的資料結構
 type ChatRequest struct {
-	Model    string     `json:"model"`
-	Messages []Message  `json:"messages"`
-	Tools    []api.Tool `json:"tools,omitempty"`   // 工具定義清單
-	Stream   bool       `json:"stream"`            // 是否啟用串流回傳
-	Options  Options    `json:"options,omitempty"` // 模型參數
+	Model     string          `json:"model"`
+	Messages  []Message       `json:"messages"`
+	Tools     []api.Tool      `json:"tools,omitempty"`      // 工具定義清單
+	Stream    bool            `json:"stream"`               // 是否啟用串流回傳
+	Options   Options         `json:"options,omitempty"`    // 模型參數
+	Format    json.RawMessage `json:"format,omitempty"`     // 結構化輸出 ("json" 或 JSON Schema)
+	KeepAlive string          `json:"keep_alive,omitempty"` // 模型常駐時間
 }
 
 // ChatResponseChunk 是串流回傳時每一小塊資料的格式
+// 最後一個 (done=true) 封包會附帶 Token 計數與耗時 (單位: 奈秒)
 type ChatResponseChunk struct {
-	Model     string  `json:"model"`
-	CreatedAt string  `json:"created_at"`
-	Message   Message `json:"message"`
-	Done      bool    `json:"done"`
+	Model     string `json:"model"`
+	CreatedAt string `json:"created_at"`
+	Message   struct {
+		Message
+		Thinking string `json:"thinking,omitempty"` // 推理模型的思考內容
+	} `json:"message"`
+	Done               bool  `json:"done"`
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int   `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+}
+
+// Client 指定 Ollama 主機與連線重試次數
+// 用於 Failover 鏈中同時連線多台 Ollama 主機
+type Client struct {
+	Host       string // 例如 http://192.168.1.20:11434，空字串時讀取 OLLAMA_HOST
+	MaxRetries int    // 連線失敗時的嘗試次數，<= 0 時為 3
 }
 
 // ChatStream 負責發送請求並處理串流回傳
 // 回傳完整的 Assistant Message，方便上層更新 Session 歷史
-func ChatStream(modelName string, messages []Message, tools []api.Tool, opts Options, callback func(string)) (Message, error) {
+// ctx 被取消時會中止連線與重試，並連同已收到的部分內容回傳 ctx.Err()
+func ChatStream(ctx context.Context, modelName string, messages []Message, tools []api.Tool, opts Options, callback func(string)) (Message, error) {
+	return Client{}.ChatStream(ctx, modelName, messages, tools, opts, callback)
+}
+
+// ChatStream 使用指定主機進行串流聊天
+func (c Client) ChatStream(ctx context.Context, modelName string, messages []Message, tools []api.Tool, opts Options, callback func(string)) (Message, error) {
 	reqBody := ChatRequest{
-		Model:    modelName,
-		Messages: messages,
-		Tools:    tools,
-		Stream:   true,
-		Options:  opts,
+		Model:     modelName,
+		Messages:  messages,
+		Tools:     tools,
+		Stream:    true,
+		Options:   opts,
+		Format:    opts.Format,
+		KeepAlive: opts.KeepAlive,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -62,27 +129,47 @@ func ChatStream(modelName string, messages []Message, tools []api.Tool, opts Opt
 	}
 
 	// 預設 Ollama 位址，可透過設定檔或環境變數擴充
-	ollamaURL := os.Getenv("OLLAMA_HOST")
+	ollamaURL := c.Host
+	if ollamaURL == "" {
+		ollamaURL = os.Getenv("OLLAMA_HOST")
+	}
 	if ollamaURL == "" {
 		ollamaURL = "http://localhost:11434"
 	}
 	// 處理 URL 結尾
 	ollamaURL = strings.TrimSuffix(ollamaURL, "/")
 
+	start := time.Now()
 	var resp *http.Response
-	maxRetries := 3
+	maxRetries := c.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
 
 	for i := 0; i < maxRetries; i++ {
-		// 每次重試都需要一個新的 Reader，因為 http.Post 會讀取它
-		resp, err = http.Post(ollamaURL+"/api/chat", "application/json", bytes.NewBuffer(jsonData))
+		// 每次重試都需要一個新的 Reader，因為送出請求時會讀取它
+		req, reqErr := http.NewRequestWithContext(ctx, "POST", ollamaURL+"/api/chat", bytes.NewReader(jsonData))
+		if reqErr != nil {
+			return Message{}, fmt.Errorf("建立請求失敗: %v", reqErr)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err = http.DefaultClient.Do(req)
 		if err == nil {
 			break
 		}
+		if ctx.Err() != nil {
+			return Message{}, ctx.Err()
+		}
 
 		// 若發生連線錯誤 (例如 connectex)，等待後重試
 		if i < maxRetries-1 {
 			fmt.Printf("⚠️ 連線至 Ollama 失敗 (嘗試 %d/%d): %v\n⏳ 3秒後重試...\n", i+1, maxRetries, err)
-			time.Sleep(3 * time.Second)
+			select {
+			case <-ctx.Done():
+				return Message{}, ctx.Err()
+			case <-time.After(3 * time.Second):
+			}
 		}
 	}
 
@@ -97,6 +184,7 @@ func ChatStream(modelName string, messages []Message, tools []api.Tool, opts Opt
 
 	var fullAssistantMsg Message
 	fullAssistantMsg.Role = "assistant"
+	onThinking := ThinkingHandler(ctx)
 
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
@@ -105,10 +193,20 @@ func ChatStream(modelName string, messages []Message, tools []api.Tool, opts Opt
 			continue
 		}
 
+		// 推理模型的思考內容與回答分開傳送
+		if chunk.Message.Thinking != "" {
+			fullAssistantMsg.Thinking += chunk.Message.Thinking
+			if onThinking != nil {
+				onThinking(chunk.Message.Thinking)
+			}
+		}
+
 		// 處理 AI 生成的文字內容
 		if chunk.Message.Content != "" {
 			fullAssistantMsg.Content += chunk.Message.Content
-			callback(chunk.Message.Content) // 即時回傳片段給 UI 顯示
+			if callback != nil {
+				callback(chunk.Message.Content) // 即時回傳片段給 UI 顯示
+			}
 		}
 
 		// 處理 AI 請求的工具呼叫
@@ -120,12 +218,24 @@ func ChatStream(modelName string, messages []Message, tools []api.Tool, opts Opt
 		}
 
 		if chunk.Done {
+			fullAssistantMsg.Usage = &Usage{
+				Provider:         "ollama",
+				Model:            modelName,
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				Latency:          time.Since(start),
+				EvalDuration:     time.Duration(chunk.EvalDuration),
+			}
 			break
 		}
 	}
 
+	// 串流途中被取消：連線會被中斷，回傳已收到的部分內容
+	if ctx.Err() != nil {
+		return fullAssistantMsg, ctx.Err()
+	}
 	if err := scanner.Err(); err != nil {
-		return Message{}, fmt.Errorf("串流讀取錯誤: %v", err)
+		return fullAssistantMsg, fmt.Errorf("串流讀取錯誤: %v", err)
 	}
 
 	return fullAssistantMsg, nil
@@ -0,0 +1,123 @@
+package ollama
+
+import (
+	"context"
+	"strings"
+)
+
+// ──────────────────────────────────────────────────────
+// 推理內容 (Reasoning / Thinking)
+// ──────────────────────────────────────────────────────
+//
+// 推理模型會以兩種方式送出思考過程：
+//   1. 獨立欄位 (Ollama 的 message.thinking、Claude 的 thinking block、部分 OpenAI 相容伺服器的 reasoning_content)
+//   2. 直接夾在內容中的 <think>...</think> 區塊
+// Provider 層負責把兩者都放進 Message.Thinking，Content 只保留回答本身；
+// Message.Thinking 不會序列化，因此不會寫入 Session、日誌或知識庫。
+
+type thinkingHandlerKey struct{}
+
+// WithThinkingHandler 設定推理內容的串流回調 (例如 CLI 以淡色顯示)
+// 未設定時推理內容只會出現在回傳的 Message.Thinking
+func WithThinkingHandler(ctx context.Context, fn func(string)) context.Context {
+	return context.WithValue(ctx, thinkingHandlerKey{}, fn)
+}
+
+// ThinkingHandler 取出 Context 中的推理回調，沒有時回傳 nil
+func ThinkingHandler(ctx context.Context) func(string) {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(thinkingHandlerKey{}).(func(string))
+	return fn
+}
+
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// ThinkSplitter 在串流中把 <think>...</think> 區塊與回答內容分開
+// 標籤可能被切在兩個封包之間，因此疑似標籤開頭的尾端會先保留到下一次 Feed
+type ThinkSplitter struct {
+	inThink bool
+	pending string
+}
+
+// Feed 處理一個串流片段，回傳可以立即輸出的回答內容與推理內容
+func (s *ThinkSplitter) Feed(chunk string) (content, thinking string) {
+	buf := s.pending + chunk
+	s.pending = ""
+
+	var c, t strings.Builder
+	for buf != "" {
+		tag := thinkOpen
+		if s.inThink {
+			tag = thinkClose
+		}
+
+		if idx := strings.Index(buf, tag); idx >= 0 {
+			if s.inThink {
+				t.WriteString(buf[:idx])
+			} else {
+				c.WriteString(buf[:idx])
+			}
+			buf = buf[idx+len(tag):]
+			s.inThink = !s.inThink
+			continue
+		}
+
+		// 尾端可能是被切斷的標籤，保留下來等待下一個片段
+		keep := partialSuffix(buf, tag)
+		out := buf[:len(buf)-keep]
+		if s.inThink {
+			t.WriteString(out)
+		} else {
+			c.WriteString(out)
+		}
+		s.pending = buf[len(buf)-keep:]
+		break
+	}
+	return c.String(), t.String()
+}
+
+// Flush 在串流結束時輸出剩餘的保留內容
+func (s *ThinkSplitter) Flush() (content, thinking string) {
+	rest := s.pending
+	s.pending = ""
+	if s.inThink {
+		return "", rest
+	}
+	return rest, ""
+}
+
+// partialSuffix 回傳 s 的尾端與 tag 開頭重疊的長度
+func partialSuffix(s, tag string) int {
+	max := len(tag) - 1
+	if len(s) < max {
+		max = len(s)
+	}
+	for n := max; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// SplitThinking 將完整文字中的 <think> 區塊取出，回傳清理後的內容與推理內容
+func SplitThinking(text string) (content, thinking string) {
+	var s ThinkSplitter
+	c, t := s.Feed(text)
+	fc, ft := s.Flush()
+	return strings.TrimSpace(c + fc), strings.TrimSpace(t + ft)
+}
+
+// StripThinking 移除文字中的 <think> 區塊 (用於舊資料或寫入記憶前的保險)
+func StripThinking(text string) string {
+	if !strings.Contains(text, thinkOpen) {
+		return text
+	}
+	content, _ := SplitThinking(text)
+	return content
+}
@@ -0,0 +1,30 @@
+package ollama
+
+import "testing"
+
+func TestThinkSplitter_TagsSplitAcrossChunks(t *testing.T) {
+	chunks := []string{"<thi", "nk>先想", "一下</th", "ink>答案", "是 42 <", "b>"}
+	var s ThinkSplitter
+	var content, thinking string
+	for _, c := range chunks {
+		ct, th := s.Feed(c)
+		content += ct
+		thinking += th
+	}
+	ct, th := s.Flush()
+	content += ct
+	thinking += th
+
+	if content != "答案是 42 <b>" || thinking != "先想一下" {
+		t.Errorf("unexpected split: content=%q thinking=%q", content, thinking)
+	}
+}
+
+func TestStripThinking(t *testing.T) {
+	if got := StripThinking("<think>推理</think>\n\n回答"); got != "回答" {
+		t.Errorf("got %q", got)
+	}
+	if got := StripThinking("  沒有推理  "); got != "  沒有推理  " {
+		t.Errorf("content without think block must be untouched, got %q", got)
+	}
+}
@@ -1,16 +1,30 @@
 package llms
 
-import "github.com/asccclass/pcai/llms/ollama"
+import (
+	"context"
 
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/asccclass/pcai/llms/openai"
+)
+
+// OpenAIProvider 以 Provider 介面包裝 OpenAI 相容端點 (非串流用途)
 type OpenAIProvider struct {
 	APIKey string
 	Model  string
-	URL    string
+	URL    string // 例如 https://api.openai.com/v1 或 http://localhost:8080/v1
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, messages []ollama.Message) (ollama.Message, error) {
+	cfg := openai.ConfigFromEnv()
+	if p.URL != "" {
+		cfg.BaseURL = p.URL
+	}
+	if p.APIKey != "" {
+		cfg.APIKey = p.APIKey
+	}
+	return cfg.ChatStream(ctx, p.Model, messages, nil, ollama.Options{}, nil)
 }
 
-func (p *OpenAIProvider) Chat(messages []ollama.Message) (ollama.Message, error) {
-	// 將 PCAI 內部的 Message 格式轉換為 OpenAI 格式
-	// 發送 HTTP 請求至 p.URL (例如 https://api.openai.com/v1/chat/completions)
-	// 回傳結果...
-	return ollama.Message{Role: "assistant", Content: "來自外部 LLM 的回覆"}, nil
+func (p *OpenAIProvider) Name() string {
+	return "openai"
 }
@@ -0,0 +1,544 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// ──────────────────────────────────────────────────────
+// 設定
+// ──────────────────────────────────────────────────────
+
+const defaultBaseURL = "https://api.openai.com/v1"
+
+// Config 定義 OpenAI 相容端點的連線設定
+// 適用於 OpenAI 官方、llama.cpp server、vLLM、LM Studio 等提供 /v1/chat/completions 的服務
+type Config struct {
+	BaseURL string            // 例如 http://localhost:8080/v1
+	APIKey  string            // 可為空 (本地端服務通常不需要)
+	Headers map[string]string // 額外的 HTTP Header
+	Timeout time.Duration
+	// NoStreamUsage 為 true 時不送出 stream_options.include_usage (給不支援的舊版伺服器)
+	NoStreamUsage bool
+}
+
+// ConfigFromEnv 從環境變數讀取設定
+//
+//	OPENAI_BASE_URL      端點位址 (預設 https://api.openai.com/v1)
+//	OPENAI_API_KEY       API Key
+//	OPENAI_EXTRA_HEADERS 額外 Header，格式為 "Key: Value; Key2: Value2"
+//	OPENAI_STREAM_USAGE  設為 false 時不要求串流回報用量
+func ConfigFromEnv() Config {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	return Config{
+		BaseURL: baseURL,
+		APIKey:  os.Getenv("OPENAI_API_KEY"),
+		Headers: ParseHeaders(os.Getenv("OPENAI_EXTRA_HEADERS")),
+		Timeout: 300 * time.Second,
+
+		NoStreamUsage: strings.EqualFold(os.Getenv("OPENAI_STREAM_USAGE"), "false"),
+	}
+}
+
+// ParseHeaders 解析 "Key: Value; Key2: Value2" 格式的 Header 字串
+func ParseHeaders(raw string) map[string]string {
+	headers := make(map[string]string)
+	for _, part := range strings.Split(raw, ";") {
+		k, v, ok := strings.Cut(part, ":")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		headers[k] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+// endpoint 回傳 chat completions 的完整 URL
+// 允許 BaseURL 寫成 http://host:port、http://host:port/v1 或完整的 /chat/completions 路徑
+func (c Config) endpoint() string {
+	base := strings.TrimSuffix(c.BaseURL, "/")
+	if base == "" {
+		base = defaultBaseURL
+	}
+	if strings.HasSuffix(base, "/chat/completions") {
+		return base
+	}
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return base + "/chat/completions"
+}
+
+// ──────────────────────────────────────────────────────
+// OpenAI API 資料結構
+// ──────────────────────────────────────────────────────
+
+type chatMessage struct {
+	Role       string     `json:"role"`
+	Content    any        `json:"content,omitempty"` // string 或 []contentPart
+	ToolCalls  []toolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+type toolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type tool struct {
+	Type     string       `json:"type"`
+	Function toolFunction `json:"function"`
+}
+
+type toolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Tools    []tool        `json:"tools,omitempty"`
+	Stream   bool          `json:"stream"`
+	// 要求在最後一個串流封包附帶 usage
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"` // 指標：明確設定的 0 也要送出
+	TopP          *float64       `json:"top_p,omitempty"`
+	Seed          int            `json:"seed,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	// 結構化輸出 (json_object 或 json_schema)
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat 是 OpenAI 的 response_format 參數
+type ResponseFormat struct {
+	Type       string          `json:"type"` // json_object 或 json_schema
+	JSONSchema *ResponseSchema `json:"json_schema,omitempty"`
+}
+
+// ResponseSchema 是 response_format.json_schema 的內容
+type ResponseSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// NewResponseFormat 將 ollama.Options.Format 轉換為 OpenAI 的 response_format
+// "json" 對應 json_object；其他內容視為 JSON Schema；空值回傳 nil
+func NewResponseFormat(format json.RawMessage) *ResponseFormat {
+	trimmed := strings.TrimSpace(string(format))
+	switch {
+	case trimmed == "" || trimmed == "null":
+		return nil
+	case trimmed == `"json"`:
+		return &ResponseFormat{Type: "json_object"}
+	default:
+		return &ResponseFormat{Type: "json_schema", JSONSchema: &ResponseSchema{Name: "response", Schema: format}}
+	}
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// usage 是 OpenAI 格式的 Token 用量
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// SSE 回應格式
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Role      string     `json:"role,omitempty"`
+			Content   string     `json:"content,omitempty"`
+			ToolCalls []toolCall `json:"tool_calls,omitempty"`
+			// 推理內容：DeepSeek / vLLM 使用 reasoning_content，OpenRouter / Ollama 相容端點使用 reasoning
+			ReasoningContent string `json:"reasoning_content,omitempty"`
+			Reasoning        string `json:"reasoning,omitempty"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *usage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// ──────────────────────────────────────────────────────
+// 格式轉換（Ollama ⇄ OpenAI）
+// ──────────────────────────────────────────────────────
+
+func convertMessages(msgs []ollama.Message) []chatMessage {
+	var out []chatMessage
+
+	// 追蹤 assistant 產生的 tool_call IDs，讓後續的 role:tool 訊息依序對應
+	var pendingToolCallIDs []string
+
+	for mi, m := range msgs {
+		msg := chatMessage{Role: m.Role, Content: m.Content}
+
+		if len(m.Images) > 0 {
+			parts := []contentPart{}
+			if m.Content != "" {
+				parts = append(parts, contentPart{Type: "text", Text: m.Content})
+			}
+			for _, img := range m.Images {
+				parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: imageDataURL(img)}})
+			}
+			msg.Content = parts
+		}
+
+		if len(m.ToolCalls) > 0 {
+			pendingToolCallIDs = nil
+			for i, tc := range m.ToolCalls {
+				callID := tc.ID
+				if callID == "" {
+					callID = fmt.Sprintf("call_%d_%d", mi, i)
+				}
+				pendingToolCallIDs = append(pendingToolCallIDs, callID)
+				argsBytes, _ := json.Marshal(tc.Function.Arguments)
+				msg.ToolCalls = append(msg.ToolCalls, toolCall{
+					ID:   callID,
+					Type: "function",
+					Function: functionCall{
+						Name:      tc.Function.Name,
+						Arguments: string(argsBytes),
+					},
+				})
+			}
+			// 帶有 tool_calls 的 assistant 訊息若無文字則送 null
+			if m.Content == "" {
+				msg.Content = nil
+			}
+		}
+
+		if m.Role == "tool" {
+			if len(pendingToolCallIDs) > 0 {
+				msg.ToolCallID = pendingToolCallIDs[0]
+				pendingToolCallIDs = pendingToolCallIDs[1:]
+			} else {
+				// 找不到對應的 tool_call，改以一般文字回傳以免 API 拒絕
+				msg.Role = "user"
+			}
+		}
+
+		out = append(out, msg)
+	}
+	return out
+}
+
+// imageDataURL 將 Ollama 的 Base64 圖片轉為 data URL (若已是 URL 則原樣回傳)
+func imageDataURL(img string) string {
+	if strings.HasPrefix(img, "data:") || strings.HasPrefix(img, "http://") || strings.HasPrefix(img, "https://") {
+		return img
+	}
+	mime := "image/png"
+	if raw, err := base64.StdEncoding.DecodeString(img); err == nil {
+		if detected := http.DetectContentType(raw); strings.HasPrefix(detected, "image/") {
+			mime = detected
+		}
+	}
+	return "data:" + mime + ";base64," + img
+}
+
+func convertTools(tools []api.Tool) []tool {
+	var out []tool
+	for _, t := range tools {
+		out = append(out, tool{
+			Type: "function",
+			Function: toolFunction{
+				Name:        t.Function.Name,
+				Description: t.Function.Description,
+				Parameters:  sanitizeParams(t.Function.Parameters),
+			},
+		})
+	}
+	return out
+}
+
+// sanitizeParams 確保參數 schema 是合法的 JSON Schema object
+// (llama.cpp / vLLM 的 grammar 產生器對缺少 type 的 property 特別敏感)
+func sanitizeParams(params any) any {
+	empty := map[string]any{"type": "object", "properties": map[string]any{}}
+
+	data, err := json.Marshal(params)
+	if err != nil || string(data) == "null" || string(data) == "{}" {
+		return empty
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return empty
+	}
+	if t, _ := m["type"].(string); t == "" {
+		m["type"] = "object"
+	}
+	if m["properties"] == nil {
+		m["properties"] = map[string]any{}
+	}
+	if props, ok := m["properties"].(map[string]any); ok {
+		for _, v := range props {
+			if propMap, ok := v.(map[string]any); ok && propMap["type"] == nil {
+				propMap["type"] = "string"
+			}
+		}
+	}
+	if req, ok := m["required"]; ok && req == nil {
+		delete(m, "required")
+	}
+	return m
+}
+
+// ──────────────────────────────────────────────────────
+// ChatStream — 核心串流聊天函式
+// ──────────────────────────────────────────────────────
+
+// ChatStream 實作 llms.ChatStreamFunc 介面，設定取自環境變數
+func ChatStream(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+	return ConfigFromEnv().ChatStream(ctx, modelName, messages, tools, opts, callback)
+}
+
+// ChatStream 以指定設定呼叫 /v1/chat/completions 並解析 SSE 串流
+func (c Config) ChatStream(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+	chatReq := chatRequest{
+		Model:       modelName,
+		Messages:    convertMessages(messages),
+		Stream:      true,
+		Temperature: &opts.Temperature, // 與 Ollama 相同一律送出 (0 代表最穩定的輸出，不是未設定)
+		// num_ctx、repeat_penalty、keep_alive 沒有對應參數 (由伺服器端決定)
+		Seed:      opts.Seed,
+		Stop:      opts.Stop,
+		MaxTokens: opts.NumPredict,
+
+		ResponseFormat: NewResponseFormat(opts.Format),
+	}
+	if opts.TopP > 0 { // top_p 為 0 沒有意義，視為未設定
+		chatReq.TopP = &opts.TopP
+	}
+	if len(tools) > 0 {
+		chatReq.Tools = convertTools(tools)
+	}
+	if !c.NoStreamUsage {
+		chatReq.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return ollama.Message{}, fmt.Errorf("JSON 序列化失敗: %v", err)
+	}
+
+	url := c.endpoint()
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		for k, v := range c.Headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+
+	client := &http.Client{Timeout: c.Timeout}
+	start := time.Now()
+
+	var resp *http.Response
+	maxRetries := 2
+	for i := 0; i <= maxRetries; i++ {
+		resp, err = client.Do(newRequest())
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ollama.Message{}, ctx.Err()
+		}
+		if i < maxRetries {
+			fmt.Printf("⚠️ OpenAI 相容端點連線失敗 (嘗試 %d/%d): %v\n", i+1, maxRetries+1, err)
+			select {
+			case <-ctx.Done():
+				return ollama.Message{}, ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
+		}
+	}
+	if err != nil {
+		return ollama.Message{}, fmt.Errorf("OpenAI 相容端點連線失敗 (%s): %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return ollama.Message{}, fmt.Errorf("OpenAI API 錯誤 (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	msg, err := parseStream(resp.Body, callback, ollama.ThinkingHandler(ctx))
+	if msg.Usage != nil {
+		msg.Usage.Provider = "openai"
+		msg.Usage.Model = modelName
+		msg.Usage.Latency = time.Since(start)
+	}
+	if ctx.Err() != nil {
+		// 串流途中被取消：只保留已收到的文字，不回傳可能不完整的工具呼叫
+		msg.ToolCalls = nil
+		return msg, ctx.Err()
+	}
+	return msg, err
+}
+
+// parseStream 解析 SSE 串流，累積文字內容並依 index 組合分段傳來的 tool_calls
+func parseStream(r io.Reader, callback, onThinking func(string)) (ollama.Message, error) {
+	fullMsg := ollama.Message{Role: "assistant"}
+
+	toolCallMap := make(map[int]*toolCall)
+	lastIndex := -1
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// SSE 格式: "data: {json}" 或 "data: [DONE]"
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != nil {
+			return fullMsg, fmt.Errorf("OpenAI 串流錯誤: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			fullMsg.Usage = &ollama.Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+
+		if reasoning := delta.ReasoningContent + delta.Reasoning; reasoning != "" {
+			fullMsg.Thinking += reasoning
+			if onThinking != nil {
+				onThinking(reasoning)
+			}
+		}
+
+		if delta.Content != "" {
+			fullMsg.Content += delta.Content
+			if callback != nil {
+				callback(delta.Content)
+			}
+		}
+
+		for _, tc := range delta.ToolCalls {
+			// 標準實作會帶 index；部分伺服器省略時，以新的 id 判斷是否為下一個呼叫
+			idx := lastIndex
+			switch {
+			case tc.Index != nil:
+				idx = *tc.Index
+			case tc.ID != "" && (lastIndex < 0 || toolCallMap[lastIndex].ID != tc.ID):
+				idx = lastIndex + 1
+			case idx < 0:
+				idx = 0
+			}
+			lastIndex = idx
+
+			entry, ok := toolCallMap[idx]
+			if !ok {
+				entry = &toolCall{Type: "function"}
+				toolCallMap[idx] = entry
+			}
+			if tc.ID != "" {
+				entry.ID = tc.ID
+			}
+			if tc.Function.Name != "" {
+				entry.Function.Name = tc.Function.Name
+			}
+			entry.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fullMsg, fmt.Errorf("串流讀取錯誤: %v", err)
+	}
+
+	// 將 OpenAI 格式的 tool_calls 依 index 順序轉回 Ollama 格式
+	indexes := make([]int, 0, len(toolCallMap))
+	for idx := range toolCallMap {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	for i, idx := range indexes {
+		tc := toolCallMap[idx]
+		if tc.Function.Name == "" {
+			continue
+		}
+		args := api.NewToolCallFunctionArguments()
+		if raw := strings.TrimSpace(tc.Function.Arguments); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &args); err != nil {
+				fmt.Printf("⚠️ [OpenAI] 無法解析 %s 的參數: %v\n", tc.Function.Name, err)
+				args = api.NewToolCallFunctionArguments()
+			}
+		}
+		fullMsg.ToolCalls = append(fullMsg.ToolCalls, api.ToolCall{
+			ID: tc.ID,
+			Function: api.ToolCallFunction{
+				Index:     i,
+				Name:      tc.Function.Name,
+				Arguments: args,
+			},
+		})
+	}
+
+	return fullMsg, nil
+}
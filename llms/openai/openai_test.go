@@ -0,0 +1,145 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func TestChatStream_ContentAndToolCalls(t *testing.T) {
+	var gotReq chatRequest
+	var gotAuth, gotExtra string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		gotAuth = r.Header.Get("Authorization")
+		gotExtra = r.Header.Get("X-Team")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &gotReq)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"choices":[{"delta":{"role":"assistant","content":"好的，"}}]}`,
+			`{"choices":[{"delta":{"content":"查詢中"}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_taiwan_weather","arguments":""}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"location\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"fs_list_dir","arguments":"{\"path\":\".\"}"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"臺北市\"}"}}]}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		}
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	cfg := Config{
+		BaseURL: server.URL,
+		APIKey:  "sk-test",
+		Headers: ParseHeaders("X-Team: pcai"),
+	}
+
+	args := api.NewToolCallFunctionArguments()
+	args.Set("path", "/tmp")
+	history := []ollama.Message{
+		{Role: "system", Content: "你是助理"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{{ID: "prev_1", Function: api.ToolCallFunction{Name: "fs_list_dir", Arguments: args}}}},
+		{Role: "tool", Content: "a.txt"},
+		{Role: "user", Content: "天氣如何?", Images: []string{"iVBORw0KGgo="}},
+	}
+
+	var streamed strings.Builder
+	msg, err := cfg.ChatStream(context.Background(), "local-model", history, nil, ollama.Options{Temperature: 0}, func(s string) {
+		streamed.WriteString(s)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream returned error: %v", err)
+	}
+
+	if gotAuth != "Bearer sk-test" || gotExtra != "pcai" {
+		t.Errorf("headers not forwarded: auth=%q extra=%q", gotAuth, gotExtra)
+	}
+	if gotReq.Model != "local-model" || !gotReq.Stream {
+		t.Errorf("unexpected request: model=%q stream=%v", gotReq.Model, gotReq.Stream)
+	}
+	if gotReq.Temperature == nil || *gotReq.Temperature != 0 || gotReq.TopP != nil {
+		t.Errorf("explicit temperature 0 should be sent and unset top_p omitted: %v %v", gotReq.Temperature, gotReq.TopP)
+	}
+	if len(gotReq.Messages) != 4 || gotReq.Messages[2].ToolCallID != "prev_1" {
+		t.Errorf("tool result not linked to previous tool call id: %+v", gotReq.Messages)
+	}
+	if parts, ok := gotReq.Messages[3].Content.([]any); !ok || len(parts) != 2 {
+		t.Errorf("expected text + image content parts, got %#v", gotReq.Messages[3].Content)
+	}
+
+	if msg.Content != "好的，查詢中" || streamed.String() != msg.Content {
+		t.Errorf("unexpected content: %q (streamed %q)", msg.Content, streamed.String())
+	}
+	if len(msg.ToolCalls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %d", len(msg.ToolCalls))
+	}
+	first := msg.ToolCalls[0]
+	if first.ID != "call_a" || first.Function.Name != "get_taiwan_weather" {
+		t.Errorf("unexpected first tool call: %+v", first)
+	}
+	if loc, _ := first.Function.Arguments.Get("location"); loc != "臺北市" {
+		t.Errorf("arguments not assembled across chunks: %v", first.Function.Arguments.ToMap())
+	}
+	if msg.ToolCalls[1].Function.Name != "fs_list_dir" {
+		t.Errorf("unexpected second tool call: %+v", msg.ToolCalls[1])
+	}
+}
+
+func TestChatStream_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := Config{BaseURL: server.URL + "/v1"}.ChatStream(context.Background(), "missing", []ollama.Message{{Role: "user", Content: "hi"}}, nil, ollama.Options{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected HTTP 404 error, got %v", err)
+	}
+}
+
+func TestConvertMessages_OrphanToolResult(t *testing.T) {
+	out := convertMessages([]ollama.Message{
+		{Role: "user", Content: "查天氣"},
+		{Role: "tool", Content: "晴"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{{ID: "call_a", Function: api.ToolCallFunction{Name: "get_taiwan_weather"}}}},
+		{Role: "tool", Content: "雨"},
+	})
+	if len(out) != 4 {
+		t.Fatalf("got %d messages, want 4", len(out))
+	}
+	if out[1].Role != "user" || out[1].ToolCallID != "" || out[1].Content != "晴" {
+		t.Errorf("orphan tool result should become user text: %+v", out[1])
+	}
+	if out[3].Role != "tool" || out[3].ToolCallID != "call_a" {
+		t.Errorf("matched tool result lost its call id: %+v", out[3])
+	}
+}
+
+func TestNewResponseFormat(t *testing.T) {
+	if NewResponseFormat(nil) != nil {
+		t.Error("empty format should not set response_format")
+	}
+	if rf := NewResponseFormat(json.RawMessage(`"json"`)); rf == nil || rf.Type != "json_object" {
+		t.Errorf("\"json\" should map to json_object, got %+v", rf)
+	}
+	schema := json.RawMessage(`{"type":"object"}`)
+	if rf := NewResponseFormat(schema); rf == nil || rf.Type != "json_schema" || string(rf.JSONSchema.Schema) != string(schema) {
+		t.Errorf("schema should map to json_schema, got %+v", rf)
+	}
+}
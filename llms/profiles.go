@@ -0,0 +1,193 @@
+package llms
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"gopkg.in/yaml.v3"
+)
+
+// ──────────────────────────────────────────────────────
+// 生成參數 Profile
+// ──────────────────────────────────────────────────────
+//
+// 各呼叫端不再寫死 Temperature 等數值，而是以名稱取用 Profile：
+//
+//	opts := llms.ProfileFor("morning_briefing", "briefing")
+//
+// 內建 Profile 可由 profiles.yaml (路徑可用 PCAI_PROFILES 指定) 覆寫，
+// bindings 區段讓 Agent、排程任務類型與技能各自改用其他 Profile：
+//
+//	profiles:
+//	  chat:
+//	    num_ctx: 16384
+//	  fast: { temperature: 0.2, num_predict: 512 }
+//	bindings:
+//	  morning_briefing: fast
+//
+// PCAI_SEED 設定時會套用到所有 Profile，方便測試取得可重現的輸出。
+
+// 內建 Profile 名稱
+const (
+	ProfileChat        = "chat"         // 一般對話 (Agent 預設)
+	ProfileToolRouting = "tool-routing" // 意圖解析、工具選擇等需要穩定輸出的決策
+	ProfileSummarize   = "summarize"    // 對話歸納、偏好萃取
+	ProfileExtraction  = "extraction"   // 記憶重整等要求高度一致的萃取
+	ProfileHeartbeat   = "heartbeat"    // 背景心跳決策
+	ProfileBriefing    = "briefing"     // 晨間簡報等較自由的寫作
+)
+
+// Profile 是一組具名的生成參數，欄位留空代表沿用上層 (內建值或 Provider 預設)
+type Profile struct {
+	Temperature   *float64 `yaml:"temperature"`
+	TopP          *float64 `yaml:"top_p"`
+	NumCtx        *int     `yaml:"num_ctx"`
+	NumPredict    *int     `yaml:"num_predict"`
+	Seed          *int     `yaml:"seed"`
+	Stop          []string `yaml:"stop"`
+	RepeatPenalty *float64 `yaml:"repeat_penalty"`
+	KeepAlive     string   `yaml:"keep_alive"`
+}
+
+// ProfileConfig 是 profiles.yaml 的內容
+type ProfileConfig struct {
+	Profiles map[string]Profile `yaml:"profiles"`
+	Bindings map[string]string  `yaml:"bindings"` // 用途 (Agent 角色、任務類型、技能名稱) -> Profile 名稱
+}
+
+// builtinProfiles 沿用各呼叫端原本寫死的數值
+var builtinProfiles = map[string]ollama.Options{
+	ProfileChat:        {Temperature: 0.7, TopP: 0.9},
+	ProfileToolRouting: {Temperature: 0.1},
+	ProfileSummarize:   {Temperature: 0.3, TopP: 0.9},
+	ProfileExtraction:  {Temperature: 0.1},
+	ProfileHeartbeat:   {Temperature: 0.3},
+	ProfileBriefing:    {Temperature: 0.5},
+}
+
+var (
+	profileMu     sync.RWMutex
+	profileConfig *ProfileConfig
+)
+
+// LoadProfiles 讀取 Profile 設定檔；檔案不存在時只使用內建 Profile
+func LoadProfiles(path string) error {
+	cfg := &ProfileConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("讀取 Profile 設定失敗: %w", err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("解析 Profile 設定失敗 (%s): %w", path, err)
+		}
+	}
+
+	profileMu.Lock()
+	profileConfig = cfg
+	profileMu.Unlock()
+	return nil
+}
+
+// currentProfiles 回傳目前的設定，第一次使用時自動載入 PCAI_PROFILES (預設 profiles.yaml)
+func currentProfiles() *ProfileConfig {
+	profileMu.RLock()
+	cfg := profileConfig
+	profileMu.RUnlock()
+	if cfg != nil {
+		return cfg
+	}
+
+	path := os.Getenv("PCAI_PROFILES")
+	if path == "" {
+		path = "profiles.yaml"
+	}
+	if err := LoadProfiles(path); err != nil {
+		fmt.Printf("⚠️ [Profile] %v，改用內建 Profile\n", err)
+		profileMu.Lock()
+		profileConfig = &ProfileConfig{}
+		profileMu.Unlock()
+	}
+	profileMu.RLock()
+	defer profileMu.RUnlock()
+	return profileConfig
+}
+
+// ProfileOptions 取得具名 Profile 的生成參數；未知名稱會退回 chat
+func ProfileOptions(name string) ollama.Options {
+	cfg := currentProfiles()
+
+	base, builtin := builtinProfiles[name]
+	override, custom := cfg.Profiles[name]
+	if !builtin && !custom {
+		fmt.Printf("⚠️ [Profile] 找不到 Profile %q，改用 %s\n", name, ProfileChat)
+		base = builtinProfiles[ProfileChat]
+		override = cfg.Profiles[ProfileChat]
+	}
+
+	opts := override.apply(base)
+	if seed, err := strconv.Atoi(os.Getenv("PCAI_SEED")); err == nil {
+		opts.Seed = seed
+	}
+	return opts
+}
+
+// ProfileFor 依用途取得生成參數：先查 bindings，沒有綁定時使用 fallback Profile
+// purpose 可為 Agent 角色 (cli, telegram, patrol)、排程任務類型或技能名稱
+func ProfileFor(purpose, fallback string) ollama.Options {
+	if name := strings.TrimSpace(currentProfiles().Bindings[purpose]); name != "" {
+		return ProfileOptions(name)
+	}
+	return ProfileOptions(fallback)
+}
+
+// ProfileNames 列出所有可用的 Profile 名稱
+func ProfileNames() []string {
+	seen := make(map[string]bool)
+	for name := range builtinProfiles {
+		seen[name] = true
+	}
+	for name := range currentProfiles().Profiles {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply 以 Profile 中有設定的欄位覆寫 base
+func (p Profile) apply(base ollama.Options) ollama.Options {
+	if p.Temperature != nil {
+		base.Temperature = *p.Temperature
+	}
+	if p.TopP != nil {
+		base.TopP = *p.TopP
+	}
+	if p.NumCtx != nil {
+		base.NumCtx = *p.NumCtx
+	}
+	if p.NumPredict != nil {
+		base.NumPredict = *p.NumPredict
+	}
+	if p.Seed != nil {
+		base.Seed = *p.Seed
+	}
+	if p.Stop != nil {
+		base.Stop = append([]string(nil), p.Stop...)
+	}
+	if p.RepeatPenalty != nil {
+		base.RepeatPenalty = *p.RepeatPenalty
+	}
+	if p.KeepAlive != "" {
+		base.KeepAlive = p.KeepAlive
+	}
+	return base
+}
@@ -0,0 +1,51 @@
+package llms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles_OverrideBindingsAndSeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	yml := `
+profiles:
+  chat:
+    num_ctx: 16384
+    keep_alive: 30m
+  fast:
+    temperature: 0.2
+    num_predict: 256
+    stop: ["###"]
+bindings:
+  morning_briefing: fast
+`
+	if err := os.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadProfiles(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { profileConfig = nil }()
+
+	chat := ProfileOptions(ProfileChat)
+	if chat.Temperature != 0.7 || chat.TopP != 0.9 || chat.NumCtx != 16384 || chat.KeepAlive != "30m" {
+		t.Errorf("override should keep built-in values and add new ones: %+v", chat)
+	}
+
+	brief := ProfileFor("morning_briefing", ProfileBriefing)
+	if brief.Temperature != 0.2 || brief.NumPredict != 256 || len(brief.Stop) != 1 {
+		t.Errorf("binding not applied: %+v", brief)
+	}
+	if got := ProfileFor("memory_sleep", ProfileExtraction); got.Temperature != 0.1 {
+		t.Errorf("unbound purpose should use fallback, got %+v", got)
+	}
+	if got := ProfileOptions("no-such-profile"); got.Temperature != 0.7 {
+		t.Errorf("unknown profile should fall back to chat, got %+v", got)
+	}
+
+	t.Setenv("PCAI_SEED", "42")
+	if got := ProfileOptions(ProfileHeartbeat); got.Seed != 42 {
+		t.Errorf("PCAI_SEED not applied: %+v", got)
+	}
+}
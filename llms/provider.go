@@ -1,15 +1,18 @@
 package llms
 
 import (
+	"context"
+
 	"github.com/asccclass/pcai/llms/ollama"
 	"github.com/ollama/ollama/api"
 )
 
 // Provider 定義了所有 LLM 供應商必須實作的方法
 type Provider interface {
-	Chat(messages []ollama.Message) (ollama.Message, error)
+	Chat(ctx context.Context, messages []ollama.Message) (ollama.Message, error)
 	Name() string
 }
 
 // ChatStreamFunc 定義了通用的 LLM 聊天函式簽名
-type ChatStreamFunc func(modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error)
+// ctx 被取消時，實作必須中止 HTTP 請求並盡快回傳 (可連同已收到的部分內容一起回傳)
+type ChatStreamFunc func(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error)
@@ -0,0 +1,309 @@
+package llms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+)
+
+// ──────────────────────────────────────────────────────
+// 結構化輸出 (Structured JSON Output)
+// ──────────────────────────────────────────────────────
+//
+// 內部決策 (Heartbeat、意圖解析、架構分析、個性化萃取) 需要模型回傳固定格式的 JSON。
+// ChatJSON 會由 Go struct 產生 JSON Schema，透過 Options.Format 交給 Provider
+// (Ollama format / OpenAI response_format / Claude system 指示)，
+// 並驗證回覆內容；不符合時把錯誤回饋給模型重新詢問。
+//
+// 欄位以 json tag 命名，omitempty 或指標型別視為選填；額外限制寫在 jsonschema tag，以分號分隔：
+//
+//	Decision string `json:"decision" jsonschema:"enum=ACTION: NOTIFY_USER|STATUS: IDLE;description=決策"`
+//	Score    int    `json:"score" jsonschema:"minimum=0;maximum=100"`
+
+// DefaultJSONAttempts 是 ChatJSON 的預設嘗試次數 (含第一次)
+const DefaultJSONAttempts = 3
+
+// JSONSchema 是 JSON Schema 的精簡子集，足以描述內部決策用的結構
+type JSONSchema struct {
+	Type        string                 `json:"type,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Minimum     *float64               `json:"minimum,omitempty"`
+	Maximum     *float64               `json:"maximum,omitempty"`
+}
+
+// Validator 可由結果型別實作，用於 Schema 無法表達的額外檢查
+type Validator interface {
+	Validate() error
+}
+
+// SchemaFor 由 Go 型別產生 JSON Schema
+func SchemaFor[T any]() *JSONSchema {
+	return schemaForType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaForType(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &JSONSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object"}
+	case reflect.Struct:
+		s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			prop := schemaForType(f.Type)
+			applySchemaTag(prop, f.Tag.Get("jsonschema"))
+			s.Properties[name] = prop
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	default:
+		// interface{} 等任意值
+		return &JSONSchema{}
+	}
+}
+
+// applySchemaTag 解析 jsonschema:"enum=A|B;minimum=0;maximum=100;description=..."
+func applySchemaTag(s *JSONSchema, tag string) {
+	for _, part := range strings.Split(tag, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "description":
+			s.Description = strings.TrimSpace(v)
+		case "enum":
+			target := s
+			if s.Type == "array" && s.Items != nil {
+				target = s.Items
+			}
+			for _, e := range strings.Split(v, "|") {
+				target.Enum = append(target.Enum, strings.TrimSpace(e))
+			}
+		case "minimum":
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				s.Minimum = &n
+			}
+		case "maximum":
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				s.Maximum = &n
+			}
+		}
+	}
+}
+
+// Validate 檢查已解析的 JSON 值是否符合 Schema，回傳第一個不符合之處
+func (s *JSONSchema) Validate(v any) error {
+	return s.validate("$", v)
+}
+
+func (s *JSONSchema) validate(path string, v any) error {
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s 必須是物件", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s 缺少必要欄位 %q", path, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			if val, ok := obj[name]; ok && val != nil {
+				if err := prop.validate(path+"."+name, val); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s 必須是陣列", path)
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s 必須是字串", path)
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, str) {
+			return fmt.Errorf("%s 的值 %q 不在允許範圍 %v 內", path, str, s.Enum)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("%s 必須是數字", path)
+		}
+		if s.Type == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s 必須是整數", path)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s 不可小於 %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s 不可大於 %v", path, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s 必須是布林值", path)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractJSON 從模型回覆中取出 JSON 本體 (去除 Markdown code block 與前後說明文字)
+func ExtractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		text = strings.TrimSpace(text)
+	}
+	if json.Valid([]byte(text)) {
+		return text
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start == -1 {
+		return text
+	}
+	closer := "}"
+	if text[start] == '[' {
+		closer = "]"
+	}
+	if end := strings.LastIndex(text, closer); end > start {
+		return text[start : end+1]
+	}
+	return text
+}
+
+// ParseJSON 依 Schema 驗證並解析模型回覆
+func ParseJSON[T any](schema *JSONSchema, reply string) (T, error) {
+	var out T
+	raw := ExtractJSON(reply)
+	if raw == "" {
+		return out, errors.New("回覆內容為空")
+	}
+
+	var generic any
+	if err := json.Unmarshal([]byte(raw), &generic); err != nil {
+		return out, fmt.Errorf("不是合法的 JSON: %v", err)
+	}
+	if err := schema.Validate(generic); err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return out, fmt.Errorf("無法解析為 %T: %v", out, err)
+	}
+	// 指標的方法集合包含值接收者的方法，兩種實作方式都能判斷到
+	if v, ok := any(&out).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// ChatJSON 以結構化輸出模式呼叫 LLM 並回傳型別 T 的結果
+// 回覆不符合 Schema 時，會把錯誤原因附加到對話中重新詢問，最多嘗試 DefaultJSONAttempts 次
+func ChatJSON[T any](ctx context.Context, chatFn ChatStreamFunc, modelName string, messages []ollama.Message, opts ollama.Options) (T, error) {
+	var zero T
+	schema := SchemaFor[T]()
+	format, err := json.Marshal(schema)
+	if err != nil {
+		return zero, fmt.Errorf("產生 JSON Schema 失敗: %v", err)
+	}
+	opts.Format = format
+
+	// 複製一份，重問時追加的訊息不影響呼叫端
+	convo := append([]ollama.Message(nil), messages...)
+	var lastErr error
+	for attempt := 1; attempt <= DefaultJSONAttempts; attempt++ {
+		var sb strings.Builder
+		msg, err := chatFn(ctx, modelName, convo, nil, opts, func(s string) { sb.WriteString(s) })
+		if err != nil {
+			return zero, err
+		}
+		reply := sb.String()
+		if reply == "" {
+			reply = msg.Content
+		}
+
+		out, perr := ParseJSON[T](schema, reply)
+		if perr == nil {
+			return out, nil
+		}
+		lastErr = perr
+
+		preview := []rune(strings.TrimSpace(reply))
+		if len(preview) > 80 {
+			preview = preview[:80]
+		}
+		fmt.Printf("⚠️ [StructuredOutput] 第 %d 次回覆不符合格式: %v (原始內容: %s...)\n", attempt, perr, string(preview))
+
+		convo = append(convo,
+			ollama.Message{Role: "assistant", Content: reply},
+			ollama.Message{Role: "user", Content: fmt.Sprintf("你的回覆不符合要求的 JSON 格式：%v\n請修正後只回傳符合以下 JSON Schema 的 JSON，不要加任何說明文字：\n%s", perr, format)},
+		)
+	}
+	return zero, fmt.Errorf("結構化輸出驗證失敗 (已嘗試 %d 次): %w", DefaultJSONAttempts, lastErr)
+}
@@ -0,0 +1,81 @@
+package llms
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+type testDecision struct {
+	Decision string   `json:"decision" jsonschema:"enum=NOTIFY|IDLE"`
+	Score    int      `json:"score" jsonschema:"minimum=0;maximum=100"`
+	Tags     []string `json:"tags,omitempty"`
+	Note     *string  `json:"note"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	s := SchemaFor[testDecision]()
+	if s.Type != "object" || len(s.Properties) != 4 {
+		t.Fatalf("unexpected schema: %+v", s)
+	}
+	if strings.Join(s.Required, ",") != "decision,score" {
+		t.Errorf("omitempty/pointer fields should be optional, got required=%v", s.Required)
+	}
+	if d := s.Properties["decision"]; d.Type != "string" || len(d.Enum) != 2 {
+		t.Errorf("enum not applied: %+v", d)
+	}
+	if sc := s.Properties["score"]; sc.Type != "integer" || sc.Minimum == nil || *sc.Maximum != 100 {
+		t.Errorf("range not applied: %+v", sc)
+	}
+	if tags := s.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("slice schema wrong: %+v", tags)
+	}
+}
+
+func TestChatJSON_ReasksUntilValid(t *testing.T) {
+	replies := []string{
+		"好的，以下是決策：",
+		"```json\n{\"decision\": \"MAYBE\", \"score\": 50}\n```",
+		"{\"decision\": \"NOTIFY\", \"score\": 90}",
+	}
+	var calls int
+	var gotFormat json.RawMessage
+	var lastConvo []ollama.Message
+	chatFn := func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		gotFormat = opts.Format
+		lastConvo = messages
+		reply := replies[calls]
+		calls++
+		cb(reply)
+		return ollama.Message{Role: "assistant", Content: reply}, nil
+	}
+
+	input := []ollama.Message{{Role: "user", Content: "決定"}}
+	dec, err := ChatJSON[testDecision](context.Background(), chatFn, "m", input, ollama.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Decision != "NOTIFY" || dec.Score != 90 || calls != 3 {
+		t.Errorf("unexpected result %+v after %d calls", dec, calls)
+	}
+	if !strings.Contains(string(gotFormat), `"enum":["NOTIFY","IDLE"]`) {
+		t.Errorf("schema not passed through Options.Format: %s", gotFormat)
+	}
+	// 第三次呼叫應帶上前兩次的錯誤回饋，且不可修改呼叫端的切片
+	if len(lastConvo) != 5 || !strings.Contains(lastConvo[4].Content, "MAYBE") {
+		t.Errorf("validation feedback not appended: %+v", lastConvo)
+	}
+	if len(input) != 1 {
+		t.Errorf("caller messages mutated")
+	}
+
+	calls = 0
+	replies = []string{"nope", "nope", "nope"}
+	if _, err := ChatJSON[testDecision](context.Background(), chatFn, "m", input, ollama.Options{}); err == nil || calls != DefaultJSONAttempts {
+		t.Errorf("expected failure after %d attempts, got err=%v calls=%d", DefaultJSONAttempts, err, calls)
+	}
+}
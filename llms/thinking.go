@@ -0,0 +1,39 @@
+package llms
+
+import (
+	"context"
+	"strings"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// SeparateThinking 包裝 ChatStreamFunc，把模型夾在內容中的 <think>...</think> 區塊移到 Message.Thinking
+// 串流回調只會收到回答內容，推理片段改交給 ollama.WithThinkingHandler 設定的回調
+// Provider 以獨立欄位回傳的推理內容 (Ollama thinking 等) 已由各 Provider 處理，這裡一併保留
+func SeparateThinking(fn ChatStreamFunc) ChatStreamFunc {
+	return func(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+		onThinking := ollama.ThinkingHandler(ctx)
+		var splitter ollama.ThinkSplitter
+		emit := func(content, thinking string) {
+			if content != "" && callback != nil {
+				callback(content)
+			}
+			if thinking != "" && onThinking != nil {
+				onThinking(thinking)
+			}
+		}
+
+		msg, err := fn(ctx, modelName, messages, tools, opts, func(s string) {
+			emit(splitter.Feed(s))
+		})
+		emit(splitter.Flush())
+
+		if strings.Contains(msg.Content, "<think>") {
+			content, thinking := ollama.SplitThinking(msg.Content)
+			msg.Content = content
+			msg.Thinking = strings.TrimSpace(strings.TrimSpace(msg.Thinking) + "\n" + thinking)
+		}
+		return msg, err
+	}
+}
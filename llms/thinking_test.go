@@ -0,0 +1,32 @@
+package llms
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func TestSeparateThinking(t *testing.T) {
+	fn := SeparateThinking(func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		for _, c := range []string{"<think>想", "想</think>", "你好"} {
+			cb(c)
+		}
+		return ollama.Message{Role: "assistant", Content: "<think>想想</think>你好", Thinking: "原生推理"}, nil
+	})
+
+	var streamed, thought strings.Builder
+	ctx := ollama.WithThinkingHandler(context.Background(), func(s string) { thought.WriteString(s) })
+	msg, err := fn(ctx, "m", nil, nil, ollama.Options{}, func(s string) { streamed.WriteString(s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != "你好" || streamed.String() != "你好" {
+		t.Errorf("think block leaked into content: msg=%q streamed=%q", msg.Content, streamed.String())
+	}
+	if thought.String() != "想想" || msg.Thinking != "原生推理\n想想" {
+		t.Errorf("unexpected thinking: handler=%q msg=%q", thought.String(), msg.Thinking)
+	}
+}
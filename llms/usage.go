@@ -0,0 +1,107 @@
+package llms
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+// UsageTags 描述一次 LLM 呼叫的來源，透過 Context 傳遞給 Provider 包裝層
+type UsageTags struct {
+	SessionID string // 例如 telegram_12345、api_bot、cli session id
+	Channel   string // cli, telegram, api, background ...
+	Workflow  string // chat, heartbeat, patrol, morning_briefing, skill_generation ...
+	Iteration int    // Agent 工具迴圈的第幾輪 (從 1 開始，0 代表非 Agent 呼叫)
+}
+
+// UsageRecord 是一次 LLM 呼叫的完整用量紀錄
+type UsageRecord struct {
+	UsageTags
+	ollama.Usage
+	Timestamp time.Time
+	Success   bool
+	Error     string
+}
+
+type usageTagsKey struct{}
+
+// WithUsageTags 將來源標籤附加到 Context；非零欄位會覆蓋上層 Context 已有的值
+func WithUsageTags(ctx context.Context, tags UsageTags) context.Context {
+	merged := UsageTagsFrom(ctx)
+	if tags.SessionID != "" {
+		merged.SessionID = tags.SessionID
+	}
+	if tags.Channel != "" {
+		merged.Channel = tags.Channel
+	}
+	if tags.Workflow != "" {
+		merged.Workflow = tags.Workflow
+	}
+	if tags.Iteration != 0 {
+		merged.Iteration = tags.Iteration
+	}
+	return context.WithValue(ctx, usageTagsKey{}, merged)
+}
+
+// UsageTagsFrom 取出 Context 中的來源標籤
+func UsageTagsFrom(ctx context.Context) UsageTags {
+	if ctx == nil {
+		return UsageTags{}
+	}
+	tags, _ := ctx.Value(usageTagsKey{}).(UsageTags)
+	return tags
+}
+
+var (
+	usageMu       sync.RWMutex
+	usageRecorder func(UsageRecord)
+)
+
+// SetUsageRecorder 設定全域用量紀錄器 (例如寫入 SQLite)，傳入 nil 可停用
+func SetUsageRecorder(fn func(UsageRecord)) {
+	usageMu.Lock()
+	usageRecorder = fn
+	usageMu.Unlock()
+}
+
+// Metered 包裝 ChatStreamFunc，在每次呼叫結束後量測耗時並交給用量紀錄器
+func Metered(providerName string, fn ChatStreamFunc) ChatStreamFunc {
+	return func(ctx context.Context, modelName string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, callback func(string)) (ollama.Message, error) {
+		start := time.Now()
+		msg, err := fn(ctx, modelName, messages, tools, opts, callback)
+
+		usageMu.RLock()
+		recorder := usageRecorder
+		usageMu.RUnlock()
+		if recorder == nil {
+			return msg, err
+		}
+
+		rec := UsageRecord{
+			UsageTags: UsageTagsFrom(ctx),
+			Timestamp: start,
+			Success:   err == nil,
+		}
+		if msg.Usage != nil {
+			rec.Usage = *msg.Usage
+		}
+		if rec.Provider == "" {
+			rec.Provider = providerName
+		}
+		if rec.Model == "" {
+			rec.Model = modelName
+		}
+		if rec.Latency <= 0 {
+			rec.Latency = time.Since(start)
+		}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		recorder(rec)
+
+		return msg, err
+	}
+}
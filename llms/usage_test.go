@@ -0,0 +1,50 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asccclass/pcai/llms/ollama"
+	"github.com/ollama/ollama/api"
+)
+
+func TestMetered_RecordsUsageWithTags(t *testing.T) {
+	var got []UsageRecord
+	SetUsageRecorder(func(r UsageRecord) { got = append(got, r) })
+	defer SetUsageRecorder(nil)
+
+	fail := false
+	fn := Metered("ollama", func(ctx context.Context, model string, messages []ollama.Message, tools []api.Tool, opts ollama.Options, cb func(string)) (ollama.Message, error) {
+		if fail {
+			return ollama.Message{}, errors.New("boom")
+		}
+		return ollama.Message{Role: "assistant", Usage: &ollama.Usage{PromptTokens: 120, CompletionTokens: 40, EvalDuration: 2 * time.Second}}, nil
+	})
+
+	ctx := WithUsageTags(context.Background(), UsageTags{SessionID: "s1", Channel: "cli", Workflow: "chat"})
+	ctx = WithUsageTags(ctx, UsageTags{Iteration: 2})
+	if _, err := fn(ctx, "llama3.3", nil, nil, ollama.Options{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	_, _ = fn(context.Background(), "llama3.3", nil, nil, ollama.Options{}, nil)
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(got))
+	}
+	r := got[0]
+	if r.SessionID != "s1" || r.Channel != "cli" || r.Workflow != "chat" || r.Iteration != 2 {
+		t.Errorf("tags not merged: %+v", r.UsageTags)
+	}
+	if r.Provider != "ollama" || r.Model != "llama3.3" || r.PromptTokens != 120 || !r.Success || r.Latency <= 0 {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if tps := r.TokensPerSecond(); tps != 20 {
+		t.Errorf("expected 20 tok/s from eval duration, got %v", tps)
+	}
+	if got[1].Success || got[1].Error != "boom" {
+		t.Errorf("failure not recorded: %+v", got[1])
+	}
+}
@@ -6,12 +6,12 @@ import (
 	"fmt"
 
 	"github.com/asccclass/pcai/internal/advisor"
+	"github.com/asccclass/pcai/llms"
 	"github.com/ollama/ollama/api"
 )
 
 // AdvisorSkill 包裝架構分析邏輯
 type AdvisorSkill struct {
-	client    *api.Client
 	modelName string
 }
 
@@ -24,9 +24,8 @@ func (s *AdvisorSkill) CreateTool() *AdvisorTool {
 	return &AdvisorTool{skill: s}
 }
 
-func NewAdvisorSkill(client *api.Client, modelName string) *AdvisorSkill {
+func NewAdvisorSkill(modelName string) *AdvisorSkill {
 	return &AdvisorSkill{
-		client:    client,
 		modelName: modelName,
 	}
 }
@@ -73,7 +72,9 @@ func (t *AdvisorTool) Run(argsJSON string) (string, error) {
 		return "", fmt.Errorf("invalid arguments: %v", err)
 	}
 
-	result, err := advisor.Analyze(context.Background(), t.skill.client, t.skill.modelName, args.Description)
+	ctx := llms.WithUsageTags(context.Background(), llms.UsageTags{Workflow: "advisor"})
+	opts := llms.ProfileFor(t.Name(), llms.ProfileToolRouting)
+	result, err := advisor.Analyze(ctx, llms.GetDefaultChatStream(), t.skill.modelName, opts, args.Description)
 	if err != nil {
 		return "", err
 	}
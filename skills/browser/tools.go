@@ -1,14 +1,19 @@
 package browserskill
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 
 	"github.com/asccclass/pcai/internal/browser"
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
+// 瀏覽器工具都實作 core.ContextTool：Playwright 操作的逾時不超過對話剩餘的時間，
+// 對話取消後不再等待頁面載入 (Run 保留給沒有 context 的呼叫端)
+
 // BrowserOpenTool
 type BrowserOpenTool struct{}
 
@@ -39,6 +44,10 @@ func (t *BrowserOpenTool) Definition() api.Tool {
 }
 
 func (t *BrowserOpenTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserOpenTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		URL string `json:"url"`
 	}
@@ -47,7 +56,7 @@ func (t *BrowserOpenTool) Run(argsJSON string) (string, error) {
 	}
 
 	mgr := browser.GetManager()
-	if err := mgr.Navigate(args.URL); err != nil {
+	if err := mgr.Navigate(ctx, args.URL); err != nil {
 		return "", fmt.Errorf("navigation failed: %v", err)
 	}
 
@@ -83,6 +92,10 @@ func (t *BrowserSnapshotTool) Definition() api.Tool {
 }
 
 func (t *BrowserSnapshotTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserSnapshotTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		InteractiveOnly bool `json:"interactive_only"`
 	}
@@ -107,7 +120,7 @@ func (t *BrowserSnapshotTool) Run(argsJSON string) (string, error) {
 	}
 
 	mgr := browser.GetManager()
-	res, err := mgr.Snapshot(args.InteractiveOnly)
+	res, err := mgr.Snapshot(ctx, args.InteractiveOnly)
 	if err != nil {
 		return "", fmt.Errorf("snapshot failed: %v", err)
 	}
@@ -144,6 +157,10 @@ func (t *BrowserClickTool) Definition() api.Tool {
 }
 
 func (t *BrowserClickTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserClickTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		Ref string `json:"ref"`
 	}
@@ -155,7 +172,7 @@ func (t *BrowserClickTool) Run(argsJSON string) (string, error) {
 	}
 
 	mgr := browser.GetManager()
-	if err := mgr.Click(args.Ref); err != nil {
+	if err := mgr.Click(ctx, args.Ref); err != nil {
 		return "", fmt.Errorf("click failed: %v", err)
 	}
 	return fmt.Sprintf("Clicked %s", args.Ref), nil
@@ -195,6 +212,10 @@ func (t *BrowserTypeTool) Definition() api.Tool {
 }
 
 func (t *BrowserTypeTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserTypeTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		Ref  string `json:"ref"`
 		Text string `json:"text"`
@@ -204,7 +225,7 @@ func (t *BrowserTypeTool) Run(argsJSON string) (string, error) {
 	}
 
 	mgr := browser.GetManager()
-	if err := mgr.Type(args.Ref, args.Text); err != nil {
+	if err := mgr.Type(ctx, args.Ref, args.Text); err != nil {
 		return "", fmt.Errorf("type failed: %v", err)
 	}
 	return fmt.Sprintf("Typed %q into %s", args.Text, args.Ref), nil
@@ -239,6 +260,10 @@ func (t *BrowserScrollTool) Definition() api.Tool {
 }
 
 func (t *BrowserScrollTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserScrollTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		Direction string `json:"direction"`
 	}
@@ -248,7 +273,7 @@ func (t *BrowserScrollTool) Run(argsJSON string) (string, error) {
 	}
 
 	mgr := browser.GetManager()
-	if err := mgr.Scroll(args.Direction); err != nil {
+	if err := mgr.Scroll(ctx, args.Direction); err != nil {
 		return "", fmt.Errorf("scroll failed: %v", err)
 	}
 	return fmt.Sprintf("Scrolled %s", args.Direction), nil
@@ -278,8 +303,12 @@ func (t *BrowserGetTextTool) Definition() api.Tool {
 }
 
 func (t *BrowserGetTextTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserGetTextTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	mgr := browser.GetManager()
-	res, err := mgr.GetFullText()
+	res, err := mgr.GetFullText(ctx)
 	if err != nil {
 		return "", fmt.Errorf("get text failed: %v", err)
 	}
@@ -326,6 +355,10 @@ func (t *BrowserGetTool) Definition() api.Tool {
 }
 
 func (t *BrowserGetTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+func (t *BrowserGetTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		What string `json:"what"`
 		Ref  string `json:"ref"`
@@ -337,7 +370,7 @@ func (t *BrowserGetTool) Run(argsJSON string) (string, error) {
 
 	mgr := browser.GetManager()
 	if args.What == "text" || args.What == "html" {
-		res, err := mgr.GetText(args.Ref) // Implementation gets OuterHTML currently
+		res, err := mgr.GetText(ctx, args.Ref) // Implementation gets OuterHTML currently
 		if err != nil {
 			return "", err
 		}
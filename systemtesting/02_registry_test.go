@@ -1,6 +1,12 @@
 package systemtesting
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/asccclass/pcai/internal/core"
@@ -66,6 +72,24 @@ func TestRegistry_AliasMapping(t *testing.T) {
 	}
 }
 
+func TestRegistry_UnregisterIgnoresAliases(t *testing.T) {
+	reg := core.NewRegistry()
+	reg.Register(&mockTool{name: "get_taiwan_weather", result: "晴"})
+	var events []core.RegistryEvent
+	reg.Subscribe(func(e core.RegistryEvent) { events = append(events, e) })
+
+	// 別名只用於呼叫，不能用來移除實際的工具
+	if reg.Unregister("weather") || reg.Unregister(":weather") {
+		t.Error("Unregister by alias should be a no-op")
+	}
+	if _, err := reg.CallTool("get_taiwan_weather", `{}`); err != nil || len(events) != 0 {
+		t.Errorf("tool should remain registered, err=%v events=%v", err, events)
+	}
+	if !reg.Unregister("get_taiwan_weather") {
+		t.Error("Unregister by real name failed")
+	}
+}
+
 // --- GetDefinitions ---
 
 func TestRegistry_GetDefinitions(t *testing.T) {
@@ -128,3 +152,207 @@ func TestRegistry_PriorityOrdering(t *testing.T) {
 		t.Errorf("Expected high_priority first, got %q", defs[0].Function.Name)
 	}
 }
+
+// --- Namespace, Unregister, Events & Snapshot ---
+
+func TestRegistry_NamespaceReplaceAndEvents(t *testing.T) {
+	reg := core.NewRegistry()
+	reg.Register(&mockTool{name: "web_search", result: "builtin"})
+	reg.RegisterIn("skill", &mockTool{name: "stock_quote", result: "v1"}, 10)
+	reg.RegisterIn("skill", &mockTool{name: "old_skill", result: "old"}, 10)
+
+	var events []string
+	unsubscribe := reg.Subscribe(func(e core.RegistryEvent) {
+		events = append(events, string(e.Change)+":"+e.Name)
+	})
+
+	if _, err := reg.CallTool("skill:stock_quote", `{}`); err != nil {
+		t.Errorf("qualified name lookup failed: %v", err)
+	}
+	if _, err := reg.CallTool("skill:web_search", `{}`); err == nil {
+		t.Error("web_search is not in the skill namespace")
+	}
+
+	snap := reg.Snapshot()
+	before := reg.Version()
+	reg.ReplaceNamespace("skill", 10, &mockTool{name: "stock_quote", result: "v2"})
+
+	if got := strings.Join(reg.Names("skill"), ","); got != "stock_quote" {
+		t.Errorf("skill namespace = %s", got)
+	}
+	if got := strings.Join(reg.Names(""), ","); got != "stock_quote,web_search" {
+		t.Errorf("all tools = %s", got)
+	}
+	if reg.Version() != before+1 {
+		t.Errorf("ReplaceNamespace should be one change, version %d -> %d", before, reg.Version())
+	}
+	if got, _ := reg.CallTool("stock_quote", `{}`); got != "v2" {
+		t.Errorf("stock_quote = %q, want v2", got)
+	}
+	sort.Strings(events)
+	if got := strings.Join(events, ","); got != "removed:old_skill,replaced:stock_quote" {
+		t.Errorf("events = %s", got)
+	}
+
+	// 快照不受之後的變動影響，且不可修改
+	if got, _ := snap.CallTool("stock_quote", `{}`); got != "v1" {
+		t.Errorf("snapshot stock_quote = %q, want v1", got)
+	}
+	if _, err := snap.CallTool("old_skill", `{}`); err != nil {
+		t.Errorf("snapshot lost old_skill: %v", err)
+	}
+	snap.Register(&mockTool{name: "new_tool"})
+	if snap.Unregister("web_search") || len(snap.Names("")) != 3 {
+		t.Error("snapshot should be read-only")
+	}
+
+	unsubscribe()
+	if !reg.Unregister("web_search") || reg.Unregister("web_search") {
+		t.Error("Unregister should remove the tool exactly once")
+	}
+	if len(events) != 2 {
+		t.Errorf("unsubscribed listener still notified: %v", events)
+	}
+}
+
+func TestRegistry_SkillShadowsBuiltin(t *testing.T) {
+	reg := core.NewRegistry()
+	reg.Register(&mockTool{name: "send_email", result: "builtin"})
+	reg.RegisterIn("skill", &mockTool{name: "send_email", result: "skill"}, 10)
+
+	if got, _ := reg.CallTool("send_email", `{}`); got != "skill" {
+		t.Fatalf("skill should shadow the builtin, got %q", got)
+	}
+
+	// 重新載入技能後該技能已不存在：內建工具恢復而不是一起消失
+	var events []string
+	reg.Subscribe(func(e core.RegistryEvent) { events = append(events, string(e.Change)+":"+e.Namespace) })
+	reg.ReplaceNamespace("skill", 10)
+	if got, err := reg.CallTool("send_email", `{}`); err != nil || got != "builtin" {
+		t.Errorf("builtin should be restored, got %q, %v", got, err)
+	}
+	if got := strings.Join(events, ","); got != "replaced:" {
+		t.Errorf("events = %s", got)
+	}
+
+	// 內建工具後註冊時遮蔽技能，移除技能命名空間後不會再恢復技能
+	reg.RegisterIn("skill", &mockTool{name: "send_email", result: "skill"}, 10)
+	reg.Register(&mockTool{name: "send_email", result: "builtin v2"})
+	reg.ReplaceNamespace("skill", 10)
+	if !reg.Unregister("send_email") {
+		t.Fatal("Unregister failed")
+	}
+	if _, err := reg.CallTool("send_email", `{}`); err == nil {
+		t.Error("removed skill must not come back")
+	}
+}
+
+func TestRegistry_ConcurrentReloadAndCall(t *testing.T) {
+	reg := core.NewRegistry()
+	reg.RegisterIn("skill", &mockTool{name: "stock_quote", result: "ok"}, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				reg.ReplaceNamespace("skill", 10, &mockTool{name: "stock_quote", result: "ok"})
+				reg.Register(&mockTool{name: "tmp"})
+				reg.Unregister("tmp")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := reg.CallTool("stock_quote", `{}`); err != nil {
+					t.Errorf("CallTool during reload: %v", err)
+					return
+				}
+				_ = reg.GetDefinitions()
+				_ = reg.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// schemaTool 回傳收到的參數，用來檢查 Registry 的參數驗證與修正
+type schemaTool struct{}
+
+func (s *schemaTool) Name() string  { return "search_notes" }
+func (s *schemaTool) IsSkill() bool { return false }
+func (s *schemaTool) Definition() api.Tool {
+	var props api.ToolPropertiesMap
+	_ = json.Unmarshal([]byte(`{
+		"query": {"type": "string", "description": "搜尋關鍵字"},
+		"limit": {"type": "integer"},
+		"exact": {"type": "boolean"},
+		"tags":  {"type": "array", "items": {"type": "string"}},
+		"mode":  {"type": "string", "enum": ["daily", "long_term"]}
+	}`), &props)
+	return api.Tool{Type: "function", Function: api.ToolFunction{
+		Name:       "search_notes",
+		Parameters: api.ToolFunctionParameters{Type: "object", Properties: &props, Required: []string{"query"}},
+	}}
+}
+func (s *schemaTool) ArgDefaults() map[string]any         { return map[string]any{"limit": 5} }
+func (s *schemaTool) Run(argsJSON string) (string, error) { return argsJSON, nil }
+
+func TestRegistry_ArgValidation(t *testing.T) {
+	reg := core.NewRegistry()
+	reg.Register(&schemaTool{})
+
+	got, err := reg.CallTool("search_notes", `{"query": 42, "limit": "10", "exact": "true", "tags": "work", "mode": "long-term"}`)
+	if err != nil {
+		t.Fatalf("coercible args rejected: %v", err)
+	}
+	var args map[string]any
+	if err := json.Unmarshal([]byte(got), &args); err != nil {
+		t.Fatalf("tool received invalid JSON %q: %v", got, err)
+	}
+	want := map[string]any{"query": "42", "limit": 10.0, "exact": true, "tags": []any{"work"}, "mode": "long_term"}
+	for k, v := range want {
+		if !reflect.DeepEqual(args[k], v) {
+			t.Errorf("%s = %#v, want %#v", k, args[k], v)
+		}
+	}
+
+	// 缺少的參數套用預設值，null 視為未提供
+	got, _ = reg.CallTool("search_notes", `{"query": "會議", "limit": null}`)
+	if !strings.Contains(got, `"limit":5`) {
+		t.Errorf("default not applied: %s", got)
+	}
+
+	// 無法修正時回傳列出每個問題的錯誤
+	_, err = reg.CallTool("search_notes", `{"limit": "很多", "mode": "weekly"}`)
+	var argErr *core.ArgError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("expected ArgError, got %v", err)
+	}
+	var params []string
+	for _, p := range argErr.Problems {
+		params = append(params, p.Param)
+	}
+	if strings.Join(params, ",") != "limit,mode,query" {
+		t.Errorf("problems = %+v", argErr.Problems)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "缺少必要參數") || !strings.Contains(msg, "可用值: daily, long_term") {
+		t.Errorf("error message lacks guidance: %s", msg)
+	}
+
+	// 包在 ```json 區塊中、不需修正的參數也要以去掉區塊後的 JSON 交給工具
+	got, err = reg.CallTool("search_notes", "```json\n{\"query\": \"會議\", \"limit\": 3}\n```")
+	if err != nil || got != `{"query": "會議", "limit": 3}` {
+		t.Errorf("fenced args: got %q, err %v", got, err)
+	}
+
+	if _, err := reg.CallTool("search_notes", `query=會議`); !errors.As(err, &argErr) {
+		t.Errorf("invalid JSON should be an ArgError, got %v", err)
+	}
+
+	// 參數為 null 時視為空物件：套用預設值並回報缺少的必要參數，而不是 panic
+	if _, err := reg.CallTool("search_notes", `null`); !errors.As(err, &argErr) || argErr.Problems[0].Param != "query" {
+		t.Errorf("null args: got %v", err)
+	}
+}
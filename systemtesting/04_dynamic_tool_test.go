@@ -1,6 +1,7 @@
 package systemtesting
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -94,6 +95,34 @@ func TestDynamicTool_RegisterInRegistry(t *testing.T) {
 	}
 }
 
+// --- Schema 驗證 (經 Registry 呼叫) ---
+
+func TestDynamicTool_SchemaValidation(t *testing.T) {
+	def := newTestDef("area_forecast", "fetch {{location}} --unit={{unit}}")
+	def.Options = map[string][]string{"location": {"臺北市", "新北市"}}
+	def.OptionAliases = map[string]map[string]string{"location": {"信義區": "臺北市"}}
+	tool := skillloader.NewDynamicTool(def, nil, nil)
+
+	// 只有獨立出現的 {{location}} 是必要參數
+	if req := tool.Definition().Function.Parameters.Required; len(req) != 1 || req[0] != "location" {
+		t.Errorf("Required = %v, want [location]", req)
+	}
+
+	// 參數名稱別名與 option_aliases 在驗證前套用
+	args := map[string]any{"city": "信義區", "date": "today"}
+	tool.NormalizeArgs(args)
+	if len(args) != 1 || args["location"] != "臺北市" {
+		t.Errorf("normalized args = %v", args)
+	}
+
+	reg := core.NewRegistry()
+	reg.Register(tool)
+	var argErr *core.ArgError
+	if _, err := reg.CallTool("area_forecast", `{"location": "火星"}`); !errors.As(err, &argErr) {
+		t.Errorf("value outside options should be an ArgError, got %v", err)
+	}
+}
+
 // --- Parameter Substitution (透過 Run，但 echo 指令可在 Windows 執行) ---
 
 func TestDynamicTool_Run_ParamSubstitution(t *testing.T) {
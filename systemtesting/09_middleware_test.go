@@ -0,0 +1,202 @@
+package systemtesting
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/asccclass/pcai/internal/skillloader"
+	"github.com/asccclass/pcai/internal/toolmw"
+	"github.com/asccclass/pcai/llms"
+)
+
+// ============================================================
+// Stage 9: Middleware — 工具中介層
+// 驗證重試、跨重新啟動的結果快取、速率限制、輸出遮蔽與依工具設定
+// ============================================================
+
+// flakyTool 前 failures 次呼叫失敗，之後成功
+type flakyTool struct {
+	mockTool
+	failures int
+	calls    int
+}
+
+func (f *flakyTool) Run(argsJSON string) (string, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return "", errors.New("connection reset")
+	}
+	return f.result + " " + argsJSON, nil
+}
+
+func newMiddlewareRegistry(t *testing.T, dir, yaml string) *core.Registry {
+	t.Helper()
+	path := filepath.Join(dir, "toolmiddleware.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	reg := core.NewRegistry()
+	toolmw.New(path, dir).Install(reg)
+	return reg
+}
+
+func TestMiddleware_RetryCacheRateRedact(t *testing.T) {
+	dir := t.TempDir()
+	config := `
+tools:
+  flaky_fetch:
+    retry: {attempts: 3, backoff: 10ms}
+  cached_lookup:
+    cache: 1h
+  limited_api:
+    rate: {per_minute: 2}
+  raw_env:
+    redact: false
+`
+	reg := newMiddlewareRegistry(t, dir, config)
+
+	// 重試：失敗兩次後第三次成功
+	flaky := &flakyTool{mockTool: mockTool{name: "flaky_fetch", result: "ok"}, failures: 2}
+	reg.Register(flaky)
+	if out, err := reg.CallTool("flaky_fetch", `{}`); err != nil || !strings.HasPrefix(out, "ok") || flaky.calls != 3 {
+		t.Fatalf("retry: out=%q err=%v calls=%d", out, err, flaky.calls)
+	}
+
+	// 超過重試次數時回傳最後的錯誤
+	flaky.calls, flaky.failures = 0, 5
+	if _, err := reg.CallTool("flaky_fetch", `{}`); err == nil || flaky.calls != 3 {
+		t.Errorf("retry exhausted: err=%v calls=%d", err, flaky.calls)
+	}
+
+	// 快取：參數順序不同也命中，新的 Chain (模擬重新啟動) 仍可讀取檔案快取
+	lookup := &flakyTool{mockTool: mockTool{name: "cached_lookup", result: "rate"}}
+	reg.Register(lookup)
+	first, _ := reg.CallTool("cached_lookup", `{"from":"USD","to":"TWD"}`)
+	second, _ := reg.CallTool("cached_lookup", `{"to":"TWD","from":"USD"}`)
+	if lookup.calls != 1 || first != second {
+		t.Errorf("cache: calls=%d first=%q second=%q", lookup.calls, first, second)
+	}
+	restarted := newMiddlewareRegistry(t, dir, config)
+	relookup := &flakyTool{mockTool: mockTool{name: "cached_lookup", result: "fresh"}}
+	restarted.Register(relookup)
+	if out, _ := restarted.CallTool("cached_lookup", `{"from":"USD","to":"TWD"}`); out != first || relookup.calls != 0 {
+		t.Errorf("persistent cache: out=%q calls=%d", out, relookup.calls)
+	}
+	// 不同發送者不共用快取
+	if _, err := restarted.CallToolContext(context.Background(), &core.RunEnv{Sender: "bob"}, "cached_lookup", `{"from":"USD","to":"TWD"}`); err != nil || relookup.calls != 1 {
+		t.Errorf("cache per sender: calls=%d err=%v", relookup.calls, err)
+	}
+
+	// 速率限制：每分鐘 2 次
+	reg.Register(&mockTool{name: "limited_api", result: "ok"})
+	for i := 0; i < 2; i++ {
+		if _, err := reg.CallTool("limited_api", `{}`); err != nil {
+			t.Fatalf("call %d should pass: %v", i+1, err)
+		}
+	}
+	if _, err := reg.CallTool("limited_api", `{}`); err == nil || !strings.Contains(err.Error(), "速率上限") {
+		t.Errorf("third call should be rate limited, got %v", err)
+	}
+
+	// 遮蔽：預設開啟，可依工具關閉
+	secret := "token sk-abcdefghijklmnopqrstuvwx and password=hunter22"
+	reg.Register(&mockTool{name: "leaky", result: secret})
+	reg.Register(&mockTool{name: "raw_env", result: secret})
+	out, _ := reg.CallTool("leaky", `{}`)
+	if strings.Contains(out, "sk-abc") || strings.Contains(out, "hunter22") || !strings.Contains(out, "password=[REDACTED]") {
+		t.Errorf("redacted output = %q", out)
+	}
+	if out, _ := reg.CallTool("raw_env", `{}`); out != secret {
+		t.Errorf("redact disabled output = %q", out)
+	}
+}
+
+func TestMiddleware_DailyQuotaPersists(t *testing.T) {
+	dir := t.TempDir()
+	config := `
+tools:
+  paid_api:
+    rate: {per_day: 1}
+`
+	reg := newMiddlewareRegistry(t, dir, config)
+	reg.Register(&mockTool{name: "paid_api", result: "ok"})
+	if _, err := reg.CallTool("paid_api", `{}`); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+
+	restarted := newMiddlewareRegistry(t, dir, config)
+	restarted.Register(&mockTool{name: "paid_api", result: "ok"})
+	if _, err := restarted.CallTool("paid_api", `{}`); err == nil || !strings.Contains(err.Error(), "配額") {
+		t.Errorf("quota should persist across restarts, got %v", err)
+	}
+}
+
+func TestMiddleware_CacheUsesNormalizedSkillArgs(t *testing.T) {
+	reg := newMiddlewareRegistry(t, t.TempDir(), "tools:\n  area_forecast:\n    cache: 1h\n")
+	fetch := &flakyTool{mockTool: mockTool{name: "web_fetch", result: "晴"}}
+	reg.Register(fetch)
+
+	command := `web_fetch "https://example.com/forecast?location={{url:location}}"`
+	reg.Register(skillloader.NewDynamicTool(&skillloader.SkillDefinition{
+		Name:          "area_forecast",
+		Command:       command,
+		Params:        skillloader.ParseParams(command),
+		Options:       map[string][]string{"location": {"臺北市", "新北市"}},
+		OptionAliases: map[string]map[string]string{"location": {"信義區": "臺北市"}},
+	}, reg, nil))
+
+	// 別名與標準值在快取前已正規化，兩次呼叫共用同一筆快取
+	first, err := reg.CallTool("area_forecast", `{"city": "信義區"}`)
+	if err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	second, _ := reg.CallTool("area_forecast", `{"location": "臺北市"}`)
+	if fetch.calls != 1 || first != second {
+		t.Errorf("calls=%d first=%q second=%q", fetch.calls, first, second)
+	}
+}
+
+func TestMiddleware_ShortTermMemory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "toolmiddleware.yaml")
+	if err := os.WriteFile(path, []byte("tools:\n  lookup_stock:\n    memory: stock\n    cache: 1h\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	chain := toolmw.New(path, dir)
+	var saved []string
+	chain.SetShortTermMemory(func(source, content string) { saved = append(saved, source+"="+content) }, "telegram")
+	reg := core.NewRegistry()
+	chain.Install(reg)
+
+	reg.Register(&mockTool{name: "lookup_stock", result: "2330 上漲"})
+	reg.Register(&mockTool{name: "get_taiwan_weather", result: "晴"})
+	reg.Register(&mockTool{name: "list_files", result: "a.txt"})
+	call := func(workflow, channel, name string) {
+		t.Helper()
+		ctx := llms.WithUsageTags(context.Background(), llms.UsageTags{Channel: channel, Workflow: workflow})
+		if _, err := reg.CallToolContext(ctx, &core.RunEnv{Channel: channel, Sender: "42"}, name, `{}`); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"lookup_stock", "lookup_stock", "weather", "list_files"} {
+		call("chat", "telegram", name)
+	}
+	// 設定檔與內建設定 (天氣) 的工具存入，別名呼叫也套用；快取命中不重複存入，其他工具不存
+	if strings.Join(saved, ",") != "stock=2330 上漲,weather=晴" {
+		t.Errorf("saved = %v", saved)
+	}
+
+	// CLI、背景工作與委派的子 Agent 不寫入短期記憶
+	saved = nil
+	call("chat", "cli", "get_taiwan_weather")
+	call("heartbeat", "background", "get_taiwan_weather")
+	call("delegate", "telegram", "get_taiwan_weather")
+	if len(saved) != 0 {
+		t.Errorf("non-gateway calls saved = %v", saved)
+	}
+}
@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/asccclass/pcai/internal/agent"
+	"github.com/asccclass/pcai/internal/core"
+	"github.com/ollama/ollama/api"
+)
+
+// delegatePresets 常用的子 Agent 工具組合
+var delegatePresets = map[string][]string{
+	"research": {"web_search", "web_fetch"},
+	"browse":   {"browser_open", "browser_snapshot", "browser_get_text", "browser_scroll"},
+	"files":    {"fs_list_dir", "fs_read_file"},
+}
+
+// DelegateTaskTool 將任務交給只開放部分工具的子 Agent 執行，只把精簡結果交回主對話
+type DelegateTaskTool struct {
+	Registry  *core.Registry
+	ModelName string // 未指定 model 時使用的模型
+}
+
+func (t *DelegateTaskTool) Name() string { return agent.DelegateToolName }
+
+func (t *DelegateTaskTool) IsSkill() bool { return false }
+
+func (t *DelegateTaskTool) Definition() api.Tool {
+	var props api.ToolPropertiesMap
+	js := `{
+		"task": {
+			"type": "string",
+			"description": "交給子助理的完整任務說明，需包含所有必要背景 (子助理看不到目前的對話)"
+		},
+		"preset": {
+			"type": "string",
+			"description": "工具組合：research (web_search, web_fetch)、browse (瀏覽器)、files (讀取檔案)",
+			"enum": ["research", "browse", "files"]
+		},
+		"tools": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "子助理可使用的工具名稱 (與 preset 合併)"
+		},
+		"model": {
+			"type": "string",
+			"description": "子助理使用的模型 (選填，預設與主助理相同)"
+		}
+	}`
+	_ = json.Unmarshal([]byte(js), &props)
+
+	return api.Tool{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name:        agent.DelegateToolName,
+			Description: "把需要大量搜尋或閱讀的子任務 (例如上網研究、整理多份文件) 委派給子助理。子助理只能使用指定的工具，完成後回傳精簡結果，避免主對話上下文過長。",
+			Parameters: api.ToolFunctionParameters{
+				Type:       "object",
+				Properties: &props,
+				Required:   []string{"task"},
+			},
+		},
+	}
+}
+
+func (t *DelegateTaskTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+// RunContext 子 Agent 沿用主對話的取消與時限，主對話中斷時一併停止
+func (t *DelegateTaskTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
+	var args struct {
+		Task   interface{} `json:"task"`
+		Preset interface{} `json:"preset"`
+		Tools  interface{} `json:"tools"`
+		Model  interface{} `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return "", fmt.Errorf("參數解析失敗: %v", err)
+	}
+
+	var toolNames []string
+	if preset := strings.ToLower(strings.TrimSpace(ToString(args.Preset))); preset != "" {
+		names, ok := delegatePresets[preset]
+		if !ok {
+			return "", fmt.Errorf("未知的工具組合: %s", preset)
+		}
+		toolNames = append(toolNames, names...)
+	}
+	for _, name := range ToStringSlice(args.Tools) {
+		if name = strings.TrimSpace(name); name != "" {
+			toolNames = append(toolNames, name)
+		}
+	}
+	if len(toolNames) == 0 {
+		toolNames = delegatePresets["research"]
+	}
+
+	result, err := agent.RunSubAgent(ctx, t.Registry, t.ModelName, agent.SubTask{
+		Task:   ToString(args.Task),
+		Tools:  toolNames,
+		Model:  strings.TrimSpace(ToString(args.Model)),
+		Caller: env,
+	})
+	if err != nil {
+		return "", err
+	}
+	if result == "" {
+		return "子助理沒有回傳任何結果", nil
+	}
+	return "子助理回報：\n" + result, nil
+}
@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
@@ -27,6 +28,14 @@ func (t *EmailTool) IsSkill() bool {
 	return false
 }
 
+// Risk 寄出郵件需經使用者核准，讀取與搜尋不需要
+func (t *EmailTool) Risk(argsJSON string) core.RiskLevel {
+	if core.ArgsMatch(argsJSON, map[string][]string{"action": {"send", "reply", "forward"}}) {
+		return core.RiskHigh
+	}
+	return core.RiskNone
+}
+
 func (t *EmailTool) Definition() api.Tool {
 	return api.Tool{
 		Type: "function",
@@ -24,6 +24,12 @@ func (t *WebFetchTool) IsSkill() bool {
 	return false
 }
 
+// ParallelSafe 只讀取網頁，可與其他工具同時執行
+func (t *WebFetchTool) ParallelSafe() bool { return true }
+
+// ArgDefaults 未指定格式時輸出 markdown
+func (t *WebFetchTool) ArgDefaults() map[string]any { return map[string]any{"format": "markdown"} }
+
 func (t *WebFetchTool) Definition() api.Tool {
 	return api.Tool{
 		Type: "function",
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
@@ -125,6 +126,9 @@ type FsWriteFileTool struct {
 
 func (t *FsWriteFileTool) Name() string  { return "fs_write_file" }
 func (t *FsWriteFileTool) IsSkill() bool { return false }
+
+// Risk 會建立或覆寫檔案
+func (t *FsWriteFileTool) Risk(argsJSON string) core.RiskLevel { return core.RiskMedium }
 func (t *FsWriteFileTool) Description() string {
 	return `寫入檔案 (若存在則覆寫)。輸入 JSON: {"path": "test.txt", "content": "hello"}`
 }
@@ -187,8 +191,9 @@ type FsListDirTool struct {
 	Manager *FileSystemManager
 }
 
-func (t *FsListDirTool) Name() string  { return "fs_list_dir" }
-func (t *FsListDirTool) IsSkill() bool { return false }
+func (t *FsListDirTool) Name() string       { return "fs_list_dir" }
+func (t *FsListDirTool) IsSkill() bool      { return false }
+func (t *FsListDirTool) ParallelSafe() bool { return true }
 func (t *FsListDirTool) Description() string {
 	return `列出目錄內容。輸入 JSON: {"path": "skills/"}`
 }
@@ -263,6 +268,9 @@ type FsRemoveTool struct {
 
 func (t *FsRemoveTool) Name() string  { return "fs_remove" }
 func (t *FsRemoveTool) IsSkill() bool { return false }
+
+// Risk 刪除檔案或目錄無法復原
+func (t *FsRemoveTool) Risk(argsJSON string) core.RiskLevel { return core.RiskHigh }
 func (t *FsRemoveTool) Description() string {
 	return `刪除檔案或整個目錄 (小心使用)。JSON範例: {"path": "temp_folder"}`
 }
@@ -330,8 +338,9 @@ type FsReadFileTool struct {
 	MaxReadSize int64 // 可配置的最大讀取 byte 數，若為 0 則使用預設值
 }
 
-func (t *FsReadFileTool) Name() string  { return "fs_read_file" }
-func (t *FsReadFileTool) IsSkill() bool { return false }
+func (t *FsReadFileTool) Name() string       { return "fs_read_file" }
+func (t *FsReadFileTool) IsSkill() bool      { return false }
+func (t *FsReadFileTool) ParallelSafe() bool { return true }
 func (t *FsReadFileTool) Description() string {
 	// 為了讓 Description 準確顯示當前設定的大小
 	limit := t.MaxReadSize
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
@@ -21,6 +22,17 @@ func (t *GitAutoCommitTool) IsSkill() bool {
 	return false
 }
 
+// Risk push 會把提交推送到遠端，rollback 會丟棄最後一次提交
+func (t *GitAutoCommitTool) Risk(argsJSON string) core.RiskLevel {
+	switch {
+	case core.ArgsMatch(argsJSON, map[string][]string{"action": {"push"}}):
+		return core.RiskHigh
+	case core.ArgsMatch(argsJSON, map[string][]string{"action": {"rollback"}}):
+		return core.RiskMedium
+	}
+	return core.RiskLow
+}
+
 func (t *GitAutoCommitTool) Definition() api.Tool {
 	return api.Tool{
 		Type: "function",
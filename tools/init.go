@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +21,8 @@ import (
 	"github.com/asccclass/pcai/internal/history"
 	"github.com/asccclass/pcai/internal/memory"
 	"github.com/asccclass/pcai/internal/scheduler"
+	"github.com/asccclass/pcai/internal/toolresult"
+	"github.com/asccclass/pcai/internal/toolmw"
 	"github.com/asccclass/pcai/llms"
 	"github.com/asccclass/pcai/llms/ollama"
 	"github.com/asccclass/pcai/skills"
@@ -58,6 +61,61 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 		fmt.Printf("⚠️ [InitRegistry] 無法啟動資料庫: %v\n", err)
 	}
 	GlobalDB = sqliteDB // 導出供外部使用
+	if sqliteDB != nil {
+		// [NEW] 每次 LLM 呼叫的 Token 用量與延遲寫入 llm_usage (非同步，不阻塞對話)
+		llms.SetUsageRecorder(func(rec llms.UsageRecord) {
+			go func() {
+				ctxUsage, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := sqliteDB.AddLLMUsage(ctxUsage, database.LLMUsageEntry{
+					SessionID:        rec.SessionID,
+					Channel:          rec.Channel,
+					Workflow:         rec.Workflow,
+					Iteration:        rec.Iteration,
+					Provider:         rec.Provider,
+					Model:            rec.Model,
+					PromptTokens:     rec.PromptTokens,
+					CompletionTokens: rec.CompletionTokens,
+					LatencyMs:        rec.Latency.Milliseconds(),
+					TokensPerSec:     rec.TokensPerSecond(),
+					Success:          rec.Success,
+					Error:            rec.Error,
+				}); err != nil {
+					log.Printf("⚠️ [Usage] 寫入用量紀錄失敗: %v", err)
+				}
+			}()
+		})
+		// [NEW] 每次工具呼叫寫入 tool_audit 稽核紀錄 (pcai audit、/api/audit 查詢)
+		core.SetAuditRecorder(func(rec core.ToolCallRecord) {
+			go func() {
+				ctxAudit, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := sqliteDB.AddToolAudit(ctxAudit, database.ToolAuditEntry{
+					SessionID:  rec.SessionID,
+					Channel:    rec.Channel,
+					Sender:     rec.Sender,
+					Tool:       rec.Tool,
+					Args:       rec.Args,
+					ResultSize: rec.ResultSize,
+					ResultHash: rec.ResultHash,
+					DurationMs: rec.Duration.Milliseconds(),
+					Error:      rec.Error,
+					Provider:   rec.Provider,
+					Model:      rec.Model,
+					Timestamp:  rec.Timestamp,
+				}); err != nil {
+					log.Printf("⚠️ [Audit] 寫入稽核紀錄失敗: %v", err)
+				}
+			}()
+		})
+		retention := 90 // 稽核紀錄保留天數 (PCAI_AUDIT_RETENTION_DAYS，0 代表永久保留)
+		if days, err := strconv.Atoi(os.Getenv("PCAI_AUDIT_RETENTION_DAYS")); err == nil {
+			retention = days
+		}
+		if n, _ := sqliteDB.PruneToolAudit(context.Background(), retention); n > 0 {
+			fmt.Printf("🧹 [Audit] 已清除 %d 筆超過 %d 天的稽核紀錄\n", n, retention)
+		}
+	}
 	// Note: We do NOT close the DB here because it needs to persist for the lifetime of the application.
 	// defer sqliteDB.Close()
 
@@ -95,16 +153,12 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 	// [NEW] 註冊背景個性化分析任務 (閒置時執行)
 	schedMgr.RegisterTaskType("personalization_extraction", func() {
 		fmt.Println("🧠 [Personalization] 開始分析日誌以提取用戶偏好...")
-		worker := history.NewPersonalizationWorker(filepath.Join(home, "botmemory"), sqliteDB, cfg.Model, func(model, prompt string) (string, error) {
-			var resp strings.Builder
-			chatFn := llms.GetDefaultChatStream()
-			_, err := chatFn(model, []ollama.Message{
-				{Role: "system", Content: "你是一個個性化分析專家。"},
-				{Role: "user", Content: prompt},
-			}, nil, ollama.Options{Temperature: 0.3}, func(c string) { resp.WriteString(c) })
-			return resp.String(), err
-		})
-		if err := worker.RunOnce(); err != nil {
+		// 背景任務設定逾時，避免 LLM 卡住時佔用 Worker
+		ctxTask, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		ctxTask = llms.WithUsageTags(ctxTask, llms.UsageTags{Channel: "background", Workflow: "personalization"})
+		worker := history.NewPersonalizationWorker(filepath.Join(home, "botmemory"), sqliteDB, cfg.Model, llms.GetDefaultChatStream())
+		if err := worker.RunOnce(ctxTask); err != nil {
 			log.Printf("⚠️ [Personalization] 提取失敗: %v", err)
 		} else {
 			fmt.Println("✅ [Personalization] 提取完成！")
@@ -217,6 +271,7 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 	} else {
 		GlobalMemoryToolKit = memToolKit
 		history.GlobalMemoryToolKit = memToolKit
+		agent.SetToolEmbedder(memToolKit.Manager().Embedder()) // [NEW] 工具挑選沿用記憶系統的 Embedding
 		fmt.Printf("✅ [Memory] ToolKit 初始化完成 (索引 %d 個 chunks)\n", memToolKit.ChunkCount())
 	}
 
@@ -250,6 +305,14 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 
 	// 初始化並註冊工具
 	registry := core.NewRegistry()
+	agent.SetIntentRegistry(registry) // [NEW] 技能的 triggers 也用於 Gateway 的意圖路由
+	agent.DefaultToolSelector().Watch(registry)
+	toolmw.Default().Install(registry) // [NEW] 重試、結果快取、速率限制與輸出遮蔽 (設定見 toolmiddleware.yaml)
+	registry.Subscribe(func(e core.RegistryEvent) {
+		if e.Change == core.ToolRemoved {
+			fmt.Printf("🧩 [Registry] 已移除工具 %s (v%d)\n", e.Name, e.Version)
+		}
+	})
 
 	// 基礎工具
 	registry.Register(&ShellExecTool{Mgr: bgMgr, Manager: fsManager}) // 傳入背景管理器 與 Sandbox Manager
@@ -299,6 +362,12 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 	// 任務規劃工具
 	registry.Register(NewPlannerTool())
 
+	// [NEW] 子 Agent 委派工具 (只開放指定的工具，結果精簡後交回主對話)
+	registry.Register(&DelegateTaskTool{Registry: registry, ModelName: cfg.Model})
+
+	// [NEW] 大型工具結果分頁讀取 (結果過大時 Agent 只放預覽與代號)
+	registry.Register(&ToolResultReadTool{Store: toolresult.Default()})
+
 	// [NEW] 系統缺憾回報工具
 	registry.Register(&ReportMissingTool{})
 
@@ -315,7 +384,7 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 
 	// --- 可繼續新增：相關技能工具 ---
 	// 新增 Advisor Skill (高優先級)
-	advisorSkill := skills.NewAdvisorSkill(client, cfg.Model)
+	advisorSkill := skills.NewAdvisorSkill(cfg.Model)
 	registry.RegisterWithPriority(advisorSkill.CreateTool(), 10)
 
 	// [NEW] 載入動態技能 (skills.md)
@@ -440,10 +509,13 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 		// 使用設定的 LLM Provider 產生摘要
 		var briefingResult strings.Builder
 		chatFn := llms.GetDefaultChatStream()
-		_, llmErr := chatFn(cfg.Model, []ollama.Message{
+		ctxLLM, cancelLLM := context.WithTimeout(context.Background(), 3*time.Minute)
+		ctxLLM = llms.WithUsageTags(ctxLLM, llms.UsageTags{Channel: "background", Workflow: "morning_briefing"})
+		_, llmErr := chatFn(ctxLLM, cfg.Model, []ollama.Message{
 			{Role: "system", Content: "你是一位貼心的數位管家。"},
 			{Role: "user", Content: prompt},
-		}, nil, ollama.Options{Temperature: 0.5}, func(c string) { briefingResult.WriteString(c) })
+		}, nil, llms.ProfileFor("morning_briefing", llms.ProfileBriefing), func(c string) { briefingResult.WriteString(c) })
+		cancelLLM()
 
 		briefing := ""
 		if llmErr != nil {
@@ -519,14 +591,16 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 
 	// [NEW] 註冊 memory_sleep_optimization 任務類型 (每天凌晨 3 點執行 auto_summaries 碎片化記憶重整)
 	schedMgr.RegisterTaskType("memory_sleep", func() {
-		ctxSleep := context.Background()
+		ctxSleep, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		ctxSleep = llms.WithUsageTags(ctxSleep, llms.UsageTags{Channel: "background", Workflow: "memory_sleep"})
 		// 提供一個回調讓 history 能共用 default chat stream 送 prompt 給 LLM (這裡共用 cfg.Model)
 		err := history.OptimizeAutoSummaries(ctxSleep, func(prompt string) (string, error) {
 			var resp strings.Builder
 			chatFn := llms.GetDefaultChatStream()
-			_, lErr := chatFn(cfg.Model, []ollama.Message{
+			_, lErr := chatFn(ctxSleep, cfg.Model, []ollama.Message{
 				{Role: "user", Content: prompt},
-			}, nil, ollama.Options{Temperature: 0.1}, func(c string) { resp.WriteString(c) })
+			}, nil, llms.ProfileFor("memory_sleep", llms.ProfileExtraction), func(c string) { resp.WriteString(c) })
 			return resp.String(), lErr
 		})
 
@@ -558,7 +632,7 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 		if ttlDays <= 0 {
 			ttlDays = 7
 		}
-		adapter.SetShortTermMemoryCallback(func(source, content string) {
+		saveShortTermMemory := func(source, content string) {
 			// 截斷過長內容 (避免 DB 膨脹)
 			if len(content) > 2000 {
 				content = content[:2000] + "...«已截斷»"
@@ -569,7 +643,10 @@ func InitRegistry(bgMgr *BackgroundManager, cfg *config.Config, logger *agent.Sy
 			} else {
 				fmt.Printf("📝 [ShortTermMemory] 已存入 [%s] (%d 字元, TTL=%d天)\n", source, len(content), ttlDays)
 			}
-		})
+		}
+		adapter.SetShortTermMemoryCallback(saveShortTermMemory) // 對話回應
+		// 工具結果 (依 toolmiddleware.yaml 的 memory 設定)；只記錄 Gateway 頻道的對話，CLI、背景工作與子 Agent 不寫入
+		toolmw.Default().SetShortTermMemory(saveShortTermMemory, "telegram", "whatsapp", "websocket")
 
 		// [MEMORY-FIRST] 設定記憶預搜尋回調
 		if sqliteDB != nil || GlobalMemoryToolKit != nil {
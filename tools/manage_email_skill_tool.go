@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
@@ -28,6 +29,17 @@ func (t *ManageEmailSkillTool) Name() string { return "manage_email" }
 
 func (t *ManageEmailSkillTool) IsSkill() bool { return true }
 
+// ParallelSafe 只讀取 Gmail，可與其他工具同時執行
+func (t *ManageEmailSkillTool) ParallelSafe() bool { return true }
+
+// Risk 若模型改用 send 等動作寄信，執行前需經使用者核准
+func (t *ManageEmailSkillTool) Risk(argsJSON string) core.RiskLevel {
+	if core.ArgsMatch(argsJSON, map[string][]string{"action": {"send", "reply", "forward"}}) {
+		return core.RiskHigh
+	}
+	return core.RiskNone
+}
+
 func (t *ManageEmailSkillTool) Definition() api.Tool {
 	var props api.ToolPropertiesMap
 	js := `{
@@ -27,6 +27,9 @@ func (t *MemoryGetTool) IsSkill() bool {
 	return false
 }
 
+// ParallelSafe 只讀取記憶，可與其他工具同時執行
+func (t *MemoryGetTool) ParallelSafe() bool { return true }
+
 func (t *MemoryGetTool) Definition() api.Tool {
 	return api.Tool{
 		Type: "function",
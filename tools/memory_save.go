@@ -27,6 +27,9 @@ func (t *MemorySaveTool) IsSkill() bool {
 	return false
 }
 
+// ArgDefaults 未指定模式時存入長期記憶
+func (t *MemorySaveTool) ArgDefaults() map[string]any { return map[string]any{"mode": "long_term"} }
+
 func (t *MemorySaveTool) Definition() api.Tool {
 	return api.Tool{
 		Type: "function",
@@ -2,12 +2,15 @@ package tools
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os/exec"
+	"regexp"
 	"runtime"
 	"strings"
 
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
@@ -16,6 +19,9 @@ type ShellExecTool struct {
 	Manager *FileSystemManager // Sandbox Manager
 }
 
+// destructiveCmdRe 比對遞迴/強制刪除、格式化等無法復原的指令，執行前需再次經使用者確認
+var destructiveCmdRe = regexp.MustCompile(`(?i)(^|[;&|(]\s*)(sudo\s+)?(rm\s+(-\w*\s+)*-\w*[rf]|rmdir\s|del\s+/[sq]|rd\s+/s|mkfs|dd\s+if=|shred\s|format\s+[a-z]:|shutdown|reboot)`)
+
 // sanitizeCommand 確保指令不會因為多餘的轉義而失效
 func (t *ShellExecTool) sanitizeCommand(cmd string) string {
 	// 先將被轉義的引號還原 (把 \" 變回 ")
@@ -65,6 +71,9 @@ func (t *ShellExecTool) sanitizeCommand(cmd string) string {
 
 func (t *ShellExecTool) Name() string { return "shell_exec" }
 
+// Risk 任意系統指令都可能造成無法復原的影響，執行前需經使用者核准
+func (t *ShellExecTool) Risk(argsJSON string) core.RiskLevel { return core.RiskHigh }
+
 func (t *ShellExecTool) IsSkill() bool {
 	return false
 }
@@ -102,26 +111,30 @@ func (t *ShellExecTool) Definition() api.Tool {
 	}
 }
 
-// 實際執行 shell 的內部函式
-func (t *ShellExecTool) execute(command string) (string, error) {
+// 實際執行 shell 的內部函式，ctx 取消或逾時時終止指令；env 提供暫存目錄 (PCAI_SCRATCH)
+func (t *ShellExecTool) execute(ctx context.Context, env *core.RunEnv, command string) (string, error) {
 	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" {
 		// [FIX] Force UTF-8 encoding (chcp 65001) to avoid garbled text (mojibake)
-		cmd = exec.Command("cmd", "/C", "chcp 65001 && "+command)
+		cmd = exec.CommandContext(ctx, "cmd", "/C", "chcp 65001 && "+command)
 	} else {
-		cmd = exec.Command("sh", "-c", command)
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
 	}
 
 	// 強制設定工作目錄為 Sandbox Root
 	if t.Manager != nil {
 		cmd.Dir = t.Manager.RootPath
 	}
+	cmd.Env = env.Environ()
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("指令已中止 (%v), 輸出: %s", ctx.Err(), stdout.String())
+	}
 	if err != nil {
 		return "", fmt.Errorf("執行錯誤: %v, 輸出: %s", err, stderr.String())
 	}
@@ -134,6 +147,12 @@ func (t *ShellExecTool) execute(command string) (string, error) {
 }
 
 func (t *ShellExecTool) Run(argsJSON string) (string, error) {
+	return t.RunContext(context.Background(), nil, argsJSON)
+}
+
+// RunContext 同步執行的指令隨對話取消或工具時限終止；背景任務不受影響。
+// 破壞性指令 (rm -rf、format...) 即使工具本身已核准，仍會再請使用者確認一次
+func (t *ShellExecTool) RunContext(ctx context.Context, env *core.RunEnv, argsJSON string) (string, error) {
 	var args struct {
 		Command string `json:"command"`
 		Async   any    `json:"async"` // 新增參數
@@ -144,6 +163,12 @@ func (t *ShellExecTool) Run(argsJSON string) (string, error) {
 	// 指令清洗：處理 AI 可能多包的一層引號或轉義字元
 	cleanCommand := t.sanitizeCommand(args.Command)
 
+	if env != nil && destructiveCmdRe.MatchString(cleanCommand) {
+		if ok, reason := env.Confirm(ctx, cleanCommand); !ok {
+			return "", fmt.Errorf("使用者未確認破壞性指令，未執行: %s", reason)
+		}
+	}
+
 	// 非同步判斷 (相容性處理)
 	isAsync := false
 	switch v := args.Async.(type) {
@@ -156,10 +181,10 @@ func (t *ShellExecTool) Run(argsJSON string) (string, error) {
 	// 判斷是否為非同步執行
 	if isAsync && t.Mgr != nil {
 		taskID := t.Mgr.AddTask(cleanCommand, func() (string, error) {
-			return t.execute(cleanCommand)
+			return t.execute(context.Background(), env, cleanCommand)
 		})
 		return fmt.Sprintf("✅ 任務已在背景啟動 (ID: #%d)。你可以繼續跟我聊天，完成後我會通知你。", taskID), nil
 	}
 	// 同步執行
-	return t.execute(cleanCommand)
+	return t.execute(ctx, env, cleanCommand)
 }
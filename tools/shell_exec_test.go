@@ -0,0 +1,42 @@
+package tools
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/asccclass/pcai/internal/core"
+)
+
+func TestShellExecDestructiveCommandNeedsConfirm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	var asked string
+	env := &core.RunEnv{Approve: func(ctx context.Context, action string) (bool, string) {
+		asked = action
+		return false, "使用者拒絕"
+	}}
+	tool := &ShellExecTool{}
+	if _, err := tool.RunContext(context.Background(), env, `{"command":"rm -rf build"}`); err == nil || asked != "rm -rf build" {
+		t.Fatalf("expected confirm to block rm -rf, err=%v asked=%q", err, asked)
+	}
+
+	asked = ""
+	if _, err := tool.RunContext(context.Background(), env, `{"command":"echo hi"}`); err != nil || asked != "" {
+		t.Fatalf("non-destructive command should not ask, err=%v asked=%q", err, asked)
+	}
+}
+
+func TestShellExecExportsScratchDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	scratch := filepath.Join(t.TempDir(), "scratch")
+	out, err := (&ShellExecTool{}).RunContext(context.Background(), &core.RunEnv{ScratchDir: scratch}, `{"command":"echo $PCAI_SCRATCH"}`)
+	if err != nil || strings.TrimSpace(out) != scratch {
+		t.Fatalf("PCAI_SCRATCH = %q, err=%v", out, err)
+	}
+}
@@ -121,7 +121,7 @@ func (i *SkillInstaller) Run(argsJSON string) (string, error) {
 
 	// 4. Register & Persist
 	dynamicTool := skillloader.NewDynamicTool(def, i.Manager.Registry, i.Manager.DockerClient)
-	i.Manager.Registry.Register(dynamicTool)
+	i.Manager.Registry.RegisterIn(SkillNamespace, dynamicTool, skillPriority)
 
 	// 5. 寫入持久化清單
 	if err := i.Manager.RegisterSkill(def.Name, targetPath); err != nil {
@@ -12,6 +12,12 @@ import (
 	dclient "github.com/docker/docker/client"
 )
 
+// SkillNamespace 是技能在工具註冊表中的命名空間，重新載入時整批替換
+const SkillNamespace = "skill"
+
+// skillPriority Skills 優先於 Tools
+const skillPriority = 10
+
 // SkillEntry 定義在 registry.json 中的結構
 type SkillEntry struct {
 	Name        string    `json:"name"`
@@ -44,34 +50,49 @@ func NewSkillManager(baseDir, dbPath string, registry *core.Registry, dockerCli
 
 // LoadAll 從磁碟載入所有已安裝的技能
 func (m *SkillManager) LoadAll() error {
+	tools, err := m.installedTools()
+	if err != nil {
+		return err
+	}
+	for _, t := range tools {
+		m.Registry.RegisterIn(SkillNamespace, t, skillPriority)
+	}
+	return nil
+}
+
+// installedTools 讀取持久化清單並建立已安裝技能的工具
+func (m *SkillManager) installedTools() ([]core.AgentTool, error) {
 	data, err := os.ReadFile(m.DBPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil // 檔案不存在，視為無已安裝技能
+			return nil, nil // 檔案不存在，視為無已安裝技能
 		}
-		return fmt.Errorf("讀取 registry 失敗: %v", err)
+		return nil, fmt.Errorf("讀取 registry 失敗: %v", err)
 	}
 
 	var registry SkillRegistry
 	if err := json.Unmarshal(data, &registry); err != nil {
-		return fmt.Errorf("解析 registry 失敗: %v", err)
+		return nil, fmt.Errorf("解析 registry 失敗: %v", err)
 	}
 
 	fmt.Printf("📦 [SkillManager] Found %d installed skills in registry.\n", len(registry.InstalledSkills))
 
+	var tools []core.AgentTool
 	for _, s := range registry.InstalledSkills {
 		// 確保路徑是絕對路徑或相對於 BaseDir
 		// 如果是 ./skills/xxx，則解析為絕對路徑
 		// 簡單起見，我們假設 s.Path 是正確的可存取路徑
 
 		// 恢復技能
-		if err := m.restoreSkill(s.Path); err != nil {
+		t, err := m.restoreSkill(s.Path)
+		if err != nil {
 			fmt.Printf("⚠️ [SkillManager] 載入技能 %s (%s) 失敗: %v\n", s.Name, s.Path, err)
-		} else {
-			fmt.Printf("✅ [SkillManager] 已載入技能: %s\n", s.Name)
+			continue
 		}
+		fmt.Printf("✅ [SkillManager] 已載入技能: %s\n", s.Name)
+		tools = append(tools, t)
 	}
-	return nil
+	return tools, nil
 }
 
 // RegisterSkill 記錄新安裝的技能並寫入檔案
@@ -117,41 +138,56 @@ func (m *SkillManager) RegisterSkill(name, path string) error {
 
 // LoadLocalSkills 掃描指定目錄載入 SKILL.md (向下相容)
 func (m *SkillManager) LoadLocalSkills(dir string) error {
+	tools, err := m.localTools(dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range tools {
+		m.Registry.RegisterIn(SkillNamespace, t, skillPriority)
+	}
+	return nil
+}
+
+// localTools 掃描目錄中的 SKILL.md 並建立工具
+func (m *SkillManager) localTools(dir string) ([]core.AgentTool, error) {
 	dynamicSkills, err := skillloader.LoadSkills(dir)
 	if err != nil {
-		return fmt.Errorf("載入本地技能失敗: %v", err)
+		return nil, fmt.Errorf("載入本地技能失敗: %v", err)
 	}
 
-	count := 0
+	var tools []core.AgentTool
 	for _, ds := range dynamicSkills {
-		toolStr := skillloader.NewDynamicTool(ds, m.Registry, m.DockerClient)
-		m.Registry.RegisterWithPriority(toolStr, 10) // Skills 優先於 Tools
+		tools = append(tools, skillloader.NewDynamicTool(ds, m.Registry, m.DockerClient))
 		fmt.Printf("✅ [SkillManager] Loaded local skill: %s (%s)\n", ds.Name, ds.Description)
-		count++
 	}
-	fmt.Printf("📂 [SkillManager] Loaded %d local skills from %s\n", count, dir)
-	return nil
+	fmt.Printf("📂 [SkillManager] Loaded %d local skills from %s\n", len(tools), dir)
+	return tools, nil
 }
 
 // Reload 重新載入所有技能 (Registry + Local)
+// [FIX] 先讀完全部技能再一次替換 skill 命名空間：已刪除的技能會被移除，
+// 進行中的對話使用自己的快照，其他 goroutine 也不會看到只載入一半的技能
 func (m *SkillManager) Reload() error {
 	fmt.Println("🔄 [SkillManager] Reloading skillloader...")
 
 	// 1. Reload from Registry (Persistent)
-	if err := m.LoadAll(); err != nil {
+	installed, err := m.installedTools()
+	if err != nil {
 		return err
 	}
 
 	// 2. Reload local skills (from BaseDir)
-	if err := m.LoadLocalSkills(m.BaseDir); err != nil {
+	local, err := m.localTools(m.BaseDir)
+	if err != nil {
 		return err
 	}
 
+	m.Registry.ReplaceNamespace(SkillNamespace, skillPriority, append(installed, local...)...)
 	return nil
 }
 
-// restoreSkill 負責載入並註冊單個技能
-func (m *SkillManager) restoreSkill(path string) error {
+// restoreSkill 負責載入單個技能並建立工具
+func (m *SkillManager) restoreSkill(path string) (core.AgentTool, error) {
 	// 邏輯類似 SkillInstaller 的載入部分
 	// 嘗試讀取 skill.json 或 SKILL.md
 
@@ -175,7 +211,7 @@ func (m *SkillManager) restoreSkill(path string) error {
 		// 讀取 skill.json
 		configData, err := os.ReadFile(configPath)
 		if err != nil {
-			return fmt.Errorf("讀取 skill.json 失敗: %v", err)
+			return nil, fmt.Errorf("讀取 skill.json 失敗: %v", err)
 		}
 
 		var config struct {
@@ -185,7 +221,7 @@ func (m *SkillManager) restoreSkill(path string) error {
 			Image       string `json:"image"`
 		}
 		if err := json.Unmarshal(configData, &config); err != nil {
-			return fmt.Errorf("解析 skill.json 失敗: %v", err)
+			return nil, fmt.Errorf("解析 skill.json 失敗: %v", err)
 		}
 
 		def = &skillloader.SkillDefinition{
@@ -201,14 +237,10 @@ func (m *SkillManager) restoreSkill(path string) error {
 		// 嘗試載入 SKILL.md
 		loadedSkills, err := skillloader.LoadSkills(path)
 		if err != nil || len(loadedSkills) == 0 {
-			return fmt.Errorf("目錄 %s 無效的技能定義", path)
+			return nil, fmt.Errorf("目錄 %s 無效的技能定義", path)
 		}
 		def = loadedSkills[0]
 	}
 
-	// 註冊
-	dynamicTool := skillloader.NewDynamicTool(def, m.Registry, m.DockerClient)
-	m.Registry.RegisterWithPriority(dynamicTool, 10) // Skills 優先於 Tools
-
-	return nil
+	return skillloader.NewDynamicTool(def, m.Registry, m.DockerClient), nil
 }
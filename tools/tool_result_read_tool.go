@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/asccclass/pcai/internal/toolresult"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	resultPageLength = 4000 // 每頁預設字元數
+	resultMaxMatches = 20   // 搜尋最多回傳筆數
+)
+
+// ToolResultReadTool 分頁讀取或搜尋被存檔的大型工具結果
+type ToolResultReadTool struct {
+	Store *toolresult.Store
+}
+
+func (t *ToolResultReadTool) Name() string { return toolresult.ReadToolName }
+
+func (t *ToolResultReadTool) IsSkill() bool { return false }
+
+// ParallelSafe 只讀取已保存的結果，可與其他工具同時執行
+func (t *ToolResultReadTool) ParallelSafe() bool { return true }
+
+// ArgDefaults 未指定時從頭讀取一頁
+func (t *ToolResultReadTool) ArgDefaults() map[string]any {
+	return map[string]any{"offset": 0, "length": resultPageLength}
+}
+
+func (t *ToolResultReadTool) Definition() api.Tool {
+	var props api.ToolPropertiesMap
+	js := `{
+		"handle": {
+			"type": "string",
+			"description": "大型工具結果的代號，例如 tr_20260101_120000_1"
+		},
+		"offset": {
+			"type": "integer",
+			"description": "從第幾個字元開始讀取 (預設 0)"
+		},
+		"length": {
+			"type": "integer",
+			"description": "讀取的字元數 (預設 4000)"
+		},
+		"query": {
+			"type": "string",
+			"description": "搜尋關鍵字 (選填)，回傳包含關鍵字的行與其位移"
+		}
+	}`
+	_ = json.Unmarshal([]byte(js), &props)
+
+	return api.Tool{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name:        toolresult.ReadToolName,
+			Description: "讀取先前被存檔的大型工具結果 (網頁、檔案、郵件清單等)。可用 offset/length 分頁讀取，或用 query 搜尋關鍵字所在的行。",
+			Parameters: api.ToolFunctionParameters{
+				Type:       "object",
+				Properties: &props,
+				Required:   []string{"handle"},
+			},
+		},
+	}
+}
+
+func (t *ToolResultReadTool) Run(argsJSON string) (string, error) {
+	var args struct {
+		Handle interface{} `json:"handle"`
+		Offset interface{} `json:"offset"`
+		Length interface{} `json:"length"`
+		Query  interface{} `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return "", fmt.Errorf("參數解析失敗: %v", err)
+	}
+	store := t.Store
+	if store == nil {
+		store = toolresult.Default()
+	}
+	handle := strings.TrimSpace(ToString(args.Handle))
+	content, err := store.Load(handle)
+	if err != nil {
+		return "", err
+	}
+	total := len([]rune(content))
+
+	if query := strings.TrimSpace(ToString(args.Query)); query != "" {
+		matches := toolresult.Search(content, query, resultMaxMatches)
+		if len(matches) == 0 {
+			return fmt.Sprintf("在 %s 中找不到「%s」", handle, query), nil
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("在 %s 中找到 %d 筆「%s」(共 %d 字元)：\n", handle, len(matches), query, total))
+		for _, m := range matches {
+			sb.WriteString(fmt.Sprintf("- 第 %d 行 (offset=%d): %s\n", m.Line, m.Offset, m.Text))
+		}
+		sb.WriteString("可用 offset 讀取該處前後的完整內容。")
+		return sb.String(), nil
+	}
+
+	offset := toInt(args.Offset, 0)
+	length := toInt(args.Length, resultPageLength)
+	page, next := toolresult.Page(content, offset, length)
+	if page == "" {
+		return fmt.Sprintf("offset %d 已超過內容長度 (共 %d 字元)", offset, total), nil
+	}
+	footer := fmt.Sprintf("\n\n[%s 第 %d~%d 字元，共 %d 字元；已到結尾]", handle, offset, total, total)
+	if next > 0 {
+		footer = fmt.Sprintf("\n\n[%s 第 %d~%d 字元，共 %d 字元；下一頁請用 offset=%d]", handle, offset, next, total, next)
+	}
+	return page + footer, nil
+}
+
+// toInt 將 JSON 數字或字串轉為整數，無法轉換時回傳預設值
+func toInt(v interface{}, def int) int {
+	switch val := v.(type) {
+	case float64:
+		return int(val)
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(val)); err == nil {
+			return n
+		}
+	}
+	return def
+}
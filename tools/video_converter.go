@@ -30,6 +30,11 @@ func (t *VideoConverterTool) IsSkill() bool {
 	return false
 }
 
+// ArgDefaults 未指定格式時轉為 mp4
+func (t *VideoConverterTool) ArgDefaults() map[string]any {
+	return map[string]any{"target_format": "mp4"}
+}
+
 func (t *VideoConverterTool) Definition() api.Tool {
 	var tool api.Tool
 	jsonStr := `{
@@ -22,6 +22,9 @@ func (t *WebSearchTool) IsSkill() bool {
 	return false
 }
 
+// ParallelSafe 只查詢搜尋引擎，可與其他工具同時執行
+func (t *WebSearchTool) ParallelSafe() bool { return true }
+
 func (t *WebSearchTool) Definition() api.Tool {
 	return api.Tool{
 		Type: "function",
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/asccclass/pcai/internal/channel"
+	"github.com/asccclass/pcai/internal/core"
 	"github.com/ollama/ollama/api"
 )
 
@@ -23,6 +24,11 @@ func (t *WhatsAppSendTool) IsSkill() bool {
 	return false
 }
 
+// Risk 以使用者的帳號對外發送訊息
+func (t *WhatsAppSendTool) Risk(argsJSON string) core.RiskLevel {
+	return core.RiskHigh
+}
+
 // Definition 回傳工具定義給 LLM
 func (t *WhatsAppSendTool) Definition() api.Tool {
 	var tool api.Tool